@@ -1,22 +1,24 @@
 package main
 
 import (
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/x509"
-	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"math/big"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -38,9 +40,49 @@ import (
 
 // User represents the user structure in the application
 type User struct {
-	Name      string `json:"name"`
-	Phone     string `json:"phone"`
-	PublicKey string `json:"publicKey"`
+	Name          string   `json:"name"`
+	Handle        string   `json:"handle"`
+	Phone         string   `json:"phone"`
+	PublicKey     string   `json:"publicKey"`
+	DisplayName   string   `json:"displayName,omitempty"`
+	Bio           string   `json:"bio,omitempty"`
+	Links         []string `json:"links,omitempty"`
+	AvatarCID     string   `json:"avatarCID,omitempty"` // IPFS hash of the avatar image
+	CoverCID      string   `json:"coverCID,omitempty"`  // IPFS hash of the cover image
+	Suspended     bool     `json:"suspended,omitempty"`
+	PreviousKeys  []string `json:"previousKeys,omitempty"` // Keys the user has rotated away from, oldest first
+	Deactivated   bool     `json:"deactivated,omitempty"`
+	DeactivatedAt int64    `json:"deactivatedAt,omitempty"`
+	Erased        bool     `json:"erased,omitempty"`
+}
+
+// Deactivation is the ledger's record of a closed account, which can be
+// reactivated until reactivateBefore
+type Deactivation struct {
+	PublicKey        string `json:"publicKey"`
+	DeactivatedAt    int64  `json:"deactivatedAt"`
+	ReactivateBefore int64  `json:"reactivateBefore"`
+}
+
+// Erasure is the ledger's progress in erasing an account, with the post
+// documents of the user found by the latest batch, which are to be unpinned
+type Erasure struct {
+	PublicKey   string   `json:"publicKey"`
+	Removed     int      `json:"removed"`
+	Detached    bool     `json:"detached"` // No friendships, groups or follows are left
+	Erasing     bool     `json:"erasing"`  // Set once the grace period has passed; until then only detaching is done
+	Done        bool     `json:"done"`
+	ContentCIDs []string `json:"contentCIDs"`
+}
+
+// UserProfile is a user's public profile with the counts shown on it
+type UserProfile struct {
+	User
+	PostCount      int `json:"postCount"`
+	FriendCount    int `json:"friendCount"`
+	GroupCount     int `json:"groupCount"`
+	FollowerCount  int `json:"followerCount"`
+	FollowingCount int `json:"followingCount"`
 }
 
 // Wallet represents a crypto wallet
@@ -55,9 +97,40 @@ var walletStore = struct {
 	wallets []Wallet
 }{}
 
+// authChallengeTTL is how long a challenge from ChallengeHandler can be answered
+const authChallengeTTL = 5 * time.Minute
+
+// authChallenge is a one-time value a user signs to prove they hold their key
+type authChallenge struct {
+	Value   string
+	Expires time.Time
+}
+
+// Outstanding challenges, by public key
+var challengeStore = struct {
+	sync.Mutex
+	challenges map[string]authChallenge
+}{challenges: make(map[string]authChallenge)}
+
+// authSessionTTL is how long a session from SessionHandler lasts
+const authSessionTTL = 12 * time.Hour
+
+// authSession lets the holder of a key read their own data, such as their
+// notifications, without answering a challenge for every request
+type authSession struct {
+	PublicKey string
+	Expires   time.Time
+}
+
+// Open sessions, by token
+var sessionStore = struct {
+	sync.Mutex
+	sessions map[string]authSession
+}{sessions: make(map[string]authSession)}
+
 // Post represents a social media post
 type Post struct {
-	ID             int               `json:"id"`
+	ID             string            `json:"id,omitempty"` // Assigned by the ledger when the post is created
 	User           User              `json:"user"`
 	Wallet         Wallet            `json:"wallet"`
 	Content        string            `json:"content,omitempty"` // Optional text content
@@ -69,6 +142,97 @@ type Post struct {
 	VideoHash      string            `json:"videoHash,omitempty"` // Add this field for video IPFS hash
 	IPFSHASH       string            `json:"ipfsHASH,omitempty"`
 	ReactionCounts map[string]int    `json:"reactionCounts,omitempty"`
+	CommentCount   int               `json:"commentCount"`
+	SharedPostCID  string            `json:"sharedPostCID,omitempty"` // Set on reshares to the original post
+	SharedPost     *Post             `json:"sharedPost,omitempty"`    // The original post, filled in when rendering a reshare
+	Edited         bool              `json:"edited"`
+	Hashtags       []string          `json:"hashtags,omitempty"` // Hashtags in Content, without the '#'
+	Mentions       []string          `json:"mentions,omitempty"` // Handles mentioned in Content, without the '@'
+}
+
+// LedgerPost is the on-chain record of a post, holding its counters
+type LedgerPost struct {
+	ID            string        `json:"id"`
+	UserPublicKey string        `json:"userPublicKey"`
+	ContentCID    string        `json:"contentCID"`
+	Timestamp     int64         `json:"timestamp"`
+	ReactionCount int           `json:"reactionCount"`
+	ShareCount    int           `json:"shareCount"`
+	CommentCount  int           `json:"commentCount"`
+	SharedPostCID string        `json:"sharedPostCID"`
+	Versions      []PostVersion `json:"versions"`
+	Hidden        bool          `json:"hidden"` // Hidden by moderators, or by reports pending review
+	Deleted       bool          `json:"deleted"`
+}
+
+// PostVersion is one revision of a post's content on the ledger
+type PostVersion struct {
+	ContentCID  string `json:"contentCID"`
+	PreviousCID string `json:"previousCID"`
+	Timestamp   int64  `json:"timestamp"`
+}
+
+// PostRevision is a version of a post together with its content from IPFS
+type PostRevision struct {
+	PostVersion
+	Post *Post `json:"post,omitempty"`
+}
+
+// PostView is a post's ledger record together with its reactions and, for
+// reshares, the post they credit, as returned by the chaincode in one query
+type PostView struct {
+	Post       *LedgerPost      `json:"post"`
+	Reactions  *ReactionSummary `json:"reactions"`
+	SharedPost *PostView        `json:"sharedPost,omitempty"` // Left out when the original is hidden or deleted
+}
+
+// ReactionSummary is the aggregated view of the reactions to a post returned by the chaincode
+type ReactionSummary struct {
+	PostID    string            `json:"postId"`
+	Reactions map[string]string `json:"reactions"` // Only the viewer's reaction
+	Counts    map[string]int    `json:"counts"`
+	Total     int               `json:"total"`
+}
+
+// Share records that a user reshared a post
+type Share struct {
+	OriginalCID     string `json:"originalCID"`
+	ShareCID        string `json:"shareCID"`
+	SharerPublicKey string `json:"sharerPublicKey"`
+	Timestamp       int64  `json:"timestamp"`
+}
+
+// SharePage is one page of the shares of a post returned by the chaincode
+type SharePage struct {
+	Shares   []*Share `json:"shares"`
+	Bookmark string   `json:"bookmark"`
+}
+
+// Comment is a comment on a post as stored on the ledger, with its body from IPFS
+type Comment struct {
+	ID              string `json:"id"`
+	PostID          string `json:"postId"`
+	ParentID        string `json:"parentId"`
+	AuthorPublicKey string `json:"authorPublicKey"`
+	ContentCID      string `json:"contentCID"`
+	Timestamp       int64  `json:"timestamp"`
+	ReplyCount      int    `json:"replyCount"`
+	Hidden          bool   `json:"hidden"`
+	Deleted         bool   `json:"deleted"`
+	Content         string `json:"content,omitempty"`
+}
+
+// CommentPage is one page of comments returned by the chaincode
+type CommentPage struct {
+	Comments []*Comment `json:"comments"`
+	Bookmark string     `json:"bookmark"`
+}
+
+// CommentBody is the comment document stored in IPFS
+type CommentBody struct {
+	Content         string    `json:"content"`
+	AuthorPublicKey string    `json:"authorPublicKey"`
+	Timestamp       time.Time `json:"timestamp"`
 }
 
 type ReactionRequest struct {
@@ -78,6 +242,7 @@ type ReactionRequest struct {
 
 var (
 	ipfsShell *shell.Shell
+	network   *client.Network
 	contract  *client.Contract
 )
 
@@ -86,8 +251,9 @@ type FriendRequest struct {
 	SenderName   string `json:"senderName"`
 	Receiver     string `json:"receiver"`
 	ReceiverName string `json:"receiverName"`
-	Status       string `json:"status"` // "pending", "accepted", "rejected"
+	Status       string `json:"status"` // "pending", "accepted", "rejected", "cancelled" or "expired"
 	Timestamp    int64  `json:"timestamp"`
+	RespondedAt  int64  `json:"respondedAt,omitempty"`
 }
 
 type FriendsList struct {
@@ -100,9 +266,56 @@ type Friend struct {
 }
 
 type Group struct {
-	ID        string   `json:"id"`
-	GroupName string   `json:"groupname"`
-	Members   []string `json:"members"`
+	ID          string            `json:"id"`
+	GroupName   string            `json:"groupname"`
+	Owner       string            `json:"owner"`
+	Members     []string          `json:"members"` // Public keys
+	Roles       map[string]string `json:"roles"`
+	MemberNames []string          `json:"memberNames,omitempty"` // Display names, in the order of Members
+}
+
+// Page sizes accepted by the list endpoints' limit parameter
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// PostPage is one page of post IPFS hashes returned by the chaincode
+type PostPage struct {
+	Posts    []string `json:"posts"`
+	Bookmark string   `json:"bookmark"`
+}
+
+// HashtagCount is the number of recent posts that used a hashtag
+type HashtagCount struct {
+	Hashtag string `json:"hashtag"`
+	Count   int    `json:"count"`
+}
+
+// Limits the chaincode puts on the topics of a post
+const (
+	maxHashtagLength   = 64
+	maxHashtagsPerPost = 10
+	maxMentionsPerPost = 10
+)
+
+// Hashtags and mentions start at a word boundary, so e-mail addresses and
+// URL fragments are not picked up
+var (
+	hashtagPattern = regexp.MustCompile(`(?:^|[^\w&/])#(\w+)`)
+	mentionPattern = regexp.MustCompile(`(?:^|[^\w.@/-])@([\w.-]+)`)
+)
+
+// UserPage is one page of users returned by the chaincode
+type UserPage struct {
+	Users    []User `json:"users"`
+	Bookmark string `json:"bookmark"`
+}
+
+// FriendRequestPage is one page of friend requests returned by the chaincode
+type FriendRequestPage struct {
+	Requests []*FriendRequest `json:"requests"`
+	Bookmark string           `json:"bookmark"`
 }
 
 // var upgrader = websocket.Upgrader{
@@ -133,7 +346,7 @@ func initFabric() error {
 		return fmt.Errorf("failed to connect to gateway: %w", err)
 	}
 
-	network := gateway.GetNetwork("mychannel")
+	network = gateway.GetNetwork("mychannel")
 	contract = network.GetContract("social_media")
 	log.Println("Successfully connected to Fabric network")
 	return nil
@@ -325,16 +538,33 @@ func SignUpHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Prepare the user data for the blockchain
-	userData := map[string]interface{}{
-		"name":       user.Name,
-		"phone":      user.Phone,
-		"publicKey":  wallet.PublicKey,
-		"privateKey": wallet.PrivateKey,
+	// Store the user data in the blockchain; this fails if the name is already taken.
+	// The phone number goes through the transient map so it stays off the public ledger.
+	_, err = contract.Submit("RegisterUser",
+		client.WithArguments(user.Name, wallet.PublicKey),
+		client.WithTransient(map[string][]byte{"phone": []byte(user.Phone)}),
+	)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error registering user on blockchain: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Keep the new keys in the wallet so the user can sign transactions right away
+	storeInWallet(wallet.PublicKey, wallet.PrivateKey)
+
+	// Verify that the data was stored on the blockchain
+	stored, err := getUserAsOwner(wallet.PublicKey)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error fetching user data from blockchain: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if stored.PublicKey != wallet.PublicKey || stored.Phone != user.Phone || stored.Handle == "" {
+		http.Error(w, "User data verification failed on blockchain", http.StatusInternalServerError)
+		return
 	}
 
-	// Save keys to a file named {name}.key
-	keyFilename := fmt.Sprintf("%s.key", user.Name)
+	// Save keys to a file named {name}.key, only once the name is known to be ours
+	keyFilename := fmt.Sprintf("%s.key", stored.Name)
 	keyFile, err := os.Create(keyFilename)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error creating key file: %v", err), http.StatusInternalServerError)
@@ -349,173 +579,874 @@ func SignUpHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Store the user data in the blockchain
-	_, err = contract.SubmitTransaction("RegisterUser", user.Name, user.Phone, wallet.PublicKey)
+	token, expires, err := openSession(wallet.PublicKey)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error registering user on blockchain: %v", err), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Verify that the data was stored on the blockchain
-	response, err := contract.EvaluateTransaction("GetUser", wallet.PublicKey)
+	// Respond with the user data and a session, see verifySession
+	userData := map[string]interface{}{
+		"name":             stored.Name,
+		"handle":           stored.Handle,
+		"phone":            stored.Phone,
+		"publicKey":        wallet.PublicKey,
+		"privateKey":       wallet.PrivateKey,
+		"sessionToken":     token,
+		"sessionExpiresAt": expires,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(userData)
+}
+
+// ChangeHandleHandler moves a user to a new unique handle
+func ChangeHandleHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		PublicKey string `json:"publicKey"`
+		Handle    string `json:"handle"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.PublicKey == "" || request.Handle == "" {
+		http.Error(w, "publicKey and handle are required", http.StatusBadRequest)
+		return
+	}
+	if err := verifySession(r, request.PublicKey); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// Remember the current name so its key file can follow the change
+	response, err := contract.EvaluateTransaction("GetUser", request.PublicKey)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error fetching user data from blockchain: %v", err), http.StatusInternalServerError)
 		return
 	}
+	var current User
+	if err := json.Unmarshal(response, &current); err != nil {
+		http.Error(w, fmt.Sprintf("Error parsing user data: %v", err), http.StatusInternalServerError)
+		return
+	}
 
-	// Expected user data as a JSON string
-	expectedData := fmt.Sprintf("{\"name\":\"%s\",\"phone\":\"%s\",\"publicKey\":\"%s\"}",
-		user.Name, user.Phone, wallet.PublicKey)
+	result, err := submitSignedTransaction(request.PublicKey, "ChangeHandle", request.PublicKey, request.Handle)
+	if err != nil {
+		log.Printf("Failed to change handle: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to change handle: %v", err), http.StatusInternalServerError)
+		return
+	}
 
-	// Compare the expected and actual data
-	if string(response) == expectedData {
-		// Respond with the user data
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusCreated)
-		json.NewEncoder(w).Encode(userData)
-	} else {
-		http.Error(w, "User data verification failed on blockchain", http.StatusInternalServerError)
+	var updated User
+	if err := json.Unmarshal(result, &updated); err != nil {
+		http.Error(w, fmt.Sprintf("Error parsing user data: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	oldKeyFile := fmt.Sprintf("%s.key", current.Name)
+	newKeyFile := fmt.Sprintf("%s.key", updated.Name)
+	if oldKeyFile != newKeyFile {
+		if err := os.Rename(oldKeyFile, newKeyFile); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to rename key file %s to %s: %v", oldKeyFile, newKeyFile, err)
+		}
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
 }
 
-func GetAllUsersHandler(w http.ResponseWriter, r *http.Request) {
-	// Evaluate transaction to get all users from the blockchain
-	response, err := contract.EvaluateTransaction("GetAllUsers")
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error fetching users from blockchain: %v", err), http.StatusInternalServerError)
+// ChallengeHandler hands out a one-time challenge for a public key. Requests
+// that the wallet alone must not be able to make, such as rotating a key or
+// deactivating an account, carry the user's own signature over it, see
+// verifyChallenge.
+func ChallengeHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		PublicKey string `json:"publicKey"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.PublicKey == "" {
+		http.Error(w, "publicKey is required", http.StatusBadRequest)
 		return
 	}
 
-	// Parse the JSON response into a slice of User structs
-	var users []User
-	if err := json.Unmarshal(response, &users); err != nil {
-		http.Error(w, fmt.Sprintf("Error parsing users data: %v", err), http.StatusInternalServerError)
+	value, err := generateNonce()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	challenge := authChallenge{Value: value, Expires: time.Now().Add(authChallengeTTL)}
+
+	// A new challenge replaces any the key had outstanding
+	challengeStore.Lock()
+	for key, outstanding := range challengeStore.challenges {
+		if time.Now().After(outstanding.Expires) {
+			delete(challengeStore.challenges, key)
+		}
+	}
+	challengeStore.challenges[request.PublicKey] = challenge
+	challengeStore.Unlock()
 
-	// Respond with the list of users
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(users)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"challenge": challenge.Value,
+		"expiresAt": challenge.Expires,
+	})
 }
 
-// LoginHandler handles user login and stores keys in the wallet
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
+// verifyChallenge checks that signature is publicKey's signature over the
+// payload of function called on publicKey with the key's outstanding challenge
+// as its nonce, i.e. transactionPayload(function, [publicKey], challenge).
+// Binding the function means an answer cannot be replayed against another
+// endpoint; the challenge is used up whether or not the signature matches.
+func verifyChallenge(publicKey, function, signature string) error {
+	if signature == "" {
+		return fmt.Errorf("signature over a challenge is required; request one from /auth/challenge")
+	}
+
+	challengeStore.Lock()
+	challenge, ok := challengeStore.challenges[publicKey]
+	delete(challengeStore.challenges, publicKey)
+	challengeStore.Unlock()
+	if !ok || time.Now().After(challenge.Expires) {
+		return fmt.Errorf("no outstanding challenge for %s; request one from /auth/challenge", publicKey)
+	}
+
+	payload, err := transactionPayload(function, []string{publicKey}, challenge.Value)
+	if err != nil {
+		return err
+	}
+	valid, err := VerifySignature(payload, signature, publicKey)
+	if err != nil {
+		return fmt.Errorf("invalid challenge signature: %v", err)
+	}
+	if !valid {
+		return fmt.Errorf("challenge signature does not match %s", publicKey)
+	}
+	return nil
+}
+
+// verifyChallengeRequest reads the signature over publicKey's challenge from a
+// JSON request body and checks it, see verifyChallenge
+func verifyChallengeRequest(r *http.Request, publicKey, function string) error {
 	var request struct {
-		PublicKey  string `json:"publicKey"`
-		PrivateKey string `json:"privateKey"`
+		Signature string `json:"signature"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return fmt.Errorf("invalid request body: %v", err)
 	}
+	return verifyChallenge(publicKey, function, request.Signature)
+}
 
-	// Decode the incoming request body
+// SessionHandler opens a session for a public key. The caller proves they hold
+// the key by signing a challenge for OpenSession, see verifyChallenge, and gets
+// a token to send as "Authorization: Bearer <token>" until expiresAt.
+func SessionHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		PublicKey string `json:"publicKey"`
+		Signature string `json:"signature"`
+	}
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		http.Error(w, "Failed to decode request body: "+err.Error(), http.StatusBadRequest)
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.PublicKey == "" {
+		http.Error(w, "publicKey is required", http.StatusBadRequest)
+		return
+	}
+	if err := verifyChallenge(request.PublicKey, "OpenSession", request.Signature); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
 
-	// Debug: Print received keys
-	fmt.Printf("Received Public Key: %s\n", request.PublicKey)
-	fmt.Printf("Received Private Key: %s\n", request.PrivateKey)
-
-	// Split the public key into X and Y components
-	if len(request.PublicKey) < 128 {
-		http.Error(w, "Invalid public key length", http.StatusBadRequest)
+	token, expires, err := openSession(request.PublicKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	pubXHex := request.PublicKey[:64] // First 64 characters for X
-	pubYHex := request.PublicKey[64:] // Last 64 characters for Y
 
-	// Convert public key components to big.Int
-	pubX := new(big.Int)
-	pubY := new(big.Int)
-	pubX.SetString(pubXHex, 16)
-	pubY.SetString(pubYHex, 16)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":     token,
+		"expiresAt": expires,
+	})
+}
 
-	// Reconstruct the public key
-	pubKey := &ecdsa.PublicKey{
-		Curve: elliptic.P256(),
-		X:     pubX,
-		Y:     pubY,
+// openSession starts a session for publicKey, whose holder has already been
+// verified, and returns its token and expiry
+func openSession(publicKey string) (string, time.Time, error) {
+	token, err := generateNonce()
+	if err != nil {
+		return "", time.Time{}, err
 	}
+	session := authSession{PublicKey: publicKey, Expires: time.Now().Add(authSessionTTL)}
 
-	// Decode the private key from hex
-	privKeyInt := new(big.Int)
-	privKeyInt.SetString(request.PrivateKey, 16)
+	sessionStore.Lock()
+	for key, open := range sessionStore.sessions {
+		if time.Now().After(open.Expires) {
+			delete(sessionStore.sessions, key)
+		}
+	}
+	sessionStore.sessions[token] = session
+	sessionStore.Unlock()
+	return token, session.Expires, nil
+}
 
-	// Reconstruct the private key
-	privKey := &ecdsa.PrivateKey{
-		PublicKey: *pubKey,
-		D:         privKeyInt,
+// verifySession checks that a request carries the token of an open session
+// for publicKey, see SessionHandler. Every request the backend signs for a
+// user with their wallet key is checked this way first, so knowing a public
+// key is not enough to act as its user.
+func verifySession(r *http.Request, publicKey string) error {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return fmt.Errorf("a session token is required; open a session at /auth/session")
 	}
 
-	// Verify if the public key corresponds to the private key
-	if privKey.PublicKey.X.Cmp(pubKey.X) != 0 || privKey.PublicKey.Y.Cmp(pubKey.Y) != 0 {
-		http.Error(w, "Public key does not match private key", http.StatusUnauthorized)
+	sessionStore.Lock()
+	session, ok := sessionStore.sessions[token]
+	sessionStore.Unlock()
+	if !ok || time.Now().After(session.Expires) {
+		return fmt.Errorf("session has expired; open a new one at /auth/session")
+	}
+	if session.PublicKey != publicKey {
+		return fmt.Errorf("session does not belong to %s", publicKey)
+	}
+	return nil
+}
+
+// RotateKeyHandler replaces a user's key pair with a newly generated one, for
+// when the current private key has leaked. The caller proves they hold the
+// current key by signing a challenge, see verifyChallenge. The ledger moves
+// the user's profile to the new key and stops accepting the old one, so the
+// new keys are returned once and written to the user's key file. The user's
+// posts, friends and other records are then moved in batches, see
+// continueKeyRotation; rotationDone is false when some are left to move.
+func RotateKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		PublicKey string `json:"publicKey"`
+		Signature string `json:"signature"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.PublicKey == "" {
+		http.Error(w, "publicKey is required", http.StatusBadRequest)
+		return
+	}
+	if err := verifyChallenge(request.PublicKey, "RotateUserKey", request.Signature); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
 
-	// Blockchain Verification: Check if the public key exists in the blockchain
-	response, err := contract.EvaluateTransaction("GetUser", request.PublicKey)
+	oldPrivateKey, err := walletPrivateKey(request.PublicKey)
 	if err != nil {
-		http.Error(w, "Error querying blockchain: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	wallet, err := generateWallet()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error generating wallet: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	if string(response) == "" {
-		http.Error(w, "Public key not found in blockchain", http.StatusUnauthorized)
+	// Both keys sign the same payload, proving the caller holds each of them
+	nonce, err := generateNonce()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	payload, err := transactionPayload("RotateUserKey", []string{request.PublicKey, wallet.PublicKey}, nonce)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	signature, err := SignMessage(payload, oldPrivateKey)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to sign with the current key: %v", err), http.StatusInternalServerError)
+		return
+	}
+	newSignature, err := SignMessage(payload, wallet.PrivateKey)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to sign with the new key: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	// Store keys in wallet
-	storeInWallet(request.PublicKey, request.PrivateKey)
+	result, err := contract.SubmitTransaction("RotateUserKey", request.PublicKey, wallet.PublicKey, nonce, signature, newSignature)
+	if err != nil {
+		log.Printf("Failed to rotate key of %s: %v", request.PublicKey, err)
+		http.Error(w, fmt.Sprintf("Failed to rotate key: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var updated User
+	if err := json.Unmarshal(result, &updated); err != nil {
+		http.Error(w, fmt.Sprintf("Error parsing user data: %v", err), http.StatusInternalServerError)
+		return
+	}
 
-	// If all checks pass, send a success response
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	storeInWallet(wallet.PublicKey, wallet.PrivateKey)
+	done := continueKeyRotation(wallet.PublicKey)
 
-	// Optionally respond with user data
-	var userData map[string]interface{}
-	if err := json.Unmarshal(response, &userData); err != nil {
-		http.Error(w, "Error unmarshalling blockchain data: "+err.Error(), http.StatusInternalServerError)
+	keyFilename := fmt.Sprintf("%s.key", updated.Name)
+	keyData := fmt.Sprintf("PublicKey: %s\nPrivateKey: %s", wallet.PublicKey, wallet.PrivateKey)
+	if err := os.WriteFile(keyFilename, []byte(keyData), 0600); err != nil {
+		log.Printf("Failed to write key file %s: %v", keyFilename, err)
+	}
+
+	// Sessions of the old key no longer match; the user carries on with the new one
+	token, expires, err := openSession(wallet.PublicKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(userData)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":             updated.Name,
+		"handle":           updated.Handle,
+		"publicKey":        wallet.PublicKey,
+		"privateKey":       wallet.PrivateKey,
+		"previousKeys":     updated.PreviousKeys,
+		"rotationDone":     done,
+		"sessionToken":     token,
+		"sessionExpiresAt": expires,
+	})
 }
 
-// storeInWallet adds a key pair to the in-memory wallet store
-func storeInWallet(publicKey, privateKey string) {
-	walletStore.Lock()
-	defer walletStore.Unlock()
-
-	// Check for existing entry
-	for _, wallet := range walletStore.wallets {
-		if wallet.PublicKey == publicKey {
-			fmt.Println("Key pair already exists in wallet.")
-			return
+// maxContinueBatches bounds how many batches of a long-running ledger operation
+// are submitted in one go. Whatever is left is resumed later.
+const maxContinueBatches = 100
+
+// continueKeyRotation moves the records of a rotated key to the new key, one
+// batch per transaction, until the ledger reports the rotation done. It gives
+// up after maxContinueBatches batches, or as soon as a batch moves nothing, and
+// reports whether it finished; a rotation left unfinished is resumed by the
+// user through ContinueKeyRotationHandler.
+func continueKeyRotation(publicKey string) bool {
+	moved := -1
+	for batch := 0; batch < maxContinueBatches; batch++ {
+		// A batch size of 0 leaves it to the chaincode's default
+		result, err := submitSignedTransaction(publicKey, "ContinueKeyRotation", publicKey, "0")
+		if err != nil {
+			log.Printf("Failed to continue key rotation of %s: %v", publicKey, err)
+			return false
+		}
+		var progress struct {
+			Moved int  `json:"moved"`
+			Done  bool `json:"done"`
+		}
+		if err := json.Unmarshal(result, &progress); err != nil {
+			log.Printf("Error parsing key rotation progress of %s: %v", publicKey, err)
+			return false
 		}
+		if progress.Done {
+			log.Printf("Key rotation to %s done, %d records moved", publicKey, progress.Moved)
+			return true
+		}
+		if progress.Moved <= moved {
+			log.Printf("Key rotation to %s stalled at %d records moved", publicKey, progress.Moved)
+			return false
+		}
+		moved = progress.Moved
 	}
+	log.Printf("Key rotation to %s left unfinished after %d batches", publicKey, maxContinueBatches)
+	return false
+}
 
-	// Add new wallet entry
-	walletStore.wallets = append(walletStore.wallets, Wallet{
-		PublicKey:  publicKey,
-		PrivateKey: privateKey,
+// ContinueKeyRotationHandler resumes a key rotation that RotateKeyHandler left
+// unfinished. The caller signs a challenge with the new key.
+func ContinueKeyRotationHandler(w http.ResponseWriter, r *http.Request) {
+	publicKey := mux.Vars(r)["id"]
+	if err := verifyChallengeRequest(r, publicKey, "ContinueKeyRotation"); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"publicKey":    publicKey,
+		"rotationDone": continueKeyRotation(publicKey),
 	})
+}
 
-	fmt.Println("Key pair added to wallet.")
+// DeactivateHandler closes a user's account. The caller proves they hold the
+// account's key by signing a challenge, see verifyChallenge. The ledger moves
+// the user's profile out of public view, unlists their posts in batches, see
+// continuePostListing, and detaches them from their friends, groups and
+// follows in batches, see continueErasure. The account can be reactivated
+// until reactivateBefore.
+// After that the erasure sweep erases it and unpins its content, see
+// sweepErasableUsers.
+func DeactivateHandler(w http.ResponseWriter, r *http.Request) {
+	publicKey := mux.Vars(r)["id"]
+	if err := verifyChallengeRequest(r, publicKey, "DeactivateUser"); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	result, err := submitSignedTransaction(publicKey, "DeactivateUser", publicKey)
+	if err != nil {
+		log.Printf("Failed to deactivate %s: %v", publicKey, err)
+		http.Error(w, fmt.Sprintf("Failed to deactivate account: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var deactivation Deactivation
+	if err := json.Unmarshal(result, &deactivation); err != nil {
+		http.Error(w, fmt.Sprintf("Error parsing deactivation: %v", err), http.StatusInternalServerError)
+		return
+	}
+	continuePostListing(publicKey)
+	continueErasure(publicKey, 0)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deactivation)
 }
 
-func PostHandler(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodPost:
-		// Parse multipart form data
-		err := r.ParseMultipartForm(10 << 20) // 10 MB limit
-		if err != nil {
-			http.Error(w, "Error parsing multipart form", http.StatusBadRequest)
-			log.Printf("Error parsing form: %v", err)
+// erasureSweepInterval is how often the backend looks for deactivated
+// accounts whose grace period has passed
+const erasureSweepInterval = time.Hour
+
+// startErasureSweep erases deactivated accounts once they can no longer be
+// reactivated, until ctx is done
+func startErasureSweep(ctx context.Context) {
+	ticker := time.NewTicker(erasureSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		sweepErasableUsers()
+		select {
+		case <-ctx.Done():
 			return
+		case <-ticker.C:
 		}
+	}
+}
 
-		var post Post
+// sweepErasableUsers erases every account whose grace period has passed, and
+// finishes the erasures an earlier sweep left unfinished. The ledger erases
+// the user's personal data at once and their other records in batches, see
+// continueErasure. Post listings a deactivation or reactivation left
+// unfinished are finished first.
+func sweepErasableUsers() {
+	listings, err := evaluatePublicKeys("GetUnfinishedPostListings")
+	if err != nil {
+		log.Printf("Failed to look up unfinished post listings: %v", err)
+	}
+	for _, publicKey := range listings {
+		continuePostListing(publicKey)
+	}
+
+	unfinished, err := evaluatePublicKeys("GetUnfinishedErasures")
+	if err != nil {
+		log.Printf("Failed to look up unfinished erasures: %v", err)
+	}
+	for _, publicKey := range unfinished {
+		continueErasure(publicKey, 0)
+	}
+
+	publicKeys, err := evaluatePublicKeys("GetErasableUsers")
+	if err != nil {
+		log.Printf("Failed to look up accounts to erase: %v", err)
+		return
+	}
+	for _, publicKey := range publicKeys {
+		result, err := contract.SubmitTransaction("EraseUser", publicKey)
+		if err != nil {
+			log.Printf("Failed to erase %s: %v", publicKey, err)
+			continue
+		}
+		var erasure Erasure
+		if err := json.Unmarshal(result, &erasure); err != nil {
+			log.Printf("Error parsing erasure of %s: %v", publicKey, err)
+			continue
+		}
+		unpinned := unpinContent(erasure.ContentCIDs)
+		if !erasure.Done {
+			continueErasure(publicKey, unpinned)
+			continue
+		}
+		log.Printf("Erased the account of %s, %d hashes unpinned", publicKey, unpinned)
+	}
+}
+
+// evaluatePublicKeys evaluates a query that returns a list of public keys
+func evaluatePublicKeys(function string) ([]string, error) {
+	result, err := contract.EvaluateTransaction(function)
+	if err != nil {
+		return nil, err
+	}
+	var publicKeys []string
+	if err := json.Unmarshal(result, &publicKeys); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", function, err)
+	}
+	return publicKeys, nil
+}
+
+// continueErasure removes the remaining records of an erased account, one batch
+// per transaction, until the ledger reports the erasure done. For an account
+// that is only deactivated it stops once the account is detached. The post
+// documents each batch reports are unpinned from the local IPFS node, see
+// unpinContent; unpinned counts the hashes unpinned so far. It
+// gives up after maxContinueBatches batches, or as soon as a batch removes
+// nothing; an erasure left unfinished is resumed by the next sweep.
+func continueErasure(publicKey string, unpinned int) {
+	removed := -1
+	for batch := 0; batch < maxContinueBatches; batch++ {
+		// A batch size of 0 leaves it to the chaincode's default
+		result, err := contract.SubmitTransaction("ContinueErasure", publicKey, "0")
+		if err != nil {
+			log.Printf("Failed to continue erasure of %s: %v", publicKey, err)
+			return
+		}
+		var erasure Erasure
+		if err := json.Unmarshal(result, &erasure); err != nil {
+			log.Printf("Error parsing erasure of %s: %v", publicKey, err)
+			return
+		}
+		unpinned += unpinContent(erasure.ContentCIDs)
+		if erasure.Done {
+			log.Printf("Erased the account of %s, %d records removed and %d hashes unpinned", publicKey, erasure.Removed, unpinned)
+			return
+		}
+		if erasure.Detached && !erasure.Erasing {
+			log.Printf("Detached the account of %s, %d records removed", publicKey, erasure.Removed)
+			return
+		}
+		if erasure.Removed <= removed {
+			log.Printf("Erasure of %s stalled at %d records removed", publicKey, erasure.Removed)
+			return
+		}
+		removed = erasure.Removed
+	}
+	log.Printf("Erasure of %s left unfinished after %d batches", publicKey, maxContinueBatches)
+}
+
+// continuePostListing unlists the posts of a deactivated account, or lists
+// those of a reactivated one again, one batch per transaction, until the
+// ledger reports it done. It gives up after maxContinueBatches batches, or as
+// soon as a batch makes no progress; a listing left unfinished is resumed by
+// the next erasure sweep.
+func continuePostListing(publicKey string) {
+	offset := -1
+	for batch := 0; batch < maxContinueBatches; batch++ {
+		// A batch size of 0 leaves it to the chaincode's default
+		result, err := contract.SubmitTransaction("ContinuePostListing", publicKey, "0")
+		if err != nil {
+			log.Printf("Failed to continue post listing of %s: %v", publicKey, err)
+			return
+		}
+		var listing struct {
+			Listed bool `json:"listed"`
+			Offset int  `json:"offset"`
+			Done   bool `json:"done"`
+		}
+		if err := json.Unmarshal(result, &listing); err != nil {
+			log.Printf("Error parsing post listing of %s: %v", publicKey, err)
+			return
+		}
+		if listing.Done {
+			log.Printf("Post listing of %s done, %d posts gone through (listed %t)", publicKey, listing.Offset, listing.Listed)
+			return
+		}
+		if listing.Offset <= offset {
+			log.Printf("Post listing of %s stalled at %d posts", publicKey, listing.Offset)
+			return
+		}
+		offset = listing.Offset
+	}
+	log.Printf("Post listing of %s left unfinished after %d batches", publicKey, maxContinueBatches)
+}
+
+// unpinContent unpins the post documents of an erased account from the local
+// IPFS node and returns how many hashes were unpinned. Only the documents are
+// unpinned: they hold the author's key, so no one else's post shares their
+// hash, while an image or video another user uploaded as well would. Content
+// that is not pinned is skipped.
+func unpinContent(contentCIDs []string) int {
+	if ipfsShell == nil {
+		ipfsShell = shell.NewShell("localhost:5001")
+	}
+
+	unpinned := 0
+	for _, hash := range contentCIDs {
+		if err := ipfsShell.Unpin(hash); err != nil {
+			log.Printf("Failed to unpin %s: %v", hash, err)
+			continue
+		}
+		unpinned++
+	}
+	return unpinned
+}
+
+// ReactivateHandler reopens a deactivated account within its grace period. The
+// profile and posts come back, and the handle unless someone else has taken
+// it; friendships, groups and follows do not. As with deactivation, the
+// caller signs a challenge with the account's key.
+func ReactivateHandler(w http.ResponseWriter, r *http.Request) {
+	publicKey := mux.Vars(r)["id"]
+	if err := verifyChallengeRequest(r, publicKey, "ReactivateUser"); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	result, err := submitSignedTransaction(publicKey, "ReactivateUser", publicKey)
+	if err != nil {
+		log.Printf("Failed to reactivate %s: %v", publicKey, err)
+		http.Error(w, fmt.Sprintf("Failed to reactivate account: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var user User
+	if err := json.Unmarshal(result, &user); err != nil {
+		http.Error(w, fmt.Sprintf("Error parsing user data: %v", err), http.StatusInternalServerError)
+		return
+	}
+	continuePostListing(publicKey)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// ProfileHandler returns a user's profile with their post, friend and group
+// counts (GET), or updates it on behalf of the user (PUT). Updates are sent as a
+// multipart form with displayName, bio and links fields; avatar and cover image
+// files are stored in IPFS. Fields that are left out keep their current value,
+// and an empty avatarCID or coverCID removes the image. Only the user can
+// update their profile, with a session token, see verifySession.
+func ProfileHandler(w http.ResponseWriter, r *http.Request) {
+	publicKey := mux.Vars(r)["id"]
+
+	if r.Method == http.MethodPut {
+		if err := verifySession(r, publicKey); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err := r.ParseMultipartForm(10 << 20); err != nil {
+			http.Error(w, "Error parsing multipart form", http.StatusBadRequest)
+			return
+		}
+		if err := updateProfile(publicKey, r.MultipartForm); err != nil {
+			log.Printf("Failed to update profile of %s: %v", publicKey, err)
+			http.Error(w, fmt.Sprintf("Failed to update profile: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	result, err := contract.EvaluateTransaction("GetUserProfile", publicKey)
+	if err != nil {
+		log.Printf("Failed to fetch profile: %v", err)
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	var profile UserProfile
+	if err := json.Unmarshal(result, &profile); err != nil {
+		http.Error(w, "Failed to parse profile data.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
+
+// updateProfile applies the fields of a profile form on top of the user's
+// current profile, uploading any new images to IPFS
+func updateProfile(publicKey string, form *multipart.Form) error {
+	response, err := contract.EvaluateTransaction("GetUser", publicKey)
+	if err != nil {
+		return fmt.Errorf("failed to fetch user: %v", err)
+	}
+	var user User
+	if err := json.Unmarshal(response, &user); err != nil {
+		return fmt.Errorf("failed to parse user data: %v", err)
+	}
+
+	if values, ok := form.Value["displayName"]; ok {
+		user.DisplayName = values[0]
+	}
+	if values, ok := form.Value["bio"]; ok {
+		user.Bio = values[0]
+	}
+	if values, ok := form.Value["links"]; ok {
+		user.Links = values
+	}
+	if values, ok := form.Value["avatarCID"]; ok {
+		user.AvatarCID = values[0]
+	}
+	if values, ok := form.Value["coverCID"]; ok {
+		user.CoverCID = values[0]
+	}
+
+	if ipfsShell == nil {
+		ipfsShell = shell.NewShell("localhost:5001")
+	}
+	for field, target := range map[string]*string{"avatar": &user.AvatarCID, "cover": &user.CoverCID} {
+		files := form.File[field]
+		if len(files) == 0 {
+			continue
+		}
+		file, err := files[0].Open()
+		if err != nil {
+			return fmt.Errorf("failed to open %s image: %v", field, err)
+		}
+		hash, err := ipfsShell.Add(file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("failed to store %s image in IPFS: %v", field, err)
+		}
+		*target = hash
+	}
+
+	links := user.Links
+	if links == nil {
+		links = []string{}
+	}
+	linksJSON, err := json.Marshal(links)
+	if err != nil {
+		return fmt.Errorf("failed to serialize links: %v", err)
+	}
+	_, err = submitSignedTransaction(publicKey, "UpdateProfile", publicKey, user.DisplayName, user.Bio, string(linksJSON), user.AvatarCID, user.CoverCID)
+	return err
+}
+
+func GetAllUsersHandler(w http.ResponseWriter, r *http.Request) {
+	limit, cursor, err := pageParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Evaluate transaction to get a page of users from the blockchain
+	response, err := contract.EvaluateTransaction("GetAllUsersWithPagination", limit, cursor)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error fetching users from blockchain: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Parse the JSON response into a page of users
+	var page UserPage
+	if err := json.Unmarshal(response, &page); err != nil {
+		http.Error(w, fmt.Sprintf("Error parsing users data: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Respond with the list of users
+	w.Header().Set("Content-Type", "application/json")
+	setNextCursor(w, page.Bookmark)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(page.Users)
+}
+
+// LoginHandler handles user login and stores keys in the wallet. The response
+// carries a session token for the user's writes, see verifySession.
+func LoginHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		PublicKey  string `json:"publicKey"`
+		PrivateKey string `json:"privateKey"`
+	}
+
+	// Decode the incoming request body
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Failed to decode request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Verify that the private key belongs to the public key by signing with it
+	proof := "login:" + request.PublicKey
+	signature, err := SignMessage(proof, request.PrivateKey)
+	if err != nil {
+		http.Error(w, "Invalid private key: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if valid, err := VerifySignature(proof, signature, request.PublicKey); err != nil || !valid {
+		http.Error(w, "Public key does not match private key", http.StatusUnauthorized)
+		return
+	}
+
+	// Blockchain Verification: Check if the public key exists in the blockchain
+	response, err := contract.EvaluateTransaction("GetUser", request.PublicKey)
+	if err != nil {
+		http.Error(w, "Error querying blockchain: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if string(response) == "" {
+		http.Error(w, "Public key not found in blockchain", http.StatusUnauthorized)
+		return
+	}
+
+	// Store keys in wallet
+	storeInWallet(request.PublicKey, request.PrivateKey)
+
+	// Respond with the user data, including the owner's private fields, and a
+	// session for the user's further requests, see verifySession
+	userData, err := getUserAsOwner(request.PublicKey)
+	if err != nil {
+		http.Error(w, "Error fetching user data from blockchain: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	token, expires, err := openSession(request.PublicKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		*User
+		SessionToken     string    `json:"sessionToken"`
+		SessionExpiresAt time.Time `json:"sessionExpiresAt"`
+	}{userData, token, expires})
+}
+
+// storeInWallet adds a key pair to the in-memory wallet store
+func storeInWallet(publicKey, privateKey string) {
+	walletStore.Lock()
+	defer walletStore.Unlock()
+
+	// Check for existing entry
+	for _, wallet := range walletStore.wallets {
+		if wallet.PublicKey == publicKey {
+			fmt.Println("Key pair already exists in wallet.")
+			return
+		}
+	}
+
+	// Add new wallet entry
+	walletStore.wallets = append(walletStore.wallets, Wallet{
+		PublicKey:  publicKey,
+		PrivateKey: privateKey,
+	})
+
+	fmt.Println("Key pair added to wallet.")
+}
+
+// walletPrivateKey returns the private key held in the wallet for publicKey
+func walletPrivateKey(publicKey string) (string, error) {
+	walletStore.Lock()
+	defer walletStore.Unlock()
+
+	for _, wallet := range walletStore.wallets {
+		if wallet.PublicKey == publicKey {
+			return wallet.PrivateKey, nil
+		}
+	}
+	return "", fmt.Errorf("no key pair in wallet for public key %s; log in first", publicKey)
+}
+
+func PostHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		// Parse multipart form data
+		err := r.ParseMultipartForm(10 << 20) // 10 MB limit
+		if err != nil {
+			http.Error(w, "Error parsing multipart form", http.StatusBadRequest)
+			log.Printf("Error parsing form: %v", err)
+			return
+		}
+
+		var post Post
 		// Extract user and wallet details
 		post.User.Name = r.FormValue("user.name")
 		post.Wallet.PublicKey = r.FormValue("wallet.publicKey")
@@ -526,9 +1457,14 @@ func PostHandler(w http.ResponseWriter, r *http.Request) {
 			log.Println("Missing user public key.")
 			return
 		}
+		if err := verifySession(r, post.Wallet.PublicKey); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
 
-		// Extract post content
+		// Extract post content and the hashtags and handles it mentions
 		post.Content = r.FormValue("content")
+		post.Hashtags, post.Mentions = extractTopics(post.Content)
 
 		// Validate that at least one of content, photo, or video is provided
 		hasPhoto := r.MultipartForm.File["photo"] != nil
@@ -554,10 +1490,7 @@ func PostHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Generate a unique post ID and timestamp
-		post.ID = int(time.Now().Unix())
-		postID := strconv.Itoa(post.ID)
-		post.Timestamp = time.Now()
+		// The post ID and timestamp are assigned by the ledger once the post is submitted
 
 		// Ensure IPFS is initialized
 		if ipfsShell == nil {
@@ -604,7 +1537,7 @@ func PostHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Serialize the Post struct to JSON and upload it to IPFS
-		postJSON, err := json.Marshal(post)
+		postJSON, err := marshalPostDocument(&post)
 		if err != nil {
 			http.Error(w, "Failed to marshal post data", http.StatusInternalServerError)
 			log.Printf("Failed to marshal post: %v", err)
@@ -620,15 +1553,16 @@ func PostHandler(w http.ResponseWriter, r *http.Request) {
 
 		post.IPFSHASH = ipfsHash
 		// Submit the post to the blockchain
-		result, err := submitPostWithRetry(post.Wallet.PublicKey, post.IPFSHASH, postID)
+		result, err := submitPostWithRetry(post.Wallet.PublicKey, post.IPFSHASH, post.Hashtags, post.Mentions)
 		if err != nil {
 			log.Printf("Failed to store post in blockchain: %v", err)
 			http.Error(w, fmt.Sprintf("Failed to store post in blockchain: %v", err), http.StatusInternalServerError)
 			return
 		}
 
-		// Successfully created the post
-		log.Printf("Post created successfully. Blockchain response: %s", string(result))
+		// Successfully created the post; the ledger returns its ID
+		post.ID = string(result)
+		log.Printf("Post %s created successfully", post.ID)
 
 		// Return success response with post ID and IPFS hash
 		w.Header().Set("Content-Type", "application/json")
@@ -648,23 +1582,29 @@ func PostHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		result, err := contract.EvaluateTransaction("GetPostsByUser", publicKey)
+		limit, cursor, err := pageParams(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		result, err := contract.EvaluateTransaction("GetPostsByUserWithPagination", publicKey, limit, cursor)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Failed to fetch posts: %v", err), http.StatusInternalServerError)
 			log.Printf("Blockchain query error for posts by user: %v", err)
 			return
 		}
 
-		var postHashes []string
-		if err := json.Unmarshal(result, &postHashes); err != nil {
+		var page PostPage
+		if err := json.Unmarshal(result, &page); err != nil {
 			http.Error(w, "Failed to parse post data.", http.StatusInternalServerError)
 			log.Printf("Error unmarshalling post hashes: %v", err)
 			return
 		}
 
-		var posts []Post
-		for _, hash := range postHashes {
-			post, err := getPostFromIPFS(hash)
+		posts := []Post{}
+		for _, hash := range page.Posts {
+			post, err := hydratePost(hash, r.URL.Query().Get("viewer"))
 			if err != nil {
 				log.Printf("Failed to fetch post from IPFS: %v", err)
 				continue
@@ -673,61 +1613,157 @@ func PostHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		w.Header().Set("Content-Type", "application/json")
+		setNextCursor(w, page.Bookmark)
 		json.NewEncoder(w).Encode(posts)
 	}
 }
 
 func FeedHandler(w http.ResponseWriter, r *http.Request) {
-	// Fetch all post hashes from the blockchain (now using PublicKey)
-	result, err := contract.EvaluateTransaction("GetAllPosts")
+	limit, cursor, err := pageParams(r)
 	if err != nil {
-		log.Printf("Error calling GetAllPostsByPublicKey: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to fetch posts: %v", err), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("Raw result from GetAllPostsByPublicKey: %s", string(result))
-
-	if len(result) == 0 || string(result) == "null" {
-		log.Println("GetAllPostsByPublicKey returned null or empty result")
-		json.NewEncoder(w).Encode([]Post{})
+	// With publicKey the feed is that user's home timeline, and their blocks
+	// apply. Otherwise it lists every post, or with mode=following the posts of
+	// the users the viewer follows and with mode=hashtags the posts tagged with
+	// the hashtags the viewer follows.
+	viewer := r.URL.Query().Get("viewer")
+	mode := r.URL.Query().Get("mode")
+	if publicKey := r.URL.Query().Get("publicKey"); publicKey != "" {
+		viewer = publicKey
+		if mode == "" {
+			mode = "home"
+		}
+	}
+	var result []byte
+	switch mode {
+	case "", "all":
+		result, err = contract.EvaluateTransaction("GetAllPostsWithPagination", limit, cursor)
+	case "home", "following", "hashtags":
+		if viewer == "" {
+			http.Error(w, fmt.Sprintf("publicKey is required for the %s feed", mode), http.StatusBadRequest)
+			return
+		}
+		function := map[string]string{
+			"home":      "GetHomeFeed",
+			"following": "GetFollowingFeed",
+			"hashtags":  "GetHashtagFeed",
+		}[mode]
+		result, err = contract.EvaluateTransaction(function, viewer, limit, cursor)
+	default:
+		http.Error(w, fmt.Sprintf("Unknown feed mode %q", mode), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching feed: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to fetch posts: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	var postHashes []string
-	if err := json.Unmarshal(result, &postHashes); err != nil {
+	var page PostPage
+	if err := json.Unmarshal(result, &page); err != nil {
 		log.Printf("Error unmarshalling post hashes: %v", err)
 		http.Error(w, "Failed to parse post data.", http.StatusInternalServerError)
 		return
 	}
 
-	log.Printf("Unmarshalled %d post hashes", len(postHashes))
+	log.Printf("Unmarshalled %d post hashes", len(page.Posts))
 
-	var posts []Post
-	for _, hash := range postHashes {
-		post, err := getPostFromIPFS(hash)
+	// Leave out the posts of users the viewer has blocked
+	blocked := map[string]bool{}
+	if viewer != "" {
+		blocked, err = blockedUsers(viewer)
 		if err != nil {
-			log.Printf("Failed to fetch post from IPFS: %v", err)
-			continue
+			log.Printf("Failed to fetch blocked users: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to fetch blocked users: %v", err), http.StatusInternalServerError)
+			return
 		}
-		posts = append(posts, *post)
 	}
 
+	posts := hydratePosts(page.Posts, blocked, viewer)
 	log.Printf("Retrieved %d posts from IPFS", len(posts))
 
 	w.Header().Set("Content-Type", "application/json")
+	setNextCursor(w, page.Bookmark)
 	json.NewEncoder(w).Encode(posts)
 }
 
-func submitPostWithRetry(publicKey string, ipfsHash string, postID string) ([]byte, error) {
-	maxRetries := 4
-	var lastErr error
-
-	for attempt := 1; attempt <= maxRetries; attempt++ {
-		// Create a new transaction proposal
-		transaction, err := contract.NewProposal(
-			"CreatePost",
-			client.WithArguments(publicKey, ipfsHash, postID),
+// hydratePosts loads the posts of a page as seen by viewer, leaving out those
+// that fail to load and those by, or resharing, users in blocked
+func hydratePosts(hashes []string, blocked map[string]bool, viewer string) []Post {
+	posts := []Post{}
+	for _, hash := range hashes {
+		post, err := hydratePost(hash, viewer)
+		if err != nil {
+			log.Printf("Failed to fetch post from IPFS: %v", err)
+			continue
+		}
+		if blocked[post.User.PublicKey] || (post.SharedPost != nil && blocked[post.SharedPost.User.PublicKey]) {
+			continue
+		}
+		posts = append(posts, *post)
+	}
+	return posts
+}
+
+// extractTopics returns the distinct hashtags and handles mentioned in a post's
+// text, without their '#' and '@', keeping those the ledger would accept
+func extractTopics(content string) ([]string, []string) {
+	hashtags := []string{}
+	seen := map[string]bool{}
+	for _, match := range hashtagPattern.FindAllStringSubmatch(content, -1) {
+		tag := strings.ToLower(match[1])
+		if len(tag) > maxHashtagLength || seen[tag] || len(hashtags) == maxHashtagsPerPost {
+			continue
+		}
+		seen[tag] = true
+		hashtags = append(hashtags, tag)
+	}
+
+	mentions := []string{}
+	seen = map[string]bool{}
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		// A handle may contain '.', but not end a sentence with one
+		handle := strings.ToLower(strings.TrimRight(match[1], ".-"))
+		if handle == "" || seen[handle] || len(mentions) == maxMentionsPerPost {
+			continue
+		}
+		seen[handle] = true
+		mentions = append(mentions, handle)
+	}
+	return hashtags, mentions
+}
+
+func submitPostWithRetry(publicKey string, ipfsHash string, hashtags []string, mentions []string) ([]byte, error) {
+	maxRetries := 4
+	var lastErr error
+
+	hashtagsJSON, err := json.Marshal(hashtags)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize hashtags: %v", err)
+	}
+	mentionsJSON, err := json.Marshal(mentions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize mentions: %v", err)
+	}
+
+	// Sign once up front; the nonce is only consumed when a submission commits
+	privateKey, err := walletPrivateKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	signedArgs, err := signedArguments(privateKey, "CreatePost", publicKey, ipfsHash, string(hashtagsJSON), string(mentionsJSON))
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 1; attempt <= maxRetries; attempt++ {
+		// Create a new transaction proposal
+		transaction, err := contract.NewProposal(
+			"CreatePost",
+			client.WithArguments(signedArgs...),
 		)
 		if err != nil {
 			log.Printf("Failed to create transaction proposal: %v", err)
@@ -748,10 +1784,10 @@ func submitPostWithRetry(publicKey string, ipfsHash string, postID string) ([]by
 		}
 
 		// Submit endorsed transaction
-		result, err := endorsed.Submit()
+		_, err = endorsed.Submit()
 		if err == nil {
-			// Assuming the commit has some byte data to return (adjust based on actual commit object)
-			return []byte(result.TransactionID()), nil
+			// The chaincode returns the ID it assigned to the post
+			return endorsed.Result(), nil
 		}
 
 		log.Printf("Transaction submission failed: %v", err)
@@ -781,6 +1817,29 @@ func verifyUserExists(publicKey string) (bool, error) {
 	return isValid, nil
 }
 
+// pageParams reads the limit and cursor query parameters shared by the list endpoints
+func pageParams(r *http.Request) (string, string, error) {
+	limit := defaultPageLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			return "", "", fmt.Errorf("limit must be a positive integer")
+		}
+		limit = parsed
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+	return strconv.Itoa(limit), r.URL.Query().Get("cursor"), nil
+}
+
+// setNextCursor tells the client where the next page starts; it is omitted on the last page
+func setNextCursor(w http.ResponseWriter, cursor string) {
+	if cursor != "" {
+		w.Header().Set("X-Next-Cursor", cursor)
+	}
+}
+
 // Helper function to retrieve a post from IPFS by its hash
 func getPostFromIPFS(ipfsHash string) (*Post, error) {
 	// Get the data from IPFS
@@ -796,301 +1855,1094 @@ func getPostFromIPFS(ipfsHash string) (*Post, error) {
 		return nil, fmt.Errorf("failed to read IPFS data: %v", err)
 	}
 
-	// Unmarshal the JSON data into a Post struct
+	// Unmarshal the JSON data into a Post struct. Older documents carry the
+	// numeric ID the backend used to assign; the ID now comes from the ledger.
 	var post Post
-	if err := json.Unmarshal(data, &post); err != nil {
+	document := struct {
+		*Post
+		ID json.RawMessage `json:"id"`
+	}{Post: &post}
+	if err := json.Unmarshal(data, &document); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal post data: %v", err)
 	}
 
 	return &post, nil
 }
 
-func ReactionHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("ReactionHandler invoked")
+// marshalPostDocument encodes a post as the document stored in IPFS. The
+// timestamp is left out: the ledger stamps each post with the time of its
+// transaction, and reads take it from there.
+func marshalPostDocument(post *Post) ([]byte, error) {
+	document := struct {
+		*Post
+		Timestamp *time.Time `json:"timestamp,omitempty"`
+	}{Post: post}
+	return json.Marshal(document)
+}
 
-	// Ensure the method is POST
-	if r.Method != http.MethodPost {
-		log.Printf("Invalid HTTP method: %s. Expected POST.", r.Method)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// getLedgerPost reads the on-chain record of a live post
+func getLedgerPost(ipfsHash string) (*LedgerPost, error) {
+	result, err := contract.EvaluateTransaction("GetPost", ipfsHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch post %s from blockchain: %v", ipfsHash, err)
+	}
+	var ledgerPost LedgerPost
+	if err := json.Unmarshal(result, &ledgerPost); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal post %s: %v", ipfsHash, err)
 	}
+	if ledgerPost.Deleted {
+		return nil, fmt.Errorf("post %s has been deleted", ipfsHash)
+	}
+	return &ledgerPost, nil
+}
 
-	// Extract the post ID from the URL
-	parts := strings.Split(r.URL.Path, "/")
-	if len(parts) < 3 {
-		log.Println("Invalid URL format. Expected: /post/<postID>/react")
-		http.Error(w, "Invalid URL format", http.StatusBadRequest)
-		return
+// currentContentCID returns the IPFS hash of the latest version of a post.
+// Edited posts keep their original hash as their ID.
+func (p *LedgerPost) currentContentCID() string {
+	if len(p.Versions) == 0 {
+		return p.ContentCID
 	}
-	postID := parts[2]
-	log.Printf("Post ID extracted: %s", postID)
+	return p.Versions[len(p.Versions)-1].ContentCID
+}
 
-	// Parse the request body
-	var request ReactionRequest
-	decoder := json.NewDecoder(r.Body)
-	decoder.DisallowUnknownFields() // Prevent unknown fields in JSON
-	if err := decoder.Decode(&request); err != nil {
-		log.Printf("Error decoding request body: %v", err)
-		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
-		return
+// hydratePost loads a post from IPFS and overlays the counters kept on the ledger.
+// Reshares also carry the post they credit, so the feed can attribute them. The
+// post's reactions hold only viewer's reaction, if viewer is not empty. Deleted
+// and hidden posts are not returned.
+func hydratePost(ipfsHash string, viewer string) (*Post, error) {
+	result, err := contract.EvaluateTransaction("GetPostView", ipfsHash, viewer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch post %s from blockchain: %v", ipfsHash, err)
 	}
-	log.Printf("Request parsed: UserPublicKey=%s, ReactionType=%s", request.UserPublicKey, request.ReactionType)
+	var view PostView
+	if err := json.Unmarshal(result, &view); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal post %s: %v", ipfsHash, err)
+	}
+	if view.Post.Deleted {
+		return nil, fmt.Errorf("post %s has been deleted", ipfsHash)
+	}
+	if view.Post.Hidden {
+		return nil, fmt.Errorf("post %s has been hidden by moderators", ipfsHash)
+	}
+	return hydratePostView(&view)
+}
 
-	// Validate user public key
-	if request.UserPublicKey == "" {
-		log.Println("UserPublicKey is missing in the request")
-		http.Error(w, "User public key is required", http.StatusBadRequest)
+// hydratePostView loads the content of a post whose ledger view has already
+// been read. It makes no further ledger queries.
+func hydratePostView(view *PostView) (*Post, error) {
+	ledgerPost := view.Post
+	post, err := getPostFromIPFS(ledgerPost.currentContentCID())
+	if err != nil {
+		return nil, err
+	}
+	post.ID = ledgerPost.ID
+	post.Timestamp = time.Unix(ledgerPost.Timestamp, 0)
+	post.IPFSHASH = ledgerPost.ContentCID
+	post.Edited = len(ledgerPost.Versions) > 1
+
+	post.ShareCount = ledgerPost.ShareCount
+	post.CommentCount = ledgerPost.CommentCount
+	post.SharedPostCID = ledgerPost.SharedPostCID
+
+	post.Reactions = view.Reactions.Reactions
+	post.ReactionCounts = view.Reactions.Counts
+	post.ReactionCount = view.Reactions.Total
+
+	if view.SharedPost != nil {
+		// Reshares always credit an original post, so this recurses at most once
+		post.SharedPost, err = hydratePostView(view.SharedPost)
+		if err != nil {
+			log.Printf("Failed to fetch shared post %s: %v", ledgerPost.SharedPostCID, err)
+		}
+	}
+
+	return post, nil
+}
+
+// EditPostHandler replaces the text of a post on behalf of its author. The edited
+// document is stored in IPFS as a new version; media and the post ID are kept.
+func EditPostHandler(w http.ResponseWriter, r *http.Request) {
+	postHash, err := getPostHashByID(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Post not found", http.StatusNotFound)
 		return
 	}
 
-	// Validate reaction type
-	validReactions := map[string]bool{
-		"like":  true,
-		"love":  true,
-		"laugh": true,
-		"angry": true,
-		"sad":   true,
+	var request struct {
+		PublicKey string `json:"publicKey"`
+		Content   string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.PublicKey == "" {
+		http.Error(w, "User public key is required.", http.StatusBadRequest)
+		return
 	}
-	if !validReactions[request.ReactionType] {
-		log.Printf("Invalid ReactionType: %s", request.ReactionType)
-		http.Error(w, "Invalid reaction type", http.StatusBadRequest)
+	if err := verifySession(r, request.PublicKey); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
 
-	// Retrieve post hash using postID
-	postHash, err := getPostHashByID(postID)
+	// Start from the authored document of the latest version, without ledger counters
+	ledgerPost, err := getLedgerPost(postHash)
 	if err != nil {
-		log.Printf("Failed to retrieve post hash for PostID=%s: %v", postID, err)
-		http.Error(w, "Post not found", http.StatusNotFound)
+		log.Printf("Failed to fetch post %s: %v", postHash, err)
+		http.Error(w, "Failed to retrieve post data", http.StatusInternalServerError)
 		return
 	}
-	log.Printf("Post hash retrieved: %s", postHash)
-
-	// Retrieve the post from IPFS
-	post, err := getPostFromIPFS(postHash)
+	edited, err := getPostFromIPFS(ledgerPost.currentContentCID())
 	if err != nil {
-		log.Printf("Failed to fetch post from IPFS. PostHash=%s, Error=%v", postHash, err)
+		log.Printf("Failed to fetch post %s from IPFS: %v", postHash, err)
 		http.Error(w, "Failed to retrieve post data", http.StatusInternalServerError)
 		return
 	}
-
-	// Update the reaction for the user
-	if post.Reactions == nil {
-		post.Reactions = make(map[string]string)
+	edited.Content = request.Content
+	edited.Hashtags, edited.Mentions = extractTopics(edited.Content)
+	hashtagsJSON, err := json.Marshal(edited.Hashtags)
+	if err != nil {
+		http.Error(w, "Failed to serialize hashtags", http.StatusInternalServerError)
+		return
 	}
-	oldReaction := post.Reactions[request.UserPublicKey]
-	post.Reactions[request.UserPublicKey] = request.ReactionType
-
-	// Recalculate reaction counts
-	if post.ReactionCounts == nil {
-		post.ReactionCounts = make(map[string]int)
+	mentionsJSON, err := json.Marshal(edited.Mentions)
+	if err != nil {
+		http.Error(w, "Failed to serialize mentions", http.StatusInternalServerError)
+		return
 	}
-	if oldReaction != "" {
-		post.ReactionCounts[oldReaction]--
+	editedJSON, err := marshalPostDocument(edited)
+	if err != nil {
+		http.Error(w, "Failed to marshal post data", http.StatusInternalServerError)
+		return
 	}
-	post.ReactionCounts[request.ReactionType]++
-	post.ReactionCount = len(post.Reactions)
-
-	log.Printf("Updated reactions for post %d: %+v", post.ID, post.ReactionCounts)
-
-	// Serialize the updated post to JSON and store it back in IPFS
-	postJSON, err := json.Marshal(post)
+	editedHash, err := ipfsShell.Add(bytes.NewReader(editedJSON))
 	if err != nil {
-		log.Printf("Failed to marshal updated post. Error=%v", err)
-		http.Error(w, "Failed to update post data", http.StatusInternalServerError)
+		log.Printf("IPFS storage error: %v", err)
+		http.Error(w, "Failed to store post in IPFS. Please try again later.", http.StatusInternalServerError)
 		return
 	}
 
-	updatedIPFSHash, err := ipfsShell.Add(strings.NewReader(string(postJSON)))
+	_, err = submitSignedTransaction(request.PublicKey, "EditPost", postHash, request.PublicKey, editedHash, string(hashtagsJSON), string(mentionsJSON))
 	if err != nil {
-		log.Printf("Failed to store updated post in IPFS. Error=%v", err)
-		http.Error(w, "Failed to store updated post in IPFS", http.StatusInternalServerError)
+		log.Printf("Failed to edit post: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to edit post: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	log.Printf("Updated post stored in IPFS with hash: %s", updatedIPFSHash)
-
-	// Respond with the updated post
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(post); err != nil {
-		log.Printf("Failed to encode updated post response. Error=%v", err)
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	post, err := hydratePost(postHash, request.PublicKey)
+	if err != nil {
+		http.Error(w, "Failed to retrieve post data", http.StatusInternalServerError)
 		return
 	}
-
-	log.Println("Reaction successfully added and updated post returned.")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(post)
 }
 
-func getPostHashByID(postID string) (string, error) {
-	// Step 1: Query the blockchain for all posts
-	result, err := contract.EvaluateTransaction("GetAllUserPosts")
+// DeletePostHandler deletes a post on behalf of its author
+func DeletePostHandler(w http.ResponseWriter, r *http.Request) {
+	postHash, err := getPostHashByID(mux.Vars(r)["id"])
 	if err != nil {
-		return "", fmt.Errorf("failed to evaluate transaction: %v", err)
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
 	}
 
-	// Step 2: Parse the result into a map of posts grouped by public key
-	var allPosts map[string][]string
-	if err := json.Unmarshal(result, &allPosts); err != nil {
-		return "", fmt.Errorf("failed to unmarshal result: %v", err)
+	var request struct {
+		PublicKey string `json:"publicKey"`
 	}
-	log.Printf("All Posts: %+v", allPosts)
-
-	// Convert postID to integer
-	postIDInt, err := strconv.Atoi(postID)
-	if err != nil {
-		return "", fmt.Errorf("invalid postID format: %v", err)
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.PublicKey == "" {
+		http.Error(w, "User public key is required.", http.StatusBadRequest)
+		return
+	}
+	if err := verifySession(r, request.PublicKey); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
 	}
 
-	// Step 3: Iterate through all users' posts
-	for _, postHashes := range allPosts {
-		for _, hash := range postHashes {
-			// Call getPostFromIPFS function to fetch the post from IPFS
-			post, err := getPostFromIPFS(hash)
-			if err != nil {
-				log.Printf("Failed to retrieve post for hash %s: %v", hash, err)
-				continue // Skip this hash if retrieval fails
-			}
-
-			log.Printf("Checking post with POST ID: %d", post.ID)
-
-			// Step 4: Match the postID
-			if post.ID == postIDInt {
-				if hash != "" {
-					return hash, nil
-				}
-				return "", fmt.Errorf("post found but IPFS hash is empty for post ID: %s", postID)
-			}
-		}
+	_, err = submitSignedTransaction(request.PublicKey, "DeletePost", postHash, request.PublicKey)
+	if err != nil {
+		log.Printf("Failed to delete post: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to delete post: %v", err), http.StatusInternalServerError)
+		return
 	}
 
-	// If no matching post is found, return an error
-	return "", fmt.Errorf("no post found with post ID: %s", postID)
+	w.WriteHeader(http.StatusNoContent)
 }
 
-// SearchUserByName searches the blockchain for a user by their name and retrieves their public key.
-func SearchUserByName(name string, contract *client.Contract) (string, error) {
-	// Query the blockchain for user details.
-	queryResult, err := contract.EvaluateTransaction("QueryUserByName", name)
+// PostHistoryHandler lists every version of a post, oldest first, with its content
+func PostHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	postHash, err := getPostHashByID(mux.Vars(r)["id"])
 	if err != nil {
-		return "", fmt.Errorf("failed to query user: %v", err)
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
 	}
 
-	// Parse the query result.
-	var user User
-	if err := json.Unmarshal(queryResult, &user); err != nil {
-		return "", fmt.Errorf("failed to unmarshal query result: %v", err)
+	result, err := contract.EvaluateTransaction("GetPostHistory", postHash)
+	if err != nil {
+		log.Printf("Failed to fetch post history: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to fetch post history: %v", err), http.StatusInternalServerError)
+		return
 	}
-
-	if user.PublicKey == "" {
-		return "", fmt.Errorf("user not found or public key missing")
+	var versions []PostVersion
+	if err := json.Unmarshal(result, &versions); err != nil {
+		http.Error(w, "Failed to parse post history.", http.StatusInternalServerError)
+		return
 	}
 
-	return user.PublicKey, nil
-}
-
-// Message represents an individual message in a chat
-type Message struct {
-	IPFSHash  string    `json:"ipfsHash"`  // IPFS hash of the encrypted message
-	Signature string    `json:"signature"` // Signature for authenticity
-	Sender    string    `json:"sender"`    // Sender's public key
-	Receiver  string    `json:"receiver"`  // Receiver's public key
-	Timestamp time.Time `json:"timestamp"` // Time of message
-}
+	revisions := make([]PostRevision, 0, len(versions))
+	for _, version := range versions {
+		revision := PostRevision{PostVersion: version}
+		post, err := getPostFromIPFS(version.ContentCID)
+		if err != nil {
+			log.Printf("Failed to fetch post version %s from IPFS: %v", version.ContentCID, err)
+		} else {
+			revision.Post = post
+		}
+		revisions = append(revisions, revision)
+	}
 
-// Chat represents a chat between two users
-type Chat struct {
-	Participants [2]string `json:"participants"` // Public keys of the two participants
-	Messages     []Message `json:"messages"`     // List of messages exchanged
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(revisions)
 }
 
-// EncryptMessage encrypts plaintext using ECIES with AES-GCM
-func EncryptMessage(plainText string, publicKey string) (string, error) {
-	// Decode the public key from hex
-	log.Printf("Received public key: %s", plainText)
-	decodedKey, err := hex.DecodeString(publicKey)
-	log.Printf("decoded key %s", decodedKey)
+// ShareHandler reshares a post, optionally with a quote
+func ShareHandler(w http.ResponseWriter, r *http.Request) {
+	postHash, err := getPostHashByID(mux.Vars(r)["id"])
 	if err != nil {
-		return "", fmt.Errorf("invalid public key encoding: %v", err)
+		log.Printf("Failed to retrieve post hash: %v", err)
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
 	}
 
-	// Parse the public key
-	pubKey, err := x509.ParsePKIXPublicKey(decodedKey)
-	if err != nil {
-		return "", fmt.Errorf("invalid public key format: %v", err)
+	var request struct {
+		PublicKey string `json:"publicKey"`
+		Name      string `json:"name"`
+		Content   string `json:"content"` // Optional quote
 	}
-
-	ecdsaPubKey, ok := pubKey.(*ecdsa.PublicKey)
-	if !ok {
-		return "", fmt.Errorf("invalid public key type")
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
 	}
-
-	// Generate an ephemeral private key
-	ephemeralPrivKey, err := ecdsa.GenerateKey(ecdsaPubKey.Curve, rand.Reader)
-	if err != nil {
-		return "", fmt.Errorf("failed to generate ephemeral key: %v", err)
+	if request.PublicKey == "" {
+		http.Error(w, "User public key is required.", http.StatusBadRequest)
+		return
 	}
-
-	// Compute shared secret
-	sharedX, _ := ecdsaPubKey.Curve.ScalarMult(ecdsaPubKey.X, ecdsaPubKey.Y, ephemeralPrivKey.D.Bytes())
-
-	// Derive AES key from shared secret (use the first 32 bytes of sharedX)
-	aesKey := sharedX.Bytes()
-	if len(aesKey) > 32 {
-		aesKey = aesKey[:32]
+	if err := verifySession(r, request.PublicKey); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
 	}
 
-	// Create AES-GCM cipher
-	block, err := aes.NewCipher(aesKey)
+	// The reshare is a post of its own whose IPFS document holds the quote
+	share := Post{
+		User:          User{Name: request.Name, PublicKey: request.PublicKey},
+		Wallet:        Wallet{PublicKey: request.PublicKey},
+		Content:       request.Content,
+		SharedPostCID: postHash,
+	}
+	shareJSON, err := marshalPostDocument(&share)
 	if err != nil {
-		return "", fmt.Errorf("failed to create AES cipher: %v", err)
+		http.Error(w, "Failed to marshal post data", http.StatusInternalServerError)
+		return
 	}
-	aesGCM, err := cipher.NewGCM(block)
+	shareHash, err := ipfsShell.Add(bytes.NewReader(shareJSON))
 	if err != nil {
-		return "", fmt.Errorf("failed to create AES-GCM: %v", err)
+		log.Printf("IPFS storage error: %v", err)
+		http.Error(w, "Failed to store post in IPFS. Please try again later.", http.StatusInternalServerError)
+		return
 	}
 
-	// Generate a random nonce
-	nonce := make([]byte, aesGCM.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return "", fmt.Errorf("failed to generate nonce: %v", err)
+	result, err := submitSignedTransaction(request.PublicKey, "SharePost", request.PublicKey, postHash, shareHash)
+	if err != nil {
+		log.Printf("Failed to share post: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to share post: %v", err), http.StatusInternalServerError)
+		return
 	}
 
-	// Encrypt the plaintext
-	cipherText := aesGCM.Seal(nil, nonce, []byte(plainText), nil)
-
-	// Marshal ephemeral public key
-	ephemeralPubKey := elliptic.Marshal(ephemeralPrivKey.Curve, ephemeralPrivKey.PublicKey.X, ephemeralPrivKey.PublicKey.Y)
-
-	// Combine ephemeral public key, nonce, and ciphertext
-	encryptedMessage := append(ephemeralPubKey, append(nonce, cipherText...)...)
-
-	log.Printf("Encrypted message length: %d", len(encryptedMessage)) // Add log to check length
-
-	return hex.EncodeToString(encryptedMessage), nil
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  "Post shared successfully.",
+		"postID":   string(result),
+		"ipfsHASH": shareHash,
+	})
 }
 
-// DecryptMessage decrypts the encrypted message using ECIES with AES-GCM
-func DecryptMessage(encryptedText string, privateKey string) (string, error) {
-	// Decode the private key
-	decodedKey, err := hex.DecodeString(privateKey)
+// SharesHandler lists who shared a post
+func SharesHandler(w http.ResponseWriter, r *http.Request) {
+	postHash, err := getPostHashByID(mux.Vars(r)["id"])
 	if err != nil {
-		return "", fmt.Errorf("invalid private key encoding: %v", err)
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
 	}
 
-	// Parse the private key
-	privKey, err := x509.ParseECPrivateKey(decodedKey)
+	limit, cursor, err := pageParams(r)
 	if err != nil {
-		return "", fmt.Errorf("invalid private key format: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	// Decode the encrypted message
-	encrypted, err := hex.DecodeString(encryptedText)
+	result, err := contract.EvaluateTransaction("GetPostShares", postHash, limit, cursor)
 	if err != nil {
-		return "", fmt.Errorf("failed to decode encrypted message: %v", err)
+		log.Printf("Failed to fetch shares: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to fetch shares: %v", err), http.StatusInternalServerError)
+		return
 	}
 
-	// Extract curve details
-	curve := privKey.Curve
+	var page SharePage
+	if err := json.Unmarshal(result, &page); err != nil {
+		http.Error(w, "Failed to parse share data.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	setNextCursor(w, page.Bookmark)
+	json.NewEncoder(w).Encode(page.Shares)
+}
+
+// ReactionHandler records (POST) or withdraws (DELETE) a user's reaction to a post
+// on the ledger and responds with the post and its updated reaction tallies
+func ReactionHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("ReactionHandler invoked")
+
+	// Extract the post ID from the URL
+	postID := mux.Vars(r)["id"]
+	log.Printf("Post ID extracted: %s", postID)
+
+	// Parse the request body
+	var request ReactionRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields() // Prevent unknown fields in JSON
+	if err := decoder.Decode(&request); err != nil {
+		log.Printf("Error decoding request body: %v", err)
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("Request parsed: UserPublicKey=%s, ReactionType=%s", request.UserPublicKey, request.ReactionType)
+
+	// Validate user public key
+	if request.UserPublicKey == "" {
+		log.Println("UserPublicKey is missing in the request")
+		http.Error(w, "User public key is required", http.StatusBadRequest)
+		return
+	}
+	if err := verifySession(r, request.UserPublicKey); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// Retrieve post hash using postID
+	postHash, err := getPostHashByID(postID)
+	if err != nil {
+		log.Printf("Failed to retrieve post hash for PostID=%s: %v", postID, err)
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("Post hash retrieved: %s", postHash)
+
+	switch r.Method {
+	case http.MethodPost:
+		// Validate reaction type against the types configured on the ledger
+		validReactions, err := reactionTypes()
+		if err != nil {
+			log.Printf("Failed to fetch reaction types: %v", err)
+			http.Error(w, "Failed to fetch reaction types", http.StatusInternalServerError)
+			return
+		}
+		valid := false
+		for _, reactionType := range validReactions {
+			if reactionType == request.ReactionType {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			log.Printf("Invalid ReactionType: %s", request.ReactionType)
+			http.Error(w, "Invalid reaction type", http.StatusBadRequest)
+			return
+		}
+
+		_, err = submitSignedTransaction(request.UserPublicKey, "AddReaction", postHash, request.UserPublicKey, request.ReactionType)
+		if err != nil {
+			log.Printf("Failed to add reaction: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to add reaction: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+	case http.MethodDelete:
+		_, err = submitSignedTransaction(request.UserPublicKey, "RemoveReaction", postHash, request.UserPublicKey)
+		if err != nil {
+			log.Printf("Failed to remove reaction: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to remove reaction: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Respond with the updated post
+	post, err := hydratePost(postHash, request.UserPublicKey)
+	if err != nil {
+		log.Printf("Failed to fetch updated post. PostHash=%s, Error=%v", postHash, err)
+		http.Error(w, "Failed to retrieve post data", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(post); err != nil {
+		log.Printf("Failed to encode updated post response. Error=%v", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	log.Println("Reaction successfully updated and post returned.")
+}
+
+// reactionTypes returns the reaction types configured on the ledger
+func reactionTypes() ([]string, error) {
+	result, err := contract.EvaluateTransaction("GetReactionTypes")
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate transaction: %v", err)
+	}
+
+	var types []string
+	if err := json.Unmarshal(result, &types); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal reaction types: %v", err)
+	}
+	return types, nil
+}
+
+// ReactionTypesHandler lists the accepted reaction types. Admins change them
+// with SetReactionTypes from their own Fabric client.
+func ReactionTypesHandler(w http.ResponseWriter, r *http.Request) {
+	types, err := reactionTypes()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to fetch reaction types: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(types)
+}
+
+// getLedgerPostByID reads the on-chain record of a post by the ID the ledger assigned it
+func getLedgerPostByID(postID string) (*LedgerPost, error) {
+	result, err := contract.EvaluateTransaction("GetPostByID", postID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch post %s from blockchain: %v", postID, err)
+	}
+	var ledgerPost LedgerPost
+	if err := json.Unmarshal(result, &ledgerPost); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal post %s: %v", postID, err)
+	}
+	return &ledgerPost, nil
+}
+
+// getPostHashByID resolves a post ID to the IPFS hash the post is stored under
+func getPostHashByID(postID string) (string, error) {
+	ledgerPost, err := getLedgerPostByID(postID)
+	if err != nil {
+		return "", err
+	}
+	return ledgerPost.ContentCID, nil
+}
+
+// GetPostByIDHandler returns a single post by its ID, with the reaction of the
+// user given by the viewer query parameter
+func GetPostByIDHandler(w http.ResponseWriter, r *http.Request) {
+	result, err := contract.EvaluateTransaction("GetPostViewByID", mux.Vars(r)["id"], r.URL.Query().Get("viewer"))
+	if err != nil {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	var view PostView
+	if err := json.Unmarshal(result, &view); err != nil {
+		log.Printf("Failed to unmarshal post %s: %v", mux.Vars(r)["id"], err)
+		http.Error(w, "Failed to retrieve post data", http.StatusInternalServerError)
+		return
+	}
+	ledgerPost := view.Post
+	if ledgerPost.Deleted {
+		http.Error(w, "Post has been deleted", http.StatusGone)
+		return
+	}
+	if ledgerPost.Hidden {
+		http.Error(w, "Post has been hidden by moderators", http.StatusForbidden)
+		return
+	}
+
+	post, err := hydratePostView(&view)
+	if err != nil {
+		log.Printf("Failed to fetch post %s: %v", ledgerPost.ContentCID, err)
+		http.Error(w, "Failed to retrieve post data", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(post)
+}
+
+// HashtagPostsHandler lists the posts tagged with a hashtag, newest first
+func HashtagPostsHandler(w http.ResponseWriter, r *http.Request) {
+	limit, cursor, err := pageParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := contract.EvaluateTransaction("GetPostsByHashtag", mux.Vars(r)["tag"], limit, cursor)
+	if err != nil {
+		log.Printf("Failed to fetch posts by hashtag: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to fetch posts: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var page PostPage
+	if err := json.Unmarshal(result, &page); err != nil {
+		http.Error(w, "Failed to parse post data.", http.StatusInternalServerError)
+		return
+	}
+
+	blocked := map[string]bool{}
+	viewer := r.URL.Query().Get("viewer")
+	if viewer != "" {
+		blocked, err = blockedUsers(viewer)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to fetch blocked users: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	setNextCursor(w, page.Bookmark)
+	json.NewEncoder(w).Encode(hydratePosts(page.Posts, blocked, viewer))
+}
+
+// TrendingHashtagsHandler lists the hashtags used by the most posts over the
+// last days days, most used first
+func TrendingHashtagsHandler(w http.ResponseWriter, r *http.Request) {
+	days := 1
+	if value := r.URL.Query().Get("days"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "days must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		days = parsed
+	}
+	limit, _, err := pageParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := contract.EvaluateTransaction("GetTrendingHashtags", strconv.Itoa(days), limit)
+	if err != nil {
+		log.Printf("Failed to fetch trending hashtags: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to fetch trending hashtags: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var trending []HashtagCount
+	if err := json.Unmarshal(result, &trending); err != nil {
+		http.Error(w, "Failed to parse trending hashtags.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(trending)
+}
+
+// HashtagFollowHandler follows (POST) or unfollows (DELETE) a hashtag for a user
+func HashtagFollowHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		PublicKey string `json:"publicKey"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.PublicKey == "" {
+		http.Error(w, "publicKey is required", http.StatusBadRequest)
+		return
+	}
+	if err := verifySession(r, request.PublicKey); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	function := "FollowHashtag"
+	if r.Method == http.MethodDelete {
+		function = "UnfollowHashtag"
+	}
+	_, err := submitSignedTransaction(request.PublicKey, function, request.PublicKey, mux.Vars(r)["tag"])
+	if err != nil {
+		log.Printf("%s failed: %v", function, err)
+		http.Error(w, fmt.Sprintf("Failed to update followed hashtags: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// FollowedHashtagsHandler lists the hashtags a user follows
+func FollowedHashtagsHandler(w http.ResponseWriter, r *http.Request) {
+	result, err := contract.EvaluateTransaction("GetFollowedHashtags", mux.Vars(r)["id"])
+	if err != nil {
+		log.Printf("Failed to fetch followed hashtags: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to fetch followed hashtags: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+// MentionsHandler lists the posts that mention a user, newest first
+func MentionsHandler(w http.ResponseWriter, r *http.Request) {
+	userPublicKey := mux.Vars(r)["id"]
+
+	limit, cursor, err := pageParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := contract.EvaluateTransaction("GetMentionsForUser", userPublicKey, limit, cursor)
+	if err != nil {
+		log.Printf("Failed to fetch mentions: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to fetch mentions: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var page PostPage
+	if err := json.Unmarshal(result, &page); err != nil {
+		http.Error(w, "Failed to parse post data.", http.StatusInternalServerError)
+		return
+	}
+
+	blocked, err := blockedUsers(userPublicKey)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to fetch blocked users: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	setNextCursor(w, page.Bookmark)
+	json.NewEncoder(w).Encode(hydratePosts(page.Posts, blocked, userPublicKey))
+}
+
+// ReportHandler reports a post (/post/{id}/report) or a user (/users/{id}/report)
+// on behalf of the reporting user
+func ReportHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		PublicKey string `json:"publicKey"`
+		Reason    string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.PublicKey == "" || request.Reason == "" {
+		http.Error(w, "publicKey and reason are required", http.StatusBadRequest)
+		return
+	}
+	if err := verifySession(r, request.PublicKey); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	function, target := "ReportUser", mux.Vars(r)["id"]
+	if strings.HasPrefix(r.URL.Path, "/post/") {
+		postHash, err := getPostHashByID(target)
+		if err != nil {
+			http.Error(w, "Post not found", http.StatusNotFound)
+			return
+		}
+		function, target = "ReportPost", postHash
+	}
+	_, err := submitSignedTransaction(request.PublicKey, function, target, request.PublicKey, request.Reason)
+	if err != nil {
+		log.Printf("%s failed: %v", function, err)
+		http.Error(w, fmt.Sprintf("Failed to submit report: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ModerationLogHandler lists the moderation log, oldest first
+func ModerationLogHandler(w http.ResponseWriter, r *http.Request) {
+	limit, cursor, err := pageParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := contract.EvaluateTransaction("GetModerationLog", limit, cursor)
+	if err != nil {
+		log.Printf("Failed to fetch moderation log: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to fetch moderation log: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var page struct {
+		Actions  []json.RawMessage `json:"actions"`
+		Bookmark string            `json:"bookmark"`
+	}
+	if err := json.Unmarshal(result, &page); err != nil {
+		http.Error(w, "Failed to parse moderation log.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	setNextCursor(w, page.Bookmark)
+	json.NewEncoder(w).Encode(page.Actions)
+}
+
+// ReportThresholdHandler returns how many reports hide a post until a moderator
+// reviews it. Admins change it with SetReportThreshold from their own Fabric client.
+func ReportThresholdHandler(w http.ResponseWriter, r *http.Request) {
+	result, err := contract.EvaluateTransaction("GetReportThreshold")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to fetch report threshold: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+// getCommentFromIPFS retrieves a comment body from IPFS by its hash
+func getCommentFromIPFS(ipfsHash string) (*CommentBody, error) {
+	reader, err := ipfsShell.Cat(ipfsHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve from IPFS: %v", err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read IPFS data: %v", err)
+	}
+
+	var body CommentBody
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal comment data: %v", err)
+	}
+	return &body, nil
+}
+
+// CommentsHandler lists the comments on a post (GET) or adds one (POST).
+// GET takes an optional parent query parameter to list the replies to a comment.
+func CommentsHandler(w http.ResponseWriter, r *http.Request) {
+	postHash, err := getPostHashByID(mux.Vars(r)["id"])
+	if err != nil {
+		log.Printf("Failed to retrieve post hash: %v", err)
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		limit, cursor, err := pageParams(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		result, err := contract.EvaluateTransaction("GetComments", postHash, r.URL.Query().Get("parent"), limit, cursor)
+		if err != nil {
+			log.Printf("Failed to fetch comments: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to fetch comments: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		var page CommentPage
+		if err := json.Unmarshal(result, &page); err != nil {
+			log.Printf("Error unmarshalling comments: %v", err)
+			http.Error(w, "Failed to parse comment data.", http.StatusInternalServerError)
+			return
+		}
+
+		// Hidden and deleted comments come back without a content hash
+		for _, comment := range page.Comments {
+			if comment.ContentCID == "" {
+				continue
+			}
+			body, err := getCommentFromIPFS(comment.ContentCID)
+			if err != nil {
+				log.Printf("Failed to fetch comment %s from IPFS: %v", comment.ID, err)
+				continue
+			}
+			comment.Content = body.Content
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		setNextCursor(w, page.Bookmark)
+		json.NewEncoder(w).Encode(page.Comments)
+
+	case http.MethodPost:
+		var request struct {
+			PublicKey string `json:"publicKey"`
+			Content   string `json:"content"`
+			ParentID  string `json:"parentId"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if request.PublicKey == "" || strings.TrimSpace(request.Content) == "" {
+			http.Error(w, "publicKey and content are required", http.StatusBadRequest)
+			return
+		}
+		if err := verifySession(r, request.PublicKey); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		// Store the comment body in IPFS, like post bodies
+		bodyJSON, err := json.Marshal(CommentBody{
+			Content:         request.Content,
+			AuthorPublicKey: request.PublicKey,
+			Timestamp:       time.Now(),
+		})
+		if err != nil {
+			http.Error(w, "Failed to encode comment", http.StatusInternalServerError)
+			return
+		}
+		contentCID, err := ipfsShell.Add(bytes.NewReader(bodyJSON))
+		if err != nil {
+			log.Printf("Failed to store comment in IPFS: %v", err)
+			http.Error(w, "Failed to store comment in IPFS", http.StatusInternalServerError)
+			return
+		}
+
+		commentID, err := submitSignedTransaction(request.PublicKey, "AddComment", postHash, request.ParentID, request.PublicKey, contentCID)
+		if err != nil {
+			log.Printf("Failed to add comment: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to add comment: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{"id": string(commentID), "contentCID": contentCID})
+	}
+}
+
+// DeleteCommentHandler deletes a comment on behalf of its author
+func DeleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	postHash, err := getPostHashByID(vars["id"])
+	if err != nil {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+
+	var request struct {
+		PublicKey string `json:"publicKey"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.PublicKey == "" {
+		http.Error(w, "publicKey is required", http.StatusBadRequest)
+		return
+	}
+	if err := verifySession(r, request.PublicKey); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	_, err = submitSignedTransaction(request.PublicKey, "DeleteComment", postHash, vars["commentId"], request.PublicKey)
+	if err != nil {
+		log.Printf("Failed to delete comment: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to delete comment: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HideCommentHandler lets a post's author hide or unhide a comment on it
+func HideCommentHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	postHash, err := getPostHashByID(vars["id"])
+	if err != nil {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+
+	var request struct {
+		PublicKey string `json:"publicKey"`
+		Hidden    bool   `json:"hidden"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.PublicKey == "" {
+		http.Error(w, "publicKey is required", http.StatusBadRequest)
+		return
+	}
+	if err := verifySession(r, request.PublicKey); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	_, err = submitSignedTransaction(request.PublicKey, "SetCommentHidden", postHash, vars["commentId"], request.PublicKey, strconv.FormatBool(request.Hidden))
+	if err != nil {
+		log.Printf("Failed to update comment: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to update comment: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CommentSettingsHandler lets a post's author turn comments on the post on or off
+func CommentSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	postHash, err := getPostHashByID(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+
+	var request struct {
+		PublicKey string `json:"publicKey"`
+		Enabled   bool   `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.PublicKey == "" {
+		http.Error(w, "publicKey is required", http.StatusBadRequest)
+		return
+	}
+	if err := verifySession(r, request.PublicKey); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	_, err = submitSignedTransaction(request.PublicKey, "SetCommentsEnabled", postHash, request.PublicKey, strconv.FormatBool(request.Enabled))
+	if err != nil {
+		log.Printf("Failed to update comment settings: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to update comment settings: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SearchUserByName resolves a user's name through the on-chain handle index and returns their public key.
+func SearchUserByName(name string, contract *client.Contract) (string, error) {
+	publicKey, err := contract.EvaluateTransaction("ResolveHandle", name)
+	if err != nil {
+		return "", fmt.Errorf("failed to query user: %v", err)
+	}
+
+	if len(publicKey) == 0 {
+		return "", fmt.Errorf("user not found or public key missing")
+	}
+
+	return string(publicKey), nil
+}
+
+// Message represents an individual message in a chat
+type Message struct {
+	Seq       int       `json:"seq"`       // Position of the message in its chat, set by the chaincode
+	IPFSHash  string    `json:"ipfsHash"`  // IPFS hash of the encrypted message
+	Signature string    `json:"signature"` // Signature for authenticity
+	Sender    string    `json:"sender"`    // Sender's public key
+	Receiver  string    `json:"receiver"`  // Receiver's public key
+	Timestamp time.Time `json:"timestamp"` // Time of message
+}
+
+// Chat represents a chat between two users
+type Chat struct {
+	ChatID       string    `json:"chatId"`
+	Participants [2]string `json:"participants"` // Public keys of the two participants
+	LastSeq      int       `json:"lastSeq"`      // Sequence number of the newest message
+}
+
+// ChatPage is one page of a chat's messages, newest first, as returned by the
+// chaincode. Before is the cursor for the next, older page, 0 once there is none.
+type ChatPage struct {
+	ChatID       string    `json:"chatId"`
+	Participants [2]string `json:"participants"`
+	LastSeq      int       `json:"lastSeq"`
+	Messages     []Message `json:"messages"`
+	Before       int       `json:"before"`
+}
+
+// EncryptMessage encrypts plaintext using ECIES with AES-GCM
+func EncryptMessage(plainText string, publicKey string) (string, error) {
+	// Decode the public key from hex
+	log.Printf("Received public key: %s", plainText)
+	decodedKey, err := hex.DecodeString(publicKey)
+	log.Printf("decoded key %s", decodedKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid public key encoding: %v", err)
+	}
+
+	// Parse the public key
+	pubKey, err := x509.ParsePKIXPublicKey(decodedKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid public key format: %v", err)
+	}
+
+	ecdsaPubKey, ok := pubKey.(*ecdsa.PublicKey)
+	if !ok {
+		return "", fmt.Errorf("invalid public key type")
+	}
+
+	// Generate an ephemeral private key
+	ephemeralPrivKey, err := ecdsa.GenerateKey(ecdsaPubKey.Curve, rand.Reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate ephemeral key: %v", err)
+	}
+
+	// Compute shared secret
+	sharedX, _ := ecdsaPubKey.Curve.ScalarMult(ecdsaPubKey.X, ecdsaPubKey.Y, ephemeralPrivKey.D.Bytes())
+
+	// Derive AES key from shared secret (use the first 32 bytes of sharedX)
+	aesKey := sharedX.Bytes()
+	if len(aesKey) > 32 {
+		aesKey = aesKey[:32]
+	}
+
+	// Create AES-GCM cipher
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to create AES cipher: %v", err)
+	}
+	aesGCM, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", fmt.Errorf("failed to create AES-GCM: %v", err)
+	}
+
+	// Generate a random nonce
+	nonce := make([]byte, aesGCM.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %v", err)
+	}
+
+	// Encrypt the plaintext
+	cipherText := aesGCM.Seal(nil, nonce, []byte(plainText), nil)
+
+	// Marshal ephemeral public key
+	ephemeralPubKey := elliptic.Marshal(ephemeralPrivKey.Curve, ephemeralPrivKey.PublicKey.X, ephemeralPrivKey.PublicKey.Y)
+
+	// Combine ephemeral public key, nonce, and ciphertext
+	encryptedMessage := append(ephemeralPubKey, append(nonce, cipherText...)...)
+
+	log.Printf("Encrypted message length: %d", len(encryptedMessage)) // Add log to check length
+
+	return hex.EncodeToString(encryptedMessage), nil
+}
+
+// DecryptMessage decrypts the encrypted message using ECIES with AES-GCM
+func DecryptMessage(encryptedText string, privateKey string) (string, error) {
+	// Decode the private key
+	decodedKey, err := hex.DecodeString(privateKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid private key encoding: %v", err)
+	}
+
+	// Parse the private key
+	privKey, err := x509.ParseECPrivateKey(decodedKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid private key format: %v", err)
+	}
+
+	// Decode the encrypted message
+	encrypted, err := hex.DecodeString(encryptedText)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode encrypted message: %v", err)
+	}
+
+	// Extract curve details
+	curve := privKey.Curve
 	keySize := (curve.Params().BitSize + 7) / 8
 	ephemeralKeySize := 2*keySize + 1
 
@@ -1136,14 +2988,14 @@ func DecryptMessage(encryptedText string, privateKey string) (string, error) {
 	if len(cipherText) == 0 {
 		return "", fmt.Errorf("no ciphertext found")
 	}
-	log.Println("Cipher Text %s", cipherText)
+	log.Printf("Cipher Text %x", cipherText)
 
 	// Decrypt the ciphertext
 	plainText, err := aesGCM.Open(nil, nonce, cipherText, nil)
 	if err != nil {
 		return "", fmt.Errorf("decryption failed: %v", err)
 	}
-	log.Println("Plain Text %+v", string(plainText))
+	log.Printf("Plain Text %+v", string(plainText))
 	return string(plainText), nil
 }
 
@@ -1214,6 +3066,108 @@ func VerifySignature(plainText string, signature string, publicKeyHex string) (b
 	return isValid, nil
 }
 
+// generateNonce returns a random hex string used once per signed transaction
+func generateNonce() (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	return hex.EncodeToString(nonce), nil
+}
+
+// transactionPayload mirrors the chaincode's canonical encoding of a signed call:
+// a JSON array of the function name, its arguments in order and the nonce
+func transactionPayload(function string, args []string, nonce string) (string, error) {
+	fields := append([]string{function}, args...)
+	fields = append(fields, nonce)
+
+	payload, err := json.Marshal(fields)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode signed payload: %v", err)
+	}
+	return string(payload), nil
+}
+
+// signedArguments appends a fresh nonce and the user's signature over the call to args
+func signedArguments(privateKeyHex string, function string, args ...string) ([]string, error) {
+	nonce, err := generateNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := transactionPayload(function, args, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := SignMessage(payload, privateKeyHex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign %s: %v", function, err)
+	}
+
+	signed := append([]string{}, args...)
+	return append(signed, nonce, signature), nil
+}
+
+// submitSignedTransaction signs a call with the wallet key of signerPublicKey and submits it
+func submitSignedTransaction(signerPublicKey string, function string, args ...string) ([]byte, error) {
+	privateKey, err := walletPrivateKey(signerPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	signedArgs, err := signedArguments(privateKey, function, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return contract.SubmitTransaction(function, signedArgs...)
+}
+
+// ownerReadTransient builds the transient data that proves a read of function
+// for publicKey is made by its owner. Reads cannot record nonces, so the nonce
+// is the current unix time, which the chaincode only accepts for a few minutes.
+func ownerReadTransient(privateKeyHex string, function string, publicKey string) (map[string][]byte, error) {
+	nonce := strconv.FormatInt(time.Now().Unix(), 10)
+	payload, err := transactionPayload(function, []string{publicKey}, nonce)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := SignMessage(payload, privateKeyHex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign %s: %v", function, err)
+	}
+
+	auth, err := json.Marshal(map[string]string{"nonce": nonce, "signature": signature})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode owner authentication: %v", err)
+	}
+	return map[string][]byte{"auth": auth}, nil
+}
+
+// getUserAsOwner reads a user, including the private fields only the owner may
+// see, signing the read with the user's wallet key
+func getUserAsOwner(publicKey string) (*User, error) {
+	privateKey, err := walletPrivateKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	transient, err := ownerReadTransient(privateKey, "GetUser", publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := contract.Evaluate("GetUser", client.WithArguments(publicKey), client.WithTransient(transient))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch user: %v", err)
+	}
+	var user User
+	if err := json.Unmarshal(response, &user); err != nil {
+		return nil, fmt.Errorf("failed to parse user data: %v", err)
+	}
+	return &user, nil
+}
+
 // UploadToIPFS uploads content to IPFS and returns the IPFS hash
 func UploadMessageToIPFS(content string) (string, error) {
 	sh := shell.NewShell("localhost:5001") // Ensure IPFS daemon is running on localhost:5001
@@ -1276,7 +3230,7 @@ func SendMessage(chat *Chat, senderPrivateKey string, senderPublicKey string, re
 	fmt.Println("Generated Chat ID:", chatID) // Print statement for debugging
 
 	// Add the message to the blockchain
-	err = AddMessageToBlockchain(chatID, message, senderPublicKey, receiverPublicKey)
+	err = AddMessageToBlockchain(chatID, message, senderPrivateKey, senderPublicKey, receiverPublicKey)
 	if err != nil {
 		fmt.Println("Error while adding message to blockchain:", err) // Print statement for debugging
 		return fmt.Errorf("failed to add message to blockchain: %v", err)
@@ -1287,15 +3241,34 @@ func SendMessage(chat *Chat, senderPrivateKey string, senderPublicKey string, re
 	return nil
 }
 
-func AddMessageToBlockchain(chatID string, message Message, senderPublicKey string, receiverPublicKey string) error {
+func AddMessageToBlockchain(chatID string, message Message, senderPrivateKey string, senderPublicKey string, receiverPublicKey string) error {
 	// Convert the message to JSON
 	messageBytes, err := json.Marshal(message)
 	if err != nil {
 		return fmt.Errorf("failed to marshal message: %v", err)
 	}
 
+	// Sign the call with the sender's key so the chaincode can authenticate it
+	signedArgs, err := signedArguments(senderPrivateKey, "AddMessage", chatID, string(messageBytes), senderPublicKey, receiverPublicKey)
+	if err != nil {
+		return err
+	}
+
+	// The arguments go through the transient map, keeping who talks to whom off the public ledger
+	input, err := json.Marshal(map[string]string{
+		"chatId":            signedArgs[0],
+		"message":           signedArgs[1],
+		"senderPublicKey":   signedArgs[2],
+		"receiverPublicKey": signedArgs[3],
+		"nonce":             signedArgs[4],
+		"signature":         signedArgs[5],
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode message input: %v", err)
+	}
+
 	// Submit the transaction to the blockchain
-	result, err := contract.SubmitTransaction("AddMessage", chatID, string(messageBytes), senderPublicKey, receiverPublicKey)
+	result, err := contract.Submit("AddMessage", client.WithTransient(map[string][]byte{"message": input}))
 	if err != nil {
 		return fmt.Errorf("failed to submit transaction: %v", err)
 	}
@@ -1327,21 +3300,27 @@ func FetchFromIPFS(ipfsHash string) (string, error) {
 	return string(content), nil
 }
 
-func DecryptAndFetchMessages(chatID string, senderPublicKey string, receiverPublicKey string, senderPrivateKey string, receiverPrivateKey string) ([]string, error) {
-	// Fetch the chat data from the blockchain using the chaincode
-	chat, err := GetChatFromBlockchain(chatID)
+// DecryptAndFetchMessages decrypts one page of a chat, the pageSize messages
+// before the given sequence number (the newest when before is 0). Only that
+// page is loaded from the ledger and IPFS. The messages are returned oldest
+// first, along with the cursor for the next, older page.
+func DecryptAndFetchMessages(senderPublicKey string, receiverPublicKey string, senderPrivateKey string, receiverPrivateKey string, pageSize int, before int) ([]string, int, error) {
+	// Fetch the page from the blockchain using the chaincode
+	page, err := GetChatFromBlockchain(senderPublicKey, receiverPublicKey, pageSize, before)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch chat: %v", err)
+		return nil, 0, fmt.Errorf("failed to fetch chat: %v", err)
 	}
 
-	var decryptedMessages []string
+	decryptedMessages := make([]string, 0, len(page.Messages))
+
+	// The page is newest first; walk it backwards so the conversation reads in order
+	for i := len(page.Messages) - 1; i >= 0; i-- {
+		message := page.Messages[i]
 
-	// For each message in the chat, fetch the IPFS content, decrypt it, and then verify its signature
-	for _, message := range chat.Messages {
 		// Fetch the encrypted message from IPFS
 		encryptedMessage, err := FetchFromIPFS(message.IPFSHash)
 		if err != nil {
-			return nil, fmt.Errorf("failed to fetch message from IPFS: %v", err)
+			return nil, 0, fmt.Errorf("failed to fetch message from IPFS: %v", err)
 		}
 
 		// Determine which private key to use for decryption
@@ -1355,7 +3334,7 @@ func DecryptAndFetchMessages(chatID string, senderPublicKey string, receiverPubl
 		// Decrypt the message using the chosen private key
 		decryptedMessage, err := DecryptMessage(encryptedMessage, privateKeyToUse)
 		if err != nil {
-			return nil, fmt.Errorf("failed to decrypt message: %v", err)
+			return nil, 0, fmt.Errorf("failed to decrypt message: %v", err)
 		}
 
 		log.Printf("Message sender: %s", message.Sender)
@@ -1367,7 +3346,7 @@ func DecryptAndFetchMessages(chatID string, senderPublicKey string, receiverPubl
 			if err != nil {
 				log.Printf("Signature verification error: %v", err)
 			}
-			return nil, fmt.Errorf("signature verification failed for decrypted message: %s", decryptedMessage)
+			return nil, 0, fmt.Errorf("signature verification failed for decrypted message: %s", decryptedMessage)
 		}
 		log.Printf("Signature verified for decrypted message: %s", decryptedMessage)
 
@@ -1375,24 +3354,27 @@ func DecryptAndFetchMessages(chatID string, senderPublicKey string, receiverPubl
 		decryptedMessages = append(decryptedMessages, decryptedMessage)
 	}
 
-	return decryptedMessages, nil
+	return decryptedMessages, page.Before, nil
 }
 
-func GetChatFromBlockchain(chatID string) (*Chat, error) {
+// GetChatFromBlockchain reads one page of the chat between two users, newest
+// first. The chaincode finds the chat from the users' keys, including one begun
+// under keys they have since rotated.
+func GetChatFromBlockchain(publicKey string, otherPublicKey string, pageSize int, before int) (*ChatPage, error) {
 	// Query the blockchain for the chat data
-	result, err := contract.EvaluateTransaction("GetChat", chatID)
+	result, err := contract.EvaluateTransaction("GetChat", publicKey, otherPublicKey, strconv.Itoa(pageSize), strconv.Itoa(before))
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch chat: %v", err)
 	}
 
-	// Unmarshal the result into a Chat object
-	var chat Chat
-	err = json.Unmarshal(result, &chat)
+	// Unmarshal the result into a ChatPage object
+	var page ChatPage
+	err = json.Unmarshal(result, &page)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal chat data: %v", err)
 	}
 
-	return &chat, nil
+	return &page, nil
 }
 
 func ChatHandler(w http.ResponseWriter, r *http.Request) {
@@ -1428,6 +3410,10 @@ func ChatHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("failed to load keys for user %s: %v", baseReq.Username, err), http.StatusInternalServerError)
 		return
 	}
+	if err := verifySession(r, userKeys.PublicKey); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
 
 	log.Println("Resetting the request body for further parsing")
 	r.Body = io.NopCloser(bytes.NewBuffer(body))
@@ -1478,6 +3464,8 @@ func ChatHandler(w http.ResponseWriter, r *http.Request) {
 		log.Println("Handling 'get' operation")
 		var getReq struct {
 			SenderUsername string `json:"senderUsername"`
+			PageSize       int    `json:"pageSize"` // Defaults to the chaincode's page size
+			Before         int    `json:"before"`   // Cursor from the previous page, 0 for the newest messages
 		}
 
 		log.Println("Decoding 'get' request")
@@ -1498,21 +3486,20 @@ func ChatHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		log.Println("Sender's keys loaded successfully")
 
-		participants := []string{userKeys.PublicKey, senderKeys.PublicKey}
-		chatID := GenerateChatID(participants)
-		log.Printf("Generated chat ID: %s", chatID)
-
 		log.Println("Fetching and decrypting messages")
-		decryptedMessages, err := DecryptAndFetchMessages(chatID, senderKeys.PublicKey, userKeys.PublicKey, senderKeys.PrivateKey, userKeys.PrivateKey)
+		decryptedMessages, nextBefore, err := DecryptAndFetchMessages(senderKeys.PublicKey, userKeys.PublicKey, senderKeys.PrivateKey, userKeys.PrivateKey, getReq.PageSize, getReq.Before)
 		if err != nil {
 			log.Printf("Failed to fetch chat messages: %v", err)
 			http.Error(w, fmt.Sprintf("failed to fetch chat messages: %v", err), http.StatusInternalServerError)
 			return
 		}
-		log.Println("Messages fetched and decrypted successfully %v", decryptedMessages)
+		log.Printf("Messages fetched and decrypted successfully %v", decryptedMessages)
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(decryptedMessages)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"messages": decryptedMessages,
+			"before":   nextBefore,
+		})
 		return
 	}
 
@@ -1574,12 +3561,16 @@ func sendFriendRequestHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Sender and Receiver public keys are required", http.StatusBadRequest)
 		return
 	}
+	if err := verifySession(r, request.SenderPublicKey); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
 
 	// Call the chaincode to send a friend request
-	result, err := contract.SubmitTransaction("SendFriendRequest", request.SenderPublicKey, request.ReceiverPublicKey)
+	result, err := submitSignedTransaction(request.SenderPublicKey, "SendFriendRequest", request.SenderPublicKey, request.ReceiverPublicKey)
 	if err != nil {
 		log.Printf("Failed to send friend request: %v", err)
-		http.Error(w, "Failed to send friend request", http.StatusInternalServerError)
+		http.Error(w, "Failed to send friend request: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -1619,30 +3610,25 @@ func getFriendRequestsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	userPublicKey := parts[2]
 	log.Printf("user public key is:%s", userPublicKey)
-	// Retrieve friend requests for the user
-	log.Printf("Retrieving friend requests for user: %s", userPublicKey)
 
-	// Retrieve friend requests for the user from the chaincode
-	result, err := contract.SubmitTransaction("GetFriendRequestsByUser", userPublicKey)
+	limit, cursor, err := pageParams(r)
 	if err != nil {
-		log.Printf("Failed to retrieve friend requests: %v", err)
-		http.Error(w, "Failed to retrieve friend requests: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Decode the base64 encoded result
-	decodedResult, err := base64.StdEncoding.DecodeString(string(result))
+	// Retrieve one page of friend requests for the user from the chaincode
+	log.Printf("Retrieving friend requests for user: %s", userPublicKey)
+	result, err := contract.EvaluateTransaction("GetFriendRequestsByUserWithPagination", userPublicKey, limit, cursor)
 	if err != nil {
-		log.Printf("Failed to decode friend requests: %v", err)
-		http.Error(w, "Failed to decode friend requests", http.StatusInternalServerError)
+		log.Printf("Failed to retrieve friend requests: %v", err)
+		http.Error(w, "Failed to retrieve friend requests: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	log.Printf(string(decodedResult))
-
-	// Parse the decoded JSON
-	var friendRequests []*FriendRequest
-	err = json.Unmarshal(decodedResult, &friendRequests)
+	// Parse the page of requests
+	var page FriendRequestPage
+	err = json.Unmarshal(result, &page)
 	if err != nil {
 		log.Printf("Failed to unmarshal friend requests: %v", err)
 		http.Error(w, "Failed to process friend requests", http.StatusInternalServerError)
@@ -1651,7 +3637,8 @@ func getFriendRequestsHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Return the friend requests with sender names
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(friendRequests); err != nil {
+	setNextCursor(w, page.Bookmark)
+	if err := json.NewEncoder(w).Encode(page.Requests); err != nil {
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
 	}
 }
@@ -1682,6 +3669,10 @@ func respondToFriendRequestHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Sender, receiver public keys and response are required", http.StatusBadRequest)
 		return
 	}
+	if err := verifySession(r, request.ReceiverPublicKey); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
 
 	// Submit the response to the friend request
 	log.Printf("Submitting transaction: RespondToFriendRequest with Sender: %s, Receiver: %s, Response: %s", request.SenderPublicKey, request.ReceiverPublicKey, request.Response)
@@ -1712,7 +3703,8 @@ func respondToFriendRequestHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Responding to friend request - Sender: %s, Receiver: %s, Response: %s",
 		request.SenderPublicKey, request.ReceiverPublicKey, request.Response)
 
-	result, err := contract.SubmitTransaction("RespondToFriendRequest",
+	// The receiver is the one responding, so the call is signed with their key
+	result, err := submitSignedTransaction(request.ReceiverPublicKey, "RespondToFriendRequest",
 		request.SenderPublicKey, request.ReceiverPublicKey, request.Response)
 	if err != nil {
 		log.Printf("Failed to respond to friend request: %v", err)
@@ -1735,6 +3727,36 @@ func respondToFriendRequestHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// cancelFriendRequestHandler withdraws a pending friend request on behalf of its sender
+func cancelFriendRequestHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		SenderPublicKey   string `json:"senderPublicKey"`
+		ReceiverPublicKey string `json:"receiverPublicKey"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.SenderPublicKey == "" || request.ReceiverPublicKey == "" {
+		http.Error(w, "Sender and Receiver public keys are required", http.StatusBadRequest)
+		return
+	}
+	if err := verifySession(r, request.SenderPublicKey); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	_, err := submitSignedTransaction(request.SenderPublicKey, "CancelFriendRequest", request.SenderPublicKey, request.ReceiverPublicKey)
+	if err != nil {
+		log.Printf("Failed to cancel friend request: %v", err)
+		http.Error(w, "Failed to cancel friend request: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Friend request cancelled"})
+}
+
 // Handler to get the friends of a specific user
 func getFriendsHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println("Inside getFriendsHandler")
@@ -1773,11 +3795,161 @@ func getFriendsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(friendsJSON)
 }
 
+// removeFriendHandler ends a friendship between the user in the path and friendId
+func removeFriendHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userPublicKey := vars["id"]
+	friendPublicKey := vars["friendId"]
+	if err := verifySession(r, userPublicKey); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	_, err := submitSignedTransaction(userPublicKey, "RemoveFriend", userPublicKey, friendPublicKey)
+	if err != nil {
+		log.Printf("Failed to remove friend: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to remove friend: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// BlockHandler blocks (POST) or unblocks (DELETE) a user on behalf of publicKey
+func BlockHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		PublicKey        string `json:"publicKey"`
+		BlockedPublicKey string `json:"blockedPublicKey"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.PublicKey == "" || request.BlockedPublicKey == "" {
+		http.Error(w, "publicKey and blockedPublicKey are required", http.StatusBadRequest)
+		return
+	}
+	if err := verifySession(r, request.PublicKey); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	function := "BlockUser"
+	if r.Method == http.MethodDelete {
+		function = "UnblockUser"
+	}
+	_, err := submitSignedTransaction(request.PublicKey, function, request.PublicKey, request.BlockedPublicKey)
+	if err != nil {
+		log.Printf("%s failed: %v", function, err)
+		http.Error(w, fmt.Sprintf("Failed to update block: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// FollowHandler follows (POST) or unfollows (DELETE) a user
+func FollowHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		PublicKey         string `json:"publicKey"`
+		FollowedPublicKey string `json:"followedPublicKey"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.PublicKey == "" || request.FollowedPublicKey == "" {
+		http.Error(w, "publicKey and followedPublicKey are required", http.StatusBadRequest)
+		return
+	}
+	if err := verifySession(r, request.PublicKey); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	function := "Follow"
+	if r.Method == http.MethodDelete {
+		function = "Unfollow"
+	}
+	_, err := submitSignedTransaction(request.PublicKey, function, request.PublicKey, request.FollowedPublicKey)
+	if err != nil {
+		log.Printf("%s failed: %v", function, err)
+		http.Error(w, fmt.Sprintf("Failed to update follow: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// FollowersHandler lists the followers of a user, or with the following route
+// the users they follow
+func FollowersHandler(w http.ResponseWriter, r *http.Request) {
+	function := "GetFollowers"
+	if strings.HasSuffix(r.URL.Path, "/following") {
+		function = "GetFollowing"
+	}
+
+	limit, cursor, err := pageParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := contract.EvaluateTransaction(function, mux.Vars(r)["id"], limit, cursor)
+	if err != nil {
+		log.Printf("%s failed: %v", function, err)
+		http.Error(w, fmt.Sprintf("Failed to fetch follows: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var page UserPage
+	if err := json.Unmarshal(result, &page); err != nil {
+		http.Error(w, "Failed to parse user data.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	setNextCursor(w, page.Bookmark)
+	json.NewEncoder(w).Encode(page.Users)
+}
+
+// blockedUsers returns the set of users publicKey has blocked
+func blockedUsers(publicKey string) (map[string]bool, error) {
+	result, err := contract.EvaluateTransaction("GetBlockedUsers", publicKey)
+	if err != nil {
+		return nil, err
+	}
+	var blocked []string
+	if err := json.Unmarshal(result, &blocked); err != nil {
+		return nil, err
+	}
+	set := make(map[string]bool, len(blocked))
+	for _, key := range blocked {
+		set[key] = true
+	}
+	return set, nil
+}
+
+// GetBlockedUsersHandler lists the public keys a user has blocked
+func GetBlockedUsersHandler(w http.ResponseWriter, r *http.Request) {
+	userPublicKey := mux.Vars(r)["id"]
+
+	result, err := contract.EvaluateTransaction("GetBlockedUsers", userPublicKey)
+	if err != nil {
+		log.Printf("Failed to retrieve blocked users: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to retrieve blocked users: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
 // HTTP Handler to create a new group
 func CreateGroupHandler(w http.ResponseWriter, r *http.Request) {
 	var groupRequest struct {
-		GroupName string   `json:"groupname"`
-		Members   []string `json:"members"`
+		GroupName        string   `json:"groupname"`
+		CreatorPublicKey string   `json:"creatorPublicKey"`
+		Members          []string `json:"members"` // Public keys of the other members
 	}
 
 	// Decode the request body
@@ -1787,44 +3959,78 @@ func CreateGroupHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate input
-	if groupRequest.GroupName == "" || len(groupRequest.Members) == 0 {
-		http.Error(w, "Group name and members are required", http.StatusBadRequest)
+	if groupRequest.GroupName == "" || groupRequest.CreatorPublicKey == "" {
+		http.Error(w, "Group name and creator public key are required", http.StatusBadRequest)
 		return
 	}
+	if err := verifySession(r, groupRequest.CreatorPublicKey); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if groupRequest.Members == nil {
+		groupRequest.Members = []string{}
+	}
 
-	// Generate a unique group ID
-	groupID := fmt.Sprintf("group-%d", time.Now().UnixNano())
-
-	// Serialize member names
+	// Serialize member public keys
 	membersJSON, err := json.Marshal(groupRequest.Members)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to serialize member names: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Failed to serialize members: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	// Create group on blockchain using member names
-	_, err = contract.SubmitTransaction("CreateGroup", groupID, groupRequest.GroupName, string(membersJSON))
+	// Create group on blockchain, signed by its creator; the ledger assigns its ID
+	result, err := submitSignedTransaction(groupRequest.CreatorPublicKey, "CreateGroup", groupRequest.GroupName, groupRequest.CreatorPublicKey, string(membersJSON))
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to create group on blockchain: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	// Prepare the response
-	newGroup := map[string]interface{}{
-		"id":      groupID,
-		"name":    groupRequest.GroupName,
-		"members": groupRequest.Members,
+	group, err := readGroup(string(result))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read created group: %v", err), http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(newGroup)
+	json.NewEncoder(w).Encode(group)
+}
+
+// readGroup fetches a group from the ledger with its members' names filled in
+func readGroup(groupID string) (*Group, error) {
+	result, err := contract.EvaluateTransaction("ReadGroup", groupID)
+	if err != nil {
+		return nil, err
+	}
+	var group Group
+	if err := json.Unmarshal(result, &group); err != nil {
+		return nil, err
+	}
+	fillMemberNames(&group)
+	return &group, nil
+}
+
+// fillMemberNames looks up the display name of each member of a group
+func fillMemberNames(group *Group) {
+	group.MemberNames = make([]string, len(group.Members))
+	for i, publicKey := range group.Members {
+		group.MemberNames[i] = publicKey
+		result, err := contract.EvaluateTransaction("GetUser", publicKey)
+		if err != nil {
+			log.Printf("Failed to look up member %s of group %s: %v", publicKey, group.ID, err)
+			continue
+		}
+		var user User
+		if err := json.Unmarshal(result, &user); err == nil {
+			group.MemberNames[i] = user.Name
+		}
+	}
 }
 
-// HTTP Handler to retrieve user's groups by user name
+// HTTP Handler to retrieve the groups a user belongs to
 func GetAllGroupsHandler(w http.ResponseWriter, r *http.Request) {
-	// Extract user name from request
 	var requestBody struct {
-		UserName string `json:"user_name"`
+		PublicKey string `json:"publicKey"`
+		UserName  string `json:"user_name"`
 	}
 
 	// Decode the request body
@@ -1833,43 +4039,37 @@ func GetAllGroupsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate user name
-	if requestBody.UserName == "" {
-		http.Error(w, "User name is required", http.StatusBadRequest)
+	// Older clients identify the user by name
+	publicKey := requestBody.PublicKey
+	if publicKey == "" && requestBody.UserName != "" {
+		result, err := contract.EvaluateTransaction("ResolveHandle", requestBody.UserName)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("User not found: %v", err), http.StatusNotFound)
+			return
+		}
+		publicKey = string(result)
+	}
+	if publicKey == "" {
+		http.Error(w, "User public key is required", http.StatusBadRequest)
 		return
 	}
 
-	// Retrieve all groups from the blockchain
-	allGroupsData, err := contract.EvaluateTransaction("GetAllGroups")
+	// Retrieve the user's groups from the member index
+	result, err := contract.EvaluateTransaction("GetGroupsByMember", publicKey)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to retrieve groups: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	// Log the raw data returned from the blockchain
-	log.Printf("Raw groups data: %s", string(allGroupsData))
-
-	// Deserialize groups
-	var allGroups []*Group
-	if err := json.Unmarshal(allGroupsData, &allGroups); err != nil {
+	userGroups := []*Group{}
+	if err := json.Unmarshal(result, &userGroups); err != nil {
 		http.Error(w, "Failed to process groups", http.StatusInternalServerError)
 		return
 	}
-
-	// Filter groups where the provided user is a member
-	var userGroups []*Group
-	for _, group := range allGroups {
-		for _, member := range group.Members {
-			if member == requestBody.UserName {
-				userGroups = append(userGroups, group)
-				break
-			}
-		}
+	for _, group := range userGroups {
+		fillMemberNames(group)
 	}
 
-	// Log the filtered groups
-	log.Printf("Filtered groups for user %s: %+v", requestBody.UserName, userGroups)
-
 	// Prepare response
 	response := struct {
 		Groups []*Group `json:"groups"`
@@ -1883,73 +4083,128 @@ func GetAllGroupsHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-//-----------------------------------------------------------//
+// GroupMembersHandler adds a member to a group (POST) or removes one (DELETE).
+// A member removing themselves leaves the group.
+func GroupMembersHandler(w http.ResponseWriter, r *http.Request) {
+	groupID := mux.Vars(r)["id"]
 
-func generateGroupChatID(groupID string) string {
-	// Concatenate groupID and senderPublicKey
+	var request struct {
+		ActorPublicKey  string `json:"actorPublicKey"`
+		MemberPublicKey string `json:"memberPublicKey"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.ActorPublicKey == "" || request.MemberPublicKey == "" {
+		http.Error(w, "actorPublicKey and memberPublicKey are required", http.StatusBadRequest)
+		return
+	}
+	if err := verifySession(r, request.ActorPublicKey); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
 
-	combined := groupID
-	// Hash the concatenated string to generate the chatID
-	hash := sha256.New()
-	hash.Write([]byte(combined))
-	chatID := hash.Sum(nil)
+	var err error
+	switch {
+	case r.Method == http.MethodPost:
+		_, err = submitSignedTransaction(request.ActorPublicKey, "AddMemberToGroup", groupID, request.ActorPublicKey, request.MemberPublicKey)
+	case request.ActorPublicKey == request.MemberPublicKey:
+		_, err = submitSignedTransaction(request.ActorPublicKey, "LeaveGroup", groupID, request.ActorPublicKey)
+	default:
+		_, err = submitSignedTransaction(request.ActorPublicKey, "RemoveMember", groupID, request.ActorPublicKey, request.MemberPublicKey)
+	}
+	if err != nil {
+		log.Printf("Failed to update members of group %s: %v", groupID, err)
+		http.Error(w, fmt.Sprintf("Failed to update group members: %v", err), http.StatusInternalServerError)
+		return
+	}
 
-	// Return the chatID as a hexadecimal string
-	return hex.EncodeToString(chatID)
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func SendGroupMessage(chat *Chat, senderPrivateKey string, senderPublicKey string, receiverPublicKey string, plainText string, chatID string) error {
-	// Debugging statement: Log the public key of the receiver
-	fmt.Println("Receiver Public Key:", receiverPublicKey)
+// GroupRoleHandler changes a member's role; only the group owner can do this
+func GroupRoleHandler(w http.ResponseWriter, r *http.Request) {
+	groupID := mux.Vars(r)["id"]
 
-	// Encrypt the message
-	encryptedMessage, err := EncryptGroupMessage(plainText, receiverPublicKey)
-	if err != nil {
-		fmt.Println("Error while encrypting message:", err) // Print statement for debugging
-		return fmt.Errorf("failed to encrypt message: %v", err)
+	var request struct {
+		OwnerPublicKey  string `json:"ownerPublicKey"`
+		MemberPublicKey string `json:"memberPublicKey"`
+		Role            string `json:"role"` // "owner", "admin" or "member"
 	}
-	fmt.Println("Encrypted Message:", encryptedMessage) // Print statement for debugging
-
-	// Upload the encrypted message to IPFS
-	ipfsHash, err := UploadMessageToIPFS(encryptedMessage)
-	if err != nil {
-		fmt.Println("Error while uploading message to IPFS:", err) // Print statement for debugging
-		return fmt.Errorf("failed to upload message to IPFS: %v", err)
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.OwnerPublicKey == "" || request.MemberPublicKey == "" || request.Role == "" {
+		http.Error(w, "ownerPublicKey, memberPublicKey and role are required", http.StatusBadRequest)
+		return
+	}
+	if err := verifySession(r, request.OwnerPublicKey); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
 	}
-	fmt.Println("IPFS Hash:", ipfsHash) // Print statement for debugging
 
-	// Sign the original message
-	signature, err := SignGroupMessage(plainText, senderPrivateKey)
+	_, err := submitSignedTransaction(request.OwnerPublicKey, "PromoteMember", groupID, request.OwnerPublicKey, request.MemberPublicKey, request.Role)
 	if err != nil {
-		fmt.Println("Error while signing message:", err) // Print statement for debugging
-		return fmt.Errorf("failed to sign message: %v", err)
+		log.Printf("Failed to change role in group %s: %v", groupID, err)
+		http.Error(w, fmt.Sprintf("Failed to change member role: %v", err), http.StatusInternalServerError)
+		return
 	}
-	fmt.Println("Signature:", signature) // Print statement for debugging
 
-	// Prepare the message object
-	message := Message{
-		IPFSHash:  ipfsHash,
-		Signature: signature,
-		Sender:    senderPublicKey,
-		Receiver:  receiverPublicKey,
-		Timestamp: time.Now(),
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GroupHandler renames (PUT) or deletes (DELETE) a group
+func GroupHandler(w http.ResponseWriter, r *http.Request) {
+	groupID := mux.Vars(r)["id"]
+
+	var request struct {
+		PublicKey string `json:"publicKey"`
+		GroupName string `json:"groupname"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.PublicKey == "" {
+		http.Error(w, "User public key is required.", http.StatusBadRequest)
+		return
+	}
+	if err := verifySession(r, request.PublicKey); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
 	}
 
-	// Log the chat ID for debugging
-	fmt.Println("Generated Chat ID:", chatID) // Print statement for debugging
+	if r.Method == http.MethodDelete {
+		_, err := submitSignedTransaction(request.PublicKey, "DeleteGroup", groupID, request.PublicKey)
+		if err != nil {
+			log.Printf("Failed to delete group %s: %v", groupID, err)
+			http.Error(w, fmt.Sprintf("Failed to delete group: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
 
-	// Add the message to the blockchain
-	err = AddMessageToBlockchain(chatID, message, senderPublicKey, receiverPublicKey)
+	if request.GroupName == "" {
+		http.Error(w, "groupname is required", http.StatusBadRequest)
+		return
+	}
+	_, err := submitSignedTransaction(request.PublicKey, "RenameGroup", groupID, request.PublicKey, request.GroupName)
 	if err != nil {
-		fmt.Println("Error while adding message to blockchain:", err) // Print statement for debugging
-		return fmt.Errorf("failed to add message to blockchain: %v", err)
+		log.Printf("Failed to rename group %s: %v", groupID, err)
+		http.Error(w, fmt.Sprintf("Failed to rename group: %v", err), http.StatusInternalServerError)
+		return
 	}
 
-	// Print statement to confirm successful completion
-	fmt.Println("Message successfully sent and added to blockchain.") // Print statement for debugging
-	return nil
+	group, err := readGroup(groupID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read group: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(group)
 }
 
+//-----------------------------------------------------------//
+
 // EncryptMessage encrypts plaintext using ECIES with AES-GCM for a specific recipient
 func EncryptGroupMessage(plainText string, publicKey string) (string, error) {
 	// Validate input
@@ -2093,273 +4348,913 @@ func DecryptGroupMessage(encryptedText string, privateKey string) (string, error
 		return "", fmt.Errorf("decryption failed: %v", err)
 	}
 
-	return string(plainText), nil
+	return string(plainText), nil
+}
+
+// Optional: Helper function to generate a signature for the message
+func SignGroupMessage(plainText string, privateKey string) (string, error) {
+	// Decode the private key
+	decodedPrivKey, err := hex.DecodeString(privateKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid private key encoding: %v", err)
+	}
+
+	// Parse the private key
+	privKey, err := x509.ParseECPrivateKey(decodedPrivKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid private key format: %v", err)
+	}
+
+	// Compute hash of the message
+	hash := sha256.Sum256([]byte(plainText))
+
+	// Sign the hash
+	r, s, err := ecdsa.Sign(rand.Reader, privKey, hash[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign message: %v", err)
+	}
+
+	// Return signature as comma-separated r and s values
+	return fmt.Sprintf("%s,%s", r.String(), s.String()), nil
+}
+
+// Optional: Helper function to verify signature
+func VerifyGroupSignature(plainText string, signature string, publicKeyHex string) (bool, error) {
+	// Decode the public key
+	pubKeyBytes, err := hex.DecodeString(publicKeyHex)
+	if err != nil {
+		return false, fmt.Errorf("invalid public key encoding: %v", err)
+	}
+
+	// Parse the public key
+	pubKey, err := x509.ParsePKIXPublicKey(pubKeyBytes)
+	if err != nil {
+		return false, fmt.Errorf("invalid public key format: %v", err)
+	}
+
+	// Assert the public key is of type ECDSA
+	ecdsaPubKey, ok := pubKey.(*ecdsa.PublicKey)
+	if !ok {
+		return false, fmt.Errorf("invalid public key type")
+	}
+
+	// Split the signature into r and s
+	parts := bytes.Split([]byte(signature), []byte(","))
+	if len(parts) != 2 {
+		return false, fmt.Errorf("invalid signature format")
+	}
+
+	// Parse r and s
+	r := new(big.Int)
+	r.SetString(string(parts[0]), 10)
+	s := new(big.Int)
+	s.SetString(string(parts[1]), 10)
+
+	// Compute hash of the message
+	hash := sha256.Sum256([]byte(plainText))
+
+	// Verify the signature
+	return ecdsa.Verify(ecdsaPubKey, hash[:], r, s), nil
+}
+
+// GroupMessage is a message in a group's on-chain chat
+type GroupMessage struct {
+	Seq       int      `json:"seq"`
+	IPFSHash  string   `json:"ipfsHash"`  // IPFS hash of the message encrypted for each recipient
+	Signature string   `json:"signature"` // Sender's signature over the plain text
+	Sender    string   `json:"sender"`
+	Receiver  []string `json:"receiver"` // Members of the group when the message was sent
+	Timestamp string   `json:"timestamp"`
+}
+
+// GroupChatPage is one page of a group's messages, newest first, as returned
+// by the chaincode. Before is the cursor for the next, older page, 0 once there is none.
+type GroupChatPage struct {
+	GroupID      string         `json:"groupId"`
+	Participants []string       `json:"participants"`
+	LastSeq      int            `json:"lastSeq"`
+	Messages     []GroupMessage `json:"messages"`
+	Before       int            `json:"before"`
+}
+
+// GroupChatMessage is a decrypted group message as returned to the client
+type GroupChatMessage struct {
+	Seq            int    `json:"seq"`
+	Sender         string `json:"sender"`
+	SenderUsername string `json:"senderUsername"`
+	PlainText      string `json:"plainText"`
+	Timestamp      string `json:"timestamp"`
+}
+
+// sendGroupMessage encrypts a message for every member of a group, stores the
+// encrypted copies in IPFS and appends the message to the group's chat
+func sendGroupMessage(group *Group, senderPublicKey string, plainText string) (int, error) {
+	senderPrivateKey, err := walletPrivateKey(senderPublicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	// One copy per member, including the sender so they can read their own messages
+	copies := make(map[string]string, len(group.Members))
+	for _, member := range group.Members {
+		encrypted, err := EncryptGroupMessage(plainText, member)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encrypt message for %s: %v", member, err)
+		}
+		copies[member] = encrypted
+	}
+	copiesJSON, err := json.Marshal(copies)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode message: %v", err)
+	}
+	ipfsHash, err := UploadMessageToIPFS(string(copiesJSON))
+	if err != nil {
+		return 0, fmt.Errorf("failed to upload message to IPFS: %v", err)
+	}
+
+	signature, err := SignGroupMessage(plainText, senderPrivateKey)
+	if err != nil {
+		return 0, fmt.Errorf("failed to sign message: %v", err)
+	}
+	messageJSON, err := json.Marshal(GroupMessage{IPFSHash: ipfsHash, Signature: signature})
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode message: %v", err)
+	}
+
+	result, err := submitSignedTransaction(senderPublicKey, "AddGroupMessage", group.ID, string(messageJSON), senderPublicKey)
+	if err != nil {
+		return 0, fmt.Errorf("failed to add message to blockchain: %v", err)
+	}
+	return strconv.Atoi(string(result))
+}
+
+// readGroupMessages decrypts one page of a group's chat, the pageSize messages
+// before the given sequence number (the newest when before is 0), keeping those
+// that were sent to readerPublicKey. The messages are returned in sequence
+// order, along with the cursor for the next, older page.
+func readGroupMessages(group *Group, readerPublicKey string, pageSize int, before int) ([]GroupChatMessage, int, error) {
+	readerPrivateKey, err := walletPrivateKey(readerPublicKey)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	result, err := contract.EvaluateTransaction("GetGroupChat", group.ID, strconv.Itoa(pageSize), strconv.Itoa(before))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch group chat: %v", err)
+	}
+	var page GroupChatPage
+	if err := json.Unmarshal(result, &page); err != nil {
+		return nil, 0, fmt.Errorf("failed to parse group chat: %v", err)
+	}
+
+	names := make(map[string]string, len(group.Members))
+	for i, member := range group.Members {
+		names[member] = group.MemberNames[i]
+	}
+
+	// The page is newest first; walk it backwards so the chat reads in order
+	messages := []GroupChatMessage{}
+	for i := len(page.Messages) - 1; i >= 0; i-- {
+		message := page.Messages[i]
+		content, err := FetchFromIPFS(message.IPFSHash)
+		if err != nil {
+			log.Printf("Failed to fetch group message %d: %v", message.Seq, err)
+			continue
+		}
+		var copies map[string]string
+		if err := json.Unmarshal([]byte(content), &copies); err != nil {
+			log.Printf("Failed to parse group message %d: %v", message.Seq, err)
+			continue
+		}
+
+		// Members who joined after the message was sent have no copy of it
+		encrypted, ok := copies[readerPublicKey]
+		if !ok {
+			continue
+		}
+		plainText, err := DecryptGroupMessage(encrypted, readerPrivateKey)
+		if err != nil {
+			log.Printf("Failed to decrypt group message %d: %v", message.Seq, err)
+			continue
+		}
+		valid, err := VerifyGroupSignature(plainText, message.Signature, message.Sender)
+		if err != nil || !valid {
+			log.Printf("Signature verification failed for group message %d", message.Seq)
+			continue
+		}
+
+		senderName, ok := names[message.Sender]
+		if !ok {
+			senderName = message.Sender
+		}
+		messages = append(messages, GroupChatMessage{
+			Seq:            message.Seq,
+			Sender:         message.Sender,
+			SenderUsername: senderName,
+			PlainText:      plainText,
+			Timestamp:      message.Timestamp,
+		})
+	}
+	return messages, page.Before, nil
+}
+
+// GroupChatHandler sends a message to a group or returns the group's messages.
+// Only current members of the group can do either.
+func GroupChatHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		Operation string `json:"operation"` // "send" or "get"
+		GroupID   string `json:"groupID"`
+		PublicKey string `json:"publicKey"`
+		PlainText string `json:"plainText"`
+		PageSize  int    `json:"pageSize"` // Defaults to the chaincode's page size
+		Before    int    `json:"before"`   // Cursor from the previous page, 0 for the newest messages
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if (request.Operation != "send" && request.Operation != "get") || request.GroupID == "" {
+		http.Error(w, "invalid operation or groupID specified. Use 'send' or 'get'.", http.StatusBadRequest)
+		return
+	}
+	if request.PublicKey == "" {
+		http.Error(w, "User public key is required", http.StatusBadRequest)
+		return
+	}
+	if err := verifySession(r, request.PublicKey); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	group, err := readGroup(request.GroupID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Group not found: %v", err), http.StatusNotFound)
+		return
+	}
+	if _, ok := group.Roles[request.PublicKey]; !ok {
+		http.Error(w, "Not a member of this group", http.StatusForbidden)
+		return
+	}
+
+	if request.Operation == "send" {
+		if request.PlainText == "" {
+			http.Error(w, "plainText is required", http.StatusBadRequest)
+			return
+		}
+		seq, err := sendGroupMessage(group, request.PublicKey, request.PlainText)
+		if err != nil {
+			log.Printf("Failed to send message to group %s: %v", group.ID, err)
+			http.Error(w, fmt.Sprintf("Failed to send message: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "Message sent successfully", "seq": seq})
+		return
+	}
+
+	messages, nextBefore, err := readGroupMessages(group, request.PublicKey, request.PageSize, request.Before)
+	if err != nil {
+		log.Printf("Failed to fetch messages of group %s: %v", group.ID, err)
+		http.Error(w, fmt.Sprintf("Failed to fetch messages: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"messages": messages,
+		"before":   nextBefore,
+	})
+}
+
+// chaincodeEventsCheckpointFile records the last chaincode event delivered to
+// subscribers, so the listener resumes where it left off after a restart
+const chaincodeEventsCheckpointFile = "chaincode-events.checkpoint"
+
+// StreamEvent is a chaincode event as delivered to /events subscribers. Payload
+// is the typed JSON payload emitted by the SmartContract.
+type StreamEvent struct {
+	Type        string          `json:"type"`
+	TxID        string          `json:"txId"`
+	BlockNumber uint64          `json:"blockNumber"`
+	Payload     json.RawMessage `json:"payload"`
 }
 
-// Optional: Helper function to generate a signature for the message
-func SignGroupMessage(plainText string, privateKey string) (string, error) {
-	// Decode the private key
-	decodedPrivKey, err := hex.DecodeString(privateKey)
-	if err != nil {
-		return "", fmt.Errorf("invalid private key encoding: %v", err)
+// eventHub fans ledger events out to the connected clients
+var eventHub = struct {
+	sync.Mutex
+	subscribers map[chan StreamEvent]bool
+}{subscribers: make(map[chan StreamEvent]bool)}
+
+func subscribeEvents() chan StreamEvent {
+	ch := make(chan StreamEvent, 64)
+	eventHub.Lock()
+	eventHub.subscribers[ch] = true
+	eventHub.Unlock()
+	return ch
+}
+
+func unsubscribeEvents(ch chan StreamEvent) {
+	eventHub.Lock()
+	delete(eventHub.subscribers, ch)
+	eventHub.Unlock()
+}
+
+// publishEvent delivers an event to every subscriber. A subscriber that is too
+// slow to keep up misses the event rather than stalling the listener.
+func publishEvent(event StreamEvent) {
+	eventHub.Lock()
+	defer eventHub.Unlock()
+	for ch := range eventHub.subscribers {
+		select {
+		case ch <- event:
+		default:
+			log.Printf("Dropping %s event for a slow subscriber", event.Type)
+		}
 	}
+}
 
-	// Parse the private key
-	privKey, err := x509.ParseECPrivateKey(decodedPrivKey)
+// startEventListener reads the social_media chaincode events from the gateway and
+// publishes them to the event hub. Each event is checkpointed once published, and
+// the stream is reopened from the checkpoint whenever it breaks.
+func startEventListener(ctx context.Context) {
+	checkpointer, err := client.NewFileCheckpointer(chaincodeEventsCheckpointFile)
 	if err != nil {
-		return "", fmt.Errorf("invalid private key format: %v", err)
+		log.Printf("Failed to open event checkpoint, events are disabled: %v", err)
+		return
 	}
+	defer checkpointer.Close()
 
-	// Compute hash of the message
-	hash := sha256.Sum256([]byte(plainText))
+	backoff := time.Second
+	for {
+		events, err := network.ChaincodeEvents(ctx, "social_media", client.WithCheckpoint(checkpointer))
+		if err != nil {
+			log.Printf("Failed to listen for chaincode events: %v", err)
+		} else {
+			log.Println("Listening for chaincode events")
+			for event := range events {
+				backoff = time.Second
+				payload := json.RawMessage(event.Payload)
+				if !json.Valid(payload) {
+					payload, _ = json.Marshal(string(event.Payload))
+				}
+				streamEvent := StreamEvent{
+					Type:        event.EventName,
+					TxID:        event.TransactionID,
+					BlockNumber: event.BlockNumber,
+					Payload:     payload,
+				}
+				publishEvent(streamEvent)
+				applyNotificationEvent(streamEvent)
+				if err := checkpointer.CheckpointChaincodeEvent(event); err != nil {
+					log.Printf("Failed to checkpoint chaincode event: %v", err)
+				}
+			}
+		}
 
-	// Sign the hash
-	r, s, err := ecdsa.Sign(rand.Reader, privKey, hash[:])
-	if err != nil {
-		return "", fmt.Errorf("failed to sign message: %v", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+		log.Println("Reconnecting to chaincode events")
 	}
-
-	// Return signature as comma-separated r and s values
-	return fmt.Sprintf("%s,%s", r.String(), s.String()), nil
 }
 
-// Optional: Helper function to verify signature
-func VerifyGroupSignature(plainText string, signature string, publicKeyHex string) (bool, error) {
-	// Decode the public key
-	pubKeyBytes, err := hex.DecodeString(publicKeyHex)
-	if err != nil {
-		return false, fmt.Errorf("invalid public key encoding: %v", err)
+// eventConcerns reports whether an event names publicKey in its payload, as
+// its sender, receiver, author, a mentioned user and so on. Payload fields are
+// checked at the top level only, where the SmartContract puts user keys.
+func eventConcerns(event StreamEvent, publicKey string) bool {
+	var payload map[string]interface{}
+	if err := json.Unmarshal(event.Payload, &payload); err != nil {
+		return false
+	}
+	for _, value := range payload {
+		switch value := value.(type) {
+		case string:
+			if value == publicKey {
+				return true
+			}
+		case []interface{}:
+			for _, item := range value {
+				if item == publicKey {
+					return true
+				}
+			}
+		}
 	}
+	return false
+}
 
-	// Parse the public key
-	pubKey, err := x509.ParsePKIXPublicKey(pubKeyBytes)
-	if err != nil {
-		return false, fmt.Errorf("invalid public key format: %v", err)
+// EventsHandler streams the ledger events that concern a user to them as
+// server-sent events, see eventConcerns. Only the user can subscribe, with a
+// session token, see verifySession. The optional types parameter is a comma
+// separated list of event types to receive.
+func EventsHandler(w http.ResponseWriter, r *http.Request) {
+	publicKey := r.URL.Query().Get("publicKey")
+	if publicKey == "" {
+		http.Error(w, "publicKey is required", http.StatusBadRequest)
+		return
+	}
+	if err := verifySession(r, publicKey); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
 	}
 
-	// Assert the public key is of type ECDSA
-	ecdsaPubKey, ok := pubKey.(*ecdsa.PublicKey)
+	flusher, ok := w.(http.Flusher)
 	if !ok {
-		return false, fmt.Errorf("invalid public key type")
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
 	}
 
-	// Split the signature into r and s
-	parts := bytes.Split([]byte(signature), []byte(","))
-	if len(parts) != 2 {
-		return false, fmt.Errorf("invalid signature format")
+	var types map[string]bool
+	if filter := r.URL.Query().Get("types"); filter != "" {
+		types = make(map[string]bool)
+		for _, t := range strings.Split(filter, ",") {
+			types[strings.TrimSpace(t)] = true
+		}
 	}
 
-	// Parse r and s
-	r := new(big.Int)
-	r.SetString(string(parts[0]), 10)
-	s := new(big.Int)
-	s.SetString(string(parts[1]), 10)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
 
-	// Compute hash of the message
-	hash := sha256.Sum256([]byte(plainText))
+	events := subscribeEvents()
+	defer unsubscribeEvents(events)
 
-	// Verify the signature
-	return ecdsa.Verify(ecdsaPubKey, hash[:], r, s), nil
-}
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
 
-func DecryptAndFetchGroupMessages(chatID string, senderPublicKey string, receiverPublicKey string, senderPrivateKey string, receiverPrivateKey string, waitTime time.Duration) ([]string, error) {
-	// Fetch the chat data from the blockchain using the chaincode
-	chat, err := GetChatFromBlockchain(chatID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch chat: %v", err)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case event := <-events:
+			if types != nil && !types[event.Type] {
+				continue
+			}
+			if !eventConcerns(event, publicKey) {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Printf("Failed to marshal %s event: %v", event.Type, err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			flusher.Flush()
+		}
 	}
+}
 
-	var decryptedMessages []string
-	var mu sync.Mutex
+// notificationsDir keeps the notification inboxes across restarts, one file
+// per user, so an event only rewrites the inboxes it changes. Events are
+// applied to the inboxes before they are checkpointed, so none are lost.
+const notificationsDir = "notifications"
+
+// maxNotificationsPerUser bounds an inbox; the oldest notifications are dropped first
+const maxNotificationsPerUser = 200
+
+// maxNotificationTxIDs bounds the transactions a notification remembers. Only
+// events after the last checkpoint are replayed, so the latest few suffice.
+const maxNotificationTxIDs = 50
+
+// Types of notification
+const (
+	notificationFriendRequest  = "friend_request"
+	notificationFriendAccepted = "friend_request_accepted"
+	notificationReaction       = "reaction"
+	notificationComment        = "comment"
+	notificationReply          = "reply"
+	notificationMention        = "mention"
+)
 
-	// For each message in the chat, fetch the IPFS content, decrypt it, and then verify its signature
-	for _, message := range chat.Messages {
-		// Simulate wait time until decryption
-		log.Printf("Waiting for %v before decrypting message from sender: %s", waitTime, message.Sender)
-		time.Sleep(waitTime)
+// notificationVerbs describes what the actors of each type of notification did
+var notificationVerbs = map[string]string{
+	notificationFriendRequest:  "sent you a friend request",
+	notificationFriendAccepted: "accepted your friend request",
+	notificationReaction:       "reacted to your post",
+	notificationComment:        "commented on your post",
+	notificationReply:          "replied to your comment",
+	notificationMention:        "mentioned you in a post",
+}
 
-		// Fetch the encrypted message from IPFS
-		encryptedMessage, err := FetchFromIPFS(message.IPFSHash)
-		if err != nil {
-			return nil, fmt.Errorf("failed to fetch message from IPFS: %v", err)
-		}
+// Notification tells a user that others acted on them or their posts. Unread
+// notifications of the same type about the same post are grouped, so five
+// reactions to a post make one notification with five actors.
+type Notification struct {
+	ID        string    `json:"id"` // Transaction ID of the event that started the notification
+	Type      string    `json:"type"`
+	PostID    string    `json:"postId,omitempty"` // ID the ledger assigned the post, as used by /post/{id}
+	Actors    []string  `json:"actors"`           // Public keys, most recent first
+	Count     int       `json:"count"`            // Number of distinct actors
+	Summary   string    `json:"summary,omitempty"`
+	Read      bool      `json:"read"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"` // When the latest actor was added
+	TxIDs     []string  `json:"txIds"`     // Transactions applied to the notification, most recent last
+}
 
-		// Determine which private key to use for decryption
-		var privateKeyToUse string
-		if message.Sender == senderPublicKey {
-			privateKeyToUse = receiverPrivateKey
-		} else {
-			privateKeyToUse = senderPrivateKey
-		}
+// notificationStore holds each user's inbox, newest first
+var notificationStore = struct {
+	sync.Mutex
+	inboxes map[string][]*Notification
+	dirty   map[string]bool // Inboxes changed since they were last saved
+}{inboxes: make(map[string][]*Notification), dirty: make(map[string]bool)}
+
+// savedInbox is the file an inbox is saved to
+type savedInbox struct {
+	PublicKey     string          `json:"publicKey"`
+	Notifications []*Notification `json:"notifications"`
+}
 
-		log.Printf("Sender Public Key: %s", message.Sender)
-		log.Printf("Receiver Public Key: %s", receiverPublicKey)
-		log.Printf("Private Key being used: %s", privateKeyToUse)
-		log.Printf("Encrypted Message: %s", encryptedMessage)
+// inboxFile is the path of a user's inbox. Public keys are too long for file
+// names, so the file is named after a hash of the key.
+func inboxFile(publicKey string) string {
+	sum := sha256.Sum256([]byte(publicKey))
+	return filepath.Join(notificationsDir, hex.EncodeToString(sum[:])+".json")
+}
 
-		// Decrypt the message using the chosen private key
-		decryptedMessage, err := DecryptGroupMessage(encryptedMessage, privateKeyToUse)
+// loadNotifications reads the inboxes saved by saveNotifications
+func loadNotifications() error {
+	notificationStore.Lock()
+	defer notificationStore.Unlock()
+
+	if err := os.MkdirAll(notificationsDir, 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %v", notificationsDir, err)
+	}
+	entries, err := os.ReadDir(notificationsDir)
+	if err != nil {
+		return fmt.Errorf("failed to read notifications: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(notificationsDir, entry.Name()))
 		if err != nil {
-			return nil, fmt.Errorf("failed to decrypt message: %v", err)
+			return fmt.Errorf("failed to read notifications: %v", err)
 		}
+		var inbox savedInbox
+		if err := json.Unmarshal(data, &inbox); err != nil {
+			return fmt.Errorf("failed to parse notifications in %s: %v", entry.Name(), err)
+		}
+		notificationStore.inboxes[inbox.PublicKey] = inbox.Notifications
+	}
+	return nil
+}
 
-		log.Printf("Message sender: %s", message.Sender)
-		log.Printf("Message signature: %s", message.Signature)
-
-		// Verify the decrypted message's signature
-		isValid, err := VerifyGroupSignature(decryptedMessage, message.Signature, message.Sender)
-		if err != nil || !isValid {
-			if err != nil {
-				log.Printf("Signature verification error: %v", err)
-			}
-			return nil, fmt.Errorf("signature verification failed for decrypted message: %s", decryptedMessage)
+// saveNotifications writes the inboxes changed since the last save to disk.
+// Inboxes that fail to save are tried again on the next save. The caller holds
+// the store's lock.
+func saveNotifications() {
+	for publicKey := range notificationStore.dirty {
+		if err := saveInbox(publicKey); err != nil {
+			log.Printf("Failed to save notifications of %s: %v", publicKey, err)
+			continue
 		}
-		log.Printf("Signature verified for decrypted message: %s", decryptedMessage)
+		delete(notificationStore.dirty, publicKey)
+	}
+}
 
-		// Synchronize access to the decryptedMessages slice
-		mu.Lock()
-		decryptedMessages = append(decryptedMessages, decryptedMessage)
-		mu.Unlock()
+// saveInbox writes a user's inbox to its file, or removes the file once the
+// inbox has been dropped
+func saveInbox(publicKey string) error {
+	path := inboxFile(publicKey)
+	inbox, ok := notificationStore.inboxes[publicKey]
+	if !ok {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
 	}
 
-	return decryptedMessages, nil
+	data, err := json.Marshal(savedInbox{PublicKey: publicKey, Notifications: inbox})
+	if err != nil {
+		return err
+	}
+	temp := path + ".tmp"
+	if err := os.WriteFile(temp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(temp, path)
 }
 
-func GroupChatHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("ChatHandler invoked")
-
-	if r.Method != http.MethodPost {
-		log.Println("Invalid method. Only POST is allowed")
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+// notify adds actor to recipient's inbox. Post notifications join the unread
+// notification of the same type about the same post, if there is one, which
+// then moves to the top of the inbox. Events replayed after a restart were
+// applied already and change nothing.
+func notify(recipient string, notificationType string, postID string, actor string, txID string) {
+	if recipient == "" || recipient == actor {
 		return
 	}
+	now := time.Now()
+	inbox := notificationStore.inboxes[recipient]
 
-	log.Println("Reading raw request body")
-	body, _ := io.ReadAll(r.Body)
-	log.Printf("Raw request body: %s", string(body))
-
-	log.Println("Parsing operation, username, groupID, and participants")
-	var baseReq struct {
-		Operation    string   `json:"operation"`
-		GroupID      string   `json:"groupID"`
-		Participants []string `json:"participants"`
+	for _, notification := range inbox {
+		for _, applied := range notification.TxIDs {
+			if applied == txID {
+				return
+			}
+		}
 	}
-	err := json.Unmarshal(body, &baseReq)
-	if err != nil || (baseReq.Operation != "send" && baseReq.Operation != "get") || baseReq.GroupID == "" {
-		log.Println("Invalid operation or groupID specified")
-		http.Error(w, "invalid operation or groupID specified. Use 'send' or 'get'.", http.StatusBadRequest)
+
+	for i, notification := range inbox {
+		if postID == "" || notification.Read || notification.Type != notificationType || notification.PostID != postID {
+			continue
+		}
+		actors := []string{actor}
+		for _, existing := range notification.Actors {
+			if existing != actor {
+				actors = append(actors, existing)
+			}
+		}
+		notification.Actors = actors
+		notification.Count = len(actors)
+		notification.UpdatedAt = now
+		notification.TxIDs = append(notification.TxIDs, txID)
+		if len(notification.TxIDs) > maxNotificationTxIDs {
+			notification.TxIDs = notification.TxIDs[len(notification.TxIDs)-maxNotificationTxIDs:]
+		}
+		inbox = append(inbox[:i], inbox[i+1:]...)
+		notificationStore.inboxes[recipient] = append([]*Notification{notification}, inbox...)
+		notificationStore.dirty[recipient] = true
 		return
 	}
 
-	log.Printf("Operation: %s, GroupID: %s", baseReq.Operation, baseReq.GroupID)
+	notification := &Notification{
+		ID:        txID,
+		Type:      notificationType,
+		PostID:    postID,
+		Actors:    []string{actor},
+		Count:     1,
+		CreatedAt: now,
+		UpdatedAt: now,
+		TxIDs:     []string{txID},
+	}
+	inbox = append([]*Notification{notification}, inbox...)
+	if len(inbox) > maxNotificationsPerUser {
+		inbox = inbox[:maxNotificationsPerUser]
+	}
+	notificationStore.inboxes[recipient] = inbox
+	notificationStore.dirty[recipient] = true
+}
 
-	if len(baseReq.Participants) == 0 {
-		log.Println("No participants in the group")
-		http.Error(w, "no participants in the group", http.StatusBadRequest)
+// applyNotificationEvent turns a ledger event into notifications for the users
+// it concerns. Events that notify nobody are ignored.
+func applyNotificationEvent(event StreamEvent) {
+	var payload struct {
+		Sender          string   `json:"sender"`
+		Receiver        string   `json:"receiver"`
+		Status          string   `json:"status"`
+		PostID          string   `json:"postId"`
+		AuthorPublicKey string   `json:"authorPublicKey"`
+		UserPublicKey   string   `json:"userPublicKey"`
+		ReactionType    string   `json:"reactionType"`
+		PostAuthor      string   `json:"postAuthor"`
+		ParentAuthor    string   `json:"parentAuthor"`
+		Mentions        []string `json:"mentions"`
+		PublicKey       string   `json:"publicKey"`
+		OldPublicKey    string   `json:"oldPublicKey"`
+		NewPublicKey    string   `json:"newPublicKey"`
+	}
+	if err := json.Unmarshal(event.Payload, &payload); err != nil {
 		return
 	}
 
-	for _, participant := range baseReq.Participants {
-		log.Printf("Processing participant: %s", participant)
+	notificationStore.Lock()
+	defer notificationStore.Unlock()
 
-		if baseReq.Operation == "send" {
-			log.Println("Handling 'send' operation")
-			var sendReq struct {
-				Username  string `json:"username"`
-				PlainText string `json:"plainText"`
-			}
-
-			r.Body = io.NopCloser(bytes.NewBuffer(body))
-
-			log.Println("Decoding 'send' request")
-			err := json.NewDecoder(r.Body).Decode(&sendReq)
-			if err != nil {
-				log.Println("Invalid request body for 'send'")
-				http.Error(w, "invalid request body for 'send'", http.StatusBadRequest)
-				return
-			}
-			log.Printf("Sender: %s, PlainText: %s", sendReq.Username, sendReq.PlainText)
-
-			if participant == sendReq.Username {
-				log.Printf("Skipping sender: %s", participant)
-				continue
-			}
+	switch event.Type {
+	case "FriendRequestSent":
+		notify(payload.Receiver, notificationFriendRequest, "", payload.Sender, event.TxID)
+	case "FriendRequestResponded":
+		if payload.Status != "accepted" {
+			return
+		}
+		notify(payload.Sender, notificationFriendAccepted, "", payload.Receiver, event.TxID)
+	case "ReactionChanged":
+		// Removing a reaction does not take the notification back
+		if payload.ReactionType == "" {
+			return
+		}
+		notify(payload.AuthorPublicKey, notificationReaction, payload.PostID, payload.UserPublicKey, event.TxID)
+	case "CommentAdded":
+		notify(payload.PostAuthor, notificationComment, payload.PostID, payload.AuthorPublicKey, event.TxID)
+		if payload.ParentAuthor != payload.PostAuthor {
+			notify(payload.ParentAuthor, notificationReply, payload.PostID, payload.AuthorPublicKey, event.TxID)
+		}
+	case "PostCreated", "PostEdited":
+		for _, mentioned := range payload.Mentions {
+			notify(mentioned, notificationMention, payload.PostID, payload.AuthorPublicKey, event.TxID)
+		}
+	case "UserKeyRotated":
+		if inbox, ok := notificationStore.inboxes[payload.OldPublicKey]; ok {
+			merged := append(notificationStore.inboxes[payload.NewPublicKey], inbox...)
+			sort.SliceStable(merged, func(i, j int) bool {
+				return merged[i].UpdatedAt.After(merged[j].UpdatedAt)
+			})
+			notificationStore.inboxes[payload.NewPublicKey] = merged
+			delete(notificationStore.inboxes, payload.OldPublicKey)
+			notificationStore.dirty[payload.NewPublicKey] = true
+			notificationStore.dirty[payload.OldPublicKey] = true
+		}
+	case "UserErased":
+		delete(notificationStore.inboxes, payload.PublicKey)
+		notificationStore.dirty[payload.PublicKey] = true
+	default:
+		return
+	}
+	saveNotifications()
+}
 
-			senderKeys, err := loadKeys(sendReq.Username)
-			if err != nil {
-				log.Printf("Failed to load keys for sender %s: %v", sendReq.Username, err)
-				http.Error(w, fmt.Sprintf("failed to load keys for sender %s: %v", sendReq.Username, err), http.StatusInternalServerError)
-				return
-			}
+// notificationCursor marks the position of a notification in its inbox. It is
+// built from fields that never change, so it still finds the notification after
+// more actors are grouped into it.
+func notificationCursor(notification *Notification) string {
+	return fmt.Sprintf("%d-%s", notification.CreatedAt.UnixNano(), notification.ID)
+}
 
-			participantKeys, err := loadKeys(participant)
-			if err != nil {
-				log.Printf("Failed to load keys for participant %s: %v", participant, err)
-				http.Error(w, fmt.Sprintf("failed to load keys for participant %s: %v", participant, err), http.StatusInternalServerError)
-				return
+// summarizeNotification describes a notification in words, such as "alice and
+// 4 others reacted to your post". names caches the actors' names.
+func summarizeNotification(notification *Notification, names map[string]string) string {
+	name, ok := names[notification.Actors[0]]
+	if !ok {
+		name = "Someone"
+		if response, err := contract.EvaluateTransaction("GetUser", notification.Actors[0]); err == nil {
+			var user User
+			if err := json.Unmarshal(response, &user); err == nil && user.Name != "" {
+				name = user.Name
 			}
+		}
+		names[notification.Actors[0]] = name
+	}
 
-			chatID := generateGroupChatID(baseReq.GroupID)
-			log.Printf("Generated chat ID: %s", chatID)
+	switch notification.Count {
+	case 1:
+	case 2:
+		name += " and 1 other"
+	default:
+		name += fmt.Sprintf(" and %d others", notification.Count-1)
+	}
+	return name + " " + notificationVerbs[notification.Type]
+}
 
-			err = SendGroupMessage(&Chat{}, senderKeys.PrivateKey, senderKeys.PublicKey, participantKeys.PublicKey, sendReq.PlainText, chatID)
-			if err != nil {
-				log.Printf("Failed to send message to participant %s: %v", participant, err)
-				continue
-			}
-			log.Printf("Message sent successfully to participant %s", participant)
+// NotificationsHandler returns one page of a user's notifications, newest
+// first. unread=true lists only unread notifications, and type limits the
+// list to one type of notification. Only the user can read their
+// notifications, with a session token, see verifySession. A cursor whose notification has since been dropped
+// from the inbox is rejected, and the client starts again from the first page.
+func NotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	publicKey := r.URL.Query().Get("publicKey")
+	if publicKey == "" {
+		http.Error(w, "publicKey is required", http.StatusBadRequest)
+		return
+	}
+	if err := verifySession(r, publicKey); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	limitParam, cursor, err := pageParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, _ := strconv.Atoi(limitParam)
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+	notificationType := r.URL.Query().Get("type")
+
+	// Copy the page so it can be summarized without holding the lock
+	page := []Notification{}
+	next := ""
+	notificationStore.Lock()
+	started := cursor == ""
+	for _, notification := range notificationStore.inboxes[publicKey] {
+		if !started {
+			started = notificationCursor(notification) == cursor
+			continue
+		}
+		if (unreadOnly && notification.Read) || (notificationType != "" && notification.Type != notificationType) {
+			continue
 		}
+		if len(page) == limit {
+			next = notificationCursor(&page[len(page)-1])
+			break
+		}
+		page = append(page, *notification)
+	}
+	notificationStore.Unlock()
+	if !started {
+		http.Error(w, "cursor no longer matches a notification", http.StatusBadRequest)
+		return
+	}
 
-		if baseReq.Operation == "get" {
-			log.Println("Handling 'get' operation")
-			var getReq struct {
-				SenderUsername string `json:"senderUsername"`
-			}
+	names := make(map[string]string)
+	for i := range page {
+		page[i].Summary = summarizeNotification(&page[i], names)
+	}
 
-			r.Body = io.NopCloser(bytes.NewBuffer(body))
+	setNextCursor(w, next)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
 
-			log.Println("Decoding 'get' request")
-			err := json.NewDecoder(r.Body).Decode(&getReq)
-			if err != nil {
-				log.Println("Invalid request body for 'get'")
-				http.Error(w, "invalid request body for 'get'", http.StatusBadRequest)
-				return
-			}
+// UnreadNotificationsHandler returns how many of a user's notifications are
+// unread, in total and by type. Only the user can read their counts, with a
+// session token, see verifySession.
+func UnreadNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	publicKey := r.URL.Query().Get("publicKey")
+	if publicKey == "" {
+		http.Error(w, "publicKey is required", http.StatusBadRequest)
+		return
+	}
+	if err := verifySession(r, publicKey); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
 
-			log.Printf("Fetching messages for participant: %s", participant)
+	unread := 0
+	byType := make(map[string]int)
+	notificationStore.Lock()
+	for _, notification := range notificationStore.inboxes[publicKey] {
+		if !notification.Read {
+			unread++
+			byType[notification.Type]++
+		}
+	}
+	notificationStore.Unlock()
 
-			if participant == getReq.SenderUsername {
-				log.Printf("Skipping sender: %s for message fetching", participant)
-				continue
-			}
-			participantKeys, err := loadKeys(participant)
-			if err != nil {
-				log.Printf("Failed to load keys for participant %s: %v", participant, err)
-				http.Error(w, fmt.Sprintf("failed to load keys for participant %s: %v", participant, err), http.StatusInternalServerError)
-				continue
-			}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"unread": unread, "byType": byType})
+}
 
-			senderKeys, err := loadKeys(getReq.SenderUsername)
-			if err != nil {
-				log.Printf("Failed to load keys for sender %s: %v", getReq.SenderUsername, err)
-				http.Error(w, fmt.Sprintf("failed to load keys for sender %s: %v", getReq.SenderUsername, err), http.StatusInternalServerError)
-				continue
-			}
+// MarkNotificationReadHandler marks one of a user's notifications as read
+func MarkNotificationReadHandler(w http.ResponseWriter, r *http.Request) {
+	markNotificationsRead(w, r, false)
+}
 
-			log.Println("Fetching messages...")
+// MarkAllNotificationsReadHandler marks all of a user's notifications as read
+func MarkAllNotificationsReadHandler(w http.ResponseWriter, r *http.Request) {
+	markNotificationsRead(w, r, true)
+}
 
-			chatID := generateGroupChatID(baseReq.GroupID)
-			decryptedMessages, err := DecryptAndFetchGroupMessages(chatID, senderKeys.PublicKey, participantKeys.PublicKey, senderKeys.PrivateKey, participantKeys.PrivateKey, 10*time.Second)
-			if err != nil {
-				log.Printf("Failed to fetch messages for participant %s: %v", participant, err)
-				continue
-			}
+// markNotificationsRead marks the notification with the requested id as read,
+// or every notification when all is set. Only the user can change their
+// notifications, with a session token, see verifySession.
+func markNotificationsRead(w http.ResponseWriter, r *http.Request, all bool) {
+	var request struct {
+		PublicKey string `json:"publicKey"`
+		ID        string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.PublicKey == "" || (!all && request.ID == "") {
+		http.Error(w, "publicKey and id are required", http.StatusBadRequest)
+		return
+	}
+	if err := verifySession(r, request.PublicKey); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
 
-			log.Printf("Messages fetched successfully for participant %s", participant)
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(map[string]interface{}{"participant": participant, "messages": decryptedMessages})
+	marked := 0
+	found := all
+	notificationStore.Lock()
+	for _, notification := range notificationStore.inboxes[request.PublicKey] {
+		if !all && notification.ID != request.ID {
+			continue
+		}
+		found = true
+		if !notification.Read {
+			notification.Read = true
+			marked++
 		}
 	}
+	if marked > 0 {
+		notificationStore.dirty[request.PublicKey] = true
+		saveNotifications()
+	}
+	notificationStore.Unlock()
+
+	if !found {
+		http.Error(w, "Notification not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"marked": marked})
 }
 
 // -----------------------------------------------------------//
@@ -2370,32 +5265,91 @@ func main() {
 		log.Fatalf("Error initializing Fabric: %v", err)
 	}
 
+	// Stream ledger events to connected clients and into the notification inboxes
+	if err := loadNotifications(); err != nil {
+		log.Printf("Starting with empty notification inboxes: %v", err)
+	}
+	go startEventListener(context.Background())
+
+	// Erase deactivated accounts once their grace period has passed
+	go startErasureSweep(context.Background())
+
 	// Register handlers
 	r := mux.NewRouter()
 	r.HandleFunc("/signup", SignUpHandler).Methods("POST")
 	r.HandleFunc("/login", LoginHandler).Methods("POST")
-	r.HandleFunc("/post", PostHandler).Methods("POST")
+	r.HandleFunc("/auth/challenge", ChallengeHandler).Methods("POST")
+	r.HandleFunc("/auth/session", SessionHandler).Methods("POST")
+	r.HandleFunc("/post", PostHandler).Methods("POST", "GET")
 	r.HandleFunc("/feed", FeedHandler).Methods("GET")
-	r.HandleFunc("/post/{id}/react", ReactionHandler).Methods("POST")
+	r.HandleFunc("/post/{id}/react", ReactionHandler).Methods("POST", "DELETE")
+	r.HandleFunc("/reactions/types", ReactionTypesHandler).Methods("GET")
+	r.HandleFunc("/post/{id}", GetPostByIDHandler).Methods("GET")
+	r.HandleFunc("/post/{id}", EditPostHandler).Methods("PUT")
+	r.HandleFunc("/post/{id}", DeletePostHandler).Methods("DELETE")
+	r.HandleFunc("/post/{id}/history", PostHistoryHandler).Methods("GET")
+	r.HandleFunc("/post/{id}/share", ShareHandler).Methods("POST")
+	r.HandleFunc("/post/{id}/shares", SharesHandler).Methods("GET")
+	r.HandleFunc("/post/{id}/report", ReportHandler).Methods("POST")
+	r.HandleFunc("/post/{id}/comments", CommentsHandler).Methods("GET", "POST")
+	r.HandleFunc("/post/{id}/comments/settings", CommentSettingsHandler).Methods("POST")
+	r.HandleFunc("/post/{id}/comments/{commentId}", DeleteCommentHandler).Methods("DELETE")
+	r.HandleFunc("/post/{id}/comments/{commentId}/hide", HideCommentHandler).Methods("POST")
 	r.HandleFunc("/users", GetAllUsersHandler).Methods("GET")
+	r.HandleFunc("/users/handle", ChangeHandleHandler).Methods("POST")
+	r.HandleFunc("/users/key", RotateKeyHandler).Methods("POST")
+	r.HandleFunc("/users/{id}/key/continue", ContinueKeyRotationHandler).Methods("POST")
+	r.HandleFunc("/users/{id}", ProfileHandler).Methods("GET", "PUT")
+	r.HandleFunc("/users/{id}/mentions", MentionsHandler).Methods("GET")
+	r.HandleFunc("/users/{id}/followers", FollowersHandler).Methods("GET")
+	r.HandleFunc("/users/{id}/following", FollowersHandler).Methods("GET")
+	r.HandleFunc("/users/{id}/report", ReportHandler).Methods("POST")
+	r.HandleFunc("/users/{id}/deactivate", DeactivateHandler).Methods("POST")
+	r.HandleFunc("/users/{id}/reactivate", ReactivateHandler).Methods("POST")
+	r.HandleFunc("/users/{id}/hashtags", FollowedHashtagsHandler).Methods("GET")
+	r.HandleFunc("/hashtags/trending", TrendingHashtagsHandler).Methods("GET")
+	r.HandleFunc("/hashtags/{tag}/posts", HashtagPostsHandler).Methods("GET")
+	r.HandleFunc("/hashtags/{tag}/follow", HashtagFollowHandler).Methods("POST", "DELETE")
 	r.HandleFunc("/chat", ChatHandler)
 	r.HandleFunc("/groups", CreateGroupHandler).Methods("POST")
+	r.HandleFunc("/groups/{id}", GroupHandler).Methods("PUT", "DELETE")
+	r.HandleFunc("/groups/{id}/members", GroupMembersHandler).Methods("POST", "DELETE")
+	r.HandleFunc("/groups/{id}/role", GroupRoleHandler).Methods("POST")
 	// r.HandleFunc("/usergroups", UserGroupHandler).Methods("GET")
 	// r.HandleFunc("/getchat", GetChatMessagesHandler)
 	r.HandleFunc("/friend-request/send", sendFriendRequestHandler).Methods("POST")
 	r.HandleFunc("/friend-requests/{id}", getFriendRequestsHandler).Methods("GET")
 	r.HandleFunc("/friend-request/respond", respondToFriendRequestHandler).Methods("POST")
+	r.HandleFunc("/friend-request/cancel", cancelFriendRequestHandler).Methods("POST")
 	r.HandleFunc("/friends/{id}", getFriendsHandler).Methods("GET")
+	r.HandleFunc("/friends/{id}/{friendId}", removeFriendHandler).Methods("DELETE")
+	r.HandleFunc("/blocks", BlockHandler).Methods("POST", "DELETE")
+	r.HandleFunc("/blocks/{id}", GetBlockedUsersHandler).Methods("GET")
+	r.HandleFunc("/follows", FollowHandler).Methods("POST", "DELETE")
+	// Moderator and admin transactions (GetReports, HidePost, RestorePost,
+	// SuspendUser, ReinstateUser, SetReportThreshold and SetReactionTypes) are
+	// not served here. The backend submits everything under one shared gateway
+	// identity and cannot tell who is calling, so moderators and admins submit
+	// them from their own Fabric clients, enrolled with the decentrum.moderator
+	// or decentrum.admin attribute. The gateway identity must not carry either.
+	r.HandleFunc("/moderation/log", ModerationLogHandler).Methods("GET")
+	r.HandleFunc("/moderation/threshold", ReportThresholdHandler).Methods("GET")
 
 	r.HandleFunc("/usergroups", GetAllGroupsHandler).Methods("POST")
 	r.HandleFunc("/groupchat", GroupChatHandler)
+	r.HandleFunc("/events", EventsHandler).Methods("GET")
+	r.HandleFunc("/notifications", NotificationsHandler).Methods("GET")
+	r.HandleFunc("/notifications/unread", UnreadNotificationsHandler).Methods("GET")
+	r.HandleFunc("/notifications/read", MarkNotificationReadHandler).Methods("POST")
+	r.HandleFunc("/notifications/read-all", MarkAllNotificationsReadHandler).Methods("POST")
 	//r.HandleFunc("/getchat", GetChatMessagesHandler)
 
 	// Apply CORS middleware
 	c := cors.New(cors.Options{
 		AllowedOrigins: []string{"*"}, // Replace with specific domains for production
 		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders: []string{"Content-Type"},
+		AllowedHeaders: []string{"Content-Type", "Authorization"},
+		ExposedHeaders: []string{"X-Next-Cursor"},
 	})
 	handler := c.Handler(r)
 
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// removeFromFriendsList drops friend from owner's friends list, reporting whether it was there
+func (s *SmartContract) removeFromFriendsList(ctx contractapi.TransactionContextInterface, owner string, friend string) (bool, error) {
+	friendsKey, err := entityKey(ctx, friendsObjectType, owner)
+	if err != nil {
+		return false, err
+	}
+	friendsJSON, err := ctx.GetStub().GetState(friendsKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to get friends list for %s: %v", owner, err)
+	}
+	if friendsJSON == nil {
+		return false, nil
+	}
+
+	var friendsList FriendsList
+	err = json.Unmarshal(friendsJSON, &friendsList)
+	if err != nil {
+		return false, fmt.Errorf("failed to unmarshal friends list for %s: %v", owner, err)
+	}
+
+	remaining := []string{}
+	for _, existing := range friendsList.Friends {
+		if existing != friend {
+			remaining = append(remaining, existing)
+		}
+	}
+	if len(remaining) == len(friendsList.Friends) {
+		return false, nil
+	}
+	friendsList.Friends = remaining
+
+	updatedJSON, err := json.Marshal(friendsList)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal friends list for %s: %v", owner, err)
+	}
+	err = ctx.GetStub().PutState(friendsKey, updatedJSON)
+	if err != nil {
+		return false, fmt.Errorf("failed to save friends list for %s: %v", owner, err)
+	}
+	return true, nil
+}
+
+// removeFriendship drops two users from each other's friends lists, reporting
+// whether they were friends
+func (s *SmartContract) removeFriendship(ctx contractapi.TransactionContextInterface, user1 string, user2 string) (bool, error) {
+	removed1, err := s.removeFromFriendsList(ctx, user1, user2)
+	if err != nil {
+		return false, err
+	}
+	removed2, err := s.removeFromFriendsList(ctx, user2, user1)
+	if err != nil {
+		return false, err
+	}
+	return removed1 || removed2, nil
+}
+
+// RemoveFriend ends a friendship, signed by either of the two friends
+func (s *SmartContract) RemoveFriend(ctx contractapi.TransactionContextInterface, publicKey string, friendPublicKey string, nonce string, signature string) error {
+	err := s.verifyUserSignature(ctx, publicKey, "RemoveFriend", []string{publicKey, friendPublicKey}, nonce, signature)
+	if err != nil {
+		return err
+	}
+
+	removed, err := s.removeFriendship(ctx, publicKey, friendPublicKey)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return fmt.Errorf("user %s is not a friend of user %s", friendPublicKey, publicKey)
+	}
+
+	log.Printf("User %s removed friend %s", publicKey, friendPublicKey)
+	return emitEvent(ctx, eventFriendRemoved, FriendRequestEvent{Sender: publicKey, Receiver: friendPublicKey, Status: relationshipRemoved})
+}
+
+// hasBlocked reports whether blocker has blocked blocked, under its current
+// key or one it has replaced
+func (s *SmartContract) hasBlocked(ctx contractapi.TransactionContextInterface, blocker string, blocked string) (bool, error) {
+	blockedKeys, err := s.userKeys(ctx, blocked)
+	if err != nil {
+		return false, err
+	}
+	for _, key := range blockedKeys {
+		blockKey, err := entityKey(ctx, blockObjectType, blocker, key)
+		if err != nil {
+			return false, err
+		}
+		marker, err := ctx.GetStub().GetState(blockKey)
+		if err != nil {
+			return false, fmt.Errorf("failed to read block: %v", err)
+		}
+		if marker != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// checkNotBlocked fails if either user has blocked the other
+func (s *SmartContract) checkNotBlocked(ctx contractapi.TransactionContextInterface, user1 string, user2 string) error {
+	blocked, err := s.hasBlocked(ctx, user1, user2)
+	if err != nil {
+		return err
+	}
+	if !blocked {
+		blocked, err = s.hasBlocked(ctx, user2, user1)
+		if err != nil {
+			return err
+		}
+	}
+	if blocked {
+		return fmt.Errorf("there is a block between users %s and %s", user1, user2)
+	}
+	return nil
+}
+
+// setChatBlock records a block in the chat collection, or removes it when
+// blocked is false. AddMessage checks blocks there, so sending a message reads
+// no public record that names both users.
+func setChatBlock(ctx contractapi.TransactionContextInterface, blocker string, blocked string, isBlocked bool) error {
+	blockKey, err := entityKey(ctx, chatBlockObjectType, blocker, blocked)
+	if err != nil {
+		return err
+	}
+	if isBlocked {
+		err = ctx.GetStub().PutPrivateData(chatPrivateCollection, blockKey, indexMarker)
+	} else {
+		err = ctx.GetStub().DelPrivateData(chatPrivateCollection, blockKey)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update chat block: %v", err)
+	}
+	return nil
+}
+
+// checkNoChatBlock is checkNotBlocked read from the chat collection. Blocks
+// there are not moved when a key is rotated, so every key of either user is
+// checked.
+func checkNoChatBlock(ctx contractapi.TransactionContextInterface, user1 string, user2 string) error {
+	keys1, err := chatUserKeys(ctx, user1)
+	if err != nil {
+		return err
+	}
+	keys2, err := chatUserKeys(ctx, user2)
+	if err != nil {
+		return err
+	}
+	for _, key1 := range keys1 {
+		for _, key2 := range keys2 {
+			for _, pair := range [][2]string{{key1, key2}, {key2, key1}} {
+				blockKey, err := entityKey(ctx, chatBlockObjectType, pair[0], pair[1])
+				if err != nil {
+					return err
+				}
+				marker, err := ctx.GetStub().GetPrivateData(chatPrivateCollection, blockKey)
+				if err != nil {
+					return fmt.Errorf("failed to read chat block: %v", err)
+				}
+				if marker != nil {
+					return fmt.Errorf("there is a block between users %s and %s", user1, user2)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkGroupBlocks fails if a user joining a group has a block with any of its members
+func (s *SmartContract) checkGroupBlocks(ctx contractapi.TransactionContextInterface, publicKey string, members []string) error {
+	for _, member := range members {
+		if member == publicKey {
+			continue
+		}
+		err := s.checkNotBlocked(ctx, publicKey, member)
+		if err != nil {
+			return fmt.Errorf("cannot add user to the group: %v", err)
+		}
+	}
+	return nil
+}
+
+// dropPendingFriendRequest cancels a friend request from sender to receiver if it is still pending
+func (s *SmartContract) dropPendingFriendRequest(ctx contractapi.TransactionContextInterface, sender string, receiver string) error {
+	request, err := s.getFriendRequest(ctx, sender, receiver)
+	if err != nil {
+		return err
+	}
+	if request == nil || request.Status != friendRequestPending {
+		return nil
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	request.Status = friendRequestCancelled
+	request.RespondedAt = now
+	_, err = s.saveFriendRequest(ctx, request)
+	return err
+}
+
+// BlockUser blocks another user, signed by the blocker. Any friendship, follows
+// and pending friend requests between the two are removed, and neither can send
+// the other friend requests or messages, or follow the other, until the block
+// is lifted.
+func (s *SmartContract) BlockUser(ctx contractapi.TransactionContextInterface, publicKey string, blockedPublicKey string, nonce string, signature string) error {
+	err := s.verifyUserSignature(ctx, publicKey, "BlockUser", []string{publicKey, blockedPublicKey}, nonce, signature)
+	if err != nil {
+		return err
+	}
+	if publicKey == blockedPublicKey {
+		return fmt.Errorf("users cannot block themselves")
+	}
+
+	exists, err := s.UserExists(ctx, blockedPublicKey)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("user %s does not exist", blockedPublicKey)
+	}
+
+	blocked, err := s.hasBlocked(ctx, publicKey, blockedPublicKey)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return fmt.Errorf("user %s is already blocked", blockedPublicKey)
+	}
+
+	blockKey, err := entityKey(ctx, blockObjectType, publicKey, blockedPublicKey)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(blockKey, indexMarker)
+	if err != nil {
+		return fmt.Errorf("failed to store block: %v", err)
+	}
+	err = setChatBlock(ctx, publicKey, blockedPublicKey, true)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.removeFriendship(ctx, publicKey, blockedPublicKey)
+	if err != nil {
+		return err
+	}
+	err = s.dropPendingFriendRequest(ctx, publicKey, blockedPublicKey)
+	if err != nil {
+		return err
+	}
+	err = s.dropPendingFriendRequest(ctx, blockedPublicKey, publicKey)
+	if err != nil {
+		return err
+	}
+	_, err = s.removeFollow(ctx, publicKey, blockedPublicKey)
+	if err != nil {
+		return err
+	}
+	_, err = s.removeFollow(ctx, blockedPublicKey, publicKey)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("User %s blocked %s", publicKey, blockedPublicKey)
+	return emitEvent(ctx, eventUserBlocked, FriendRequestEvent{Sender: publicKey, Receiver: blockedPublicKey, Status: relationshipBlocked})
+}
+
+// UnblockUser lifts a block, signed by the blocker. The friendship removed by
+// the block is not restored.
+func (s *SmartContract) UnblockUser(ctx contractapi.TransactionContextInterface, publicKey string, blockedPublicKey string, nonce string, signature string) error {
+	err := s.verifyUserSignature(ctx, publicKey, "UnblockUser", []string{publicKey, blockedPublicKey}, nonce, signature)
+	if err != nil {
+		return err
+	}
+
+	blocked, err := s.hasBlocked(ctx, publicKey, blockedPublicKey)
+	if err != nil {
+		return err
+	}
+	if !blocked {
+		return fmt.Errorf("user %s is not blocked", blockedPublicKey)
+	}
+
+	// The block may have been placed on a key the user has since replaced
+	blockedKeys, err := s.userKeys(ctx, blockedPublicKey)
+	if err != nil {
+		return err
+	}
+	for _, key := range blockedKeys {
+		blockKey, err := entityKey(ctx, blockObjectType, publicKey, key)
+		if err != nil {
+			return err
+		}
+		err = ctx.GetStub().DelState(blockKey)
+		if err != nil {
+			return fmt.Errorf("failed to remove block: %v", err)
+		}
+	}
+
+	// The copy in the chat collection stays under the keys it was made with
+	blockerKeys, err := s.userKeys(ctx, publicKey)
+	if err != nil {
+		return err
+	}
+	for _, blockerKey := range blockerKeys {
+		for _, key := range blockedKeys {
+			err = setChatBlock(ctx, blockerKey, key, false)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	log.Printf("User %s unblocked %s", publicKey, blockedPublicKey)
+	return emitEvent(ctx, eventUserUnblocked, FriendRequestEvent{Sender: publicKey, Receiver: blockedPublicKey, Status: relationshipUnblocked})
+}
+
+// GetBlockedUsers returns the public keys of the users publicKey has blocked
+func (s *SmartContract) GetBlockedUsers(ctx contractapi.TransactionContextInterface, publicKey string) ([]string, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(blockObjectType, []string{publicKey})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blocked users: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	blocked := []string{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate blocked users: %v", err)
+		}
+
+		// block~blocker~blocked
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to split composite key: %v", err)
+		}
+		if len(compositeKeyParts) != 2 {
+			continue
+		}
+		blocked = append(blocked, compositeKeyParts[1])
+	}
+
+	return blocked, nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ChatPage is one page of a chat's messages, newest first. Before is the cursor
+// for the next, older page, and 0 once the oldest message has been returned.
+type ChatPage struct {
+	ChatID       string     `json:"chatId"`
+	Participants [2]string  `json:"participants"`
+	LastSeq      int        `json:"lastSeq"`
+	Messages     []*Message `json:"messages"`
+	Before       int        `json:"before"`
+}
+
+// chatMessageKey builds the key of message seq of a chat. The sequence number is
+// zero padded so messages iterate in order.
+func chatMessageKey(ctx contractapi.TransactionContextInterface, chatID string, seq int) (string, error) {
+	return entityKey(ctx, chatMessageObjectType, chatID, fmt.Sprintf("%019d", seq))
+}
+
+// getChatHeader reads the header of a chat from the chat collection, returning nil if it does not exist
+func (s *SmartContract) getChatHeader(ctx contractapi.TransactionContextInterface, chatID string) (*Chat, error) {
+	chatKey, err := entityKey(ctx, chatObjectType, chatID)
+	if err != nil {
+		return nil, err
+	}
+	chatJSON, err := ctx.GetStub().GetPrivateData(chatPrivateCollection, chatKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chat: %v", err)
+	}
+	if chatJSON == nil {
+		return nil, nil
+	}
+
+	var chat Chat
+	err = json.Unmarshal(chatJSON, &chat)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal chat data: %v", err)
+	}
+	chat.ChatID = chatID
+	return &chat, nil
+}
+
+// saveChatHeader writes the header of a chat to the chat collection
+func (s *SmartContract) saveChatHeader(ctx contractapi.TransactionContextInterface, chat *Chat) error {
+	chatJSON, err := json.Marshal(chat)
+	if err != nil {
+		return fmt.Errorf("failed to marshal chat: %v", err)
+	}
+	chatKey, err := entityKey(ctx, chatObjectType, chat.ChatID)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutPrivateData(chatPrivateCollection, chatKey, chatJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store chat: %v", err)
+	}
+	return nil
+}
+
+// saveChatMessage writes a message under its own key in the chat collection
+func (s *SmartContract) saveChatMessage(ctx contractapi.TransactionContextInterface, chatID string, message *Message) error {
+	messageJSON, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %v", err)
+	}
+	messageKey, err := chatMessageKey(ctx, chatID, message.Seq)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutPrivateData(chatPrivateCollection, messageKey, messageJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store message %d of chat %s: %v", message.Seq, chatID, err)
+	}
+	return nil
+}
+
+// chatIDFor derives the ID of the chat between two users: the hex SHA-256 of
+// their public keys, sorted and concatenated, as the backend computes it
+func chatIDFor(publicKey1 string, publicKey2 string) string {
+	participants := []string{publicKey1, publicKey2}
+	sort.Strings(participants)
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(strings.Join(participants, ""))))
+}
+
+// findChat reads the header of the chat between two users. A chat begun before
+// either of them rotated their key stays under the ID derived from the keys
+// they had then, so every pair of their keys is tried, the current pair first.
+// The participants of the returned header are named by their current keys. It
+// returns nil if the users have no chat.
+func (s *SmartContract) findChat(ctx contractapi.TransactionContextInterface, publicKey1 string, publicKey2 string) (*Chat, error) {
+	keys1, err := chatUserKeys(ctx, publicKey1)
+	if err != nil {
+		return nil, err
+	}
+	keys2, err := chatUserKeys(ctx, publicKey2)
+	if err != nil {
+		return nil, err
+	}
+	for _, key1 := range keys1 {
+		for _, key2 := range keys2 {
+			chat, err := s.getChatHeader(ctx, chatIDFor(key1, key2))
+			if err != nil {
+				return nil, err
+			}
+			if chat == nil {
+				continue
+			}
+			for i, participant := range chat.Participants {
+				switch participant {
+				case key1:
+					chat.Participants[i] = publicKey1
+				case key2:
+					chat.Participants[i] = publicKey2
+				}
+			}
+			return chat, nil
+		}
+	}
+	return nil, nil
+}
+
+// AddMessage appends a message to a chat, signed with the sender's key. Its
+// arguments are passed as a MessageInput under "message" in the transient map,
+// and the chat is kept in the chat collection, so who talks to whom is not
+// visible to the whole channel. The nonce is recorded in the chat collection
+// too, blocks are checked against their copy there, and the MessageAdded event
+// does not name the chat, whose ID is derived from its participants, see
+// chatIDFor. Each message is stored under its own key, so sending one only
+// rewrites the small chat header rather than the whole conversation. A chat
+// begun before either user rotated their key carries on under the ID it began
+// with, see findChat. The message's sequence number is returned.
+func (s *SmartContract) AddMessage(ctx contractapi.TransactionContextInterface) (int, error) {
+	inputJSON, err := transientField(ctx, transientMessage)
+	if err != nil {
+		return 0, err
+	}
+	if inputJSON == nil {
+		return 0, fmt.Errorf("message must be passed in the transient map")
+	}
+	var input MessageInput
+	err = json.Unmarshal(inputJSON, &input)
+	if err != nil {
+		return 0, fmt.Errorf("failed to unmarshal message input: %v", err)
+	}
+	chatID, message := input.ChatID, input.Message
+	senderPublicKey, receiverPublicKey := input.SenderPublicKey, input.ReceiverPublicKey
+
+	err = s.verifyPrivateUserSignature(ctx, chatPrivateCollection, senderPublicKey, "AddMessage", []string{chatID, message, senderPublicKey, receiverPublicKey}, input.Nonce, input.Signature)
+	if err != nil {
+		return 0, err
+	}
+
+	// The ID must be the one derived from the two users, so no one else can
+	// claim the chat first and lock them out of it
+	if chatID != chatIDFor(senderPublicKey, receiverPublicKey) {
+		return 0, fmt.Errorf("chat %s is not between users %s and %s", chatID, senderPublicKey, receiverPublicKey)
+	}
+
+	// Blocks are read from the chat collection; only the sender's own account
+	// is read from public state, to check that it may sign
+	err = checkNoChatBlock(ctx, senderPublicKey, receiverPublicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	chat, err := s.findChat(ctx, senderPublicKey, receiverPublicKey)
+	if err != nil {
+		return 0, err
+	}
+	if chat == nil {
+		chat = &Chat{
+			ChatID:       chatID,
+			Participants: [2]string{senderPublicKey, receiverPublicKey},
+		}
+	}
+
+	var newMessage Message
+	err = json.Unmarshal([]byte(message), &newMessage)
+	if err != nil {
+		return 0, fmt.Errorf("failed to unmarshal message data: %v", err)
+	}
+
+	chat.LastSeq++
+	newMessage.Seq = chat.LastSeq
+	newMessage.Sender = senderPublicKey
+	newMessage.Receiver = receiverPublicKey
+	err = s.saveChatMessage(ctx, chat.ChatID, &newMessage)
+	if err != nil {
+		return 0, err
+	}
+	err = s.saveChatHeader(ctx, chat)
+	if err != nil {
+		return 0, err
+	}
+
+	// Fire an event to notify the client
+	err = emitEvent(ctx, eventMessageAdded, MessageEvent{})
+	if err != nil {
+		return 0, err
+	}
+	return newMessage.Seq, nil
+}
+
+// GetChat returns one page of the messages between two users, newest first.
+// Messages with a sequence number below before are returned; a before of 0 or
+// less starts from the newest message. The users are given by their current
+// keys; a chat begun under keys they have since rotated is found as well.
+func (s *SmartContract) GetChat(ctx contractapi.TransactionContextInterface, publicKey string, otherPublicKey string, pageSize int32, before int) (*ChatPage, error) {
+	chat, err := s.findChat(ctx, publicKey, otherPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if chat == nil {
+		return nil, fmt.Errorf("chat between users %s and %s not found", publicKey, otherPublicKey)
+	}
+	chatID := chat.ChatID
+
+	pageSize = normalizePageSize(pageSize)
+	if before <= 0 || before > chat.LastSeq+1 {
+		before = chat.LastSeq + 1
+	}
+
+	page := &ChatPage{
+		ChatID:       chatID,
+		Participants: chat.Participants,
+		LastSeq:      chat.LastSeq,
+		Messages:     []*Message{},
+	}
+
+	// Sequence numbers are contiguous, so the page is read key by key
+	seq := before - 1
+	for ; seq >= 1 && int32(len(page.Messages)) < pageSize; seq-- {
+		messageKey, err := chatMessageKey(ctx, chatID, seq)
+		if err != nil {
+			return nil, err
+		}
+		messageJSON, err := ctx.GetStub().GetPrivateData(chatPrivateCollection, messageKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get message %d of chat %s: %v", seq, chatID, err)
+		}
+		if messageJSON == nil {
+			continue
+		}
+
+		var message Message
+		err = json.Unmarshal(messageJSON, &message)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal message %d of chat %s: %v", seq, chatID, err)
+		}
+		page.Messages = append(page.Messages, &message)
+	}
+
+	if seq >= 1 {
+		page.Before = seq + 1
+	}
+	return page, nil
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Comment is a comment on a post, or a reply to another comment when ParentID is set.
+// The comment body is stored in IPFS and referenced by ContentCID.
+type Comment struct {
+	ID              string `json:"id"`
+	PostID          string `json:"postId"`
+	ParentID        string `json:"parentId"`
+	AuthorPublicKey string `json:"authorPublicKey"`
+	ContentCID      string `json:"contentCID"`
+	Timestamp       int64  `json:"timestamp"`
+	ReplyCount      int    `json:"replyCount"` // Stored value predates the counters; see GetComments
+	Hidden          bool   `json:"hidden"`
+	Deleted         bool   `json:"deleted"`
+}
+
+// CommentPage is one page of comments in a thread
+type CommentPage struct {
+	Comments []*Comment `json:"comments"`
+	Bookmark string     `json:"bookmark"`
+}
+
+// txTimestamp returns the transaction's timestamp in unix seconds. Unlike the
+// local clock it is the same on every endorsing peer.
+func txTimestamp(ctx contractapi.TransactionContextInterface) (int64, error) {
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get transaction timestamp: %v", err)
+	}
+	return timestamp.GetSeconds(), nil
+}
+
+// getComment reads a comment on postID
+func (s *SmartContract) getComment(ctx contractapi.TransactionContextInterface, postID string, commentID string) (*Comment, error) {
+	commentKey, err := entityKey(ctx, commentObjectType, postID, commentID)
+	if err != nil {
+		return nil, err
+	}
+	commentJSON, err := ctx.GetStub().GetState(commentKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read comment %s: %v", commentID, err)
+	}
+	if commentJSON == nil {
+		return nil, fmt.Errorf("comment %s does not exist on post %s", commentID, postID)
+	}
+
+	var comment Comment
+	err = json.Unmarshal(commentJSON, &comment)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal comment %s: %v", commentID, err)
+	}
+	return &comment, nil
+}
+
+// saveComment writes a comment back to the ledger
+func (s *SmartContract) saveComment(ctx contractapi.TransactionContextInterface, comment *Comment) error {
+	commentJSON, err := json.Marshal(comment)
+	if err != nil {
+		return fmt.Errorf("failed to marshal comment: %v", err)
+	}
+	commentKey, err := entityKey(ctx, commentObjectType, comment.PostID, comment.ID)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(commentKey, commentJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store comment %s: %v", comment.ID, err)
+	}
+	return nil
+}
+
+// AddComment adds a comment to a post, or a reply to parentID when it is not
+// empty, signed with the author's key. Posts that are deleted, hidden by
+// moderators or closed to comments cannot be commented on. The comment ID is
+// returned. The post and the parent comment are only read; their counts are
+// kept in counters so concurrent comments do not conflict.
+func (s *SmartContract) AddComment(ctx contractapi.TransactionContextInterface, postID string, parentID string, authorPublicKey string, contentCID string, nonce string, signature string) (string, error) {
+	err := s.verifyUserSignature(ctx, authorPublicKey, "AddComment", []string{postID, parentID, authorPublicKey, contentCID}, nonce, signature)
+	if err != nil {
+		return "", err
+	}
+	if contentCID == "" {
+		return "", fmt.Errorf("comment content is required")
+	}
+
+	post, err := s.getPost(ctx, postID)
+	if err != nil {
+		return "", err
+	}
+	if post.Deleted {
+		return "", fmt.Errorf("post %s has been deleted", postID)
+	}
+	if post.Hidden {
+		return "", fmt.Errorf("post %s has been hidden by moderators", postID)
+	}
+	if post.CommentsDisabled {
+		return "", fmt.Errorf("comments are turned off for post %s", postID)
+	}
+
+	// Replies must belong to a live comment on the same post
+	var parentAuthor string
+	if parentID != "" {
+		parent, err := s.getComment(ctx, postID, parentID)
+		if err != nil {
+			return "", err
+		}
+		parentAuthor = parent.AuthorPublicKey
+		if parent.Deleted {
+			return "", fmt.Errorf("cannot reply to deleted comment %s", parentID)
+		}
+		err = addToCounter(ctx, counterObjectType, parentID, replyCounter, authorPublicKey, 1)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return "", err
+	}
+	comment := &Comment{
+		ID:              ctx.GetStub().GetTxID(),
+		PostID:          postID,
+		ParentID:        parentID,
+		AuthorPublicKey: authorPublicKey,
+		ContentCID:      contentCID,
+		Timestamp:       timestamp,
+	}
+	err = s.saveComment(ctx, comment)
+	if err != nil {
+		return "", err
+	}
+
+	// Threads list comments oldest first
+	threadKey, err := entityKey(ctx, commentThreadObjectType, postID, parentID, fmt.Sprintf("%019d", timestamp), comment.ID)
+	if err != nil {
+		return "", err
+	}
+	err = ctx.GetStub().PutState(threadKey, indexMarker)
+	if err != nil {
+		return "", fmt.Errorf("failed to index comment %s: %v", comment.ID, err)
+	}
+
+	err = addToCounter(ctx, counterObjectType, postID, commentCounter, authorPublicKey, 1)
+	if err != nil {
+		return "", err
+	}
+
+	err = emitEvent(ctx, eventCommentAdded, CommentEvent{
+		PostID:          post.ID,
+		PostCID:         postID,
+		PostAuthor:      post.UserPublicKey,
+		CommentID:       comment.ID,
+		ParentID:        parentID,
+		ParentAuthor:    parentAuthor,
+		AuthorPublicKey: authorPublicKey,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("User %s commented on post %s", authorPublicKey, postID)
+	return comment.ID, nil
+}
+
+// GetComments returns one page of the comments on a post, oldest first. With an
+// empty parentID the top-level comments are listed, otherwise the replies to that
+// comment. Hidden and deleted comments keep their place in the thread so their
+// replies stay reachable, but their content is withheld.
+func (s *SmartContract) GetComments(ctx contractapi.TransactionContextInterface, postID string, parentID string, pageSize int32, bookmark string) (*CommentPage, error) {
+	pageSize = normalizePageSize(pageSize)
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(commentThreadObjectType, []string{postID, parentID}, pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get comments: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	page := &CommentPage{Comments: []*Comment{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate comments: %v", err)
+		}
+
+		// commentthread~postID~parentID~timestamp~commentID
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to split composite key: %v", err)
+		}
+		if len(compositeKeyParts) != 4 {
+			continue
+		}
+
+		comment, err := s.getComment(ctx, postID, compositeKeyParts[3])
+		if err != nil {
+			return nil, err
+		}
+		if comment.Hidden || comment.Deleted {
+			comment.ContentCID = ""
+		}
+		// Comments stay under the key they were written with
+		comment.AuthorPublicKey, err = resolveUserKey(ctx, comment.AuthorPublicKey)
+		if err != nil {
+			return nil, err
+		}
+		counts, err := readCounters(ctx, counterObjectType, comment.ID, replyCounter)
+		if err != nil {
+			return nil, err
+		}
+		comment.ReplyCount += counts[replyCounter]
+		page.Comments = append(page.Comments, comment)
+	}
+
+	page.Bookmark = nextBookmark(metadata.FetchedRecordsCount, metadata.Bookmark, pageSize)
+	return page, nil
+}
+
+// DeleteComment removes a comment's content, signed by its author. The comment is
+// kept as a tombstone so replies to it remain threaded, but no longer counts
+// towards the post's comments or its parent's replies.
+func (s *SmartContract) DeleteComment(ctx contractapi.TransactionContextInterface, postID string, commentID string, publicKey string, nonce string, signature string) error {
+	err := s.verifyUserSignature(ctx, publicKey, "DeleteComment", []string{postID, commentID, publicKey}, nonce, signature)
+	if err != nil {
+		return err
+	}
+
+	comment, err := s.getComment(ctx, postID, commentID)
+	if err != nil {
+		return err
+	}
+	authored, err := isUserKey(ctx, comment.AuthorPublicKey, publicKey)
+	if err != nil {
+		return err
+	}
+	if !authored {
+		return fmt.Errorf("only the author can delete comment %s", commentID)
+	}
+	if comment.Deleted {
+		return fmt.Errorf("comment %s is already deleted", commentID)
+	}
+
+	comment.Deleted = true
+	comment.ContentCID = ""
+	err = s.saveComment(ctx, comment)
+	if err != nil {
+		return err
+	}
+	if comment.ParentID != "" {
+		err = addToCounter(ctx, counterObjectType, comment.ParentID, replyCounter, publicKey, -1)
+		if err != nil {
+			return err
+		}
+	}
+	return addToCounter(ctx, counterObjectType, postID, commentCounter, publicKey, -1)
+}
+
+// SetCommentHidden hides or unhides a comment on a post, signed by the post's author
+func (s *SmartContract) SetCommentHidden(ctx contractapi.TransactionContextInterface, postID string, commentID string, publicKey string, hidden bool, nonce string, signature string) error {
+	err := s.verifyUserSignature(ctx, publicKey, "SetCommentHidden", []string{postID, commentID, publicKey, strconv.FormatBool(hidden)}, nonce, signature)
+	if err != nil {
+		return err
+	}
+
+	post, err := s.getPost(ctx, postID)
+	if err != nil {
+		return err
+	}
+	authored, err := isUserKey(ctx, post.UserPublicKey, publicKey)
+	if err != nil {
+		return err
+	}
+	if !authored {
+		return fmt.Errorf("only the author of post %s can hide its comments", postID)
+	}
+
+	comment, err := s.getComment(ctx, postID, commentID)
+	if err != nil {
+		return err
+	}
+	comment.Hidden = hidden
+	return s.saveComment(ctx, comment)
+}
+
+// SetCommentsEnabled turns comments on a post on or off, signed by the post's author.
+// Existing comments are kept either way.
+func (s *SmartContract) SetCommentsEnabled(ctx contractapi.TransactionContextInterface, postID string, publicKey string, enabled bool, nonce string, signature string) error {
+	err := s.verifyUserSignature(ctx, publicKey, "SetCommentsEnabled", []string{postID, publicKey, strconv.FormatBool(enabled)}, nonce, signature)
+	if err != nil {
+		return err
+	}
+
+	post, err := s.getPost(ctx, postID)
+	if err != nil {
+		return err
+	}
+	authored, err := isUserKey(ctx, post.UserPublicKey, publicKey)
+	if err != nil {
+		return err
+	}
+	if !authored {
+		return fmt.Errorf("only the author of post %s can change its comment settings", postID)
+	}
+
+	post.CommentsDisabled = !enabled
+	return s.savePost(ctx, post)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// counterShards is how many keys a counter is spread over. Each update writes
+// the shard picked by the acting user's public key, so concurrent updates by
+// different users rarely touch the same key, and reading a counter costs at
+// most counterShards reads however large it grows.
+const counterShards = 16
+
+// Counters kept for posts and comments
+const (
+	commentCounter = "comments" // Live comments on a post
+	shareCounter   = "shares"   // Reshares of a post
+	reportCounter  = "reports"  // Reports of a post since it was last reviewed
+	replyCounter   = "replies"  // Replies to a comment
+)
+
+// counterShard returns the shard of a counter that actor's updates go to
+func counterShard(actor string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(actor))
+	return fmt.Sprintf("%02d", hash.Sum32()%counterShards)
+}
+
+// addToCounter adds delta to actor's shard of the counter name of targetID,
+// stored under objectType
+func addToCounter(ctx contractapi.TransactionContextInterface, objectType string, targetID string, name string, actor string, delta int) error {
+	return addToCounterShard(ctx, objectType, targetID, name, counterShard(actor), delta)
+}
+
+// addToCounterShard adds delta to one shard of a counter. Shards that come back
+// to zero are deleted. A transaction does not see its own writes, so it must
+// update each shard at most once.
+func addToCounterShard(ctx contractapi.TransactionContextInterface, objectType string, targetID string, name string, shard string, delta int) error {
+	shardKey, err := entityKey(ctx, objectType, targetID, name, shard)
+	if err != nil {
+		return err
+	}
+	value, err := ctx.GetStub().GetState(shardKey)
+	if err != nil {
+		return fmt.Errorf("failed to read %s counter of %s: %v", name, targetID, err)
+	}
+
+	count := 0
+	if value != nil {
+		count, err = strconv.Atoi(string(value))
+		if err != nil {
+			return fmt.Errorf("invalid %s counter of %s: %v", name, targetID, err)
+		}
+	}
+	count += delta
+
+	if count == 0 {
+		err = ctx.GetStub().DelState(shardKey)
+	} else {
+		err = ctx.GetStub().PutState(shardKey, []byte(strconv.Itoa(count)))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update %s counter of %s: %v", name, targetID, err)
+	}
+	return nil
+}
+
+// readCounters sums the shards of the counters of targetID stored under
+// objectType, by counter name. With a name only that counter is read, which
+// keeps the other counters out of a writing transaction's read set.
+func readCounters(ctx contractapi.TransactionContextInterface, objectType string, targetID string, name ...string) (map[string]int, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(objectType, append([]string{targetID}, name...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get counters of %s: %v", targetID, err)
+	}
+	defer resultsIterator.Close()
+
+	counts := make(map[string]int)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate counters of %s: %v", targetID, err)
+		}
+
+		// objectType~targetID~name~shard
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to split composite key: %v", err)
+		}
+		if len(compositeKeyParts) != 3 {
+			continue
+		}
+		count, err := strconv.Atoi(string(queryResponse.Value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s counter of %s: %v", compositeKeyParts[1], targetID, err)
+		}
+		counts[compositeKeyParts[1]] += count
+	}
+	return counts, nil
+}
+
+// clearCounter deletes every shard of the counter name of targetID
+func clearCounter(ctx contractapi.TransactionContextInterface, objectType string, targetID string, name string) error {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(objectType, []string{targetID, name})
+	if err != nil {
+		return fmt.Errorf("failed to get %s counter of %s: %v", name, targetID, err)
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return fmt.Errorf("failed to iterate %s counter of %s: %v", name, targetID, err)
+		}
+		err = ctx.GetStub().DelState(queryResponse.Key)
+		if err != nil {
+			return fmt.Errorf("failed to clear %s counter of %s: %v", name, targetID, err)
+		}
+	}
+	return nil
+}
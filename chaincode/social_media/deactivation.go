@@ -0,0 +1,973 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// reactivationGracePeriod is how long, in seconds, a deactivated account can be
+// reactivated with its key. Once it has passed, EraseUser erases the account
+// and the key stays retired for good.
+const reactivationGracePeriod = 30 * 24 * 60 * 60
+
+// Deactivation is the result of DeactivateUser
+type Deactivation struct {
+	PublicKey        string `json:"publicKey"`
+	DeactivatedAt    int64  `json:"deactivatedAt"`
+	ReactivateBefore int64  `json:"reactivateBefore"` // From then on the account can be erased, see EraseUser
+}
+
+// Erasure records how far the erasure of an account has got. It starts on
+// deactivation, which detaches the account from other users, and goes on once
+// EraseUser is called.
+type Erasure struct {
+	PublicKey   string   `json:"publicKey"`
+	Step        int      `json:"step"`     // Index in erasureSteps of the records being removed
+	Offset      int      `json:"offset"`   // Position in the user's list, for steps that work through one
+	Removed     int      `json:"removed"`  // Records removed so far
+	Detached    bool     `json:"detached"` // The first detachSteps are done
+	Erasing     bool     `json:"erasing"`  // Set by EraseUser; until then only the first detachSteps run
+	Done        bool     `json:"done"`
+	ContentCIDs []string `json:"contentCIDs,omitempty"` // Post documents found by the latest batch, for the caller to unpin
+}
+
+// PostListing records how far the unlisting of a user's posts on deactivation,
+// or their listing again on reactivation, has got
+type PostListing struct {
+	PublicKey string `json:"publicKey"`
+	Listed    bool   `json:"listed"` // Whether the posts are being listed or unlisted
+	Offset    int    `json:"offset"` // Position in the user's post list
+	Done      bool   `json:"done"`
+}
+
+// getUserRecord reads a user record as stored. Unlike GetUser it does not hide
+// the profile of a deactivated account.
+func (s *SmartContract) getUserRecord(ctx contractapi.TransactionContextInterface, publicKey string) (*User, error) {
+	userKey, err := entityKey(ctx, userObjectType, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	userJSON, err := ctx.GetStub().GetState(userKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read user data for public key %s: %v", publicKey, err)
+	}
+	if userJSON == nil {
+		return nil, fmt.Errorf("user with public key %s does not exist", publicKey)
+	}
+	var user User
+	err = json.Unmarshal(userJSON, &user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal user data: %v", err)
+	}
+	return &user, nil
+}
+
+// isDeactivated reports whether a user has deactivated their account
+func (s *SmartContract) isDeactivated(ctx contractapi.TransactionContextInterface, publicKey string) (bool, error) {
+	user, err := s.getUserRecord(ctx, publicKey)
+	if err != nil {
+		return false, err
+	}
+	return user.Deactivated, nil
+}
+
+// requireActiveUser fails unless publicKey belongs to a registered user who has
+// not deactivated their account. Deactivated users cannot be friended, followed
+// or added to groups.
+func (s *SmartContract) requireActiveUser(ctx contractapi.TransactionContextInterface, publicKey string) error {
+	deactivated, err := s.isDeactivated(ctx, publicKey)
+	if err != nil {
+		return err
+	}
+	if deactivated {
+		return fmt.Errorf("user %s has deactivated their account", publicKey)
+	}
+	return nil
+}
+
+// hiddenUser is what is kept on the ledger of a deactivated account: only the
+// fields that other records depend on
+func hiddenUser(user *User) *User {
+	return &User{
+		PublicKey:     user.PublicKey,
+		PreviousKeys:  user.PreviousKeys,
+		Suspended:     user.Suspended,
+		Deactivated:   user.Deactivated,
+		DeactivatedAt: user.DeactivatedAt,
+		Erased:        user.Erased,
+	}
+}
+
+// isPostListHidden reports whether the post list kept under publicKey, which
+// may be a key the user has since replaced, belongs to a deactivated account
+func (s *SmartContract) isPostListHidden(ctx contractapi.TransactionContextInterface, publicKey string) (bool, error) {
+	publicKey, err := resolveUserKey(ctx, publicKey)
+	if err != nil {
+		return false, err
+	}
+	return s.isDeactivated(ctx, publicKey)
+}
+
+// defaultPostListingBatchSize is how many posts a listing or unlisting goes
+// through per transaction when called without a batch size
+const defaultPostListingBatchSize = 100
+
+// setUserPostsListed starts listing or unlisting every post of a user, and
+// goes through the first batch. ContinuePostListing goes through the rest.
+// Posts hidden by moderators stay unlisted either way.
+func (s *SmartContract) setUserPostsListed(ctx contractapi.TransactionContextInterface, publicKey string, listed bool) error {
+	listing := &PostListing{PublicKey: publicKey, Listed: listed}
+	return s.runPostListing(ctx, listing, defaultPostListingBatchSize)
+}
+
+// runPostListing lists or unlists at most batchSize posts of a user and stores
+// its progress
+func (s *SmartContract) runPostListing(ctx contractapi.TransactionContextInterface, listing *PostListing, batchSize int) error {
+	if batchSize <= 0 {
+		batchSize = defaultPostListingBatchSize
+	}
+
+	// The posts follow the user to any key they have rotated to since
+	publicKey, err := resolveUserKey(ctx, listing.PublicKey)
+	if err != nil {
+		return err
+	}
+	posts, err := readKeyList(ctx, userPostsObjectType, publicKey)
+	if err != nil {
+		return err
+	}
+	end := listing.Offset + batchSize
+	if end > len(posts) {
+		end = len(posts)
+	}
+
+	for _, ipfsHash := range posts[listing.Offset:end] {
+		post, err := s.getPost(ctx, ipfsHash)
+		if err != nil {
+			return err
+		}
+		if post.Hidden || post.Deleted {
+			continue
+		}
+		err = s.setPostListed(ctx, post, listing.Listed)
+		if err != nil {
+			return err
+		}
+	}
+	listing.Offset = end
+	listing.Done = end == len(posts)
+
+	listingJSON, err := json.Marshal(listing)
+	if err != nil {
+		return fmt.Errorf("failed to marshal post listing: %v", err)
+	}
+	listingKey, err := entityKey(ctx, postListingObjectType, listing.PublicKey)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(listingKey, listingJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store post listing: %v", err)
+	}
+
+	log.Printf("Post listing of %s reached %d of %d posts (listed %t)", listing.PublicKey, end, len(posts), listing.Listed)
+	return nil
+}
+
+// ContinuePostListing goes through the next batchSize posts of the user whose
+// account was last deactivated or reactivated as publicKey, unlisting or
+// listing them again. Until the returned progress reports Done, some of the
+// posts of a deactivated account may still be listed, or some of the posts of
+// a reactivated one still unlisted. It only carries on what the user started,
+// so anyone may call it. Calling it once the listing is done changes nothing.
+func (s *SmartContract) ContinuePostListing(ctx contractapi.TransactionContextInterface, publicKey string, batchSize int) (*PostListing, error) {
+	listingKey, err := entityKey(ctx, postListingObjectType, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	listingJSON, err := ctx.GetStub().GetState(listingKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read post listing of %s: %v", publicKey, err)
+	}
+	if listingJSON == nil {
+		return nil, fmt.Errorf("the posts of user %s are not being listed or unlisted", publicKey)
+	}
+	var listing PostListing
+	err = json.Unmarshal(listingJSON, &listing)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal post listing: %v", err)
+	}
+	if listing.Done {
+		return &listing, nil
+	}
+
+	err = s.runPostListing(ctx, &listing, batchSize)
+	if err != nil {
+		return nil, err
+	}
+	return &listing, nil
+}
+
+// GetUnfinishedPostListings returns the public keys of deactivated or
+// reactivated accounts whose posts are still being unlisted or listed, see
+// ContinuePostListing
+func (s *SmartContract) GetUnfinishedPostListings(ctx contractapi.TransactionContextInterface) ([]string, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(postListingObjectType, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get state iterator: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	publicKeys := []string{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get next query result: %v", err)
+		}
+
+		var listing PostListing
+		err = json.Unmarshal(queryResponse.Value, &listing)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal post listing: %v", err)
+		}
+		if !listing.Done {
+			publicKeys = append(publicKeys, listing.PublicKey)
+		}
+	}
+	return publicKeys, nil
+}
+
+// storeDeactivatedProfile keeps the profile of an account being deactivated in
+// userPrivateCollection, for ReactivateUser to restore
+func storeDeactivatedProfile(ctx contractapi.TransactionContextInterface, user *User) error {
+	profileJSON, err := json.Marshal(user)
+	if err != nil {
+		return fmt.Errorf("failed to marshal user data: %v", err)
+	}
+	profileKey, err := entityKey(ctx, deactivatedObjectType, user.PublicKey)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutPrivateData(userPrivateCollection, profileKey, profileJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store profile of %s: %v", user.PublicKey, err)
+	}
+	return nil
+}
+
+// takeDeactivatedProfile reads and removes the profile DeactivateUser kept of
+// publicKey's account, returning nil if there is none
+func takeDeactivatedProfile(ctx contractapi.TransactionContextInterface, publicKey string) (*User, error) {
+	profileKey, err := entityKey(ctx, deactivatedObjectType, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	profileJSON, err := ctx.GetStub().GetPrivateData(userPrivateCollection, profileKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profile of %s: %v", publicKey, err)
+	}
+	if profileJSON == nil {
+		return nil, nil
+	}
+	err = ctx.GetStub().DelPrivateData(userPrivateCollection, profileKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove profile of %s: %v", publicKey, err)
+	}
+
+	var profile User
+	err = json.Unmarshal(profileJSON, &profile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal user data: %v", err)
+	}
+	return &profile, nil
+}
+
+// deleteIndexEntries removes at most limit entries of objectType listed under
+// publicKey. It returns the entries it removed and whether any are left.
+func deleteIndexEntries(ctx contractapi.TransactionContextInterface, objectType string, publicKey string, limit int) ([]movedEntry, bool, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(objectType, []string{publicKey})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read %s index: %v", objectType, err)
+	}
+	defer resultsIterator.Close()
+
+	deleted := []movedEntry{}
+	for len(deleted) < limit && resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to iterate %s index: %v", objectType, err)
+		}
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to split composite key: %v", err)
+		}
+		if len(compositeKeyParts) < 2 {
+			continue
+		}
+		err = ctx.GetStub().DelState(queryResponse.Key)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to remove %s entry: %v", objectType, err)
+		}
+		deleted = append(deleted, movedEntry{attributes: compositeKeyParts[1:], value: queryResponse.Value})
+	}
+	return deleted, resultsIterator.HasNext(), nil
+}
+
+// DeactivateUser closes a user's account, signed by the user. Reads of the user
+// return just the public key: the name, handle and profile are moved to
+// userPrivateCollection, and the handle is released. Their posts are unlisted,
+// the first batch here and the rest by ContinuePostListing. The user leaves
+// every friends list, group and follow, and pending friend requests to or from
+// them are cancelled, the first batch here and the rest by ContinueErasure;
+// they can no longer be friended, followed or added to groups. The user can
+// reactivate the account with the same key within reactivationGracePeriod,
+// see ReactivateUser. After that EraseUser erases it for good.
+func (s *SmartContract) DeactivateUser(ctx contractapi.TransactionContextInterface, publicKey string, nonce string, signature string) (*Deactivation, error) {
+	err := s.verifyUserSignature(ctx, publicKey, "DeactivateUser", []string{publicKey}, nonce, signature)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.getUserRecord(ctx, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.setUserPostsListed(ctx, publicKey, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// Personal data
+	err = storeDeactivatedProfile(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	if user.Handle != "" {
+		err = s.releaseHandle(ctx, user.Handle)
+		if err != nil {
+			return nil, err
+		}
+	}
+	user.Deactivated = true
+	user.DeactivatedAt = now
+	err = s.saveUser(ctx, hiddenUser(user))
+	if err != nil {
+		return nil, err
+	}
+
+	erasure := &Erasure{PublicKey: publicKey}
+	err = s.runErasure(ctx, erasure, defaultErasureBatchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set last, as deleting an emptied group sets its own event
+	err = emitEvent(ctx, eventUserDeactivated, UserEvent{PublicKey: publicKey})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("User %s deactivated their account", publicKey)
+	return &Deactivation{
+		PublicKey:        publicKey,
+		DeactivatedAt:    now,
+		ReactivateBefore: now + reactivationGracePeriod,
+	}, nil
+}
+
+// defaultErasureBatchSize is how many records an erasure removes per
+// transaction when called without a batch size
+const defaultErasureBatchSize = 100
+
+// erasureStep removes at most limit records of one kind of an erased user,
+// returning how many it removed and whether any are left
+type erasureStep func(s *SmartContract, ctx contractapi.TransactionContextInterface, erasure *Erasure, limit int) (int, bool, error)
+
+// erasureSteps are the kinds of records an erasure removes, in order
+var erasureSteps = []erasureStep{
+	(*SmartContract).eraseFriends,
+	(*SmartContract).eraseFriendRequests,
+	(*SmartContract).eraseGroupMemberships,
+	(*SmartContract).eraseFollowing,
+	(*SmartContract).eraseFollowers,
+	eraseIndex(hashtagFollowObjectType),
+	(*SmartContract).erasePosts,
+}
+
+// detachSteps is how many of erasureSteps run on deactivation, detaching the
+// account from other users. The rest wait for EraseUser.
+const detachSteps = 6
+
+// getErasure reads the erasure of publicKey's account, returning nil if there
+// was none
+func getErasure(ctx contractapi.TransactionContextInterface, publicKey string) (*Erasure, error) {
+	erasureKey, err := entityKey(ctx, erasureObjectType, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	erasureJSON, err := ctx.GetStub().GetState(erasureKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read erasure of %s: %v", publicKey, err)
+	}
+	if erasureJSON == nil {
+		return nil, nil
+	}
+
+	var erasure Erasure
+	err = json.Unmarshal(erasureJSON, &erasure)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal erasure: %v", err)
+	}
+	return &erasure, nil
+}
+
+// saveErasure stores the progress of an erasure. The content found by the
+// latest batch is only returned to the caller, not stored.
+func saveErasure(ctx contractapi.TransactionContextInterface, erasure *Erasure) error {
+	stored := *erasure
+	stored.ContentCIDs = nil
+	erasureJSON, err := json.Marshal(stored)
+	if err != nil {
+		return fmt.Errorf("failed to marshal erasure: %v", err)
+	}
+	erasureKey, err := entityKey(ctx, erasureObjectType, erasure.PublicKey)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(erasureKey, erasureJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store erasure: %v", err)
+	}
+	return nil
+}
+
+// runErasure removes at most batchSize records of an erasure and stores its
+// progress. Until EraseUser is called it stops once the account is detached.
+// erasure.ContentCIDs is set to the content the batch found.
+func (s *SmartContract) runErasure(ctx contractapi.TransactionContextInterface, erasure *Erasure, batchSize int) error {
+	if batchSize <= 0 {
+		batchSize = defaultErasureBatchSize
+	}
+	steps := len(erasureSteps)
+	if !erasure.Erasing {
+		steps = detachSteps
+	}
+
+	erasure.ContentCIDs = []string{}
+	processed := 0
+	for erasure.Step < steps && processed < batchSize {
+		removed, more, err := erasureSteps[erasure.Step](s, ctx, erasure, batchSize-processed)
+		if err != nil {
+			return err
+		}
+		processed += removed
+		erasure.Removed += removed
+		if !more {
+			erasure.Step++
+			erasure.Offset = 0
+		}
+	}
+	erasure.Detached = erasure.Step >= detachSteps
+	erasure.Done = erasure.Step == len(erasureSteps)
+
+	err := saveErasure(ctx, erasure)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Erasure of %s removed %d records (done %t)", erasure.PublicKey, processed, erasure.Done)
+	return nil
+}
+
+// erasePosts tombstones a batch of the user's posts like deleted ones, keeping
+// only the hashes that other records refer to, and adds the IPFS hashes of
+// their content, including earlier versions, to the batch's content. The post
+// list is worked through, then removed.
+func (s *SmartContract) erasePosts(ctx contractapi.TransactionContextInterface, erasure *Erasure, limit int) (int, bool, error) {
+	posts, err := readKeyList(ctx, userPostsObjectType, erasure.PublicKey)
+	if err != nil {
+		return 0, false, err
+	}
+	end := erasure.Offset + limit
+	if end > len(posts) {
+		end = len(posts)
+	}
+
+	for _, ipfsHash := range posts[erasure.Offset:end] {
+		post, err := s.getPost(ctx, ipfsHash)
+		if err != nil {
+			return 0, false, err
+		}
+		erasure.ContentCIDs = append(erasure.ContentCIDs, post.ContentCID)
+		for _, version := range post.Versions {
+			if version.ContentCID != post.ContentCID {
+				erasure.ContentCIDs = append(erasure.ContentCIDs, version.ContentCID)
+			}
+		}
+
+		err = s.unlistPost(ctx, post)
+		if err != nil {
+			return 0, false, err
+		}
+	}
+
+	removed := end - erasure.Offset
+	erasure.Offset = end
+	if end < len(posts) {
+		return removed, true, nil
+	}
+	postsKey, err := entityKey(ctx, userPostsObjectType, erasure.PublicKey)
+	if err != nil {
+		return 0, false, err
+	}
+	err = ctx.GetStub().DelState(postsKey)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to remove posts: %v", err)
+	}
+	return removed, false, nil
+}
+
+// eraseFriends drops the user from the friends lists of a batch of their
+// friends. The user's own list is worked through, then removed.
+func (s *SmartContract) eraseFriends(ctx contractapi.TransactionContextInterface, erasure *Erasure, limit int) (int, bool, error) {
+	friends, err := s.GetFriendsByUser(ctx, erasure.PublicKey)
+	if err != nil {
+		return 0, false, err
+	}
+	end := erasure.Offset + limit
+	if end > len(friends) {
+		end = len(friends)
+	}
+	for _, friend := range friends[erasure.Offset:end] {
+		_, err = s.removeFromFriendsList(ctx, friend, erasure.PublicKey)
+		if err != nil {
+			return 0, false, err
+		}
+	}
+
+	removed := end - erasure.Offset
+	erasure.Offset = end
+	if end < len(friends) {
+		return removed, true, nil
+	}
+	friendsKey, err := entityKey(ctx, friendsObjectType, erasure.PublicKey)
+	if err != nil {
+		return 0, false, err
+	}
+	err = ctx.GetStub().DelState(friendsKey)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to remove friends list: %v", err)
+	}
+	return removed, false, nil
+}
+
+// eraseFriendRequests cancels a batch of the pending friend requests to or from
+// the user. Answered requests stay listed, so the index is worked through by
+// position.
+func (s *SmartContract) eraseFriendRequests(ctx contractapi.TransactionContextInterface, erasure *Erasure, limit int) (int, bool, error) {
+	pairs, more, err := s.userFriendRequestPairs(ctx, erasure.PublicKey, erasure.Offset, limit)
+	if err != nil {
+		return 0, false, err
+	}
+	for _, pair := range pairs {
+		err = s.dropPendingFriendRequest(ctx, pair[0], pair[1])
+		if err != nil {
+			return 0, false, err
+		}
+	}
+	erasure.Offset += len(pairs)
+	return len(pairs), more, nil
+}
+
+// eraseGroupMemberships takes the user out of a batch of their groups, see
+// leaveGroup
+func (s *SmartContract) eraseGroupMemberships(ctx contractapi.TransactionContextInterface, erasure *Erasure, limit int) (int, bool, error) {
+	// groupmember~publicKey~groupID; leaveGroup removes the entry itself
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(groupMemberObjectType, []string{erasure.PublicKey})
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get groups of %s: %v", erasure.PublicKey, err)
+	}
+	defer resultsIterator.Close()
+
+	removed := 0
+	for removed < limit && resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to iterate groups: %v", err)
+		}
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to split composite key: %v", err)
+		}
+		if len(compositeKeyParts) != 2 {
+			continue
+		}
+
+		group, err := s.ReadGroup(ctx, compositeKeyParts[1])
+		if err != nil {
+			return 0, false, err
+		}
+		_, err = s.leaveGroup(ctx, group, erasure.PublicKey)
+		if err != nil {
+			return 0, false, err
+		}
+		removed++
+	}
+	return removed, resultsIterator.HasNext(), nil
+}
+
+// eraseFollowing removes a batch of the follows made by the user, along with
+// the followed user's side of each
+func (s *SmartContract) eraseFollowing(ctx contractapi.TransactionContextInterface, erasure *Erasure, limit int) (int, bool, error) {
+	// follow~publicKey~followed and follower~followed~publicKey
+	following, more, err := deleteIndexEntries(ctx, followObjectType, erasure.PublicKey, limit)
+	if err != nil {
+		return 0, false, err
+	}
+	for _, entry := range following {
+		followerKey, err := entityKey(ctx, followerObjectType, entry.attributes[0], erasure.PublicKey)
+		if err != nil {
+			return 0, false, err
+		}
+		err = ctx.GetStub().DelState(followerKey)
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to remove follow: %v", err)
+		}
+	}
+	return len(following), more, nil
+}
+
+// eraseFollowers removes a batch of the follows of the user, along with the
+// follower's side of each
+func (s *SmartContract) eraseFollowers(ctx contractapi.TransactionContextInterface, erasure *Erasure, limit int) (int, bool, error) {
+	// follower~publicKey~follower and follow~follower~publicKey
+	followers, more, err := deleteIndexEntries(ctx, followerObjectType, erasure.PublicKey, limit)
+	if err != nil {
+		return 0, false, err
+	}
+	for _, entry := range followers {
+		followKey, err := entityKey(ctx, followObjectType, entry.attributes[0], erasure.PublicKey)
+		if err != nil {
+			return 0, false, err
+		}
+		err = ctx.GetStub().DelState(followKey)
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to remove follow: %v", err)
+		}
+	}
+	return len(followers), more, nil
+}
+
+// eraseIndex returns a step that removes the entries of an index keyed by user
+// and nothing else
+func eraseIndex(objectType string) erasureStep {
+	return func(s *SmartContract, ctx contractapi.TransactionContextInterface, erasure *Erasure, limit int) (int, bool, error) {
+		deleted, more, err := deleteIndexEntries(ctx, objectType, erasure.PublicKey, limit)
+		return len(deleted), more, err
+	}
+}
+
+// EraseUser erases the personal data of an account deactivated at least
+// reactivationGracePeriod ago. As the account can no longer be reactivated,
+// anyone may call it. The phone number and the profile DeactivateUser kept
+// are removed at once, leaving a record holding the public key, so the key
+// cannot be registered again.
+//
+// The rest is removed in batches, the first of which runs here; the rest are
+// removed by ContinueErasure. Whatever deactivation has yet to detach goes
+// first, then the user's posts are tombstoned like deleted ones, keeping only
+// the hashes that other records refer to. Comments are not indexed by author
+// and are left in place. Each batch returns the IPFS hashes of the post
+// documents it erased, for the caller to unpin. Images and videos are not
+// reported: the same file uploaded by another user has the same hash, so
+// unpinning it could take down their content too.
+func (s *SmartContract) EraseUser(ctx contractapi.TransactionContextInterface, publicKey string) (*Erasure, error) {
+	user, err := s.getUserRecord(ctx, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if !user.Deactivated {
+		return nil, fmt.Errorf("user %s is not deactivated", publicKey)
+	}
+	if user.Erased {
+		return nil, fmt.Errorf("user %s has already been erased", publicKey)
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if now < user.DeactivatedAt+reactivationGracePeriod {
+		return nil, fmt.Errorf("user %s can reactivate their account until %d", publicKey, user.DeactivatedAt+reactivationGracePeriod)
+	}
+
+	// Personal data
+	phoneKey, err := entityKey(ctx, phoneObjectType, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	err = ctx.GetStub().DelPrivateData(userPrivateCollection, phoneKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove phone number: %v", err)
+	}
+	_, err = takeDeactivatedProfile(ctx, publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep only what other records need
+	tombstone := &User{
+		PublicKey:     publicKey,
+		PreviousKeys:  user.PreviousKeys,
+		Suspended:     user.Suspended,
+		Deactivated:   true,
+		DeactivatedAt: user.DeactivatedAt,
+		Erased:        true,
+	}
+	err = s.saveUser(ctx, tombstone)
+	if err != nil {
+		return nil, err
+	}
+
+	erasure, err := getErasure(ctx, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if erasure == nil {
+		erasure = &Erasure{PublicKey: publicKey}
+	}
+	erasure.Erasing = true
+	err = s.runErasure(ctx, erasure, defaultErasureBatchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set last, as deleting an emptied group sets its own event
+	err = emitEvent(ctx, eventUserErased, UserEvent{PublicKey: publicKey})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Erased the account of user %s", publicKey)
+	return erasure, nil
+}
+
+// ContinueErasure removes the next batchSize records of the erasure of
+// publicKey's account. Until the returned progress reports Detached, some of
+// the user's friendships, groups or follows are still on the ledger, and until
+// it reports Done, some of their posts. Before EraseUser is called it stops at
+// detaching the account. Like EraseUser anyone may call it. Calling it once
+// there is nothing left to do changes nothing.
+func (s *SmartContract) ContinueErasure(ctx contractapi.TransactionContextInterface, publicKey string, batchSize int) (*Erasure, error) {
+	erasure, err := getErasure(ctx, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if erasure == nil {
+		return nil, fmt.Errorf("the account of user %s is not being erased", publicKey)
+	}
+	if erasure.Done || (erasure.Detached && !erasure.Erasing) {
+		return erasure, nil
+	}
+
+	err = s.runErasure(ctx, erasure, batchSize)
+	if err != nil {
+		return nil, err
+	}
+	return erasure, nil
+}
+
+// GetErasableUsers returns the public keys of deactivated accounts whose grace
+// period has passed and that are yet to be erased with EraseUser
+func (s *SmartContract) GetErasableUsers(ctx contractapi.TransactionContextInterface) ([]string, error) {
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(userObjectType, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get state iterator: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	publicKeys := []string{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get next query result: %v", err)
+		}
+
+		var user User
+		err = json.Unmarshal(queryResponse.Value, &user)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal user: %v", err)
+		}
+		if user.Deactivated && !user.Erased && now >= user.DeactivatedAt+reactivationGracePeriod {
+			publicKeys = append(publicKeys, user.PublicKey)
+		}
+	}
+	return publicKeys, nil
+}
+
+// GetUnfinishedErasures returns the public keys of deactivated accounts that
+// are still being detached, and of erased accounts whose records are still
+// being removed, see ContinueErasure
+func (s *SmartContract) GetUnfinishedErasures(ctx contractapi.TransactionContextInterface) ([]string, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(erasureObjectType, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get state iterator: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	publicKeys := []string{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get next query result: %v", err)
+		}
+
+		var erasure Erasure
+		err = json.Unmarshal(queryResponse.Value, &erasure)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal erasure: %v", err)
+		}
+		if !erasure.Done && (erasure.Erasing || !erasure.Detached) {
+			publicKeys = append(publicKeys, erasure.PublicKey)
+		}
+	}
+	return publicKeys, nil
+}
+
+// userFriendRequestPairs returns the sender and receiver of at most limit
+// friend requests listed under a user, skipping the first offset, and whether
+// any are left
+func (s *SmartContract) userFriendRequestPairs(ctx contractapi.TransactionContextInterface, publicKey string, offset int, limit int) ([][2]string, bool, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(userFriendRequestObjectType, []string{publicKey})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get friend requests: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	pairs := [][2]string{}
+	skipped := 0
+	for len(pairs) < limit && resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to iterate friend requests: %v", err)
+		}
+
+		// userfriendrequest~publicKey~sender~receiver
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to split composite key: %v", err)
+		}
+		if len(compositeKeyParts) != 3 {
+			continue
+		}
+		if skipped < offset {
+			skipped++
+			continue
+		}
+		pairs = append(pairs, [2]string{compositeKeyParts[1], compositeKeyParts[2]})
+	}
+	return pairs, resultsIterator.HasNext(), nil
+}
+
+// ReactivateUser reopens a deactivated account within reactivationGracePeriod
+// of its deactivation, signed with the account's key. The name and profile
+// come back as they were, and the handle too unless another user has claimed
+// it since, in which case the user picks a new one with ChangeHandle. The
+// posts are listed again: the first batch here and the rest by
+// ContinuePostListing. Friendships, groups and follows were dropped on
+// deactivation and stay dropped; the account cannot be reactivated until
+// ContinueErasure has finished detaching it.
+func (s *SmartContract) ReactivateUser(ctx contractapi.TransactionContextInterface, publicKey string, nonce string, signature string) (*User, error) {
+	user, err := s.getUserRecord(ctx, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if !user.Deactivated {
+		return nil, fmt.Errorf("user %s is not deactivated", publicKey)
+	}
+	err = verifySignedCall(ctx, publicKey, "ReactivateUser", []string{publicKey}, nonce, signature)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if user.Erased || now >= user.DeactivatedAt+reactivationGracePeriod {
+		return nil, fmt.Errorf("the account of user %s can no longer be reactivated", publicKey)
+	}
+	erasure, err := getErasure(ctx, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if erasure != nil && !erasure.Detached {
+		return nil, fmt.Errorf("the account of user %s is still being detached, see ContinueErasure", publicKey)
+	}
+
+	profile, err := takeDeactivatedProfile(ctx, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if profile == nil {
+		return nil, fmt.Errorf("no profile was kept for user %s", publicKey)
+	}
+	if profile.Handle != "" {
+		owner, err := handleOwner(ctx, profile.Handle)
+		if err != nil {
+			return nil, err
+		}
+		if owner == "" {
+			err = s.reserveHandle(ctx, profile.Handle, publicKey)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			profile.Name = ""
+			profile.Handle = ""
+		}
+	}
+	if erasure != nil {
+		erasureKey, err := entityKey(ctx, erasureObjectType, publicKey)
+		if err != nil {
+			return nil, err
+		}
+		err = ctx.GetStub().DelState(erasureKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to remove erasure of %s: %v", publicKey, err)
+		}
+	}
+
+	err = s.setUserPostsListed(ctx, publicKey, true)
+	if err != nil {
+		return nil, err
+	}
+	// The record may have changed since, e.g. by a suspension
+	profile.Suspended = user.Suspended
+	profile.PreviousKeys = user.PreviousKeys
+	profile.Deactivated = false
+	profile.DeactivatedAt = 0
+	user = profile
+	err = s.saveUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = emitEvent(ctx, eventUserReactivated, UserEvent{PublicKey: publicKey, Name: user.Name, Handle: user.Handle})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("User %s reactivated their account", publicKey)
+	return user, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Names of the chaincode events emitted on state changes. Fabric keeps a single
+// event per transaction, so each transaction emits exactly one of these.
+const (
+	eventUserRegistered         = "UserRegistered"
+	eventHandleChanged          = "HandleChanged"
+	eventProfileUpdated         = "ProfileUpdated"
+	eventUserKeyRotated         = "UserKeyRotated"
+	eventUserDeactivated        = "UserDeactivated"
+	eventUserReactivated        = "UserReactivated"
+	eventUserErased             = "UserErased"
+	eventPostCreated            = "PostCreated"
+	eventPostShared             = "PostShared"
+	eventPostEdited             = "PostEdited"
+	eventPostDeleted            = "PostDeleted"
+	eventReactionChanged        = "ReactionChanged"
+	eventCommentAdded           = "CommentAdded"
+	eventFriendRequestSent      = "FriendRequestSent"
+	eventFriendRequestResponded = "FriendRequestResponded"
+	eventFriendRequestCancelled = "FriendRequestCancelled"
+	eventFriendRemoved          = "FriendRemoved"
+	eventUserBlocked            = "UserBlocked"
+	eventUserUnblocked          = "UserUnblocked"
+	eventUserFollowed           = "UserFollowed"
+	eventUserUnfollowed         = "UserUnfollowed"
+	eventGroupCreated           = "GroupCreated"
+	eventMemberAdded            = "MemberAdded"
+	eventMemberRemoved          = "MemberRemoved"
+	eventMemberRoleChanged      = "MemberRoleChanged"
+	eventGroupRenamed           = "GroupRenamed"
+	eventGroupDeleted           = "GroupDeleted"
+	eventContentReported        = "ContentReported"
+	eventPostHidden             = "PostHidden"
+	eventPostRestored           = "PostRestored"
+	eventUserSuspended          = "UserSuspended"
+	eventUserReinstated         = "UserReinstated"
+	eventMessageAdded           = "MessageAdded"
+	eventGroupMessageAdded      = "GroupMessageAdded"
+)
+
+// UserEvent is the payload of UserRegistered, HandleChanged, ProfileUpdated,
+// UserDeactivated, UserReactivated and UserErased
+type UserEvent struct {
+	PublicKey string `json:"publicKey"`
+	Name      string `json:"name"`
+	Handle    string `json:"handle"`
+}
+
+// KeyRotationEvent is the payload of UserKeyRotated
+type KeyRotationEvent struct {
+	OldPublicKey string `json:"oldPublicKey"`
+	NewPublicKey string `json:"newPublicKey"`
+	Handle       string `json:"handle"`
+}
+
+// PostEvent is the payload of PostCreated, PostShared, PostEdited and PostDeleted
+type PostEvent struct {
+	PostID          string   `json:"postId"`  // ID the ledger assigned the post
+	PostCID         string   `json:"postCID"` // IPFS hash the post is stored under, which edits keep
+	AuthorPublicKey string   `json:"authorPublicKey"`
+	ContentCID      string   `json:"contentCID"`              // Current content of the post
+	SharedPostCID   string   `json:"sharedPostCID,omitempty"` // Original post of a reshare
+	OriginalAuthor  string   `json:"originalAuthor,omitempty"`
+	Mentions        []string `json:"mentions,omitempty"` // Users mentioned by a new post, or newly mentioned by an edit
+}
+
+// ReactionEvent is the payload of ReactionChanged; ReactionType is empty when a reaction is removed
+type ReactionEvent struct {
+	PostID          string `json:"postId"`  // ID the ledger assigned the post
+	PostCID         string `json:"postCID"` // IPFS hash the post is stored under
+	AuthorPublicKey string `json:"authorPublicKey"`
+	UserPublicKey   string `json:"userPublicKey"`
+	ReactionType    string `json:"reactionType"`
+}
+
+// CommentEvent is the payload of CommentAdded
+type CommentEvent struct {
+	PostID          string `json:"postId"`  // ID the ledger assigned the post
+	PostCID         string `json:"postCID"` // IPFS hash the post is stored under
+	PostAuthor      string `json:"postAuthor"`
+	CommentID       string `json:"commentId"`
+	ParentID        string `json:"parentId"`
+	ParentAuthor    string `json:"parentAuthor,omitempty"`
+	AuthorPublicKey string `json:"authorPublicKey"`
+}
+
+// FriendRequestEvent is the payload of FriendRequestSent, FriendRequestResponded
+// and FriendRequestCancelled, whose Status is the request's. FriendRemoved,
+// UserBlocked and UserUnblocked share it: Sender is the user who acted,
+// Receiver the other user and Status one of the relationship statuses below.
+type FriendRequestEvent struct {
+	Sender   string `json:"sender"`
+	Receiver string `json:"receiver"`
+	Status   string `json:"status"`
+}
+
+// Statuses of FriendRequestEvent for the events that are not about a request
+const (
+	relationshipRemoved   = "removed"
+	relationshipBlocked   = "blocked"
+	relationshipUnblocked = "unblocked"
+)
+
+// FollowEvent is the payload of UserFollowed and UserUnfollowed
+type FollowEvent struct {
+	Follower string `json:"follower"`
+	Followed string `json:"followed"`
+}
+
+// GroupEvent is the payload of the group events; Members lists the members the
+// event is about
+type GroupEvent struct {
+	GroupID   string   `json:"groupId"`
+	GroupName string   `json:"groupName"`
+	Members   []string `json:"members"`
+	Actor     string   `json:"actor,omitempty"` // Member who made the change
+	Role      string   `json:"role,omitempty"`  // New role, on MemberRoleChanged
+}
+
+// ReportEvent is the payload of ContentReported. The reporter is left out.
+type ReportEvent struct {
+	TargetType  string `json:"targetType"`
+	TargetID    string `json:"targetId"`
+	ReportCount int    `json:"reportCount,omitempty"` // Reports of a post since it was last reviewed
+}
+
+// ModerationEvent is the payload of PostHidden, PostRestored, UserSuspended and
+// UserReinstated. Moderator is empty when a post was hidden by reports.
+type ModerationEvent struct {
+	TargetType      string `json:"targetType"`
+	TargetID        string `json:"targetId"`
+	AuthorPublicKey string `json:"authorPublicKey,omitempty"` // Author of a hidden or restored post
+	Moderator       string `json:"moderator,omitempty"`
+	Reason          string `json:"reason"`
+}
+
+// MessageEvent is the payload of MessageAdded. Events are visible to the whole
+// channel, and a chat's ID can be derived from its participants, so the event
+// only tells clients to read their chats again.
+type MessageEvent struct{}
+
+// GroupMessageEvent is the payload of GroupMessageAdded
+type GroupMessageEvent struct {
+	GroupID    string   `json:"groupId"`
+	GroupName  string   `json:"groupName"`
+	Sender     string   `json:"sender"`
+	Seq        int      `json:"seq"`
+	Recipients []string `json:"recipients"`
+}
+
+// emitEvent sets the transaction's chaincode event to name with a JSON payload
+func emitEvent(ctx contractapi.TransactionContextInterface, name string, payload interface{}) error {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s event: %v", name, err)
+	}
+	err = ctx.GetStub().SetEvent(name, payloadJSON)
+	if err != nil {
+		return fmt.Errorf("failed to set event: %v", err)
+	}
+	return nil
+}
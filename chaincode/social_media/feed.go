@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// feedSource is a newest-first post index whose keys end in
+// reverseTimestamp~contentCID, such as userpost~publicKey or hashtagpost~tag
+type feedSource struct {
+	objectType string
+	attributes []string
+}
+
+// feedEntry is a post read from a feed source
+type feedEntry struct {
+	reverseTimestamp string
+	contentCID       string
+}
+
+// feedCursor encodes the position after entry. The reverse timestamp is fixed
+// width, so the content CID is everything after it.
+func feedCursor(entry feedEntry) string {
+	return entry.reverseTimestamp + entry.contentCID
+}
+
+// parseFeedCursor splits a cursor made by feedCursor
+func parseFeedCursor(cursor string) (feedEntry, error) {
+	if len(cursor) <= len(reverseTimestamp(0)) {
+		return feedEntry{}, fmt.Errorf("invalid feed cursor %q", cursor)
+	}
+	split := len(reverseTimestamp(0))
+	return feedEntry{reverseTimestamp: cursor[:split], contentCID: cursor[split:]}, nil
+}
+
+// readFeedSource reads up to limit entries of a source that come after the
+// cursor. Range queries start at their bookmark key, so the cursor's own key
+// is used as the bookmark and skipped if it is still there.
+func readFeedSource(ctx contractapi.TransactionContextInterface, source feedSource, limit int32, cursor *feedEntry) ([]feedEntry, error) {
+	bookmark := ""
+	if cursor != nil {
+		key, err := entityKey(ctx, source.objectType, append(append([]string{}, source.attributes...), cursor.reverseTimestamp, cursor.contentCID)...)
+		if err != nil {
+			return nil, err
+		}
+		bookmark = key
+		limit++
+	}
+	resultsIterator, _, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(source.objectType, source.attributes, limit, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s index: %v", source.objectType, err)
+	}
+	defer resultsIterator.Close()
+
+	entries := []feedEntry{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate %s index: %v", source.objectType, err)
+		}
+		if queryResponse.Key == bookmark {
+			continue
+		}
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to split composite key: %v", err)
+		}
+		if len(compositeKeyParts) != len(source.attributes)+2 {
+			continue
+		}
+		entries = append(entries, feedEntry{
+			reverseTimestamp: compositeKeyParts[len(compositeKeyParts)-2],
+			contentCID:       compositeKeyParts[len(compositeKeyParts)-1],
+		})
+	}
+	return entries, nil
+}
+
+// mergeFeed returns one page of the posts of several sources merged newest
+// first, without duplicates. Each source is read no further than one page past
+// the cursor, so the cost of a page does not grow with how far the reader has
+// scrolled. The bookmark of the page is a cursor for the next one.
+func mergeFeed(ctx contractapi.TransactionContextInterface, sources []feedSource, pageSize int32, bookmark string) (*PostPage, error) {
+	pageSize = normalizePageSize(pageSize)
+	var cursor *feedEntry
+	if bookmark != "" {
+		parsed, err := parseFeedCursor(bookmark)
+		if err != nil {
+			return nil, err
+		}
+		cursor = &parsed
+	}
+
+	seen := make(map[string]bool)
+	merged := []feedEntry{}
+	for _, source := range sources {
+		entries, err := readFeedSource(ctx, source, pageSize, cursor)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !seen[entry.contentCID] {
+				seen[entry.contentCID] = true
+				merged = append(merged, entry)
+			}
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		if merged[i].reverseTimestamp != merged[j].reverseTimestamp {
+			return merged[i].reverseTimestamp < merged[j].reverseTimestamp
+		}
+		return merged[i].contentCID < merged[j].contentCID
+	})
+	if int32(len(merged)) > pageSize {
+		merged = merged[:pageSize]
+	}
+
+	page := &PostPage{Posts: []string{}}
+	for _, entry := range merged {
+		page.Posts = append(page.Posts, entry.contentCID)
+	}
+	if int32(len(merged)) == pageSize {
+		page.Bookmark = feedCursor(merged[len(merged)-1])
+	}
+	return page, nil
+}
+
+// GetHashtagFeed returns one page of the posts tagged with any of the hashtags
+// a user follows, newest first
+func (s *SmartContract) GetHashtagFeed(ctx contractapi.TransactionContextInterface, publicKey string, pageSize int32, bookmark string) (*PostPage, error) {
+	hashtags, err := s.GetFollowedHashtags(ctx, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	sources := make([]feedSource, 0, len(hashtags))
+	for _, tag := range hashtags {
+		sources = append(sources, feedSource{objectType: hashtagPostObjectType, attributes: []string{tag}})
+	}
+	return mergeFeed(ctx, sources, pageSize, bookmark)
+}
+
+// GetHomeFeed returns one page of a user's timeline, newest first: their own
+// posts and those of their friends, of the users they follow and tagged with
+// the hashtags they follow. Each page reads only the next few entries of each
+// author's and hashtag's index, never the posts of the whole network.
+func (s *SmartContract) GetHomeFeed(ctx contractapi.TransactionContextInterface, publicKey string, pageSize int32, bookmark string) (*PostPage, error) {
+	exists, err := s.UserExists(ctx, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("user does not exist: %s", publicKey)
+	}
+
+	friends, err := s.GetFriendsByUser(ctx, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	followed, err := s.followedUsers(ctx, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	hashtags, err := s.GetFollowedHashtags(ctx, publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := []feedSource{}
+	authors := make(map[string]bool)
+	for _, author := range append(append([]string{publicKey}, friends...), followed...) {
+		if !authors[author] {
+			authors[author] = true
+			sources = append(sources, feedSource{objectType: userPostObjectType, attributes: []string{author}})
+		}
+	}
+	for _, tag := range hashtags {
+		sources = append(sources, feedSource{objectType: hashtagPostObjectType, attributes: []string{tag}})
+	}
+	return mergeFeed(ctx, sources, pageSize, bookmark)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Follow records that one user follows another. Unlike a friendship it is one
+// way and needs no consent from the followed user.
+type Follow struct {
+	Follower  string `json:"follower"`
+	Followed  string `json:"followed"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// isFollowing reports whether follower follows followed
+func (s *SmartContract) isFollowing(ctx contractapi.TransactionContextInterface, follower string, followed string) (bool, error) {
+	followKey, err := entityKey(ctx, followObjectType, follower, followed)
+	if err != nil {
+		return false, err
+	}
+	followJSON, err := ctx.GetStub().GetState(followKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to read follow: %v", err)
+	}
+	return followJSON != nil, nil
+}
+
+// removeFollow drops the follow of followed by follower, reporting whether there was one
+func (s *SmartContract) removeFollow(ctx contractapi.TransactionContextInterface, follower string, followed string) (bool, error) {
+	following, err := s.isFollowing(ctx, follower, followed)
+	if err != nil || !following {
+		return false, err
+	}
+
+	followKey, err := entityKey(ctx, followObjectType, follower, followed)
+	if err != nil {
+		return false, err
+	}
+	followerKey, err := entityKey(ctx, followerObjectType, followed, follower)
+	if err != nil {
+		return false, err
+	}
+	for _, key := range []string{followKey, followerKey} {
+		err = ctx.GetStub().DelState(key)
+		if err != nil {
+			return false, fmt.Errorf("failed to remove follow: %v", err)
+		}
+	}
+
+	return true, nil
+}
+
+// Follow makes publicKey follow followedPublicKey, signed by the follower. Users
+// with a block between them cannot follow each other.
+func (s *SmartContract) Follow(ctx contractapi.TransactionContextInterface, publicKey string, followedPublicKey string, nonce string, signature string) error {
+	err := s.verifyUserSignature(ctx, publicKey, "Follow", []string{publicKey, followedPublicKey}, nonce, signature)
+	if err != nil {
+		return err
+	}
+	if publicKey == followedPublicKey {
+		return fmt.Errorf("users cannot follow themselves")
+	}
+
+	err = s.requireActiveUser(ctx, followedPublicKey)
+	if err != nil {
+		return err
+	}
+	err = s.checkNotBlocked(ctx, publicKey, followedPublicKey)
+	if err != nil {
+		return err
+	}
+
+	following, err := s.isFollowing(ctx, publicKey, followedPublicKey)
+	if err != nil {
+		return err
+	}
+	if following {
+		return fmt.Errorf("user %s already follows %s", publicKey, followedPublicKey)
+	}
+
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	followJSON, err := json.Marshal(Follow{Follower: publicKey, Followed: followedPublicKey, Timestamp: timestamp})
+	if err != nil {
+		return fmt.Errorf("failed to marshal follow: %v", err)
+	}
+	followKey, err := entityKey(ctx, followObjectType, publicKey, followedPublicKey)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(followKey, followJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store follow: %v", err)
+	}
+	followerKey, err := entityKey(ctx, followerObjectType, followedPublicKey, publicKey)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(followerKey, indexMarker)
+	if err != nil {
+		return fmt.Errorf("failed to index follower: %v", err)
+	}
+
+	log.Printf("User %s followed %s", publicKey, followedPublicKey)
+	return emitEvent(ctx, eventUserFollowed, FollowEvent{Follower: publicKey, Followed: followedPublicKey})
+}
+
+// Unfollow stops publicKey following followedPublicKey, signed by the follower
+func (s *SmartContract) Unfollow(ctx contractapi.TransactionContextInterface, publicKey string, followedPublicKey string, nonce string, signature string) error {
+	err := s.verifyUserSignature(ctx, publicKey, "Unfollow", []string{publicKey, followedPublicKey}, nonce, signature)
+	if err != nil {
+		return err
+	}
+
+	removed, err := s.removeFollow(ctx, publicKey, followedPublicKey)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return fmt.Errorf("user %s does not follow %s", publicKey, followedPublicKey)
+	}
+
+	log.Printf("User %s unfollowed %s", publicKey, followedPublicKey)
+	return emitEvent(ctx, eventUserUnfollowed, FollowEvent{Follower: publicKey, Followed: followedPublicKey})
+}
+
+// followPage reads one page of a follow edge index, whose keys end in the
+// public key of the user at the other end, and loads those users. The user
+// may be looked up by a key they have since replaced.
+func (s *SmartContract) followPage(ctx contractapi.TransactionContextInterface, objectType string, publicKey string, pageSize int32, bookmark string) (*UserPage, error) {
+	publicKey, err := resolveUserKey(ctx, publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	pageSize = normalizePageSize(pageSize)
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(objectType, []string{publicKey}, pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get follows: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	page := &UserPage{Users: []*User{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate follows: %v", err)
+		}
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to split composite key: %v", err)
+		}
+		if len(compositeKeyParts) != 2 {
+			continue
+		}
+		user, err := s.GetUser(ctx, compositeKeyParts[1])
+		if err != nil {
+			return nil, err
+		}
+		page.Users = append(page.Users, user)
+	}
+
+	page.Bookmark = nextBookmark(metadata.FetchedRecordsCount, metadata.Bookmark, pageSize)
+	return page, nil
+}
+
+// GetFollowers returns one page of the users who follow publicKey
+func (s *SmartContract) GetFollowers(ctx contractapi.TransactionContextInterface, publicKey string, pageSize int32, bookmark string) (*UserPage, error) {
+	return s.followPage(ctx, followerObjectType, publicKey, pageSize, bookmark)
+}
+
+// GetFollowing returns one page of the users publicKey follows
+func (s *SmartContract) GetFollowing(ctx contractapi.TransactionContextInterface, publicKey string, pageSize int32, bookmark string) (*UserPage, error) {
+	return s.followPage(ctx, followObjectType, publicKey, pageSize, bookmark)
+}
+
+// followedUsers returns the public keys of every user publicKey follows
+func (s *SmartContract) followedUsers(ctx contractapi.TransactionContextInterface, publicKey string) ([]string, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(followObjectType, []string{publicKey})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get followed users: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	followed := []string{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate followed users: %v", err)
+		}
+
+		// follow~follower~followed
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to split composite key: %v", err)
+		}
+		if len(compositeKeyParts) == 2 {
+			followed = append(followed, compositeKeyParts[1])
+		}
+	}
+	return followed, nil
+}
+
+// GetFollowingFeed returns one page of the posts of the users publicKey
+// follows, newest first
+func (s *SmartContract) GetFollowingFeed(ctx contractapi.TransactionContextInterface, publicKey string, pageSize int32, bookmark string) (*PostPage, error) {
+	followed, err := s.followedUsers(ctx, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	sources := make([]feedSource, 0, len(followed))
+	for _, author := range followed {
+		sources = append(sources, feedSource{objectType: userPostObjectType, attributes: []string{author}})
+	}
+	return mergeFeed(ctx, sources, pageSize, bookmark)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Statuses of a friend request
+const (
+	friendRequestPending   = "pending"
+	friendRequestAccepted  = "accepted"
+	friendRequestRejected  = "rejected"
+	friendRequestCancelled = "cancelled"
+	friendRequestExpired   = "expired"
+)
+
+// Lifetimes of a friend request, in seconds. A pending request expires after
+// friendRequestExpiry, and a rejected one can be sent again once
+// friendRequestCooldown has passed since the rejection.
+const (
+	friendRequestExpiry   = 30 * 24 * 60 * 60
+	friendRequestCooldown = 7 * 24 * 60 * 60
+)
+
+// getFriendRequest reads the friend request from sender to receiver, returning nil
+// if there is none. Pending requests past their expiry are reported as expired.
+func (s *SmartContract) getFriendRequest(ctx contractapi.TransactionContextInterface, sender string, receiver string) (*FriendRequest, error) {
+	requestKey, err := entityKey(ctx, friendRequestObjectType, sender, receiver)
+	if err != nil {
+		return nil, err
+	}
+	requestJSON, err := ctx.GetStub().GetState(requestKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read friend request: %v", err)
+	}
+	if requestJSON == nil {
+		return nil, nil
+	}
+
+	var friendRequest FriendRequest
+	err = json.Unmarshal(requestJSON, &friendRequest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal friend request: %v", err)
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	applyFriendRequestExpiry(&friendRequest, now)
+	return &friendRequest, nil
+}
+
+// applyFriendRequestExpiry marks a pending request as expired once it is older than friendRequestExpiry
+func applyFriendRequestExpiry(friendRequest *FriendRequest, now int64) {
+	if friendRequest.Status == friendRequestPending && now >= friendRequest.Timestamp+friendRequestExpiry {
+		friendRequest.Status = friendRequestExpired
+	}
+}
+
+// saveFriendRequest stores a friend request and indexes it under both users
+func (s *SmartContract) saveFriendRequest(ctx contractapi.TransactionContextInterface, friendRequest *FriendRequest) (string, error) {
+	// Names are filled in when requests are listed, so they are not stored
+	friendRequest.SenderName = ""
+	friendRequest.ReceiverName = ""
+
+	requestJSON, err := json.Marshal(friendRequest)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal friend request: %v", err)
+	}
+	requestKey, err := entityKey(ctx, friendRequestObjectType, friendRequest.Sender, friendRequest.Receiver)
+	if err != nil {
+		return "", err
+	}
+	err = ctx.GetStub().PutState(requestKey, requestJSON)
+	if err != nil {
+		return "", fmt.Errorf("failed to store friend request: %v", err)
+	}
+
+	err = s.indexFriendRequest(ctx, friendRequest.Sender, friendRequest.Receiver)
+	if err != nil {
+		return "", err
+	}
+	return requestKey, nil
+}
+
+// indexFriendRequest lists a request under the sender and the receiver, so each
+// user's requests can be read without scanning every request on the ledger
+func (s *SmartContract) indexFriendRequest(ctx contractapi.TransactionContextInterface, sender string, receiver string) error {
+	for _, publicKey := range []string{sender, receiver} {
+		indexKey, err := entityKey(ctx, userFriendRequestObjectType, publicKey, sender, receiver)
+		if err != nil {
+			return err
+		}
+		err = ctx.GetStub().PutState(indexKey, indexMarker)
+		if err != nil {
+			return fmt.Errorf("failed to index friend request: %v", err)
+		}
+	}
+	return nil
+}
+
+// friendRequestFromIndex loads the request an index key of userfriendrequest~publicKey~sender~receiver points to
+func (s *SmartContract) friendRequestFromIndex(ctx contractapi.TransactionContextInterface, indexKey string) (*FriendRequest, error) {
+	_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(indexKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to split composite key: %v", err)
+	}
+	if len(compositeKeyParts) != 3 {
+		return nil, nil
+	}
+	return s.getFriendRequest(ctx, compositeKeyParts[1], compositeKeyParts[2])
+}
+
+// areFriends reports whether friend is on the friends list of publicKey
+func (s *SmartContract) areFriends(ctx contractapi.TransactionContextInterface, publicKey string, friend string) (bool, error) {
+	friends, err := s.GetFriendsByUser(ctx, publicKey)
+	if err != nil {
+		return false, err
+	}
+	for _, existing := range friends {
+		if existing == friend {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CancelFriendRequest withdraws a pending friend request, signed by its sender.
+// A cancelled request can be sent again straight away.
+func (s *SmartContract) CancelFriendRequest(ctx contractapi.TransactionContextInterface, sender string, receiver string, nonce string, signature string) error {
+	err := s.verifyUserSignature(ctx, sender, "CancelFriendRequest", []string{sender, receiver}, nonce, signature)
+	if err != nil {
+		return err
+	}
+
+	friendRequest, err := s.getFriendRequest(ctx, sender, receiver)
+	if err != nil {
+		return err
+	}
+	if friendRequest == nil {
+		return fmt.Errorf("friend request not found")
+	}
+	if friendRequest.Status != friendRequestPending {
+		return fmt.Errorf("only pending friend requests can be cancelled, this one is %s", friendRequest.Status)
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	friendRequest.Status = friendRequestCancelled
+	friendRequest.RespondedAt = now
+	_, err = s.saveFriendRequest(ctx, friendRequest)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("User %s cancelled friend request to %s", sender, receiver)
+	return emitEvent(ctx, eventFriendRequestCancelled, FriendRequestEvent{Sender: sender, Receiver: receiver, Status: friendRequest.Status})
+}
+
+// checkFriendRequestCanBeSent fails if an earlier request from sender to receiver
+// is still pending, or was rejected less than friendRequestCooldown ago
+func checkFriendRequestCanBeSent(existing *FriendRequest, now int64) error {
+	if existing == nil {
+		return nil
+	}
+	switch existing.Status {
+	case friendRequestPending:
+		return fmt.Errorf("friend request is already pending")
+	case friendRequestRejected:
+		resendAt := existing.RespondedAt + friendRequestCooldown
+		if now < resendAt {
+			return fmt.Errorf("friend request was rejected and can be sent again after %s", time.Unix(resendAt, 0).UTC().Format(time.RFC3339))
+		}
+	}
+	return nil
+}
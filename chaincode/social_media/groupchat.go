@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// GroupChatPage is one page of a group's messages, newest first. Before is the
+// cursor for the next, older page, and 0 once the oldest message has been returned.
+type GroupChatPage struct {
+	GroupID      string          `json:"groupId"`
+	Participants []string        `json:"participants"`
+	LastSeq      int             `json:"lastSeq"`
+	Messages     []*GroupMessage `json:"messages"`
+	Before       int             `json:"before"`
+}
+
+// groupChatMessageKey builds the key of message seq of a group's chat. The
+// sequence number is zero padded so messages iterate in order.
+func groupChatMessageKey(ctx contractapi.TransactionContextInterface, groupID string, seq int) (string, error) {
+	return entityKey(ctx, groupChatMessageObjectType, groupID, fmt.Sprintf("%019d", seq))
+}
+
+// getGroupChat reads the header of a group's chat, returning an empty chat if nothing has been sent yet
+func (s *SmartContract) getGroupChat(ctx contractapi.TransactionContextInterface, groupID string) (*GroupChat, error) {
+	chatKey, err := entityKey(ctx, groupChatObjectType, groupID)
+	if err != nil {
+		return nil, err
+	}
+	chatJSON, err := ctx.GetStub().GetState(chatKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get group chat: %v", err)
+	}
+
+	chat := &GroupChat{GroupID: groupID, Participants: []string{}}
+	if chatJSON == nil {
+		return chat, nil
+	}
+	err = json.Unmarshal(chatJSON, chat)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal group chat: %v", err)
+	}
+	return chat, nil
+}
+
+// saveGroupChatMessage writes a group message under its own key
+func (s *SmartContract) saveGroupChatMessage(ctx contractapi.TransactionContextInterface, groupID string, message *GroupMessage) error {
+	messageJSON, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal group message: %v", err)
+	}
+	messageKey, err := groupChatMessageKey(ctx, groupID, message.Seq)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(messageKey, messageJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store message %d of group %s: %v", message.Seq, groupID, err)
+	}
+	return nil
+}
+
+// AddGroupMessage appends a message to a group's chat, signed with the sender's
+// key. The sender must be a current member of the group. message is a JSON
+// GroupMessage of which only the IPFS hash and the sender's signature over the
+// plain text are used; the chaincode fills in the rest. Each message is stored
+// under its own key, so sending one only rewrites the small chat header. The
+// message's sequence number is returned.
+func (s *SmartContract) AddGroupMessage(ctx contractapi.TransactionContextInterface, groupID string, message string, senderPublicKey string, nonce string, signature string) (int, error) {
+	err := s.verifyUserSignature(ctx, senderPublicKey, "AddGroupMessage", []string{groupID, message, senderPublicKey}, nonce, signature)
+	if err != nil {
+		return 0, err
+	}
+
+	group, err := s.ReadGroup(ctx, groupID)
+	if err != nil {
+		return 0, err
+	}
+	_, err = groupRole(group, senderPublicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	var newMessage GroupMessage
+	err = json.Unmarshal([]byte(message), &newMessage)
+	if err != nil {
+		return 0, fmt.Errorf("failed to unmarshal message data: %v", err)
+	}
+	if newMessage.IPFSHash == "" {
+		return 0, fmt.Errorf("message IPFS hash is required")
+	}
+
+	chat, err := s.getGroupChat(ctx, groupID)
+	if err != nil {
+		return 0, err
+	}
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// The recipients are the members at the time of sending, so later joiners
+	// cannot read earlier messages
+	chat.LastSeq++
+	newMessage.Seq = chat.LastSeq
+	newMessage.Sender = senderPublicKey
+	newMessage.Receiver = append([]string{}, group.Members...)
+	newMessage.Timestamp = time.Unix(timestamp, 0).UTC().Format(time.RFC3339)
+	chat.Participants = newMessage.Receiver
+	err = s.saveGroupChatMessage(ctx, groupID, &newMessage)
+	if err != nil {
+		return 0, err
+	}
+
+	chatJSON, err := json.Marshal(chat)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal group chat: %v", err)
+	}
+	chatKey, err := entityKey(ctx, groupChatObjectType, groupID)
+	if err != nil {
+		return 0, err
+	}
+	err = ctx.GetStub().PutState(chatKey, chatJSON)
+	if err != nil {
+		return 0, fmt.Errorf("failed to store group chat: %v", err)
+	}
+
+	err = emitEvent(ctx, eventGroupMessageAdded, GroupMessageEvent{
+		GroupID:    groupID,
+		GroupName:  group.GroupName,
+		Sender:     senderPublicKey,
+		Seq:        newMessage.Seq,
+		Recipients: newMessage.Receiver,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return newMessage.Seq, nil
+}
+
+// GetGroupChat returns one page of a group's messages, newest first. Messages
+// with a sequence number below before are returned; a before of 0 or less
+// starts from the newest message.
+func (s *SmartContract) GetGroupChat(ctx contractapi.TransactionContextInterface, groupID string, pageSize int32, before int) (*GroupChatPage, error) {
+	exists, err := s.GroupExists(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("group with ID %s does not exist", groupID)
+	}
+
+	chat, err := s.getGroupChat(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	pageSize = normalizePageSize(pageSize)
+	if before <= 0 || before > chat.LastSeq+1 {
+		before = chat.LastSeq + 1
+	}
+
+	page := &GroupChatPage{
+		GroupID:      groupID,
+		Participants: chat.Participants,
+		LastSeq:      chat.LastSeq,
+		Messages:     []*GroupMessage{},
+	}
+
+	// Sequence numbers are contiguous, so the page is read key by key
+	seq := before - 1
+	for ; seq >= 1 && int32(len(page.Messages)) < pageSize; seq-- {
+		messageKey, err := groupChatMessageKey(ctx, groupID, seq)
+		if err != nil {
+			return nil, err
+		}
+		messageJSON, err := ctx.GetStub().GetState(messageKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get message %d of group %s: %v", seq, groupID, err)
+		}
+		if messageJSON == nil {
+			continue
+		}
+
+		var message GroupMessage
+		err = json.Unmarshal(messageJSON, &message)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal message %d of group %s: %v", seq, groupID, err)
+		}
+		page.Messages = append(page.Messages, &message)
+	}
+
+	if seq >= 1 {
+		page.Before = seq + 1
+	}
+	return page, nil
+}
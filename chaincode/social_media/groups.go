@@ -0,0 +1,370 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Roles of a group member. Each group has exactly one owner; admins can manage
+// members and rename the group, and only the owner can change roles or delete it.
+const (
+	groupRoleOwner  = "owner"
+	groupRoleAdmin  = "admin"
+	groupRoleMember = "member"
+)
+
+// groupRoleRank orders the roles so a member can only manage those ranked below them
+var groupRoleRank = map[string]int{
+	groupRoleMember: 1,
+	groupRoleAdmin:  2,
+	groupRoleOwner:  3,
+}
+
+// saveGroup writes a group back to the ledger
+func (s *SmartContract) saveGroup(ctx contractapi.TransactionContextInterface, group *Group) error {
+	groupJSON, err := json.Marshal(group)
+	if err != nil {
+		return fmt.Errorf("failed to serialize group: %v", err)
+	}
+	groupKey, err := entityKey(ctx, groupObjectType, group.ID)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(groupKey, groupJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put group state: %v", err)
+	}
+	return nil
+}
+
+// indexGroupMember lists a group under one of its members
+func (s *SmartContract) indexGroupMember(ctx contractapi.TransactionContextInterface, groupID string, publicKey string) error {
+	indexKey, err := entityKey(ctx, groupMemberObjectType, publicKey, groupID)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(indexKey, indexMarker)
+	if err != nil {
+		return fmt.Errorf("failed to index group member: %v", err)
+	}
+	return nil
+}
+
+// unindexGroupMember removes a group from a member's index
+func (s *SmartContract) unindexGroupMember(ctx contractapi.TransactionContextInterface, groupID string, publicKey string) error {
+	indexKey, err := entityKey(ctx, groupMemberObjectType, publicKey, groupID)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().DelState(indexKey)
+	if err != nil {
+		return fmt.Errorf("failed to remove group member index: %v", err)
+	}
+	return nil
+}
+
+// addGroupMember adds a user to a group with the given role. The user must exist,
+// not have deactivated their account, not already be a member, and have no
+// block with any existing member.
+func (s *SmartContract) addGroupMember(ctx contractapi.TransactionContextInterface, group *Group, publicKey string, role string) error {
+	if _, ok := group.Roles[publicKey]; ok {
+		return fmt.Errorf("user %s is already a member of group %s", publicKey, group.ID)
+	}
+	err := s.requireActiveUser(ctx, publicKey)
+	if err != nil {
+		return err
+	}
+
+	// Users who have blocked each other cannot share a group
+	err = s.checkGroupBlocks(ctx, publicKey, group.Members)
+	if err != nil {
+		return err
+	}
+
+	group.Members = append(group.Members, publicKey)
+	group.Roles[publicKey] = role
+	return s.indexGroupMember(ctx, group.ID, publicKey)
+}
+
+// removeGroupMember drops a user from a group's members, roles and index
+func (s *SmartContract) removeGroupMember(ctx contractapi.TransactionContextInterface, group *Group, publicKey string) error {
+	remaining := []string{}
+	for _, member := range group.Members {
+		if member != publicKey {
+			remaining = append(remaining, member)
+		}
+	}
+	group.Members = remaining
+	delete(group.Roles, publicKey)
+	return s.unindexGroupMember(ctx, group.ID, publicKey)
+}
+
+// groupRole returns a member's role, failing if the user is not in the group
+func groupRole(group *Group, publicKey string) (string, error) {
+	role, ok := group.Roles[publicKey]
+	if !ok {
+		return "", fmt.Errorf("user %s is not a member of group %s", publicKey, group.ID)
+	}
+	return role, nil
+}
+
+// requireGroupRole fails unless publicKey holds at least minimumRole in the group
+func requireGroupRole(group *Group, publicKey string, minimumRole string) error {
+	role, err := groupRole(group, publicKey)
+	if err != nil {
+		return err
+	}
+	if groupRoleRank[role] < groupRoleRank[minimumRole] {
+		return fmt.Errorf("only a group %s can do this in group %s", minimumRole, group.ID)
+	}
+	return nil
+}
+
+// RemoveMember removes a user from a group, signed by an admin or the owner.
+// Admins can only remove plain members; the owner cannot be removed.
+func (s *SmartContract) RemoveMember(ctx contractapi.TransactionContextInterface, id string, actorPublicKey string, memberPublicKey string, nonce string, signature string) error {
+	err := s.verifyUserSignature(ctx, actorPublicKey, "RemoveMember", []string{id, actorPublicKey, memberPublicKey}, nonce, signature)
+	if err != nil {
+		return err
+	}
+
+	group, err := s.ReadGroup(ctx, id)
+	if err != nil {
+		return err
+	}
+	err = requireGroupRole(group, actorPublicKey, groupRoleAdmin)
+	if err != nil {
+		return err
+	}
+	memberRole, err := groupRole(group, memberPublicKey)
+	if err != nil {
+		return err
+	}
+	if groupRoleRank[memberRole] >= groupRoleRank[group.Roles[actorPublicKey]] {
+		return fmt.Errorf("a group %s cannot remove a group %s", group.Roles[actorPublicKey], memberRole)
+	}
+
+	err = s.removeGroupMember(ctx, group, memberPublicKey)
+	if err != nil {
+		return err
+	}
+	err = s.saveGroup(ctx, group)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("User %s removed %s from group %s", actorPublicKey, memberPublicKey, id)
+	return emitEvent(ctx, eventMemberRemoved, GroupEvent{GroupID: id, GroupName: group.GroupName, Members: []string{memberPublicKey}, Actor: actorPublicKey})
+}
+
+// LeaveGroup removes the signer from a group. When the owner leaves, ownership
+// passes to the longest-standing admin, or failing that the longest-standing
+// member; a group left with no members is deleted.
+func (s *SmartContract) LeaveGroup(ctx contractapi.TransactionContextInterface, id string, publicKey string, nonce string, signature string) error {
+	err := s.verifyUserSignature(ctx, publicKey, "LeaveGroup", []string{id, publicKey}, nonce, signature)
+	if err != nil {
+		return err
+	}
+
+	group, err := s.ReadGroup(ctx, id)
+	if err != nil {
+		return err
+	}
+	deleted, err := s.leaveGroup(ctx, group, publicKey)
+	if err != nil || deleted {
+		return err
+	}
+
+	log.Printf("User %s left group %s", publicKey, id)
+	return emitEvent(ctx, eventMemberRemoved, GroupEvent{GroupID: id, GroupName: group.GroupName, Members: []string{publicKey}, Actor: publicKey})
+}
+
+// leaveGroup removes a member from a group, handing ownership on if the owner
+// leaves, and deletes the group if no members are left. It reports whether the
+// group was deleted.
+func (s *SmartContract) leaveGroup(ctx contractapi.TransactionContextInterface, group *Group, publicKey string) (bool, error) {
+	role, err := groupRole(group, publicKey)
+	if err != nil {
+		return false, err
+	}
+
+	err = s.removeGroupMember(ctx, group, publicKey)
+	if err != nil {
+		return false, err
+	}
+	if len(group.Members) == 0 {
+		return true, s.deleteGroup(ctx, group, publicKey)
+	}
+
+	if role == groupRoleOwner {
+		successor := group.Members[0]
+		for _, member := range group.Members {
+			if group.Roles[member] == groupRoleAdmin {
+				successor = member
+				break
+			}
+		}
+		group.Roles[successor] = groupRoleOwner
+		group.Owner = successor
+	}
+	return false, s.saveGroup(ctx, group)
+}
+
+// PromoteMember changes a member's role, signed by the owner. Giving another
+// member the owner role transfers ownership, and the previous owner becomes an admin.
+func (s *SmartContract) PromoteMember(ctx contractapi.TransactionContextInterface, id string, ownerPublicKey string, memberPublicKey string, role string, nonce string, signature string) error {
+	err := s.verifyUserSignature(ctx, ownerPublicKey, "PromoteMember", []string{id, ownerPublicKey, memberPublicKey, role}, nonce, signature)
+	if err != nil {
+		return err
+	}
+	if _, ok := groupRoleRank[role]; !ok {
+		return fmt.Errorf("invalid group role %q", role)
+	}
+
+	group, err := s.ReadGroup(ctx, id)
+	if err != nil {
+		return err
+	}
+	err = requireGroupRole(group, ownerPublicKey, groupRoleOwner)
+	if err != nil {
+		return err
+	}
+	_, err = groupRole(group, memberPublicKey)
+	if err != nil {
+		return err
+	}
+	if memberPublicKey == ownerPublicKey {
+		return fmt.Errorf("the owner's role can only change by transferring ownership")
+	}
+
+	if role == groupRoleOwner {
+		group.Roles[ownerPublicKey] = groupRoleAdmin
+		group.Owner = memberPublicKey
+	}
+	group.Roles[memberPublicKey] = role
+
+	err = s.saveGroup(ctx, group)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("User %s made %s a %s of group %s", ownerPublicKey, memberPublicKey, role, id)
+	return emitEvent(ctx, eventMemberRoleChanged, GroupEvent{GroupID: id, GroupName: group.GroupName, Members: []string{memberPublicKey}, Actor: ownerPublicKey, Role: role})
+}
+
+// RenameGroup changes a group's name, signed by an admin or the owner
+func (s *SmartContract) RenameGroup(ctx contractapi.TransactionContextInterface, id string, publicKey string, groupname string, nonce string, signature string) error {
+	err := s.verifyUserSignature(ctx, publicKey, "RenameGroup", []string{id, publicKey, groupname}, nonce, signature)
+	if err != nil {
+		return err
+	}
+	if groupname == "" {
+		return fmt.Errorf("group name is required")
+	}
+
+	group, err := s.ReadGroup(ctx, id)
+	if err != nil {
+		return err
+	}
+	err = requireGroupRole(group, publicKey, groupRoleAdmin)
+	if err != nil {
+		return err
+	}
+
+	group.GroupName = groupname
+	err = s.saveGroup(ctx, group)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, eventGroupRenamed, GroupEvent{GroupID: id, GroupName: groupname, Actor: publicKey})
+}
+
+// DeleteGroup deletes a group, signed by its owner
+func (s *SmartContract) DeleteGroup(ctx contractapi.TransactionContextInterface, id string, publicKey string, nonce string, signature string) error {
+	err := s.verifyUserSignature(ctx, publicKey, "DeleteGroup", []string{id, publicKey}, nonce, signature)
+	if err != nil {
+		return err
+	}
+
+	group, err := s.ReadGroup(ctx, id)
+	if err != nil {
+		return err
+	}
+	err = requireGroupRole(group, publicKey, groupRoleOwner)
+	if err != nil {
+		return err
+	}
+
+	return s.deleteGroup(ctx, group, publicKey)
+}
+
+// deleteGroup removes a group, its member index entries and its chat header.
+// The messages are left in place rather than deleted in one transaction: group
+// IDs are transaction IDs and never reused, so without the header nothing
+// reads them again.
+func (s *SmartContract) deleteGroup(ctx contractapi.TransactionContextInterface, group *Group, actorPublicKey string) error {
+	for _, member := range group.Members {
+		err := s.unindexGroupMember(ctx, group.ID, member)
+		if err != nil {
+			return err
+		}
+	}
+
+	groupKey, err := entityKey(ctx, groupObjectType, group.ID)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().DelState(groupKey)
+	if err != nil {
+		return fmt.Errorf("failed to delete group %s: %v", group.ID, err)
+	}
+	chatKey, err := entityKey(ctx, groupChatObjectType, group.ID)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().DelState(chatKey)
+	if err != nil {
+		return fmt.Errorf("failed to delete chat of group %s: %v", group.ID, err)
+	}
+
+	log.Printf("Group %s deleted", group.ID)
+	return emitEvent(ctx, eventGroupDeleted, GroupEvent{GroupID: group.ID, GroupName: group.GroupName, Members: group.Members, Actor: actorPublicKey})
+}
+
+// GetGroupsByMember returns the groups a user belongs to
+func (s *SmartContract) GetGroupsByMember(ctx contractapi.TransactionContextInterface, publicKey string) ([]*Group, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(groupMemberObjectType, []string{publicKey})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get groups of %s: %v", publicKey, err)
+	}
+	defer resultsIterator.Close()
+
+	groups := []*Group{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate groups: %v", err)
+		}
+
+		// groupmember~publicKey~groupID
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to split composite key: %v", err)
+		}
+		if len(compositeKeyParts) != 2 {
+			continue
+		}
+
+		group, err := s.ReadGroup(ctx, compositeKeyParts[1])
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+
+	return groups, nil
+}
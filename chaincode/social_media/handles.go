@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Length limits of a user handle
+const (
+	minHandleLength = 3
+	maxHandleLength = 32
+)
+
+// normalizeHandle returns the canonical form of a handle that uniqueness is
+// enforced on. Handles are case-insensitive and may only contain letters,
+// digits, '.', '_' and '-', which also keeps them safe to use in file names.
+func normalizeHandle(handle string) (string, error) {
+	normalized := strings.ToLower(strings.TrimSpace(handle))
+	if len(normalized) < minHandleLength || len(normalized) > maxHandleLength {
+		return "", fmt.Errorf("handle must be between %d and %d characters", minHandleLength, maxHandleLength)
+	}
+	for _, c := range normalized {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '.' && c != '_' && c != '-' {
+			return "", fmt.Errorf("handle %q may only contain letters, digits, '.', '_' and '-'", handle)
+		}
+	}
+	return normalized, nil
+}
+
+// reserveHandle claims a normalized handle for publicKey, failing if another user holds it
+func (s *SmartContract) reserveHandle(ctx contractapi.TransactionContextInterface, handle string, publicKey string) error {
+	handleKey, err := entityKey(ctx, handleObjectType, handle)
+	if err != nil {
+		return err
+	}
+	owner, err := ctx.GetStub().GetState(handleKey)
+	if err != nil {
+		return fmt.Errorf("failed to read handle %s: %v", handle, err)
+	}
+	if owner != nil && string(owner) != publicKey {
+		return fmt.Errorf("handle %s is already taken", handle)
+	}
+
+	err = ctx.GetStub().PutState(handleKey, []byte(publicKey))
+	if err != nil {
+		return fmt.Errorf("failed to reserve handle %s: %v", handle, err)
+	}
+	return nil
+}
+
+// handleOwner returns the public key holding a normalized handle, or "" if it is free
+func handleOwner(ctx contractapi.TransactionContextInterface, handle string) (string, error) {
+	handleKey, err := entityKey(ctx, handleObjectType, handle)
+	if err != nil {
+		return "", err
+	}
+	owner, err := ctx.GetStub().GetState(handleKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to read handle %s: %v", handle, err)
+	}
+	return string(owner), nil
+}
+
+// releaseHandle frees a handle so another user can claim it
+func (s *SmartContract) releaseHandle(ctx contractapi.TransactionContextInterface, handle string) error {
+	handleKey, err := entityKey(ctx, handleObjectType, handle)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().DelState(handleKey)
+	if err != nil {
+		return fmt.Errorf("failed to release handle %s: %v", handle, err)
+	}
+	return nil
+}
+
+// ResolveHandle returns the public key of the user holding a handle. The
+// handle of a deactivated account is released, see DeactivateUser.
+func (s *SmartContract) ResolveHandle(ctx contractapi.TransactionContextInterface, handle string) (string, error) {
+	normalized, err := normalizeHandle(handle)
+	if err != nil {
+		return "", err
+	}
+
+	handleKey, err := entityKey(ctx, handleObjectType, normalized)
+	if err != nil {
+		return "", err
+	}
+	publicKey, err := ctx.GetStub().GetState(handleKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to read handle %s: %v", normalized, err)
+	}
+	if publicKey == nil {
+		return "", fmt.Errorf("user with name %s not found", handle)
+	}
+	deactivated, err := s.isDeactivated(ctx, string(publicKey))
+	if err != nil {
+		return "", err
+	}
+	if deactivated {
+		return "", fmt.Errorf("user with name %s not found", handle)
+	}
+
+	return string(publicKey), nil
+}
+
+// ChangeHandle moves a user to a new handle. The old handle is released in the
+// same transaction, so it becomes available to others only once the change commits.
+func (s *SmartContract) ChangeHandle(ctx contractapi.TransactionContextInterface, publicKey string, newHandle string, nonce string, signature string) (*User, error) {
+	err := s.verifyUserSignature(ctx, publicKey, "ChangeHandle", []string{publicKey, newHandle}, nonce, signature)
+	if err != nil {
+		return nil, err
+	}
+
+	normalized, err := normalizeHandle(newHandle)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.GetUser(ctx, publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// A change that only alters capitalisation keeps the same index entry
+	if user.Handle != normalized {
+		err = s.reserveHandle(ctx, normalized, publicKey)
+		if err != nil {
+			return nil, err
+		}
+		// Users migrated from the legacy keyspace with a clashing name have no handle to release
+		if user.Handle != "" {
+			err = s.releaseHandle(ctx, user.Handle)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	user.Name = strings.TrimSpace(newHandle)
+	user.Handle = normalized
+
+	userJSON, err := json.Marshal(user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal user data: %v", err)
+	}
+	userKey, err := entityKey(ctx, userObjectType, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	err = ctx.GetStub().PutState(userKey, userJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store user data on ledger: %v", err)
+	}
+
+	err = emitEvent(ctx, eventHandleChanged, UserEvent{PublicKey: publicKey, Name: user.Name, Handle: user.Handle})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("User %s changed handle to %s", publicKey, normalized)
+	return user, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// adminAttribute is the certificate attribute, set to "true" at enrollment,
+// that lets a client change platform-wide settings and run migrations. The
+// attributes are checked on the submitting client's own certificate, so
+// moderators and admins use their own Fabric clients; the backend's shared
+// gateway identity is not enrolled with either.
+const adminAttribute = "decentrum.admin"
+
+// moderatorAttribute is the certificate attribute, set to "true" at enrollment,
+// that lets a client review reports and hide posts or suspend users
+const moderatorAttribute = "decentrum.moderator"
+
+// requireClientAttribute checks that the submitting client's certificate carries
+// attribute with the value "true"
+func requireClientAttribute(ctx contractapi.TransactionContextInterface, attribute string) error {
+	err := ctx.GetClientIdentity().AssertAttributeValue(attribute, "true")
+	if err != nil {
+		return fmt.Errorf("client is not authorized: %v", err)
+	}
+	return nil
+}
+
+// clientID returns the identity of the submitting client, as recorded in the moderation log
+func clientID(ctx contractapi.TransactionContextInterface) (string, error) {
+	id, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return "", fmt.Errorf("failed to get client identity: %v", err)
+	}
+	return id, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Object types of the composite keys every ledger record is stored under.
+// Each entity lives in its own namespace so range queries over one kind of
+// record never see another.
+const (
+	userObjectType              = "user"              // user~publicKey -> User
+	phoneObjectType             = "phone"             // phone~publicKey -> phone number, in userPrivateCollection
+	handleObjectType            = "handle"            // handle~normalizedHandle -> publicKey
+	postObjectType              = "post"              // post~contentCID -> Post
+	postIDObjectType            = "postid"            // postid~postID -> contentCID
+	userPostsObjectType         = "posts"             // posts~publicKey -> []contentCID
+	allPostsObjectType          = "allposts"          // allposts~contentCID -> author publicKey
+	userPostObjectType          = "userpost"          // userpost~publicKey~reverseTimestamp~contentCID -> index marker
+	hashtagPostObjectType       = "hashtagpost"       // hashtagpost~tag~reverseTimestamp~contentCID -> index marker
+	hashtagDayObjectType        = "hashtagday"        // hashtagday~day~tag~contentCID -> index marker
+	hashtagFollowObjectType     = "hashtagfollow"     // hashtagfollow~publicKey~tag -> index marker
+	mentionObjectType           = "mention"           // mention~publicKey~reverseTimestamp~contentCID -> index marker
+	reactionObjectType          = "reaction"          // reaction~postID~publicKey -> reaction type
+	reactionCountObjectType     = "reactioncount"     // reactioncount~postID~reactionType~shard -> count, as a decimal
+	shareObjectType             = "share"             // share~originalCID~timestamp~shareCID -> Share
+	commentObjectType           = "comment"           // comment~postID~commentID -> Comment
+	commentThreadObjectType     = "commentthread"     // commentthread~postID~parentID~timestamp~commentID -> index marker
+	groupObjectType             = "group"             // group~groupID -> Group
+	groupMemberObjectType       = "groupmember"       // groupmember~publicKey~groupID -> index marker
+	groupChatObjectType         = "groupchat"         // groupchat~groupID -> GroupChat header
+	groupChatMessageObjectType  = "groupchatmessage"  // groupchatmessage~groupID~seq -> GroupMessage
+	chatObjectType              = "chat"              // chat~chatID -> Chat header, in chatPrivateCollection
+	chatMessageObjectType       = "chatmessage"       // chatmessage~chatID~seq -> Message, in chatPrivateCollection
+	friendsObjectType           = "friends"           // friends~publicKey -> FriendsList
+	friendRequestObjectType     = "friendrequest"     // friendrequest~sender~receiver -> FriendRequest
+	userFriendRequestObjectType = "userfriendrequest" // userfriendrequest~publicKey~sender~receiver -> index marker
+	blockObjectType             = "block"             // block~blocker~blocked -> index marker
+	chatBlockObjectType         = "chatblock"         // chatblock~blocker~blocked -> index marker, in chatPrivateCollection
+	followObjectType            = "follow"            // follow~follower~followed -> Follow
+	followerObjectType          = "follower"          // follower~followed~follower -> index marker
+	reportObjectType            = "report"            // report~targetType~targetID~reporter -> Report
+	moderationLogObjectType     = "modlog"            // modlog~timestamp~txID -> ModerationAction
+	keyForwardObjectType        = "keyforward"        // keyforward~oldPublicKey -> current publicKey
+	keyHistoryObjectType        = "keyhistory"        // keyhistory~publicKey -> []retired publicKey, in chatPrivateCollection
+	keyRotationObjectType       = "keyrotation"       // keyrotation~newPublicKey -> KeyRotation
+	erasureObjectType           = "erasure"           // erasure~publicKey -> Erasure
+	deactivatedObjectType       = "deactivated"       // deactivated~publicKey -> User, in userPrivateCollection
+	postListingObjectType       = "postlisting"       // postlisting~publicKey -> PostListing
+	nonceObjectType             = "nonce"             // nonce~publicKey~nonce -> used marker, in chatPrivateCollection for AddMessage
+	migrationObjectType         = "migration"         // migration~name -> MigrationStatus
+	configObjectType            = "config"            // config~name -> setting, as JSON
+	counterObjectType           = "counter"           // counter~targetID~name~shard -> count, as a decimal
+)
+
+// entityKey builds the composite key an entity of objectType is stored under
+func entityKey(ctx contractapi.TransactionContextInterface, objectType string, attributes ...string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s key: %v", objectType, err)
+	}
+	return key, nil
+}
+
+// indexMarker is the value stored under index keys, whose content is all in the key.
+// PutState treats an empty value as a delete, so it cannot be empty.
+var indexMarker = []byte{0}
+
+// reverseTimestamp encodes a unix timestamp so that ascending key order lists
+// the newest entries first
+func reverseTimestamp(timestamp int64) string {
+	return fmt.Sprintf("%019d", math.MaxInt64-timestamp)
+}
@@ -0,0 +1,726 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Names of the progress records of the resumable migrations
+const (
+	keyspaceMigration       = "keyspace"
+	groupMembersMigration   = "groupmembers"
+	chatMessagesMigration   = "chatmessages"
+	phoneNumbersMigration   = "phonenumbers"
+	postIDsMigration        = "postids"
+	friendRequestsMigration = "friendrequests"
+	reactionCountsMigration = "reactioncounts"
+	chatBlocksMigration     = "chatblocks"
+	keyHistoriesMigration   = "keyhistories"
+)
+
+// defaultMigrationBatchSize is used when a migration is called without a batch size
+const defaultMigrationBatchSize = 100
+
+// legacyFriendsPrefix is the prefix friends lists used to be stored under
+const legacyFriendsPrefix = "friends_"
+
+// MigrationStatus records how far a resumable migration has progressed
+type MigrationStatus struct {
+	LastKey  string `json:"lastKey"`
+	Migrated int    `json:"migrated"`
+	Skipped  int    `json:"skipped"`
+	Done     bool   `json:"done"`
+}
+
+// loadMigrationStatus reads the progress record of the migration called name.
+// A migration that has not run yet starts from an empty status.
+func loadMigrationStatus(ctx contractapi.TransactionContextInterface, name string) (*MigrationStatus, error) {
+	statusKey, err := entityKey(ctx, migrationObjectType, name)
+	if err != nil {
+		return nil, err
+	}
+	statusJSON, err := ctx.GetStub().GetState(statusKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migration status: %v", err)
+	}
+
+	status := &MigrationStatus{}
+	if statusJSON != nil {
+		err = json.Unmarshal(statusJSON, status)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal migration status: %v", err)
+		}
+	}
+	return status, nil
+}
+
+// saveMigrationStatus stores the progress record of the migration called name
+func saveMigrationStatus(ctx contractapi.TransactionContextInterface, name string, status *MigrationStatus) error {
+	statusKey, err := entityKey(ctx, migrationObjectType, name)
+	if err != nil {
+		return err
+	}
+	statusJSON, err := json.Marshal(status)
+	if err != nil {
+		return fmt.Errorf("failed to marshal migration status: %v", err)
+	}
+	err = ctx.GetStub().PutState(statusKey, statusJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store migration status: %v", err)
+	}
+	return nil
+}
+
+// compositeMigrations maps the migrations run with migrateCompositeKeys to the
+// object type of the records they go through
+var compositeMigrations = map[string]string{
+	groupMembersMigration:   groupObjectType,
+	chatMessagesMigration:   chatObjectType,
+	phoneNumbersMigration:   userObjectType,
+	postIDsMigration:        postObjectType,
+	friendRequestsMigration: friendRequestObjectType,
+	reactionCountsMigration: reactionObjectType,
+	chatBlocksMigration:     blockObjectType,
+	keyHistoriesMigration:   userObjectType,
+}
+
+// NextMigrationBatch returns the keys of the next batchSize records the
+// migration called name has to process, starting after the last key it
+// processed. Paginated queries are not allowed in transactions that write, and
+// a composite-key range can only be resumed through a bookmark, so a migration
+// that goes through composite keys runs in two steps: this query reads the
+// next batch, and the migration is then submitted with the keys it returned.
+// An empty batch means every record has been read; submitting it marks the
+// migration done.
+func (s *SmartContract) NextMigrationBatch(ctx contractapi.TransactionContextInterface, name string, batchSize int32) ([]string, error) {
+	objectType, ok := compositeMigrations[name]
+	if !ok {
+		return nil, fmt.Errorf("migration %s does not go through composite keys", name)
+	}
+	batchSize = normalizePageSize(batchSize)
+
+	status, err := loadMigrationStatus(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if status.Done {
+		return []string{}, nil
+	}
+
+	// A bookmark is the key a page starts from, so the last key processed is
+	// read again and passed over
+	resultsIterator, _, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(objectType, []string{}, batchSize+1, status.LastKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s records: %v", objectType, err)
+	}
+	defer resultsIterator.Close()
+
+	keys := []string{}
+	for int32(len(keys)) < batchSize && resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate %s records: %v", objectType, err)
+		}
+		if queryResponse.Key == status.LastKey {
+			continue
+		}
+		keys = append(keys, queryResponse.Key)
+	}
+	return keys, nil
+}
+
+// migrateCompositeKeys runs migrate over the records of objectType named by
+// keys, a batch read with NextMigrationBatch, and records the last key
+// processed by the migration called name so the next batch starts after it.
+// The keys must be in order and follow that last key. Records removed since
+// the batch was read are skipped, and an empty batch marks the migration done.
+// migrate reports whether it changed the record. Migrations may only be run by
+// clients enrolled as admins.
+func migrateCompositeKeys(ctx contractapi.TransactionContextInterface, name string, objectType string, keys []string, migrate func(key string, value []byte) (bool, error)) (*MigrationStatus, error) {
+	err := requireClientAttribute(ctx, adminAttribute)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := loadMigrationStatus(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if status.Done {
+		return status, nil
+	}
+
+	for _, key := range keys {
+		if key <= status.LastKey {
+			return nil, fmt.Errorf("key %q does not follow the last key processed by migration %s; read the batch with NextMigrationBatch", key, name)
+		}
+		keyType, _, err := ctx.GetStub().SplitCompositeKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to split composite key: %v", err)
+		}
+		if keyType != objectType {
+			return nil, fmt.Errorf("key %q is not a %s record", key, objectType)
+		}
+
+		value, err := ctx.GetStub().GetState(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s record: %v", objectType, err)
+		}
+		changed := false
+		if value != nil {
+			changed, err = migrate(key, value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if changed {
+			status.Migrated++
+		} else {
+			status.Skipped++
+		}
+		status.LastKey = key
+	}
+	status.Done = len(keys) == 0
+
+	err = saveMigrationStatus(ctx, name, status)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Migration %s processed %d records (migrated %d, skipped %d, done %t)", name, len(keys), status.Migrated, status.Skipped, status.Done)
+	return status, nil
+}
+
+// MigrateKeyspace moves records from the old flat keyspace, where users, posts,
+// groups, chats and friends lists were stored under plain keys, into their typed
+// composite-key namespaces. At most batchSize legacy keys are processed per call;
+// progress is kept on the ledger so the migration can be resumed by calling it
+// again until the returned status reports Done. Only admins can run it.
+func (s *SmartContract) MigrateKeyspace(ctx contractapi.TransactionContextInterface, batchSize int) (*MigrationStatus, error) {
+	err := requireClientAttribute(ctx, adminAttribute)
+	if err != nil {
+		return nil, err
+	}
+	if batchSize <= 0 {
+		batchSize = defaultMigrationBatchSize
+	}
+
+	status, err := loadMigrationStatus(ctx, keyspaceMigration)
+	if err != nil {
+		return nil, err
+	}
+	if status.Done {
+		return status, nil
+	}
+
+	// Plain range queries never return composite keys, so this only sees legacy records.
+	// Resume from the last key processed; it is skipped below if it is still present.
+	resultsIterator, err := ctx.GetStub().GetStateByRange(status.LastKey, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get legacy keys: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	processed := 0
+	for processed < batchSize && resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate legacy keys: %v", err)
+		}
+		if queryResponse.Key == status.LastKey {
+			continue
+		}
+
+		migrated, err := s.migrateLegacyKey(ctx, queryResponse.Key, queryResponse.Value)
+		if err != nil {
+			return nil, err
+		}
+		if migrated {
+			status.Migrated++
+		} else {
+			status.Skipped++
+		}
+		status.LastKey = queryResponse.Key
+		processed++
+	}
+	status.Done = !resultsIterator.HasNext()
+
+	err = saveMigrationStatus(ctx, keyspaceMigration, status)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Keyspace migration processed %d keys (migrated %d, skipped %d, done %t)", processed, status.Migrated, status.Skipped, status.Done)
+	return status, nil
+}
+
+// migrateLegacyKey rewrites a single legacy record under its typed key and
+// deletes the original. Records it cannot classify are left untouched.
+func (s *SmartContract) migrateLegacyKey(ctx contractapi.TransactionContextInterface, key string, value []byte) (bool, error) {
+	objectType, id := classifyLegacyRecord(key, value)
+	if objectType == "" {
+		log.Printf("Skipping unrecognised legacy key %q", key)
+		return false, nil
+	}
+
+	// Records of some types predate indexes that were added later
+	var err error
+	switch objectType {
+	case userObjectType:
+		value, err = s.migrateLegacyUser(ctx, value)
+	case postObjectType:
+		value, err = s.migrateLegacyPost(ctx, value)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	newKey, err := entityKey(ctx, objectType, id)
+	if err != nil {
+		return false, err
+	}
+	err = ctx.GetStub().PutState(newKey, value)
+	if err != nil {
+		return false, fmt.Errorf("failed to store migrated %s %s: %v", objectType, id, err)
+	}
+	err = ctx.GetStub().DelState(key)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete legacy key %s: %v", key, err)
+	}
+
+	return true, nil
+}
+
+// migrateLegacyPost adds a legacy post to the per-author index and moves the
+// reactions stored inside it to their own keys
+func (s *SmartContract) migrateLegacyPost(ctx contractapi.TransactionContextInterface, value []byte) ([]byte, error) {
+	var post Post
+	err := json.Unmarshal(value, &post)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal legacy post: %v", err)
+	}
+
+	err = s.indexUserPost(ctx, &post)
+	if err != nil {
+		return nil, err
+	}
+
+	for publicKey, reactionType := range post.Reactions {
+		reactionKey, err := entityKey(ctx, reactionObjectType, post.ContentCID, publicKey)
+		if err != nil {
+			return nil, err
+		}
+		err = ctx.GetStub().PutState(reactionKey, []byte(reactionType))
+		if err != nil {
+			return nil, fmt.Errorf("failed to store reaction for post %s: %v", post.ContentCID, err)
+		}
+	}
+	post.Reactions = make(map[string]string)
+	post.ReactionCount = 0
+
+	postJSON, err := json.Marshal(post)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal post: %v", err)
+	}
+	return postJSON, nil
+}
+
+// migrateLegacyUser reserves the handle derived from a legacy user's name. Names
+// that are not valid handles, or that clash with a user migrated earlier, are
+// left without one; those users can claim a handle with ChangeHandle.
+func (s *SmartContract) migrateLegacyUser(ctx contractapi.TransactionContextInterface, value []byte) ([]byte, error) {
+	var user User
+	err := json.Unmarshal(value, &user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal legacy user: %v", err)
+	}
+
+	handle, err := normalizeHandle(user.Name)
+	if err != nil {
+		log.Printf("Legacy user %s has no valid handle: %v", user.PublicKey, err)
+		return value, nil
+	}
+	err = s.reserveHandle(ctx, handle, user.PublicKey)
+	if err != nil {
+		log.Printf("Legacy user %s left without a handle: %v", user.PublicKey, err)
+		return value, nil
+	}
+
+	user.Handle = handle
+	userJSON, err := json.Marshal(user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal user data: %v", err)
+	}
+	return userJSON, nil
+}
+
+// convertNamedGroup rewrites a group whose members were recorded by name to
+// record them by public key with roles, and indexes it under each member. The
+// first member, who created the group, becomes its owner. Names that no longer
+// resolve to a user are dropped. Groups that already have roles are left as they are.
+func (s *SmartContract) convertNamedGroup(ctx contractapi.TransactionContextInterface, group *Group) (bool, error) {
+	if group.Roles != nil {
+		return false, nil
+	}
+
+	names := group.Members
+	group.Members = []string{}
+	group.Roles = map[string]string{}
+	for _, name := range names {
+		publicKey, err := s.ResolveHandle(ctx, name)
+		if err != nil {
+			log.Printf("Dropping member %q of group %s: %v", name, group.ID, err)
+			continue
+		}
+		if _, ok := group.Roles[publicKey]; ok {
+			continue
+		}
+
+		role := groupRoleMember
+		if len(group.Members) == 0 {
+			role = groupRoleOwner
+			group.Owner = publicKey
+		}
+		group.Members = append(group.Members, publicKey)
+		group.Roles[publicKey] = role
+
+		err = s.indexGroupMember(ctx, group.ID, publicKey)
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// MigrateGroupMembers converts the groups stored with member names to members
+// keyed by public key, one batch of groups per call, read with
+// NextMigrationBatch. Names are resolved through the handle index, so it should
+// run once MigrateKeyspace is done. It is resumable and safe to call more than
+// once.
+func (s *SmartContract) MigrateGroupMembers(ctx contractapi.TransactionContextInterface, keys []string) (*MigrationStatus, error) {
+	return migrateCompositeKeys(ctx, groupMembersMigration, groupObjectType, keys, func(key string, value []byte) (bool, error) {
+		var group Group
+		err := json.Unmarshal(value, &group)
+		if err != nil {
+			return false, fmt.Errorf("failed to unmarshal group: %v", err)
+		}
+
+		changed, err := s.convertNamedGroup(ctx, &group)
+		if err != nil || !changed {
+			return false, err
+		}
+		err = s.saveGroup(ctx, &group)
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	})
+}
+
+// legacyChat is a chat as stored before messages had their own keys
+type legacyChat struct {
+	Participants [2]string `json:"participants"`
+	Messages     []Message `json:"messages"`
+}
+
+// MigrateChatMessages moves the chats kept in public state into the chat
+// collection, one batch of chats per call, read with NextMigrationBatch. Chats
+// stored as a single value holding every message are split into a chat header
+// and one record per message, numbered in the order they were stored. It should
+// run once MigrateKeyspace is done, is resumable and is safe to call more than
+// once. The moved records remain in the ledger's history.
+func (s *SmartContract) MigrateChatMessages(ctx contractapi.TransactionContextInterface, keys []string) (*MigrationStatus, error) {
+	return migrateCompositeKeys(ctx, chatMessagesMigration, chatObjectType, keys, func(key string, value []byte) (bool, error) {
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(key)
+		if err != nil {
+			return false, fmt.Errorf("failed to split composite key: %v", err)
+		}
+		if len(compositeKeyParts) != 1 {
+			return false, nil
+		}
+		chatID := compositeKeyParts[0]
+
+		var legacy legacyChat
+		err = json.Unmarshal(value, &legacy)
+		if err != nil {
+			return false, fmt.Errorf("failed to unmarshal chat %s: %v", chatID, err)
+		}
+
+		chat := &Chat{ChatID: chatID, Participants: legacy.Participants}
+		if legacy.Messages != nil {
+			for i := range legacy.Messages {
+				chat.LastSeq++
+				legacy.Messages[i].Seq = chat.LastSeq
+				err = s.saveChatMessage(ctx, chatID, &legacy.Messages[i])
+				if err != nil {
+					return false, err
+				}
+			}
+		} else {
+			// Split by an earlier version but still in public state
+			err = json.Unmarshal(value, chat)
+			if err != nil {
+				return false, fmt.Errorf("failed to unmarshal chat %s: %v", chatID, err)
+			}
+			err = s.movePublicChatMessages(ctx, chatID)
+			if err != nil {
+				return false, err
+			}
+		}
+
+		err = s.saveChatHeader(ctx, chat)
+		if err != nil {
+			return false, err
+		}
+		err = ctx.GetStub().DelState(key)
+		if err != nil {
+			return false, fmt.Errorf("failed to delete public chat %s: %v", chatID, err)
+		}
+		return true, nil
+	})
+}
+
+// movePublicChatMessages moves the per-message records of a chat from public
+// state into the chat collection
+func (s *SmartContract) movePublicChatMessages(ctx contractapi.TransactionContextInterface, chatID string) error {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(chatMessageObjectType, []string{chatID})
+	if err != nil {
+		return fmt.Errorf("failed to get messages of chat %s: %v", chatID, err)
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return fmt.Errorf("failed to iterate messages of chat %s: %v", chatID, err)
+		}
+		err = ctx.GetStub().PutPrivateData(chatPrivateCollection, queryResponse.Key, queryResponse.Value)
+		if err != nil {
+			return fmt.Errorf("failed to store message of chat %s: %v", chatID, err)
+		}
+		err = ctx.GetStub().DelState(queryResponse.Key)
+		if err != nil {
+			return fmt.Errorf("failed to delete public message of chat %s: %v", chatID, err)
+		}
+	}
+	return nil
+}
+
+// MigratePhoneNumbers moves the phone numbers kept on public user records into
+// the user collection, one batch of users per call, read with
+// NextMigrationBatch. It is resumable and safe to call more than once. The
+// numbers remain in the ledger's history.
+func (s *SmartContract) MigratePhoneNumbers(ctx contractapi.TransactionContextInterface, keys []string) (*MigrationStatus, error) {
+	return migrateCompositeKeys(ctx, phoneNumbersMigration, userObjectType, keys, func(key string, value []byte) (bool, error) {
+		var user User
+		err := json.Unmarshal(value, &user)
+		if err != nil {
+			return false, fmt.Errorf("failed to unmarshal user: %v", err)
+		}
+		if user.Phone == "" {
+			return false, nil
+		}
+
+		err = s.savePhone(ctx, user.PublicKey, user.Phone)
+		if err != nil {
+			return false, err
+		}
+		user.Phone = ""
+		userJSON, err := json.Marshal(user)
+		if err != nil {
+			return false, fmt.Errorf("failed to marshal user: %v", err)
+		}
+		err = ctx.GetStub().PutState(key, userJSON)
+		if err != nil {
+			return false, fmt.Errorf("failed to store user %s: %v", user.PublicKey, err)
+		}
+		return true, nil
+	})
+}
+
+// IndexPostIDs adds the posts created before the post ID index existed to that
+// index, one batch of posts per call, read with NextMigrationBatch. Older posts
+// were given IDs by the backend, which could collide; when two posts share an
+// ID the first one indexed keeps it. It is resumable and safe to call more than
+// once.
+func (s *SmartContract) IndexPostIDs(ctx contractapi.TransactionContextInterface, keys []string) (*MigrationStatus, error) {
+	return migrateCompositeKeys(ctx, postIDsMigration, postObjectType, keys, func(key string, value []byte) (bool, error) {
+		var post Post
+		err := json.Unmarshal(value, &post)
+		if err != nil {
+			return false, fmt.Errorf("failed to unmarshal post: %v", err)
+		}
+		if post.ID == "" {
+			return false, nil
+		}
+
+		indexKey, err := entityKey(ctx, postIDObjectType, post.ID)
+		if err != nil {
+			return false, err
+		}
+		existing, err := ctx.GetStub().GetState(indexKey)
+		if err != nil {
+			return false, fmt.Errorf("failed to read post index: %v", err)
+		}
+		if existing != nil {
+			if string(existing) != post.ContentCID {
+				log.Printf("Post ID %s of %s is already used by %s", post.ID, post.ContentCID, existing)
+			}
+			return false, nil
+		}
+
+		err = s.indexPostID(ctx, &post)
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	})
+}
+
+// classifyLegacyRecord works out which entity a legacy record holds from its key
+// and the fields of its JSON value, and returns the object type and ID it should
+// be stored under
+func classifyLegacyRecord(key string, value []byte) (string, string) {
+	if strings.HasPrefix(key, legacyFriendsPrefix) {
+		return friendsObjectType, strings.TrimPrefix(key, legacyFriendsPrefix)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(value, &fields); err != nil {
+		return "", ""
+	}
+	has := func(names ...string) bool {
+		for _, name := range names {
+			if _, ok := fields[name]; !ok {
+				return false
+			}
+		}
+		return true
+	}
+
+	switch {
+	case has("contentCID", "userPublicKey"):
+		return postObjectType, key
+	case has("participants", "messages"):
+		return chatObjectType, key
+	case has("groupname", "members"):
+		return groupObjectType, key
+	case has("name", "publicKey"):
+		return userObjectType, key
+	}
+	return "", ""
+}
+
+// IndexFriendRequests adds the friend requests stored before the per-user index
+// existed to that index, one batch of requests per call, read with
+// NextMigrationBatch. It is resumable and safe to call more than once.
+func (s *SmartContract) IndexFriendRequests(ctx contractapi.TransactionContextInterface, keys []string) (*MigrationStatus, error) {
+	return migrateCompositeKeys(ctx, friendRequestsMigration, friendRequestObjectType, keys, func(key string, value []byte) (bool, error) {
+		// friendrequest~sender~receiver
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(key)
+		if err != nil {
+			return false, fmt.Errorf("failed to split composite key: %v", err)
+		}
+		if len(compositeKeyParts) != 2 {
+			return false, nil
+		}
+
+		err = s.indexFriendRequest(ctx, compositeKeyParts[0], compositeKeyParts[1])
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	})
+}
+
+// CountReactions builds the per-type reaction counters from the reactions made
+// before the counters existed, one batch of reactions per call, read with
+// NextMigrationBatch. It needs the reactions moved out of legacy posts, so it
+// only runs once MigrateKeyspace is done. It is resumable; reactions changed
+// while it runs are counted once either way.
+func (s *SmartContract) CountReactions(ctx contractapi.TransactionContextInterface, keys []string) (*MigrationStatus, error) {
+	keyspace, err := loadMigrationStatus(ctx, keyspaceMigration)
+	if err != nil {
+		return nil, err
+	}
+	if !keyspace.Done {
+		return nil, fmt.Errorf("MigrateKeyspace must be done before reactions are counted")
+	}
+
+	// A transaction does not see its own writes, so the counts of the batch are
+	// added up by shard before any is written
+	type shard struct{ postID, reactionType, shard string }
+	deltas := make(map[shard]int)
+	status, err := migrateCompositeKeys(ctx, reactionCountsMigration, reactionObjectType, keys, func(key string, value []byte) (bool, error) {
+		// reaction~postID~publicKey
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(key)
+		if err != nil {
+			return false, fmt.Errorf("failed to split composite key: %v", err)
+		}
+		if len(compositeKeyParts) != 2 {
+			return false, nil
+		}
+		deltas[shard{compositeKeyParts[0], string(value), counterShard(compositeKeyParts[1])}]++
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for counter, delta := range deltas {
+		err = addToCounterShard(ctx, reactionCountObjectType, counter.postID, counter.reactionType, counter.shard, delta)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
+
+// MirrorChatBlocks copies the blocks made before AddMessage read them from the
+// chat collection into that collection, one batch of blocks per call, read with
+// NextMigrationBatch. It is resumable and safe to call more than once.
+func (s *SmartContract) MirrorChatBlocks(ctx contractapi.TransactionContextInterface, keys []string) (*MigrationStatus, error) {
+	return migrateCompositeKeys(ctx, chatBlocksMigration, blockObjectType, keys, func(key string, value []byte) (bool, error) {
+		// block~blocker~blocked
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(key)
+		if err != nil {
+			return false, fmt.Errorf("failed to split composite key: %v", err)
+		}
+		if len(compositeKeyParts) != 2 {
+			return false, nil
+		}
+
+		err = setChatBlock(ctx, compositeKeyParts[0], compositeKeyParts[1], true)
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	})
+}
+
+// MirrorKeyHistories copies the retired keys of the users who rotated their key
+// before AddMessage read them from the chat collection into that collection,
+// one batch of users per call, read with NextMigrationBatch. It is resumable
+// and safe to call more than once.
+func (s *SmartContract) MirrorKeyHistories(ctx contractapi.TransactionContextInterface, keys []string) (*MigrationStatus, error) {
+	return migrateCompositeKeys(ctx, keyHistoriesMigration, userObjectType, keys, func(key string, value []byte) (bool, error) {
+		var user User
+		err := json.Unmarshal(value, &user)
+		if err != nil {
+			return false, fmt.Errorf("failed to unmarshal user: %v", err)
+		}
+		if len(user.PreviousKeys) == 0 {
+			return false, nil
+		}
+
+		err = saveKeyHistory(ctx, user.PublicKey, user.PreviousKeys)
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	})
+}
@@ -0,0 +1,620 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Kinds of content that can be reported and moderated
+const (
+	reportTargetPost = "post"
+	reportTargetUser = "user"
+)
+
+// Actions recorded in the moderation log
+const (
+	moderationHidePost     = "hidePost"
+	moderationAutoHidePost = "autoHidePost"
+	moderationRestorePost  = "restorePost"
+	moderationSuspendUser  = "suspendUser"
+	moderationReinstate    = "reinstateUser"
+)
+
+// reportThresholdConfig names the setting holding how many reports hide a post
+const reportThresholdConfig = "reportthreshold"
+
+// defaultReportThreshold applies until an admin configures another threshold
+const defaultReportThreshold = 3
+
+// maxReportReasonLength bounds the reason given with a report or moderator action
+const maxReportReasonLength = 500
+
+// Report is a user's report of a post or another user
+type Report struct {
+	TargetType string `json:"targetType"` // "post" or "user"
+	TargetID   string `json:"targetId"`   // IPFS hash of the post, or public key of the user
+	Reporter   string `json:"reporter"`
+	Reason     string `json:"reason"`
+	Timestamp  int64  `json:"timestamp"`
+}
+
+// ReportPage is one page of the reports of a post or user
+type ReportPage struct {
+	Reports  []*Report `json:"reports"`
+	Bookmark string    `json:"bookmark"`
+}
+
+// ModerationAction is an entry of the moderation log. Entries are only ever
+// added, never changed or removed.
+type ModerationAction struct {
+	ID         string `json:"id"` // ID of the transaction that made the change
+	Action     string `json:"action"`
+	TargetType string `json:"targetType"`
+	TargetID   string `json:"targetId"`
+	Moderator  string `json:"moderator"` // Client identity of the moderator; empty for automatic actions
+	Reason     string `json:"reason"`
+	Timestamp  int64  `json:"timestamp"`
+}
+
+// ModerationLogPage is one page of the moderation log, oldest first
+type ModerationLogPage struct {
+	Actions  []*ModerationAction `json:"actions"`
+	Bookmark string              `json:"bookmark"`
+}
+
+// normalizeReason trims a report or moderation reason and checks its length
+func normalizeReason(reason string) (string, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return "", fmt.Errorf("a reason is required")
+	}
+	if len(reason) > maxReportReasonLength {
+		return "", fmt.Errorf("reason must be at most %d characters", maxReportReasonLength)
+	}
+	return reason, nil
+}
+
+// isSuspended reports whether a user has been suspended, failing if the user does not exist
+func (s *SmartContract) isSuspended(ctx contractapi.TransactionContextInterface, publicKey string) (bool, error) {
+	userKey, err := entityKey(ctx, userObjectType, publicKey)
+	if err != nil {
+		return false, err
+	}
+	userJSON, err := ctx.GetStub().GetState(userKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to read user data for public key %s: %v", publicKey, err)
+	}
+	if userJSON == nil {
+		return false, fmt.Errorf("user with public key %s does not exist", publicKey)
+	}
+	var user User
+	err = json.Unmarshal(userJSON, &user)
+	if err != nil {
+		return false, fmt.Errorf("failed to unmarshal user data: %v", err)
+	}
+	return user.Suspended, nil
+}
+
+// GetReportThreshold returns how many reports hide a post until a moderator reviews it
+func (s *SmartContract) GetReportThreshold(ctx contractapi.TransactionContextInterface) (int, error) {
+	configKey, err := entityKey(ctx, configObjectType, reportThresholdConfig)
+	if err != nil {
+		return 0, err
+	}
+	thresholdJSON, err := ctx.GetStub().GetState(configKey)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read report threshold: %v", err)
+	}
+	if thresholdJSON == nil {
+		return defaultReportThreshold, nil
+	}
+
+	var threshold int
+	err = json.Unmarshal(thresholdJSON, &threshold)
+	if err != nil {
+		return 0, fmt.Errorf("failed to unmarshal report threshold: %v", err)
+	}
+	return threshold, nil
+}
+
+// SetReportThreshold sets how many reports hide a post. Only admins may call it.
+// Posts already past the new threshold are hidden on their next report.
+func (s *SmartContract) SetReportThreshold(ctx contractapi.TransactionContextInterface, threshold int) error {
+	err := requireClientAttribute(ctx, adminAttribute)
+	if err != nil {
+		return err
+	}
+	if threshold < 1 {
+		return fmt.Errorf("report threshold must be at least 1")
+	}
+
+	thresholdJSON, err := json.Marshal(threshold)
+	if err != nil {
+		return fmt.Errorf("failed to marshal report threshold: %v", err)
+	}
+	configKey, err := entityKey(ctx, configObjectType, reportThresholdConfig)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(configKey, thresholdJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store report threshold: %v", err)
+	}
+
+	log.Printf("Report threshold set to %d", threshold)
+	return nil
+}
+
+// storeReport records a report, failing if the reporter already reported the target
+func (s *SmartContract) storeReport(ctx contractapi.TransactionContextInterface, report *Report) error {
+	reportKey, err := entityKey(ctx, reportObjectType, report.TargetType, report.TargetID, report.Reporter)
+	if err != nil {
+		return err
+	}
+	existing, err := ctx.GetStub().GetState(reportKey)
+	if err != nil {
+		return fmt.Errorf("failed to read report: %v", err)
+	}
+	if existing != nil {
+		return fmt.Errorf("%s %s has already been reported by %s", report.TargetType, report.TargetID, report.Reporter)
+	}
+
+	reportJSON, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to marshal report: %v", err)
+	}
+	err = ctx.GetStub().PutState(reportKey, reportJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store report: %v", err)
+	}
+	return nil
+}
+
+// appendModerationLog adds an entry to the moderation log
+func (s *SmartContract) appendModerationLog(ctx contractapi.TransactionContextInterface, action string, targetType string, targetID string, moderator string, reason string) error {
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	entry := ModerationAction{
+		ID:         ctx.GetStub().GetTxID(),
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Moderator:  moderator,
+		Reason:     reason,
+		Timestamp:  timestamp,
+	}
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal moderation log entry: %v", err)
+	}
+	logKey, err := entityKey(ctx, moderationLogObjectType, fmt.Sprintf("%019d", timestamp), entry.ID)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(logKey, entryJSON)
+	if err != nil {
+		return fmt.Errorf("failed to append to moderation log: %v", err)
+	}
+	return nil
+}
+
+// setPostListed adds a post to, or removes it from, the indexes that list
+// posts: all posts, its author's posts and its hashtags and mentions. The post
+// itself stays readable by its ID.
+func (s *SmartContract) setPostListed(ctx contractapi.TransactionContextInterface, post *Post, listed bool) error {
+	allPostsKey, err := entityKey(ctx, allPostsObjectType, post.ContentCID)
+	if err != nil {
+		return err
+	}
+	userPostKey, err := entityKey(ctx, userPostObjectType, post.UserPublicKey, reverseTimestamp(post.Timestamp), post.ContentCID)
+	if err != nil {
+		return err
+	}
+
+	if !listed {
+		for _, key := range []string{allPostsKey, userPostKey} {
+			err = ctx.GetStub().DelState(key)
+			if err != nil {
+				return fmt.Errorf("failed to remove post %s from index: %v", post.ContentCID, err)
+			}
+		}
+		return s.unindexPostTopics(ctx, post)
+	}
+
+	err = ctx.GetStub().PutState(allPostsKey, []byte(post.UserPublicKey))
+	if err != nil {
+		return fmt.Errorf("failed to store post in all posts: %v", err)
+	}
+	err = s.indexUserPost(ctx, post)
+	if err != nil {
+		return err
+	}
+	return s.indexPostTopics(ctx, post)
+}
+
+// listedPosts drops the posts hidden by moderators or deleted from a post list.
+// A user's post list keeps every post they made, so readers of it filter here.
+func (s *SmartContract) listedPosts(ctx contractapi.TransactionContextInterface, posts []string) ([]string, error) {
+	listed := []string{}
+	for _, ipfsHash := range posts {
+		post, err := s.getPost(ctx, ipfsHash)
+		if err != nil {
+			return nil, err
+		}
+		if post.Hidden || post.Deleted {
+			continue
+		}
+		listed = append(listed, ipfsHash)
+	}
+	return listed, nil
+}
+
+// ReportPost reports a post, signed by the reporter. Each user may report a post
+// once. A post that reaches the report threshold is hidden until a moderator
+// reviews it. Reports are counted apart from the post record, which is only
+// written when the post is hidden.
+func (s *SmartContract) ReportPost(ctx contractapi.TransactionContextInterface, postID string, reporterPublicKey string, reason string, nonce string, signature string) error {
+	err := s.verifyUserSignature(ctx, reporterPublicKey, "ReportPost", []string{postID, reporterPublicKey, reason}, nonce, signature)
+	if err != nil {
+		return err
+	}
+	reason, err = normalizeReason(reason)
+	if err != nil {
+		return err
+	}
+
+	post, err := s.getPost(ctx, postID)
+	if err != nil {
+		return err
+	}
+	if post.Deleted {
+		return fmt.Errorf("post %s has been deleted", postID)
+	}
+	if post.UserPublicKey == reporterPublicKey {
+		return fmt.Errorf("users cannot report their own posts")
+	}
+
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	err = s.storeReport(ctx, &Report{
+		TargetType: reportTargetPost,
+		TargetID:   postID,
+		Reporter:   reporterPublicKey,
+		Reason:     reason,
+		Timestamp:  timestamp,
+	})
+	if err != nil {
+		return err
+	}
+
+	threshold, err := s.GetReportThreshold(ctx)
+	if err != nil {
+		return err
+	}
+	counts, err := readCounters(ctx, counterObjectType, postID, reportCounter)
+	if err != nil {
+		return err
+	}
+	err = addToCounter(ctx, counterObjectType, postID, reportCounter, reporterPublicKey, 1)
+	if err != nil {
+		return err
+	}
+	reportCount := post.ReportCount + counts[reportCounter] + 1
+
+	autoHide := !post.Hidden && reportCount >= threshold
+	autoReason := fmt.Sprintf("reported by %d users", reportCount)
+	if autoHide {
+		post.Hidden = true
+		err = s.setPostListed(ctx, post, false)
+		if err != nil {
+			return err
+		}
+		err = s.appendModerationLog(ctx, moderationAutoHidePost, reportTargetPost, postID, "", autoReason)
+		if err != nil {
+			return err
+		}
+		err = s.savePost(ctx, post)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Post %s reported (%d reports)", postID, reportCount)
+	if autoHide {
+		return emitEvent(ctx, eventPostHidden, ModerationEvent{
+			TargetType:      reportTargetPost,
+			TargetID:        postID,
+			AuthorPublicKey: post.UserPublicKey,
+			Reason:          autoReason,
+		})
+	}
+	return emitEvent(ctx, eventContentReported, ReportEvent{TargetType: reportTargetPost, TargetID: postID, ReportCount: reportCount})
+}
+
+// ReportUser reports another user, signed by the reporter. Each user may report
+// another once; reports of users are reviewed by moderators.
+func (s *SmartContract) ReportUser(ctx contractapi.TransactionContextInterface, reportedPublicKey string, reporterPublicKey string, reason string, nonce string, signature string) error {
+	err := s.verifyUserSignature(ctx, reporterPublicKey, "ReportUser", []string{reportedPublicKey, reporterPublicKey, reason}, nonce, signature)
+	if err != nil {
+		return err
+	}
+	reason, err = normalizeReason(reason)
+	if err != nil {
+		return err
+	}
+	if reportedPublicKey == reporterPublicKey {
+		return fmt.Errorf("users cannot report themselves")
+	}
+	exists, err := s.UserExists(ctx, reportedPublicKey)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("user %s does not exist", reportedPublicKey)
+	}
+
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	err = s.storeReport(ctx, &Report{
+		TargetType: reportTargetUser,
+		TargetID:   reportedPublicKey,
+		Reporter:   reporterPublicKey,
+		Reason:     reason,
+		Timestamp:  timestamp,
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("User %s reported", reportedPublicKey)
+	return emitEvent(ctx, eventContentReported, ReportEvent{TargetType: reportTargetUser, TargetID: reportedPublicKey})
+}
+
+// GetReports returns one page of the reports of a post or user. Only moderators may call it.
+func (s *SmartContract) GetReports(ctx contractapi.TransactionContextInterface, targetType string, targetID string, pageSize int32, bookmark string) (*ReportPage, error) {
+	err := requireClientAttribute(ctx, moderatorAttribute)
+	if err != nil {
+		return nil, err
+	}
+	if targetType != reportTargetPost && targetType != reportTargetUser {
+		return nil, fmt.Errorf("report target type must be %q or %q", reportTargetPost, reportTargetUser)
+	}
+
+	pageSize = normalizePageSize(pageSize)
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(reportObjectType, []string{targetType, targetID}, pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reports: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	page := &ReportPage{Reports: []*Report{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate reports: %v", err)
+		}
+
+		var report Report
+		err = json.Unmarshal(queryResponse.Value, &report)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal report: %v", err)
+		}
+		page.Reports = append(page.Reports, &report)
+	}
+
+	page.Bookmark = nextBookmark(metadata.FetchedRecordsCount, metadata.Bookmark, pageSize)
+	return page, nil
+}
+
+// HidePost hides a post from every post listing and clears its report count.
+// Hiding a post that reports already hid records that a moderator reviewed it
+// and kept it hidden. Only moderators may call it.
+func (s *SmartContract) HidePost(ctx contractapi.TransactionContextInterface, postID string, reason string) error {
+	err := requireClientAttribute(ctx, moderatorAttribute)
+	if err != nil {
+		return err
+	}
+	reason, err = normalizeReason(reason)
+	if err != nil {
+		return err
+	}
+	moderator, err := clientID(ctx)
+	if err != nil {
+		return err
+	}
+
+	post, err := s.getPost(ctx, postID)
+	if err != nil {
+		return err
+	}
+	if post.Deleted {
+		return fmt.Errorf("post %s has been deleted", postID)
+	}
+	if !post.Hidden {
+		post.Hidden = true
+		err = s.setPostListed(ctx, post, false)
+		if err != nil {
+			return err
+		}
+	}
+	post.ReportCount = 0
+	err = clearCounter(ctx, counterObjectType, postID, reportCounter)
+	if err != nil {
+		return err
+	}
+	err = s.savePost(ctx, post)
+	if err != nil {
+		return err
+	}
+	err = s.appendModerationLog(ctx, moderationHidePost, reportTargetPost, postID, moderator, reason)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Post %s hidden by a moderator", postID)
+	return emitEvent(ctx, eventPostHidden, ModerationEvent{
+		TargetType:      reportTargetPost,
+		TargetID:        postID,
+		AuthorPublicKey: post.UserPublicKey,
+		Moderator:       moderator,
+		Reason:          reason,
+	})
+}
+
+// RestorePost lists a hidden post again after review and clears its report
+// count. Only moderators may call it.
+func (s *SmartContract) RestorePost(ctx contractapi.TransactionContextInterface, postID string, reason string) error {
+	err := requireClientAttribute(ctx, moderatorAttribute)
+	if err != nil {
+		return err
+	}
+	reason, err = normalizeReason(reason)
+	if err != nil {
+		return err
+	}
+	moderator, err := clientID(ctx)
+	if err != nil {
+		return err
+	}
+
+	post, err := s.getPost(ctx, postID)
+	if err != nil {
+		return err
+	}
+	if post.Deleted {
+		return fmt.Errorf("post %s has been deleted", postID)
+	}
+	if !post.Hidden {
+		return fmt.Errorf("post %s is not hidden", postID)
+	}
+
+	post.Hidden = false
+	post.ReportCount = 0
+	err = clearCounter(ctx, counterObjectType, postID, reportCounter)
+	if err != nil {
+		return err
+	}
+	// The posts of a deactivated account stay unlisted until it is reactivated
+	hidden, err := s.isPostListHidden(ctx, post.UserPublicKey)
+	if err != nil {
+		return err
+	}
+	if !hidden {
+		err = s.setPostListed(ctx, post, true)
+		if err != nil {
+			return err
+		}
+	}
+	err = s.savePost(ctx, post)
+	if err != nil {
+		return err
+	}
+	err = s.appendModerationLog(ctx, moderationRestorePost, reportTargetPost, postID, moderator, reason)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Post %s restored by a moderator", postID)
+	return emitEvent(ctx, eventPostRestored, ModerationEvent{
+		TargetType:      reportTargetPost,
+		TargetID:        postID,
+		AuthorPublicKey: post.UserPublicKey,
+		Moderator:       moderator,
+		Reason:          reason,
+	})
+}
+
+// setUserSuspended suspends or reinstates a user on behalf of a moderator
+func (s *SmartContract) setUserSuspended(ctx contractapi.TransactionContextInterface, publicKey string, suspended bool, reason string) error {
+	err := requireClientAttribute(ctx, moderatorAttribute)
+	if err != nil {
+		return err
+	}
+	reason, err = normalizeReason(reason)
+	if err != nil {
+		return err
+	}
+	moderator, err := clientID(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Read the record as stored, as a deactivated account's profile is hidden
+	current, err := resolveUserKey(ctx, publicKey)
+	if err != nil {
+		return err
+	}
+	user, err := s.getUserRecord(ctx, current)
+	if err != nil {
+		return err
+	}
+	if user.Suspended == suspended {
+		return fmt.Errorf("user %s is already in that state", publicKey)
+	}
+	user.Suspended = suspended
+	err = s.saveUser(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	action, event := moderationSuspendUser, eventUserSuspended
+	if !suspended {
+		action, event = moderationReinstate, eventUserReinstated
+	}
+	err = s.appendModerationLog(ctx, action, reportTargetUser, publicKey, moderator, reason)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("User %s suspended: %t", publicKey, suspended)
+	return emitEvent(ctx, event, ModerationEvent{TargetType: reportTargetUser, TargetID: publicKey, Moderator: moderator, Reason: reason})
+}
+
+// SuspendUser stops a user from making any signed write until reinstated. Their
+// existing posts are left to HidePost. Only moderators may call it.
+func (s *SmartContract) SuspendUser(ctx contractapi.TransactionContextInterface, publicKey string, reason string) error {
+	return s.setUserSuspended(ctx, publicKey, true, reason)
+}
+
+// ReinstateUser lifts a user's suspension. Only moderators may call it.
+func (s *SmartContract) ReinstateUser(ctx contractapi.TransactionContextInterface, publicKey string, reason string) error {
+	return s.setUserSuspended(ctx, publicKey, false, reason)
+}
+
+// GetModerationLog returns one page of the moderation log, oldest first
+func (s *SmartContract) GetModerationLog(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*ModerationLogPage, error) {
+	pageSize = normalizePageSize(pageSize)
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(moderationLogObjectType, []string{}, pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get moderation log: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	page := &ModerationLogPage{Actions: []*ModerationAction{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate moderation log: %v", err)
+		}
+
+		var entry ModerationAction
+		err = json.Unmarshal(queryResponse.Value, &entry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal moderation log entry: %v", err)
+		}
+		page.Actions = append(page.Actions, &entry)
+	}
+
+	page.Bookmark = nextBookmark(metadata.FetchedRecordsCount, metadata.Bookmark, pageSize)
+	return page, nil
+}
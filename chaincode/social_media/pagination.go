@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Page sizes accepted by the paginated queries
+const (
+	defaultPageSize int32 = 20
+	maxPageSize     int32 = 100
+)
+
+// PostPage is one page of post IPFS hashes
+type PostPage struct {
+	Posts    []string `json:"posts"`
+	Bookmark string   `json:"bookmark"`
+}
+
+// UserPage is one page of users
+type UserPage struct {
+	Users    []*User `json:"users"`
+	Bookmark string  `json:"bookmark"`
+}
+
+// GroupPage is one page of groups
+type GroupPage struct {
+	Groups   []*Group `json:"groups"`
+	Bookmark string   `json:"bookmark"`
+}
+
+// UserPostsPage is one page of post lists keyed by the author's public key
+type UserPostsPage struct {
+	Posts    map[string][]string `json:"posts"`
+	Bookmark string              `json:"bookmark"`
+}
+
+// FriendRequestPage is one page of friend requests
+type FriendRequestPage struct {
+	Requests []*FriendRequest `json:"requests"`
+	Bookmark string           `json:"bookmark"`
+}
+
+// normalizePageSize applies the default and upper bound to a requested page size
+func normalizePageSize(pageSize int32) int32 {
+	if pageSize <= 0 {
+		return defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		return maxPageSize
+	}
+	return pageSize
+}
+
+// nextBookmark returns the bookmark to continue from, or "" once the last page has been read
+func nextBookmark(fetched int32, bookmark string, pageSize int32) string {
+	if fetched < pageSize {
+		return ""
+	}
+	return bookmark
+}
+
+// indexUserPost records a post in its author's newest-first index
+func (s *SmartContract) indexUserPost(ctx contractapi.TransactionContextInterface, post *Post) error {
+	indexKey, err := entityKey(ctx, userPostObjectType, post.UserPublicKey, reverseTimestamp(post.Timestamp), post.ContentCID)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(indexKey, indexMarker)
+	if err != nil {
+		return fmt.Errorf("failed to index post %s: %v", post.ContentCID, err)
+	}
+	return nil
+}
+
+// GetAllPostsWithPagination returns one page of the IPFS hashes of all posts
+func (s *SmartContract) GetAllPostsWithPagination(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*PostPage, error) {
+	pageSize = normalizePageSize(pageSize)
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(allPostsObjectType, []string{}, pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all posts: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	page := &PostPage{Posts: []string{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate posts: %v", err)
+		}
+
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to split composite key: %v", err)
+		}
+		if len(compositeKeyParts) > 0 {
+			page.Posts = append(page.Posts, compositeKeyParts[0])
+		}
+	}
+
+	page.Bookmark = nextBookmark(metadata.FetchedRecordsCount, metadata.Bookmark, pageSize)
+	return page, nil
+}
+
+// GetPostsByUserWithPagination returns one page of a user's posts, newest
+// first. The user may be looked up by a key they have since replaced.
+func (s *SmartContract) GetPostsByUserWithPagination(ctx contractapi.TransactionContextInterface, publicKey string, pageSize int32, bookmark string) (*PostPage, error) {
+	publicKey, err := resolveUserKey(ctx, publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	userExists, err := s.UserExists(ctx, publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read user data: %v", err)
+	}
+	if !userExists {
+		return nil, fmt.Errorf("user does not exist: %s", publicKey)
+	}
+
+	pageSize = normalizePageSize(pageSize)
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(userPostObjectType, []string{publicKey}, pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve posts: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	page := &PostPage{Posts: []string{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate posts: %v", err)
+		}
+
+		// userpost~publicKey~reverseTimestamp~contentCID
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to split composite key: %v", err)
+		}
+		if len(compositeKeyParts) == 3 {
+			page.Posts = append(page.Posts, compositeKeyParts[2])
+		}
+	}
+
+	page.Bookmark = nextBookmark(metadata.FetchedRecordsCount, metadata.Bookmark, pageSize)
+	return page, nil
+}
+
+// GetAllUserPostsWithPagination returns one page of post lists keyed by
+// author, leaving out hidden and deleted posts
+func (s *SmartContract) GetAllUserPostsWithPagination(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*UserPostsPage, error) {
+	pageSize = normalizePageSize(pageSize)
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(userPostsObjectType, []string{}, pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve posts: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	page := &UserPostsPage{Posts: make(map[string][]string)}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate through posts: %v", err)
+		}
+
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to split composite key: %v", err)
+		}
+		if len(compositeKeyParts) == 0 {
+			continue
+		}
+		publicKey := compositeKeyParts[0]
+
+		// The posts of deactivated accounts are hidden
+		hidden, err := s.isPostListHidden(ctx, publicKey)
+		if err != nil {
+			return nil, err
+		}
+		if hidden {
+			continue
+		}
+
+		var posts []string
+		err = json.Unmarshal(queryResponse.Value, &posts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal posts for user %s: %v", publicKey, err)
+		}
+		posts, err = s.listedPosts(ctx, posts)
+		if err != nil {
+			return nil, err
+		}
+		page.Posts[publicKey] = posts
+	}
+
+	page.Bookmark = nextBookmark(metadata.FetchedRecordsCount, metadata.Bookmark, pageSize)
+	return page, nil
+}
+
+// GetAllUsersWithPagination returns one page of registered users
+func (s *SmartContract) GetAllUsersWithPagination(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*UserPage, error) {
+	pageSize = normalizePageSize(pageSize)
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(userObjectType, []string{}, pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	page := &UserPage{Users: []*User{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get next query result: %v", err)
+		}
+
+		var user User
+		err = json.Unmarshal(queryResponse.Value, &user)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal user: %v", err)
+		}
+		if user.Deactivated {
+			continue
+		}
+		page.Users = append(page.Users, &user)
+	}
+
+	page.Bookmark = nextBookmark(metadata.FetchedRecordsCount, metadata.Bookmark, pageSize)
+	return page, nil
+}
+
+// GetAllGroupsWithPagination returns one page of groups
+func (s *SmartContract) GetAllGroupsWithPagination(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*GroupPage, error) {
+	pageSize = normalizePageSize(pageSize)
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(groupObjectType, []string{}, pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get groups: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	page := &GroupPage{Groups: []*Group{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate query results: %v", err)
+		}
+
+		var group Group
+		err = json.Unmarshal(queryResponse.Value, &group)
+		if err != nil {
+			return nil, fmt.Errorf("failed to deserialize group: %v", err)
+		}
+		page.Groups = append(page.Groups, &group)
+	}
+
+	page.Bookmark = nextBookmark(metadata.FetchedRecordsCount, metadata.Bookmark, pageSize)
+	return page, nil
+}
+
+// GetFriendRequestsByUserWithPagination returns one page of the friend requests
+// sent or received by a user
+func (s *SmartContract) GetFriendRequestsByUserWithPagination(ctx contractapi.TransactionContextInterface, publicKey string, pageSize int32, bookmark string) (*FriendRequestPage, error) {
+	pageSize = normalizePageSize(pageSize)
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(userFriendRequestObjectType, []string{publicKey}, pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get iterator for friend requests: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	page := &FriendRequestPage{Requests: []*FriendRequest{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get next item from iterator: %v", err)
+		}
+
+		friendRequest, err := s.friendRequestFromIndex(ctx, queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		if friendRequest == nil {
+			continue
+		}
+		err = s.fillFriendRequestNames(ctx, friendRequest)
+		if err != nil {
+			return nil, err
+		}
+		page.Requests = append(page.Requests, friendRequest)
+	}
+
+	page.Bookmark = nextBookmark(metadata.FetchedRecordsCount, metadata.Bookmark, pageSize)
+	return page, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestGetPostsByUserWithPaginationBookmarks(t *testing.T) {
+	for _, count := range []int{7, 6, 0} {
+		t.Run(fmt.Sprintf("%d posts", count), func(t *testing.T) {
+			contract := new(SmartContract)
+			stub := newFakeStub()
+			alice := registerTestUser(t, contract, stub, "alice")
+			for i := 0; i < count; i++ {
+				createTestPost(t, contract, stub, alice, fmt.Sprintf("Qm%d", i))
+			}
+
+			posts := []string{}
+			bookmark := ""
+			for pages := 1; ; pages++ {
+				if pages > count+1 {
+					t.Fatalf("bookmarks did not reach the last page")
+				}
+				page, err := contract.GetPostsByUserWithPagination(stub.newContext(), alice.publicKey, 3, bookmark)
+				if err != nil {
+					t.Fatalf("failed to read page %d: %v", pages, err)
+				}
+				if len(page.Posts) > 3 {
+					t.Fatalf("page %d has %d posts", pages, len(page.Posts))
+				}
+				posts = append(posts, page.Posts...)
+				if page.Bookmark == "" {
+					break
+				}
+				bookmark = page.Bookmark
+			}
+
+			// Every post is listed once, newest first
+			if len(posts) != count {
+				t.Fatalf("listed %d posts, want %d: %v", len(posts), count, posts)
+			}
+			for i, post := range posts {
+				if want := fmt.Sprintf("Qm%d", count-1-i); post != want {
+					t.Fatalf("post %d is %s, want %s", i, post, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNextMigrationBatchResumesAfterLastKey(t *testing.T) {
+	contract := new(SmartContract)
+	stub := newFakeStub()
+	for _, name := range []string{"alice", "bob", "carol", "dave", "erin"} {
+		registerTestUser(t, contract, stub, name)
+	}
+
+	seen := map[string]bool{}
+	batches := 0
+	for {
+		batches++
+		if batches > 10 {
+			t.Fatalf("migration did not finish")
+		}
+		keys, err := contract.NextMigrationBatch(stub.newContext(), keyHistoriesMigration, 2)
+		if err != nil {
+			t.Fatalf("failed to read batch %d: %v", batches, err)
+		}
+		for _, key := range keys {
+			if seen[key] {
+				t.Fatalf("key %q read in more than one batch", key)
+			}
+			seen[key] = true
+		}
+
+		status, err := contract.MirrorKeyHistories(stub.newAdminContext(), keys)
+		if err != nil {
+			t.Fatalf("failed to run batch %d: %v", batches, err)
+		}
+		if status.Done {
+			if len(keys) != 0 {
+				t.Fatalf("migration done after a batch of %d keys", len(keys))
+			}
+			break
+		}
+
+		// A batch cannot be submitted twice
+		if _, err := contract.MirrorKeyHistories(stub.newAdminContext(), keys); err == nil {
+			t.Fatalf("batch %d was accepted twice", batches)
+		}
+	}
+
+	if len(seen) != 5 || batches != 4 {
+		t.Fatalf("read %d users in %d batches, want 5 in 4", len(seen), batches)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Private data collections, defined in collections_config.json next to the
+// chaincode. Their contents stay on the peers of member organizations; the rest
+// of the channel only sees hashes.
+const (
+	userPrivateCollection = "userPrivateCollection" // phone~publicKey -> phone number, deactivated~publicKey -> User
+	chatPrivateCollection = "chatPrivateCollection" // chat~chatID -> Chat, chatmessage~chatID~seq -> Message, nonce~publicKey~nonce -> used marker, chatblock~blocker~blocked -> block marker, keyhistory~publicKey -> retired keys
+)
+
+// Fields of the transient map. Values passed this way reach the chaincode
+// without being recorded in the transaction.
+const (
+	transientPhone   = "phone"
+	transientMessage = "message"
+	transientAuth    = "auth"
+)
+
+// ownerAuthWindow is how long, in seconds, a signed owner read stays valid
+const ownerAuthWindow = 5 * 60
+
+// OwnerAuth proves that a read is made by the owner of a public key. The nonce
+// is the unix time of signing, and the signature covers the function name, the
+// public key and the nonce, as for signed writes.
+type OwnerAuth struct {
+	Nonce     string `json:"nonce"`
+	Signature string `json:"signature"`
+}
+
+// MessageInput is the transient input of AddMessage. It is the argument list the
+// function used to take, kept out of the transaction so the chat's participants
+// are not visible to the whole channel.
+type MessageInput struct {
+	ChatID            string `json:"chatId"`
+	Message           string `json:"message"`
+	SenderPublicKey   string `json:"senderPublicKey"`
+	ReceiverPublicKey string `json:"receiverPublicKey"`
+	Nonce             string `json:"nonce"`
+	Signature         string `json:"signature"`
+}
+
+// transientField returns a field of the transaction's transient map, or nil if it was not passed
+func transientField(ctx contractapi.TransactionContextInterface, name string) ([]byte, error) {
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transient data: %v", err)
+	}
+	return transient[name], nil
+}
+
+// savePhone stores a user's phone number in the user collection
+func (s *SmartContract) savePhone(ctx contractapi.TransactionContextInterface, publicKey string, phone string) error {
+	phoneKey, err := entityKey(ctx, phoneObjectType, publicKey)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutPrivateData(userPrivateCollection, phoneKey, []byte(phone))
+	if err != nil {
+		return fmt.Errorf("failed to store phone number: %v", err)
+	}
+	return nil
+}
+
+// getPhone reads a user's phone number from the user collection
+func (s *SmartContract) getPhone(ctx contractapi.TransactionContextInterface, publicKey string) (string, error) {
+	phoneKey, err := entityKey(ctx, phoneObjectType, publicKey)
+	if err != nil {
+		return "", err
+	}
+	phone, err := ctx.GetStub().GetPrivateData(userPrivateCollection, phoneKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to read phone number: %v", err)
+	}
+	return string(phone), nil
+}
+
+// VerifyPhone reports whether the phone number passed in the transient map is
+// the one registered for publicKey. It compares hashes, so it works on peers
+// that do not hold the user collection and never reveals the stored number.
+func (s *SmartContract) VerifyPhone(ctx contractapi.TransactionContextInterface, publicKey string) (bool, error) {
+	phone, err := transientField(ctx, transientPhone)
+	if err != nil {
+		return false, err
+	}
+	if len(phone) == 0 {
+		return false, fmt.Errorf("phone number must be passed in the transient map")
+	}
+
+	phoneKey, err := entityKey(ctx, phoneObjectType, publicKey)
+	if err != nil {
+		return false, err
+	}
+	storedHash, err := ctx.GetStub().GetPrivateDataHash(userPrivateCollection, phoneKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to read phone number hash: %v", err)
+	}
+	if storedHash == nil {
+		return false, nil
+	}
+
+	hash := sha256.Sum256(phone)
+	return bytes.Equal(hash[:], storedHash), nil
+}
+
+// isOwnerRead reports whether a read of function for publicKey carries a valid
+// owner signature in the transient map. Reads cannot record nonces, so the nonce
+// is a timestamp that must be within ownerAuthWindow of the transaction's.
+// A signature that is present but invalid is an error.
+func (s *SmartContract) isOwnerRead(ctx contractapi.TransactionContextInterface, function string, publicKey string) (bool, error) {
+	authJSON, err := transientField(ctx, transientAuth)
+	if err != nil {
+		return false, err
+	}
+	if authJSON == nil {
+		return false, nil
+	}
+
+	var auth OwnerAuth
+	err = json.Unmarshal(authJSON, &auth)
+	if err != nil {
+		return false, fmt.Errorf("failed to unmarshal owner authentication: %v", err)
+	}
+	signedAt, err := strconv.ParseInt(auth.Nonce, 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("owner authentication nonce must be a unix timestamp")
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return false, err
+	}
+	if signedAt > now+ownerAuthWindow || signedAt < now-ownerAuthWindow {
+		return false, fmt.Errorf("owner authentication has expired")
+	}
+
+	payload, err := canonicalPayload(function, []string{publicKey}, auth.Nonce)
+	if err != nil {
+		return false, err
+	}
+	err = verifySignature(payload, auth.Signature, publicKey)
+	if err != nil {
+		return false, fmt.Errorf("invalid owner authentication for %s: %v", function, err)
+	}
+	return true, nil
+}
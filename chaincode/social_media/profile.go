@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Limits on the editable fields of a profile
+const (
+	maxDisplayNameLength = 64
+	maxBioLength         = 500
+	maxProfileLinks      = 5
+	maxLinkLength        = 200
+	maxProfileCIDLength  = 128
+)
+
+// UserProfile is a user together with the counts shown on their profile
+type UserProfile struct {
+	User
+	PostCount      int `json:"postCount"`
+	FriendCount    int `json:"friendCount"`
+	GroupCount     int `json:"groupCount"`
+	FollowerCount  int `json:"followerCount"`
+	FollowingCount int `json:"followingCount"`
+}
+
+// validateProfileLinks checks the links shown on a profile, which must be web addresses
+func validateProfileLinks(links []string) ([]string, error) {
+	if len(links) > maxProfileLinks {
+		return nil, fmt.Errorf("a profile can have at most %d links", maxProfileLinks)
+	}
+	validated := []string{}
+	for _, link := range links {
+		link = strings.TrimSpace(link)
+		if link == "" {
+			continue
+		}
+		if len(link) > maxLinkLength {
+			return nil, fmt.Errorf("links must be at most %d characters", maxLinkLength)
+		}
+		if !strings.HasPrefix(link, "https://") && !strings.HasPrefix(link, "http://") {
+			return nil, fmt.Errorf("link %q must start with http:// or https://", link)
+		}
+		validated = append(validated, link)
+	}
+	return validated, nil
+}
+
+// UpdateProfile replaces the editable fields of a user's profile, signed with
+// the user's key. The avatar and cover are the IPFS hashes of images uploaded
+// beforehand; empty fields clear the corresponding part of the profile. The
+// updated user is returned.
+func (s *SmartContract) UpdateProfile(ctx contractapi.TransactionContextInterface, publicKey string, displayName string, bio string, links []string, avatarCID string, coverCID string, nonce string, signature string) (*User, error) {
+	linksJSON, err := json.Marshal(links)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize links: %v", err)
+	}
+	err = s.verifyUserSignature(ctx, publicKey, "UpdateProfile", []string{publicKey, displayName, bio, string(linksJSON), avatarCID, coverCID}, nonce, signature)
+	if err != nil {
+		return nil, err
+	}
+
+	displayName = strings.TrimSpace(displayName)
+	bio = strings.TrimSpace(bio)
+	if len(displayName) > maxDisplayNameLength {
+		return nil, fmt.Errorf("display name must be at most %d characters", maxDisplayNameLength)
+	}
+	if len(bio) > maxBioLength {
+		return nil, fmt.Errorf("bio must be at most %d characters", maxBioLength)
+	}
+	if len(avatarCID) > maxProfileCIDLength || len(coverCID) > maxProfileCIDLength {
+		return nil, fmt.Errorf("image hashes must be at most %d characters", maxProfileCIDLength)
+	}
+	links, err = validateProfileLinks(links)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.GetUser(ctx, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	user.DisplayName = displayName
+	user.Bio = bio
+	user.Links = links
+	user.AvatarCID = avatarCID
+	user.CoverCID = coverCID
+	err = s.saveUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = emitEvent(ctx, eventProfileUpdated, UserEvent{PublicKey: publicKey, Name: user.Name, Handle: user.Handle})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("User %s updated their profile", publicKey)
+	return user, nil
+}
+
+// countKeys returns the number of keys under a partial composite key
+func countKeys(ctx contractapi.TransactionContextInterface, objectType string, attributes []string) (int, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(objectType, attributes)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count %s keys: %v", objectType, err)
+	}
+	defer resultsIterator.Close()
+
+	count := 0
+	for resultsIterator.HasNext() {
+		_, err := resultsIterator.Next()
+		if err != nil {
+			return 0, fmt.Errorf("failed to iterate %s keys: %v", objectType, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// GetUserProfile returns a user's public profile with their post, friend,
+// group, follower and following counts. Posts are counted as listed on the
+// profile, so hidden and deleted posts are left out. Follows are counted from
+// the follow edges rather than kept on the user record, so following someone
+// does not rewrite either user. The phone number is never included. The user
+// may be looked up by a key they have since replaced. A deactivated account has
+// no profile beyond its public key.
+func (s *SmartContract) GetUserProfile(ctx contractapi.TransactionContextInterface, publicKey string) (*UserProfile, error) {
+	publicKey, err := resolveUserKey(ctx, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.GetUser(ctx, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	user.Phone = ""
+	if user.Deactivated {
+		return &UserProfile{User: *user}, nil
+	}
+
+	postCount, err := countKeys(ctx, userPostObjectType, []string{publicKey})
+	if err != nil {
+		return nil, err
+	}
+	friends, err := s.GetFriendsByUser(ctx, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	groupCount, err := countKeys(ctx, groupMemberObjectType, []string{publicKey})
+	if err != nil {
+		return nil, err
+	}
+	followerCount, err := countKeys(ctx, followerObjectType, []string{publicKey})
+	if err != nil {
+		return nil, err
+	}
+	followingCount, err := countKeys(ctx, followObjectType, []string{publicKey})
+	if err != nil {
+		return nil, err
+	}
+
+	return &UserProfile{
+		User:           *user,
+		PostCount:      postCount,
+		FriendCount:    len(friends),
+		GroupCount:     groupCount,
+		FollowerCount:  followerCount,
+		FollowingCount: followingCount,
+	}, nil
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// reactionTypesConfig names the setting holding the accepted reaction types
+const reactionTypesConfig = "reactions"
+
+// defaultReactionTypes are accepted until an admin configures other types
+var defaultReactionTypes = []string{"like", "love", "laugh", "angry", "sad"}
+
+// ReactionSummary is the aggregated view of the reactions to a post
+type ReactionSummary struct {
+	PostID    string            `json:"postId"`
+	Reactions map[string]string `json:"reactions"` // The viewer's reaction, if any: public key -> reaction type
+	Counts    map[string]int    `json:"counts"`    // Reaction type -> number of users
+	Total     int               `json:"total"`
+}
+
+// GetReactionTypes returns the reaction types users may react with
+func (s *SmartContract) GetReactionTypes(ctx contractapi.TransactionContextInterface) ([]string, error) {
+	configKey, err := entityKey(ctx, configObjectType, reactionTypesConfig)
+	if err != nil {
+		return nil, err
+	}
+	typesJSON, err := ctx.GetStub().GetState(configKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reaction types: %v", err)
+	}
+	if typesJSON == nil {
+		return defaultReactionTypes, nil
+	}
+
+	var types []string
+	err = json.Unmarshal(typesJSON, &types)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal reaction types: %v", err)
+	}
+	return types, nil
+}
+
+// SetReactionTypes replaces the accepted reaction types. Only admins may call it.
+// Existing reactions of a type that is removed are kept and still counted.
+func (s *SmartContract) SetReactionTypes(ctx contractapi.TransactionContextInterface, types []string) error {
+	err := requireClientAttribute(ctx, adminAttribute)
+	if err != nil {
+		return err
+	}
+	if len(types) == 0 {
+		return fmt.Errorf("at least one reaction type is required")
+	}
+	seen := make(map[string]bool)
+	for _, reactionType := range types {
+		if reactionType == "" || seen[reactionType] {
+			return fmt.Errorf("reaction types must be non-empty and unique")
+		}
+		seen[reactionType] = true
+	}
+
+	typesJSON, err := json.Marshal(types)
+	if err != nil {
+		return fmt.Errorf("failed to marshal reaction types: %v", err)
+	}
+	configKey, err := entityKey(ctx, configObjectType, reactionTypesConfig)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(configKey, typesJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store reaction types: %v", err)
+	}
+
+	log.Printf("Reaction types set to %v", types)
+	return nil
+}
+
+// AddReaction records userPublicKey's reaction to a post, replacing any earlier
+// one, including one made with a key the user has since retired, signed with
+// the reacting user's key. Each reaction is its own key and is counted in the
+// reacting user's shard of the per-type counters; the post record is not
+// touched, so concurrent reactions to the same post rarely conflict.
+func (s *SmartContract) AddReaction(ctx contractapi.TransactionContextInterface, postID string, userPublicKey string, reactionType string, nonce string, signature string) error {
+	err := s.verifyUserSignature(ctx, userPublicKey, "AddReaction", []string{postID, userPublicKey, reactionType}, nonce, signature)
+	if err != nil {
+		return err
+	}
+
+	types, err := s.GetReactionTypes(ctx)
+	if err != nil {
+		return err
+	}
+	valid := false
+	for _, allowed := range types {
+		if allowed == reactionType {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return fmt.Errorf("invalid reaction type %s", reactionType)
+	}
+
+	// The post is only read, so reactions still do not conflict with each other
+	post, err := s.getPost(ctx, postID)
+	if err != nil {
+		return err
+	}
+	if post.Deleted {
+		return fmt.Errorf("post %s has been deleted", postID)
+	}
+	if post.Hidden {
+		return fmt.Errorf("post %s has been hidden by moderators", postID)
+	}
+	deactivated, err := s.isPostListHidden(ctx, post.UserPublicKey)
+	if err != nil {
+		return err
+	}
+	if deactivated {
+		return fmt.Errorf("the author of post %s has deactivated their account", postID)
+	}
+
+	reactionKey, err := entityKey(ctx, reactionObjectType, postID, userPublicKey)
+	if err != nil {
+		return err
+	}
+	existing, err := s.findReaction(ctx, postID, userPublicKey)
+	if err != nil {
+		return err
+	}
+
+	// A reaction made with a retired key is replaced by one under the current key
+	changes := []reactionCountChange{}
+	if existing != nil {
+		counted, err := reactionCounted(ctx, existing.key)
+		if err != nil {
+			return err
+		}
+		if counted {
+			changes = append(changes, reactionCountChange{existing.reactionType, counterShard(existing.publicKey), -1})
+		}
+		if existing.key != reactionKey {
+			err = ctx.GetStub().DelState(existing.key)
+			if err != nil {
+				return fmt.Errorf("failed to remove reaction for postID '%s': %v", postID, err)
+			}
+		}
+	}
+	counted, err := reactionCounted(ctx, reactionKey)
+	if err != nil {
+		return err
+	}
+	if counted {
+		changes = append(changes, reactionCountChange{reactionType, counterShard(userPublicKey), 1})
+	}
+	err = applyReactionCountChanges(ctx, postID, changes)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutState(reactionKey, []byte(reactionType))
+	if err != nil {
+		return fmt.Errorf("failed to store reaction for postID '%s': %v", postID, err)
+	}
+
+	return emitEvent(ctx, eventReactionChanged, ReactionEvent{
+		PostID:          post.ID,
+		PostCID:         postID,
+		AuthorPublicKey: post.UserPublicKey,
+		UserPublicKey:   userPublicKey,
+		ReactionType:    reactionType,
+	})
+}
+
+// RemoveReaction withdraws userPublicKey's reaction to a post, signed with the user's key
+func (s *SmartContract) RemoveReaction(ctx contractapi.TransactionContextInterface, postID string, userPublicKey string, nonce string, signature string) error {
+	err := s.verifyUserSignature(ctx, userPublicKey, "RemoveReaction", []string{postID, userPublicKey}, nonce, signature)
+	if err != nil {
+		return err
+	}
+
+	existing, err := s.findReaction(ctx, postID, userPublicKey)
+	if err != nil {
+		return err
+	}
+
+	post, err := s.getPost(ctx, postID)
+	if err != nil {
+		return err
+	}
+	removedLegacy, err := s.dropLegacyReaction(ctx, post, userPublicKey)
+	if err != nil {
+		return err
+	}
+	if existing == nil && !removedLegacy {
+		return fmt.Errorf("user has not reacted to post %s", postID)
+	}
+
+	if existing != nil {
+		counted, err := reactionCounted(ctx, existing.key)
+		if err != nil {
+			return err
+		}
+		if counted {
+			err = addToCounter(ctx, reactionCountObjectType, postID, existing.reactionType, existing.publicKey, -1)
+			if err != nil {
+				return err
+			}
+		}
+		err = ctx.GetStub().DelState(existing.key)
+		if err != nil {
+			return fmt.Errorf("failed to remove reaction for postID '%s': %v", postID, err)
+		}
+	}
+
+	return emitEvent(ctx, eventReactionChanged, ReactionEvent{
+		PostID:          post.ID,
+		PostCID:         postID,
+		AuthorPublicKey: post.UserPublicKey,
+		UserPublicKey:   userPublicKey,
+	})
+}
+
+// dropLegacyReaction removes a user's reaction stored inside the post record,
+// where reactions were kept before they got their own keys, so it cannot
+// resurface once the keyed reaction is gone. It reports whether there was one.
+func (s *SmartContract) dropLegacyReaction(ctx contractapi.TransactionContextInterface, post *Post, userPublicKey string) (bool, error) {
+	keys, err := s.userKeys(ctx, userPublicKey)
+	if err != nil {
+		return false, err
+	}
+	dropped := false
+	for _, key := range keys {
+		if _, ok := post.Reactions[key]; ok {
+			delete(post.Reactions, key)
+			dropped = true
+		}
+	}
+	if !dropped {
+		return false, nil
+	}
+
+	post.ReactionCount = len(post.Reactions)
+	return true, s.savePost(ctx, post)
+}
+
+// keyedReaction is a reaction stored under its own key
+type keyedReaction struct {
+	key          string // reaction~postID~publicKey
+	publicKey    string // Key the reaction was made with, which may since have been retired
+	reactionType string
+}
+
+// findReaction returns a user's reaction to a post, or nil if they have not
+// reacted. Reactions stay under the key they were made with, so the keys the
+// user has retired are looked at as well and a rotation cannot be used to react twice.
+func (s *SmartContract) findReaction(ctx contractapi.TransactionContextInterface, postID string, userPublicKey string) (*keyedReaction, error) {
+	keys, err := s.userKeys(ctx, userPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		reactionKey, err := entityKey(ctx, reactionObjectType, postID, key)
+		if err != nil {
+			return nil, err
+		}
+		reactionType, err := ctx.GetStub().GetState(reactionKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read reaction for postID '%s': %v", postID, err)
+		}
+		if reactionType != nil {
+			return &keyedReaction{key: reactionKey, publicKey: key, reactionType: string(reactionType)}, nil
+		}
+	}
+	return nil, nil
+}
+
+// reactionCountChange is a change to one shard of a reaction counter
+type reactionCountChange struct {
+	reactionType string
+	shard        string
+	delta        int
+}
+
+// applyReactionCountChanges updates the reaction counters of a post. Changes
+// to the same shard are combined first, since a transaction must update each
+// shard at most once.
+func applyReactionCountChanges(ctx contractapi.TransactionContextInterface, postID string, changes []reactionCountChange) error {
+	combined := []reactionCountChange{}
+	for _, change := range changes {
+		merged := false
+		for i := range combined {
+			if combined[i].reactionType == change.reactionType && combined[i].shard == change.shard {
+				combined[i].delta += change.delta
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			combined = append(combined, change)
+		}
+	}
+	for _, change := range combined {
+		if change.delta == 0 {
+			continue
+		}
+		err := addToCounterShard(ctx, reactionCountObjectType, postID, change.reactionType, change.shard, change.delta)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reactionCounted reports whether the reaction stored under reactionKey is
+// included in the per-type counters. Reactions made before the counters existed
+// are counted by CountReactions; until it has passed a reaction, changes to
+// that reaction are left for it to count.
+func reactionCounted(ctx contractapi.TransactionContextInterface, reactionKey string) (bool, error) {
+	status, err := loadMigrationStatus(ctx, reactionCountsMigration)
+	if err != nil {
+		return false, err
+	}
+	return status.Done || reactionKey <= status.LastKey, nil
+}
+
+// userReaction returns a user's reaction to a post, made with any of their
+// keys, or "" if they have not reacted
+func (s *SmartContract) userReaction(ctx contractapi.TransactionContextInterface, post *Post, publicKey string) (string, error) {
+	publicKey, err := resolveUserKey(ctx, publicKey)
+	if err != nil {
+		return "", err
+	}
+	reaction, err := s.findReaction(ctx, post.ContentCID, publicKey)
+	if err != nil {
+		return "", err
+	}
+	if reaction != nil {
+		return reaction.reactionType, nil
+	}
+
+	// Reactions from before they had their own keys
+	keys, err := s.userKeys(ctx, publicKey)
+	if err != nil {
+		return "", err
+	}
+	for _, key := range keys {
+		if reactionType, ok := post.Reactions[key]; ok {
+			return reactionType, nil
+		}
+	}
+	return "", nil
+}
+
+// GetPostReactions returns the number of reactions to a post by type, and the
+// reaction of viewerPublicKey when it is not empty. The counts are read from
+// the per-type counters, so the cost does not grow with the number of reactions.
+func (s *SmartContract) GetPostReactions(ctx contractapi.TransactionContextInterface, postID string, viewerPublicKey string) (*ReactionSummary, error) {
+	post, err := s.getPost(ctx, postID)
+	if err != nil {
+		return nil, err
+	}
+	return s.postReactions(ctx, post, viewerPublicKey)
+}
+
+// postReactions summarizes the reactions to a post whose record has already been read
+func (s *SmartContract) postReactions(ctx contractapi.TransactionContextInterface, post *Post, viewerPublicKey string) (*ReactionSummary, error) {
+	postID := post.ContentCID
+	summary := &ReactionSummary{
+		PostID:    postID,
+		Reactions: make(map[string]string),
+	}
+	if viewerPublicKey != "" {
+		reactionType, err := s.userReaction(ctx, post, viewerPublicKey)
+		if err != nil {
+			return nil, err
+		}
+		if reactionType != "" {
+			summary.Reactions[viewerPublicKey] = reactionType
+		}
+	}
+
+	status, err := loadMigrationStatus(ctx, reactionCountsMigration)
+	if err != nil {
+		return nil, err
+	}
+	if !status.Done {
+		summary.Counts, err = s.scanPostReactions(ctx, post)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		summary.Counts, err = readCounters(ctx, reactionCountObjectType, postID)
+		if err != nil {
+			return nil, err
+		}
+		// Reactions from before they had their own keys, unless since replaced by one
+		for publicKey, reactionType := range post.Reactions {
+			reactionKey, err := entityKey(ctx, reactionObjectType, postID, publicKey)
+			if err != nil {
+				return nil, err
+			}
+			keyed, err := ctx.GetStub().GetState(reactionKey)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read reaction for postID '%s': %v", postID, err)
+			}
+			if keyed == nil {
+				summary.Counts[reactionType]++
+			}
+		}
+	}
+
+	for _, count := range summary.Counts {
+		summary.Total += count
+	}
+	return summary, nil
+}
+
+// scanPostReactions counts the reactions to a post by reading each of them. It
+// is used until CountReactions has built the counters.
+func (s *SmartContract) scanPostReactions(ctx contractapi.TransactionContextInterface, post *Post) (map[string]int, error) {
+	// Reactions from before they had their own keys; keyed reactions take precedence
+	reactions := make(map[string]string)
+	for publicKey, reactionType := range post.Reactions {
+		reactions[publicKey] = reactionType
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(reactionObjectType, []string{post.ContentCID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reactions: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate reactions: %v", err)
+		}
+
+		// reaction~postID~publicKey
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to split composite key: %v", err)
+		}
+		if len(compositeKeyParts) != 2 {
+			continue
+		}
+		reactions[compositeKeyParts[1]] = string(queryResponse.Value)
+	}
+
+	counts := make(map[string]int)
+	for _, reactionType := range reactions {
+		counts[reactionType]++
+	}
+	return counts, nil
+}
@@ -0,0 +1,877 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// resolveUserKey returns the current public key of a user. Keys retired by
+// RotateUserKey forward to the key that replaced them; any other key is
+// returned unchanged.
+func resolveUserKey(ctx contractapi.TransactionContextInterface, publicKey string) (string, error) {
+	forwardKey, err := entityKey(ctx, keyForwardObjectType, publicKey)
+	if err != nil {
+		return "", err
+	}
+	current, err := ctx.GetStub().GetState(forwardKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to read key forwarding of %s: %v", publicKey, err)
+	}
+	if current == nil {
+		return publicKey, nil
+	}
+	return string(current), nil
+}
+
+// isUserKey reports whether key is publicKey or a key publicKey replaced.
+// Records made before a key rotation, and those it has not moved yet, still
+// name the retired key.
+func isUserKey(ctx contractapi.TransactionContextInterface, key string, publicKey string) (bool, error) {
+	if key == publicKey {
+		return true, nil
+	}
+	current, err := resolveUserKey(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	return current == publicKey, nil
+}
+
+// userKeys returns the current key of a user followed by the keys it replaced.
+// Records other users keep about a user, such as their blocks, may still name
+// one of the earlier keys.
+func (s *SmartContract) userKeys(ctx contractapi.TransactionContextInterface, publicKey string) ([]string, error) {
+	userKey, err := entityKey(ctx, userObjectType, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	userJSON, err := ctx.GetStub().GetState(userKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read user data for public key %s: %v", publicKey, err)
+	}
+	if userJSON == nil {
+		return []string{publicKey}, nil
+	}
+
+	var user User
+	err = json.Unmarshal(userJSON, &user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal user data: %v", err)
+	}
+	return append([]string{publicKey}, user.PreviousKeys...), nil
+}
+
+// chatUserKeys is userKeys read from the copy of the user's retired keys kept
+// in the chat collection, for calls that must not read public records naming
+// the user
+func chatUserKeys(ctx contractapi.TransactionContextInterface, publicKey string) ([]string, error) {
+	historyKey, err := entityKey(ctx, keyHistoryObjectType, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	historyJSON, err := ctx.GetStub().GetPrivateData(chatPrivateCollection, historyKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key history of %s: %v", publicKey, err)
+	}
+	if historyJSON == nil {
+		return []string{publicKey}, nil
+	}
+
+	var previousKeys []string
+	err = json.Unmarshal(historyJSON, &previousKeys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal key history: %v", err)
+	}
+	return append([]string{publicKey}, previousKeys...), nil
+}
+
+// saveKeyHistory copies a user's retired keys into the chat collection, where
+// chatUserKeys reads them
+func saveKeyHistory(ctx contractapi.TransactionContextInterface, publicKey string, previousKeys []string) error {
+	historyJSON, err := json.Marshal(previousKeys)
+	if err != nil {
+		return fmt.Errorf("failed to marshal key history: %v", err)
+	}
+	historyKey, err := entityKey(ctx, keyHistoryObjectType, publicKey)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutPrivateData(chatPrivateCollection, historyKey, historyJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store key history of %s: %v", publicKey, err)
+	}
+	return nil
+}
+
+// defaultKeyRotationBatchSize is how many records a key rotation moves per
+// transaction when called without a batch size
+const defaultKeyRotationBatchSize = 100
+
+// KeyRotation records how far the records of a rotated key have been moved to
+// the new key
+type KeyRotation struct {
+	OldPublicKey string `json:"oldPublicKey"`
+	NewPublicKey string `json:"newPublicKey"`
+	Step         int    `json:"step"`   // Index in keyRotationSteps of the records being moved
+	Offset       int    `json:"offset"` // Position in the old key's list, for steps that work through one
+	Moved        int    `json:"moved"`
+	Done         bool   `json:"done"`
+}
+
+// keyRotationStep moves at most limit records of one kind from the old key of
+// a rotation to the new one, returning how many it moved and whether any are left
+type keyRotationStep func(s *SmartContract, ctx contractapi.TransactionContextInterface, rotation *KeyRotation, limit int) (int, bool, error)
+
+// keyRotationSteps are the kinds of records a key rotation moves, in order
+var keyRotationSteps = []keyRotationStep{
+	(*SmartContract).rotatePosts,
+	(*SmartContract).rotateFriends,
+	(*SmartContract).rotateFollowing,
+	(*SmartContract).rotateFollowers,
+	(*SmartContract).rotateGroupMemberships,
+	rotateIndex(blockObjectType),
+	rotateIndex(hashtagFollowObjectType),
+	(*SmartContract).rotateFriendRequests,
+}
+
+// getKeyRotation reads the rotation that replaced a key with newPublicKey,
+// returning nil if there was none
+func getKeyRotation(ctx contractapi.TransactionContextInterface, newPublicKey string) (*KeyRotation, error) {
+	rotationKey, err := entityKey(ctx, keyRotationObjectType, newPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	rotationJSON, err := ctx.GetStub().GetState(rotationKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key rotation of %s: %v", newPublicKey, err)
+	}
+	if rotationJSON == nil {
+		return nil, nil
+	}
+
+	var rotation KeyRotation
+	err = json.Unmarshal(rotationJSON, &rotation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal key rotation: %v", err)
+	}
+	return &rotation, nil
+}
+
+// saveKeyRotation stores the progress of a key rotation
+func saveKeyRotation(ctx contractapi.TransactionContextInterface, rotation *KeyRotation) error {
+	rotationJSON, err := json.Marshal(rotation)
+	if err != nil {
+		return fmt.Errorf("failed to marshal key rotation: %v", err)
+	}
+	rotationKey, err := entityKey(ctx, keyRotationObjectType, rotation.NewPublicKey)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(rotationKey, rotationJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store key rotation: %v", err)
+	}
+	return nil
+}
+
+// runKeyRotation moves at most batchSize records of a key rotation and stores
+// its progress
+func (s *SmartContract) runKeyRotation(ctx contractapi.TransactionContextInterface, rotation *KeyRotation, batchSize int) error {
+	if batchSize <= 0 {
+		batchSize = defaultKeyRotationBatchSize
+	}
+
+	processed := 0
+	for !rotation.Done && processed < batchSize {
+		moved, more, err := keyRotationSteps[rotation.Step](s, ctx, rotation, batchSize-processed)
+		if err != nil {
+			return err
+		}
+		processed += moved
+		rotation.Moved += moved
+		if !more {
+			rotation.Step++
+			rotation.Offset = 0
+			rotation.Done = rotation.Step == len(keyRotationSteps)
+		}
+	}
+
+	err := saveKeyRotation(ctx, rotation)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Key rotation to %s moved %d records (done %t)", rotation.NewPublicKey, processed, rotation.Done)
+	return nil
+}
+
+// movedEntry is an index entry re-keyed by moveIndexEntries
+type movedEntry struct {
+	attributes []string // Attributes of the key after the public key, such as the group ID of groupmember~publicKey~groupID
+	value      []byte
+}
+
+// moveIndexEntries re-keys at most limit entries of objectType listed under
+// oldKey to newKey, keeping their values. It returns the entries it moved and
+// whether any are left.
+func moveIndexEntries(ctx contractapi.TransactionContextInterface, objectType string, oldKey string, newKey string, limit int) ([]movedEntry, bool, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(objectType, []string{oldKey})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read %s index: %v", objectType, err)
+	}
+	defer resultsIterator.Close()
+
+	moved := []movedEntry{}
+	for len(moved) < limit && resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to iterate %s index: %v", objectType, err)
+		}
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to split composite key: %v", err)
+		}
+		if len(compositeKeyParts) < 2 {
+			continue
+		}
+
+		rest := compositeKeyParts[1:]
+		movedKey, err := entityKey(ctx, objectType, append([]string{newKey}, rest...)...)
+		if err != nil {
+			return nil, false, err
+		}
+		err = ctx.GetStub().PutState(movedKey, queryResponse.Value)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to move %s entry: %v", objectType, err)
+		}
+		err = ctx.GetStub().DelState(queryResponse.Key)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to remove %s entry: %v", objectType, err)
+		}
+		moved = append(moved, movedEntry{attributes: rest, value: queryResponse.Value})
+	}
+	return moved, resultsIterator.HasNext(), nil
+}
+
+// rotateIndex returns a step that moves the entries of an index keyed by user
+// and nothing else
+func rotateIndex(objectType string) keyRotationStep {
+	return func(s *SmartContract, ctx contractapi.TransactionContextInterface, rotation *KeyRotation, limit int) (int, bool, error) {
+		moved, more, err := moveIndexEntries(ctx, objectType, rotation.OldPublicKey, rotation.NewPublicKey, limit)
+		return len(moved), more, err
+	}
+}
+
+// readKeyList reads a JSON list of strings kept under objectType~publicKey,
+// returning nil if there is none
+func readKeyList(ctx contractapi.TransactionContextInterface, objectType string, publicKey string) ([]string, error) {
+	listKey, err := entityKey(ctx, objectType, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	listJSON, err := ctx.GetStub().GetState(listKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s of %s: %v", objectType, publicKey, err)
+	}
+	if listJSON == nil {
+		return nil, nil
+	}
+
+	var list []string
+	err = json.Unmarshal(listJSON, &list)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s of %s: %v", objectType, publicKey, err)
+	}
+	return list, nil
+}
+
+// rotatePosts hands a batch of the old key's posts over to the new key: the
+// post record, its place in the list of all posts and its entry in the
+// per-author index. The old key's post list was copied to the new key when the
+// rotation started and is worked through here, then removed.
+func (s *SmartContract) rotatePosts(ctx contractapi.TransactionContextInterface, rotation *KeyRotation, limit int) (int, bool, error) {
+	posts, err := readKeyList(ctx, userPostsObjectType, rotation.OldPublicKey)
+	if err != nil {
+		return 0, false, err
+	}
+	end := rotation.Offset + limit
+	if end > len(posts) {
+		end = len(posts)
+	}
+
+	for _, ipfsHash := range posts[rotation.Offset:end] {
+		post, err := s.getPost(ctx, ipfsHash)
+		if err != nil {
+			return 0, false, err
+		}
+		if post.UserPublicKey == rotation.OldPublicKey {
+			post.UserPublicKey = rotation.NewPublicKey
+			err = s.savePost(ctx, post)
+			if err != nil {
+				return 0, false, err
+			}
+		}
+
+		// Hidden posts are not in the list of all posts
+		allPostsKey, err := entityKey(ctx, allPostsObjectType, ipfsHash)
+		if err != nil {
+			return 0, false, err
+		}
+		listed, err := ctx.GetStub().GetState(allPostsKey)
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to read post %s in all posts: %v", ipfsHash, err)
+		}
+		if listed != nil {
+			err = ctx.GetStub().PutState(allPostsKey, []byte(rotation.NewPublicKey))
+			if err != nil {
+				return 0, false, fmt.Errorf("failed to store post in all posts: %v", err)
+			}
+		}
+
+		err = moveKey(ctx, userPostObjectType,
+			[]string{rotation.OldPublicKey, reverseTimestamp(post.Timestamp), ipfsHash},
+			[]string{rotation.NewPublicKey, reverseTimestamp(post.Timestamp), ipfsHash})
+		if err != nil {
+			return 0, false, err
+		}
+	}
+
+	moved := end - rotation.Offset
+	rotation.Offset = end
+	if end < len(posts) {
+		return moved, true, nil
+	}
+	oldPostsKey, err := entityKey(ctx, userPostsObjectType, rotation.OldPublicKey)
+	if err != nil {
+		return 0, false, err
+	}
+	err = ctx.GetStub().DelState(oldPostsKey)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to remove posts: %v", err)
+	}
+	return moved, false, nil
+}
+
+// replaceFriend swaps oldKey for newKey in owner's friends list
+func (s *SmartContract) replaceFriend(ctx contractapi.TransactionContextInterface, owner string, oldKey string, newKey string) error {
+	friendsKey, err := entityKey(ctx, friendsObjectType, owner)
+	if err != nil {
+		return err
+	}
+	friendsJSON, err := ctx.GetStub().GetState(friendsKey)
+	if err != nil {
+		return fmt.Errorf("failed to get friends list for %s: %v", owner, err)
+	}
+	if friendsJSON == nil {
+		return nil
+	}
+
+	var friendsList FriendsList
+	err = json.Unmarshal(friendsJSON, &friendsList)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal friends list for %s: %v", owner, err)
+	}
+	for i, friend := range friendsList.Friends {
+		if friend == oldKey {
+			friendsList.Friends[i] = newKey
+		}
+	}
+
+	updatedJSON, err := json.Marshal(friendsList)
+	if err != nil {
+		return fmt.Errorf("failed to marshal friends list for %s: %v", owner, err)
+	}
+	err = ctx.GetStub().PutState(friendsKey, updatedJSON)
+	if err != nil {
+		return fmt.Errorf("failed to save friends list for %s: %v", owner, err)
+	}
+	return nil
+}
+
+// rotateFriends swaps the old key for the new one in the friends lists of a
+// batch of the old key's friends. The old key's own list was copied to the new
+// key when the rotation started and is worked through here, then removed.
+func (s *SmartContract) rotateFriends(ctx contractapi.TransactionContextInterface, rotation *KeyRotation, limit int) (int, bool, error) {
+	oldFriendsKey, err := entityKey(ctx, friendsObjectType, rotation.OldPublicKey)
+	if err != nil {
+		return 0, false, err
+	}
+	friendsJSON, err := ctx.GetStub().GetState(oldFriendsKey)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to retrieve friends list: %v", err)
+	}
+	if friendsJSON == nil {
+		return 0, false, nil
+	}
+	var friendsList FriendsList
+	err = json.Unmarshal(friendsJSON, &friendsList)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to unmarshal friends list: %v", err)
+	}
+
+	end := rotation.Offset + limit
+	if end > len(friendsList.Friends) {
+		end = len(friendsList.Friends)
+	}
+	for _, friend := range friendsList.Friends[rotation.Offset:end] {
+		err = s.replaceFriend(ctx, friend, rotation.OldPublicKey, rotation.NewPublicKey)
+		if err != nil {
+			return 0, false, err
+		}
+	}
+
+	moved := end - rotation.Offset
+	rotation.Offset = end
+	if end < len(friendsList.Friends) {
+		return moved, true, nil
+	}
+	err = ctx.GetStub().DelState(oldFriendsKey)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to remove friends list: %v", err)
+	}
+	return moved, false, nil
+}
+
+// rotateFollowing moves a batch of the follows made by the old key. Follow
+// records name both users, so the followed user's side is rewritten too.
+func (s *SmartContract) rotateFollowing(ctx contractapi.TransactionContextInterface, rotation *KeyRotation, limit int) (int, bool, error) {
+	oldKey, newKey := rotation.OldPublicKey, rotation.NewPublicKey
+
+	// follow~publicKey~followed, then its follower~followed~publicKey counterpart
+	following, more, err := moveIndexEntries(ctx, followObjectType, oldKey, newKey, limit)
+	if err != nil {
+		return 0, false, err
+	}
+	for _, entry := range following {
+		var follow Follow
+		err = json.Unmarshal(entry.value, &follow)
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to unmarshal follow: %v", err)
+		}
+		follow.Follower = newKey
+		err = putFollow(ctx, &follow)
+		if err != nil {
+			return 0, false, err
+		}
+		err = moveKey(ctx, followerObjectType, []string{follow.Followed, oldKey}, []string{follow.Followed, newKey})
+		if err != nil {
+			return 0, false, err
+		}
+	}
+	return len(following), more, nil
+}
+
+// rotateFollowers moves a batch of the follows of the old key, rewriting the
+// follower's side of each
+func (s *SmartContract) rotateFollowers(ctx contractapi.TransactionContextInterface, rotation *KeyRotation, limit int) (int, bool, error) {
+	oldKey, newKey := rotation.OldPublicKey, rotation.NewPublicKey
+
+	// follower~publicKey~follower, then its follow~follower~publicKey counterpart
+	followers, more, err := moveIndexEntries(ctx, followerObjectType, oldKey, newKey, limit)
+	if err != nil {
+		return 0, false, err
+	}
+	for _, entry := range followers {
+		follower := entry.attributes[0]
+		oldFollowKey, err := entityKey(ctx, followObjectType, follower, oldKey)
+		if err != nil {
+			return 0, false, err
+		}
+		followJSON, err := ctx.GetStub().GetState(oldFollowKey)
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to read follow: %v", err)
+		}
+		if followJSON == nil {
+			continue
+		}
+		var follow Follow
+		err = json.Unmarshal(followJSON, &follow)
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to unmarshal follow: %v", err)
+		}
+		follow.Followed = newKey
+		err = putFollow(ctx, &follow)
+		if err != nil {
+			return 0, false, err
+		}
+		err = ctx.GetStub().DelState(oldFollowKey)
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to remove follow: %v", err)
+		}
+	}
+	return len(followers), more, nil
+}
+
+// putFollow writes a follow record under follow~follower~followed
+func putFollow(ctx contractapi.TransactionContextInterface, follow *Follow) error {
+	followKey, err := entityKey(ctx, followObjectType, follow.Follower, follow.Followed)
+	if err != nil {
+		return err
+	}
+	followJSON, err := json.Marshal(follow)
+	if err != nil {
+		return fmt.Errorf("failed to marshal follow: %v", err)
+	}
+	err = ctx.GetStub().PutState(followKey, followJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store follow: %v", err)
+	}
+	return nil
+}
+
+// moveKey moves the value of one objectType key to another, if there is one
+func moveKey(ctx contractapi.TransactionContextInterface, objectType string, from []string, to []string) error {
+	fromKey, err := entityKey(ctx, objectType, from...)
+	if err != nil {
+		return err
+	}
+	value, err := ctx.GetStub().GetState(fromKey)
+	if err != nil {
+		return fmt.Errorf("failed to read %s entry: %v", objectType, err)
+	}
+	if value == nil {
+		return nil
+	}
+	toKey, err := entityKey(ctx, objectType, to...)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(toKey, value)
+	if err != nil {
+		return fmt.Errorf("failed to move %s entry: %v", objectType, err)
+	}
+	err = ctx.GetStub().DelState(fromKey)
+	if err != nil {
+		return fmt.Errorf("failed to remove %s entry: %v", objectType, err)
+	}
+	return nil
+}
+
+// rotateGroupMemberships moves a batch of the old key's group memberships,
+// keeping the member's role and ownership
+func (s *SmartContract) rotateGroupMemberships(ctx contractapi.TransactionContextInterface, rotation *KeyRotation, limit int) (int, bool, error) {
+	oldKey, newKey := rotation.OldPublicKey, rotation.NewPublicKey
+	memberships, more, err := moveIndexEntries(ctx, groupMemberObjectType, oldKey, newKey, limit)
+	if err != nil {
+		return 0, false, err
+	}
+	for _, entry := range memberships {
+		group, err := s.ReadGroup(ctx, entry.attributes[0])
+		if err != nil {
+			return 0, false, err
+		}
+		for i, member := range group.Members {
+			if member == oldKey {
+				group.Members[i] = newKey
+			}
+		}
+		if role, ok := group.Roles[oldKey]; ok {
+			delete(group.Roles, oldKey)
+			group.Roles[newKey] = role
+		}
+		if group.Owner == oldKey {
+			group.Owner = newKey
+		}
+		err = s.saveGroup(ctx, group)
+		if err != nil {
+			return 0, false, err
+		}
+	}
+	return len(memberships), more, nil
+}
+
+// rotateFriendRequests moves a batch of the friend requests sent or received by
+// the old key: the request is stored again under the new key and listed under
+// both users, and its old record and index entries are removed. If the other
+// user already has a request with the new key, that one is kept instead.
+func (s *SmartContract) rotateFriendRequests(ctx contractapi.TransactionContextInterface, rotation *KeyRotation, limit int) (int, bool, error) {
+	oldKey, newKey := rotation.OldPublicKey, rotation.NewPublicKey
+	rekey := func(publicKey string) string {
+		if publicKey == oldKey {
+			return newKey
+		}
+		return publicKey
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(userFriendRequestObjectType, []string{oldKey})
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get friend requests: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	moved := 0
+	for moved < limit && resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to iterate friend requests: %v", err)
+		}
+
+		// userfriendrequest~publicKey~sender~receiver
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to split composite key: %v", err)
+		}
+		if len(compositeKeyParts) != 3 {
+			continue
+		}
+		sender, receiver := compositeKeyParts[1], compositeKeyParts[2]
+
+		requestKey, err := entityKey(ctx, friendRequestObjectType, sender, receiver)
+		if err != nil {
+			return 0, false, err
+		}
+		requestJSON, err := ctx.GetStub().GetState(requestKey)
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to read friend request: %v", err)
+		}
+		if requestJSON != nil {
+			movedKey, err := entityKey(ctx, friendRequestObjectType, rekey(sender), rekey(receiver))
+			if err != nil {
+				return 0, false, err
+			}
+			existing, err := ctx.GetStub().GetState(movedKey)
+			if err != nil {
+				return 0, false, fmt.Errorf("failed to read friend request: %v", err)
+			}
+			if existing == nil {
+				var friendRequest FriendRequest
+				err = json.Unmarshal(requestJSON, &friendRequest)
+				if err != nil {
+					return 0, false, fmt.Errorf("failed to unmarshal friend request: %v", err)
+				}
+				friendRequest.Sender = rekey(friendRequest.Sender)
+				friendRequest.Receiver = rekey(friendRequest.Receiver)
+				_, err = s.saveFriendRequest(ctx, &friendRequest)
+				if err != nil {
+					return 0, false, err
+				}
+			}
+			err = ctx.GetStub().DelState(requestKey)
+			if err != nil {
+				return 0, false, fmt.Errorf("failed to remove friend request: %v", err)
+			}
+		}
+
+		for _, publicKey := range []string{sender, receiver} {
+			indexKey, err := entityKey(ctx, userFriendRequestObjectType, publicKey, sender, receiver)
+			if err != nil {
+				return 0, false, err
+			}
+			err = ctx.GetStub().DelState(indexKey)
+			if err != nil {
+				return 0, false, fmt.Errorf("failed to remove friend request from index: %v", err)
+			}
+		}
+		moved++
+	}
+	return moved, resultsIterator.HasNext(), nil
+}
+
+// copyState copies the value of objectType~from to objectType~to, if there is one
+func copyState(ctx contractapi.TransactionContextInterface, objectType string, from string, to string) error {
+	fromKey, err := entityKey(ctx, objectType, from)
+	if err != nil {
+		return err
+	}
+	value, err := ctx.GetStub().GetState(fromKey)
+	if err != nil {
+		return fmt.Errorf("failed to read %s of %s: %v", objectType, from, err)
+	}
+	if value == nil {
+		return nil
+	}
+	toKey, err := entityKey(ctx, objectType, to)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(toKey, value)
+	if err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %v", objectType, to, err)
+	}
+	return nil
+}
+
+// RotateUserKey replaces the key a user signs with, for when the old key has
+// leaked or is being retired. The call is signed by both keys over the same
+// payload and nonce, proving the caller holds each of them.
+//
+// The user's profile, handle and phone number move to the new key at once, and
+// the old key forwards to it: GetUser, GetPostsByUser and GetFriendsByUser
+// accept either, and mentions, blocks, reactions, comments and shares that
+// name the old key still count as the user's. The old key can no longer sign.
+// The user's posts, friends, follows, blocks, group memberships, followed
+// hashtags and friend requests are then moved in batches, the first of which
+// runs here; the rest are moved by ContinueKeyRotation. Chats stay under the
+// keys they began with and are found from the new key by GetChat and
+// AddMessage, which read the user's retired keys from the chat collection.
+func (s *SmartContract) RotateUserKey(ctx contractapi.TransactionContextInterface, publicKey string, newPublicKey string, nonce string, signature string, newSignature string) (*User, error) {
+	args := []string{publicKey, newPublicKey}
+	err := s.verifyUserSignature(ctx, publicKey, "RotateUserKey", args, nonce, signature)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := canonicalPayload("RotateUserKey", args, nonce)
+	if err != nil {
+		return nil, err
+	}
+	err = verifySignature(payload, newSignature, newPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature of the new key: %v", err)
+	}
+
+	// The new key must be unused, including as a retired key of any user
+	if newPublicKey == publicKey {
+		return nil, fmt.Errorf("the new key must differ from the current key")
+	}
+	exists, err := s.UserExists(ctx, newPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	current, err := resolveUserKey(ctx, newPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if exists || current != newPublicKey {
+		return nil, fmt.Errorf("public key %s is already in use", newPublicKey)
+	}
+
+	// The records of the previous rotation are moved from a single key at a time
+	previous, err := getKeyRotation(ctx, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if previous != nil && !previous.Done {
+		return nil, fmt.Errorf("the records of the previous key rotation are still being moved; call ContinueKeyRotation first")
+	}
+
+	user, err := s.GetUser(ctx, publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// The phone number moves within the user collection
+	phone, err := s.getPhone(ctx, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if phone != "" {
+		err = s.savePhone(ctx, newPublicKey, phone)
+		if err != nil {
+			return nil, err
+		}
+		phoneKey, err := entityKey(ctx, phoneObjectType, publicKey)
+		if err != nil {
+			return nil, err
+		}
+		err = ctx.GetStub().DelPrivateData(userPrivateCollection, phoneKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to remove phone number: %v", err)
+		}
+	}
+
+	// Users migrated from the legacy keyspace with a clashing name have no handle
+	if user.Handle != "" {
+		handleKey, err := entityKey(ctx, handleObjectType, user.Handle)
+		if err != nil {
+			return nil, err
+		}
+		err = ctx.GetStub().PutState(handleKey, []byte(newPublicKey))
+		if err != nil {
+			return nil, fmt.Errorf("failed to move handle %s: %v", user.Handle, err)
+		}
+	}
+
+	// The post and friends lists are copied whole, so they are complete under
+	// the new key straight away; the old copies are worked through by the batches
+	for _, objectType := range []string{userPostsObjectType, friendsObjectType} {
+		err = copyState(ctx, objectType, publicKey, newPublicKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Every retired key forwards straight to the current one
+	user.PublicKey = newPublicKey
+	user.PreviousKeys = append(user.PreviousKeys, publicKey)
+	for _, previousKey := range user.PreviousKeys {
+		forwardKey, err := entityKey(ctx, keyForwardObjectType, previousKey)
+		if err != nil {
+			return nil, err
+		}
+		err = ctx.GetStub().PutState(forwardKey, []byte(newPublicKey))
+		if err != nil {
+			return nil, fmt.Errorf("failed to forward key %s: %v", previousKey, err)
+		}
+	}
+	err = saveKeyHistory(ctx, newPublicKey, user.PreviousKeys)
+	if err != nil {
+		return nil, err
+	}
+	oldHistoryKey, err := entityKey(ctx, keyHistoryObjectType, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	err = ctx.GetStub().DelPrivateData(chatPrivateCollection, oldHistoryKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove key history: %v", err)
+	}
+
+	oldUserKey, err := entityKey(ctx, userObjectType, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	err = ctx.GetStub().DelState(oldUserKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove user data: %v", err)
+	}
+	user.Phone = ""
+	err = s.saveUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.runKeyRotation(ctx, &KeyRotation{OldPublicKey: publicKey, NewPublicKey: newPublicKey}, defaultKeyRotationBatchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	err = emitEvent(ctx, eventUserKeyRotated, KeyRotationEvent{OldPublicKey: publicKey, NewPublicKey: newPublicKey, Handle: user.Handle})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("User %s rotated key to %s", publicKey, newPublicKey)
+	return user, nil
+}
+
+// ContinueKeyRotation moves the next batchSize records of the rotation that
+// made publicKey the user's key, signed with that key. Until the returned
+// progress reports Done, some of the user's records are still found under the
+// old key. Calling it once the rotation is done changes nothing.
+func (s *SmartContract) ContinueKeyRotation(ctx contractapi.TransactionContextInterface, publicKey string, batchSize int, nonce string, signature string) (*KeyRotation, error) {
+	err := s.verifyUserSignature(ctx, publicKey, "ContinueKeyRotation", []string{publicKey, strconv.Itoa(batchSize)}, nonce, signature)
+	if err != nil {
+		return nil, err
+	}
+
+	rotation, err := getKeyRotation(ctx, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if rotation == nil {
+		return nil, fmt.Errorf("public key %s did not replace another key", publicKey)
+	}
+	if rotation.Done {
+		return rotation, nil
+	}
+
+	err = s.runKeyRotation(ctx, rotation, batchSize)
+	if err != nil {
+		return nil, err
+	}
+	return rotation, nil
+}
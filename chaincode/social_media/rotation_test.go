@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+func TestKeyRotationAcrossBatches(t *testing.T) {
+	contract := new(SmartContract)
+	stub := newFakeStub()
+	alice := registerTestUser(t, contract, stub, "alice")
+
+	// More records than RotateUserKey moves in its own batch
+	const postCount, hashtagCount = 70, 40
+	for i := 0; i < postCount; i++ {
+		createTestPost(t, contract, stub, alice, fmt.Sprintf("Qm%d", i))
+	}
+	for i := 0; i < hashtagCount; i++ {
+		hashtag := fmt.Sprintf("tag%d", i)
+		nonce, signature := alice.sign(t, "FollowHashtag", alice.publicKey, hashtag)
+		err := contract.FollowHashtag(stub.newContext(), alice.publicKey, hashtag, nonce, signature)
+		if err != nil {
+			t.Fatalf("failed to follow %s: %v", hashtag, err)
+		}
+	}
+
+	newAlice := newTestUser(t)
+	rotateArgs := []string{alice.publicKey, newAlice.publicKey}
+	nonce, signature := alice.sign(t, "RotateUserKey", rotateArgs...)
+	newSignature := newAlice.signWithNonce(t, "RotateUserKey", rotateArgs, nonce)
+	_, err := contract.RotateUserKey(stub.newContext(), alice.publicKey, newAlice.publicKey, nonce, signature, newSignature)
+	if err != nil {
+		t.Fatalf("failed to rotate key: %v", err)
+	}
+
+	rotation, err := getKeyRotation(stub.newContext(), newAlice.publicKey)
+	if err != nil {
+		t.Fatalf("failed to read key rotation: %v", err)
+	}
+	if rotation.Done || rotation.Moved != defaultKeyRotationBatchSize {
+		t.Fatalf("first batch moved %d records (done %t), want %d", rotation.Moved, rotation.Done, defaultKeyRotationBatchSize)
+	}
+
+	// A second rotation waits for the records of the first
+	nextAlice := newTestUser(t)
+	rotateArgs = []string{newAlice.publicKey, nextAlice.publicKey}
+	nonce, signature = newAlice.sign(t, "RotateUserKey", rotateArgs...)
+	newSignature = nextAlice.signWithNonce(t, "RotateUserKey", rotateArgs, nonce)
+	_, err = contract.RotateUserKey(stub.newContext(), newAlice.publicKey, nextAlice.publicKey, nonce, signature, newSignature)
+	if err == nil {
+		t.Fatalf("rotated again before the previous rotation was done")
+	}
+
+	for batches := 0; !rotation.Done; batches++ {
+		if batches > postCount+hashtagCount {
+			t.Fatalf("key rotation did not finish")
+		}
+		nonce, signature := newAlice.sign(t, "ContinueKeyRotation", newAlice.publicKey, strconv.Itoa(3))
+		rotation, err = contract.ContinueKeyRotation(stub.newContext(), newAlice.publicKey, 3, nonce, signature)
+		if err != nil {
+			t.Fatalf("failed to continue key rotation: %v", err)
+		}
+	}
+	if rotation.Moved != postCount+hashtagCount {
+		t.Fatalf("rotation moved %d records, want %d", rotation.Moved, postCount+hashtagCount)
+	}
+
+	// Every record is found under the new key and none under the old one
+	for i := 0; i < postCount; i++ {
+		post, err := contract.getPost(stub.newContext(), fmt.Sprintf("Qm%d", i))
+		if err != nil {
+			t.Fatalf("failed to read post %d: %v", i, err)
+		}
+		if post.UserPublicKey != newAlice.publicKey {
+			t.Fatalf("post %d is still under the old key", i)
+		}
+	}
+	page, err := contract.GetPostsByUserWithPagination(stub.newContext(), newAlice.publicKey, maxPageSize, "")
+	if err != nil {
+		t.Fatalf("failed to list posts: %v", err)
+	}
+	if len(page.Posts) != postCount {
+		t.Fatalf("new key lists %d posts, want %d", len(page.Posts), postCount)
+	}
+	hashtags, err := contract.GetFollowedHashtags(stub.newContext(), newAlice.publicKey)
+	if err != nil {
+		t.Fatalf("failed to list followed hashtags: %v", err)
+	}
+	if len(hashtags) != hashtagCount {
+		t.Fatalf("new key follows %d hashtags, want %d", len(hashtags), hashtagCount)
+	}
+	for _, objectType := range []string{userPostObjectType, hashtagFollowObjectType} {
+		iterator, err := stub.GetStateByPartialCompositeKey(objectType, []string{alice.publicKey})
+		if err != nil {
+			t.Fatalf("failed to read %s index: %v", objectType, err)
+		}
+		if iterator.HasNext() {
+			t.Fatalf("old key still has %s entries", objectType)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Share records that a user reshared a post. The reshare is itself a post,
+// stored under ShareCID, whose IPFS document holds the optional quote.
+type Share struct {
+	OriginalCID     string `json:"originalCID"`
+	ShareCID        string `json:"shareCID"`
+	SharerPublicKey string `json:"sharerPublicKey"`
+	Timestamp       int64  `json:"timestamp"`
+}
+
+// SharePage is one page of the shares of a post
+type SharePage struct {
+	Shares   []*Share `json:"shares"`
+	Bookmark string   `json:"bookmark"`
+}
+
+// SharePost reshares a post, signed with the sharer's key. shareCID is the IPFS
+// document of the reshare; it is listed with the sharer's own posts and credits
+// the original. Resharing a reshare credits the post it was shared from. The
+// reshare's post ID is the ID of the transaction, and is returned.
+func (s *SmartContract) SharePost(ctx contractapi.TransactionContextInterface, publicKey string, originalCID string, shareCID string, nonce string, signature string) (string, error) {
+	err := s.verifyUserSignature(ctx, publicKey, "SharePost", []string{publicKey, originalCID, shareCID}, nonce, signature)
+	if err != nil {
+		return "", err
+	}
+
+	original, err := s.getPost(ctx, originalCID)
+	if err != nil {
+		return "", err
+	}
+	if original.SharedPostCID != "" {
+		original, err = s.getPost(ctx, original.SharedPostCID)
+		if err != nil {
+			return "", err
+		}
+	}
+	if original.Deleted {
+		return "", fmt.Errorf("post %s has been deleted", original.ContentCID)
+	}
+	if original.Hidden {
+		return "", fmt.Errorf("post %s has been hidden by moderators", original.ContentCID)
+	}
+
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return "", err
+	}
+	reshare := &Post{
+		ID:            ctx.GetStub().GetTxID(),
+		UserPublicKey: publicKey,
+		ContentCID:    shareCID,
+		Timestamp:     timestamp,
+		Reactions:     make(map[string]string),
+		SharedPostCID: original.ContentCID,
+	}
+	err = s.storeNewPost(ctx, reshare)
+	if err != nil {
+		return "", err
+	}
+
+	share := Share{
+		OriginalCID:     original.ContentCID,
+		ShareCID:        shareCID,
+		SharerPublicKey: publicKey,
+		Timestamp:       timestamp,
+	}
+	shareJSON, err := json.Marshal(share)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal share: %v", err)
+	}
+	shareKey, err := entityKey(ctx, shareObjectType, original.ContentCID, fmt.Sprintf("%019d", timestamp), shareCID)
+	if err != nil {
+		return "", err
+	}
+	err = ctx.GetStub().PutState(shareKey, shareJSON)
+	if err != nil {
+		return "", fmt.Errorf("failed to store share: %v", err)
+	}
+
+	// Counted apart from the original's record, which many users may share at once
+	err = addToCounter(ctx, counterObjectType, original.ContentCID, shareCounter, publicKey, 1)
+	if err != nil {
+		return "", err
+	}
+
+	err = emitEvent(ctx, eventPostShared, PostEvent{
+		PostID:          reshare.ID,
+		PostCID:         shareCID,
+		AuthorPublicKey: publicKey,
+		ContentCID:      shareCID,
+		SharedPostCID:   original.ContentCID,
+		OriginalAuthor:  original.UserPublicKey,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("User %s shared post %s as %s", publicKey, original.ContentCID, shareCID)
+	return reshare.ID, nil
+}
+
+// GetPostShares returns one page of the shares of a post, oldest first
+func (s *SmartContract) GetPostShares(ctx contractapi.TransactionContextInterface, postID string, pageSize int32, bookmark string) (*SharePage, error) {
+	pageSize = normalizePageSize(pageSize)
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(shareObjectType, []string{postID}, pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get shares: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	page := &SharePage{Shares: []*Share{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate shares: %v", err)
+		}
+
+		var share Share
+		err = json.Unmarshal(queryResponse.Value, &share)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal share: %v", err)
+		}
+		// Shares stay under the key they were made with
+		share.SharerPublicKey, err = resolveUserKey(ctx, share.SharerPublicKey)
+		if err != nil {
+			return nil, err
+		}
+		page.Shares = append(page.Shares, &share)
+	}
+
+	page.Bookmark = nextBookmark(metadata.FetchedRecordsCount, metadata.Bookmark, pageSize)
+	return page, nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// maxNonceLength bounds the size of the replay-protection keys kept on the ledger
+const maxNonceLength = 128
+
+// canonicalPayload builds the exact bytes a user signs for a transaction:
+// a JSON array of the function name, its arguments in order and the nonce
+func canonicalPayload(function string, args []string, nonce string) ([]byte, error) {
+	fields := make([]string, 0, len(args)+2)
+	fields = append(fields, function)
+	fields = append(fields, args...)
+	fields = append(fields, nonce)
+
+	payload, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode signed payload: %v", err)
+	}
+	return payload, nil
+}
+
+// verifySignature checks an "r,s" encoded ECDSA P-256 signature over the SHA-256
+// hash of payload against a hex encoded PKIX public key
+func verifySignature(payload []byte, signature string, publicKeyHex string) error {
+	pubKeyBytes, err := hex.DecodeString(publicKeyHex)
+	if err != nil {
+		return fmt.Errorf("invalid public key encoding: %v", err)
+	}
+
+	pubKey, err := x509.ParsePKIXPublicKey(pubKeyBytes)
+	if err != nil {
+		return fmt.Errorf("invalid public key format: %v", err)
+	}
+
+	ecdsaPubKey, ok := pubKey.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("invalid public key type")
+	}
+
+	// The signature is encoded the same way the backend's SignMessage produces it
+	parts := strings.Split(signature, ",")
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid signature format")
+	}
+	r, ok := new(big.Int).SetString(parts[0], 10)
+	if !ok {
+		return fmt.Errorf("invalid signature format")
+	}
+	sig, ok := new(big.Int).SetString(parts[1], 10)
+	if !ok {
+		return fmt.Errorf("invalid signature format")
+	}
+
+	hash := sha256.Sum256(payload)
+	if !ecdsa.Verify(ecdsaPubKey, hash[:], r, sig) {
+		return fmt.Errorf("signature verification failed")
+	}
+	return nil
+}
+
+// verifyUserSignature authenticates a write made on behalf of publicKey. The
+// signature must cover the function name, its arguments and the nonce, and each
+// nonce is accepted only once per user so a signed call cannot be replayed.
+// Suspended and deactivated users and retired keys cannot make signed writes.
+func (s *SmartContract) verifyUserSignature(ctx contractapi.TransactionContextInterface, publicKey string, function string, args []string, nonce string, signature string) error {
+	err := s.checkSigner(ctx, publicKey)
+	if err != nil {
+		return err
+	}
+	return verifySignedCall(ctx, publicKey, function, args, nonce, signature)
+}
+
+// verifyPrivateUserSignature is verifyUserSignature for calls that only write
+// to a private data collection. The nonce is recorded in that collection
+// rather than in the world state, so the call leaves no public key that names
+// the signer.
+func (s *SmartContract) verifyPrivateUserSignature(ctx contractapi.TransactionContextInterface, collection string, publicKey string, function string, args []string, nonce string, signature string) error {
+	err := s.checkSigner(ctx, publicKey)
+	if err != nil {
+		return err
+	}
+	err = checkSignedCall(publicKey, function, args, nonce, signature)
+	if err != nil {
+		return err
+	}
+	return recordNonce(ctx, collection, publicKey, nonce)
+}
+
+// checkSigner fails unless publicKey is the current key of a user who may make
+// signed writes
+func (s *SmartContract) checkSigner(ctx contractapi.TransactionContextInterface, publicKey string) error {
+	// Keys retired by RotateUserKey can no longer sign
+	current, err := resolveUserKey(ctx, publicKey)
+	if err != nil {
+		return err
+	}
+	if current != publicKey {
+		return fmt.Errorf("public key %s has been replaced by %s", publicKey, current)
+	}
+
+	// The signing key is the one registered for the acting user, who must not
+	// have been suspended by a moderator or have deactivated their account
+	suspended, err := s.isSuspended(ctx, publicKey)
+	if err != nil {
+		return err
+	}
+	if suspended {
+		return fmt.Errorf("user %s is suspended", publicKey)
+	}
+	deactivated, err := s.isDeactivated(ctx, publicKey)
+	if err != nil {
+		return err
+	}
+	if deactivated {
+		return fmt.Errorf("user %s has deactivated their account", publicKey)
+	}
+	return nil
+}
+
+// verifySignedCall checks the signature of publicKey over a call and records
+// its nonce, without regard to the state of the user's account
+func verifySignedCall(ctx contractapi.TransactionContextInterface, publicKey string, function string, args []string, nonce string, signature string) error {
+	err := checkSignedCall(publicKey, function, args, nonce, signature)
+	if err != nil {
+		return err
+	}
+	return recordNonce(ctx, "", publicKey, nonce)
+}
+
+// checkSignedCall checks the signature of publicKey over a call and its nonce
+func checkSignedCall(publicKey string, function string, args []string, nonce string, signature string) error {
+	if nonce == "" || signature == "" {
+		return fmt.Errorf("nonce and signature are required")
+	}
+	if len(nonce) > maxNonceLength {
+		return fmt.Errorf("nonce must be at most %d characters", maxNonceLength)
+	}
+
+	payload, err := canonicalPayload(function, args, nonce)
+	if err != nil {
+		return err
+	}
+	if err := verifySignature(payload, signature, publicKey); err != nil {
+		return fmt.Errorf("invalid signature for %s: %v", function, err)
+	}
+	return nil
+}
+
+// recordNonce rejects replays of a previously accepted signature and records
+// its nonce, in the world state or, if collection is set, in that collection
+func recordNonce(ctx contractapi.TransactionContextInterface, collection string, publicKey string, nonce string) error {
+	nonceKey, err := entityKey(ctx, nonceObjectType, publicKey, nonce)
+	if err != nil {
+		return err
+	}
+	var used []byte
+	if collection == "" {
+		used, err = ctx.GetStub().GetState(nonceKey)
+	} else {
+		used, err = ctx.GetStub().GetPrivateData(collection, nonceKey)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read nonce: %v", err)
+	}
+	if used != nil {
+		return fmt.Errorf("nonce %s has already been used", nonce)
+	}
+
+	if collection == "" {
+		err = ctx.GetStub().PutState(nonceKey, indexMarker)
+	} else {
+		err = ctx.GetStub().PutPrivateData(collection, nonceKey, indexMarker)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record nonce: %v", err)
+	}
+	return nil
+}
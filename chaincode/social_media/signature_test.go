@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVerifyUserSignature(t *testing.T) {
+	contract := new(SmartContract)
+	stub := newFakeStub()
+	alice := registerTestUser(t, contract, stub, "alice")
+	mallory := newTestUser(t)
+
+	args := []string{alice.publicKey, "golang"}
+	nonce, signature := alice.sign(t, "FollowHashtag", args...)
+	err := contract.verifyUserSignature(stub.newContext(), alice.publicKey, "FollowHashtag", args, nonce, signature)
+	if err != nil {
+		t.Fatalf("valid signature rejected: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		function  string
+		args      []string
+		nonce     string
+		signature string
+	}{
+		{"other key", "FollowHashtag", args, "nonce-other", mallory.signWithNonce(t, "FollowHashtag", args, "nonce-other")},
+		{"other function", "UnfollowHashtag", args, "nonce-function", alice.signWithNonce(t, "FollowHashtag", args, "nonce-function")},
+		{"other arguments", "FollowHashtag", []string{alice.publicKey, "rust"}, "nonce-args", alice.signWithNonce(t, "FollowHashtag", args, "nonce-args")},
+		{"other nonce", "FollowHashtag", args, "nonce-changed", alice.signWithNonce(t, "FollowHashtag", args, "nonce-signed")},
+		{"malformed", "FollowHashtag", args, "nonce-malformed", "not a signature"},
+		{"missing", "FollowHashtag", args, "nonce-missing", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := contract.verifyUserSignature(stub.newContext(), alice.publicKey, test.function, test.args, test.nonce, test.signature)
+			if err == nil {
+				t.Fatalf("bad signature accepted")
+			}
+		})
+	}
+}
+
+func TestVerifyUserSignatureRejectsReplay(t *testing.T) {
+	contract := new(SmartContract)
+	stub := newFakeStub()
+	alice := registerTestUser(t, contract, stub, "alice")
+
+	args := []string{alice.publicKey, "golang"}
+	nonce, signature := alice.sign(t, "FollowHashtag", args...)
+	err := contract.verifyUserSignature(stub.newContext(), alice.publicKey, "FollowHashtag", args, nonce, signature)
+	if err != nil {
+		t.Fatalf("valid signature rejected: %v", err)
+	}
+
+	err = contract.verifyUserSignature(stub.newContext(), alice.publicKey, "FollowHashtag", args, nonce, signature)
+	if err == nil || !strings.Contains(err.Error(), "already been used") {
+		t.Fatalf("replayed nonce: got error %v", err)
+	}
+
+	// The nonce is spent for every call, not only the one first signed with it
+	otherArgs := []string{alice.publicKey, "rust"}
+	signature = alice.signWithNonce(t, "FollowHashtag", otherArgs, nonce)
+	err = contract.verifyUserSignature(stub.newContext(), alice.publicKey, "FollowHashtag", otherArgs, nonce, signature)
+	if err == nil || !strings.Contains(err.Error(), "already been used") {
+		t.Fatalf("reused nonce: got error %v", err)
+	}
+}
+
+func TestVerifyUserSignatureRejectsRotatedKey(t *testing.T) {
+	contract := new(SmartContract)
+	stub := newFakeStub()
+	alice := registerTestUser(t, contract, stub, "alice")
+	newAlice := newTestUser(t)
+
+	rotateArgs := []string{alice.publicKey, newAlice.publicKey}
+	nonce, signature := alice.sign(t, "RotateUserKey", rotateArgs...)
+	newSignature := newAlice.signWithNonce(t, "RotateUserKey", rotateArgs, nonce)
+	_, err := contract.RotateUserKey(stub.newContext(), alice.publicKey, newAlice.publicKey, nonce, signature, newSignature)
+	if err != nil {
+		t.Fatalf("failed to rotate key: %v", err)
+	}
+
+	args := []string{alice.publicKey, "golang"}
+	nonce, signature = alice.sign(t, "FollowHashtag", args...)
+	err = contract.verifyUserSignature(stub.newContext(), alice.publicKey, "FollowHashtag", args, nonce, signature)
+	if err == nil || !strings.Contains(err.Error(), "has been replaced") {
+		t.Fatalf("retired key: got error %v", err)
+	}
+
+	args = []string{newAlice.publicKey, "golang"}
+	nonce, signature = newAlice.sign(t, "FollowHashtag", args...)
+	err = contract.verifyUserSignature(stub.newContext(), newAlice.publicKey, "FollowHashtag", args, nonce, signature)
+	if err != nil {
+		t.Fatalf("new key rejected: %v", err)
+	}
+}
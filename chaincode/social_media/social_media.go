@@ -5,7 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"time"
+	"strings"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
@@ -13,52 +13,86 @@ import (
 // User struct defines the user structure
 // User represents the user structure in the application (including public/private keys)
 type User struct {
-	Name      string `json:"name"`
-	Phone     string `json:"phone"`
-	PublicKey string `json:"publicKey"`
+	Name          string   `json:"name"`
+	Handle        string   `json:"handle"`          // Normalized, unique form of Name
+	Phone         string   `json:"phone,omitempty"` // Kept in userPrivateCollection; only returned to the owner
+	PublicKey     string   `json:"publicKey"`
+	DisplayName   string   `json:"displayName,omitempty"` // Free-form name shown on the profile; defaults to Name
+	Bio           string   `json:"bio,omitempty"`
+	Links         []string `json:"links,omitempty"`
+	AvatarCID     string   `json:"avatarCID,omitempty"`
+	CoverCID      string   `json:"coverCID,omitempty"`
+	Suspended     bool     `json:"suspended,omitempty"`    // Set by moderators; suspended users cannot make signed writes
+	PreviousKeys  []string `json:"previousKeys,omitempty"` // Keys replaced by RotateUserKey, oldest first
+	Deactivated   bool     `json:"deactivated,omitempty"`  // Set by DeactivateUser, which moves the fields above to userPrivateCollection until ReactivateUser
+	DeactivatedAt int64    `json:"deactivatedAt,omitempty"`
+	Erased        bool     `json:"erased,omitempty"` // Set by EraseUser, which removes the fields above
 }
 
 type Post struct {
-	ID            string            `json:"id"`
-	UserPublicKey string            `json:"userPublicKey"`
-	ContentCID    string            `json:"contentCID"`
-	Timestamp     int64             `json:"timestamp"`
-	Reactions     map[string]string `json:"reactions"`
-	ReactionCount int               `json:"reactionCount"`
-	ShareCount    int               `json:"shareCount"`
+	ID               string            `json:"id"`
+	UserPublicKey    string            `json:"userPublicKey"`
+	ContentCID       string            `json:"contentCID"`
+	Timestamp        int64             `json:"timestamp"`
+	Reactions        map[string]string `json:"reactions"`     // Legacy; reactions are kept under their own keys
+	ReactionCount    int               `json:"reactionCount"` // Legacy; see GetPostReactions
+	ShareCount       int               `json:"shareCount"`    // Stored value predates the counters; see GetPost
+	CommentCount     int               `json:"commentCount"`  // Stored value predates the counters; see GetPost
+	CommentsDisabled bool              `json:"commentsDisabled"`
+	SharedPostCID    string            `json:"sharedPostCID"`      // Set on reshares to the original post
+	Versions         []PostVersion     `json:"versions"`           // Content history, oldest first; empty until the first edit
+	Hashtags         []string          `json:"hashtags,omitempty"` // Normalized, without the leading '#'
+	Mentions         []string          `json:"mentions,omitempty"` // Public keys of the mentioned users
+	ReportCount      int               `json:"reportCount"`        // Reports since the post was last reviewed; see GetPost
+	Hidden           bool              `json:"hidden"`             // Hidden by moderators, or by reports pending review
+	Deleted          bool              `json:"deleted"`
 }
 
 // Message represents a chat message structure
 // Message structure
 type Message struct {
+	Seq       int    `json:"seq"` // Position of the message in its chat, from 1
 	IPFSHash  string `json:"ipfsHash"`
 	Signature string `json:"signature"`
 	Sender    string `json:"sender"`
 	Receiver  string `json:"receiver"`
 	Timestamp string `json:"timestamp"`
 }
+
+// Chat is the header of a chat between two users. Its messages are stored
+// separately under chatmessage~chatID~seq.
 type Chat struct {
+	ChatID       string    `json:"chatId"`
 	Participants [2]string `json:"participants"` // Public keys of the two participants
-	Messages     []Message `json:"messages"`     // List of messages exchanged
+	LastSeq      int       `json:"lastSeq"`      // Sequence number of the newest message
 }
 
 // Group represents a group structure in the blockchain
 type Group struct {
-	ID        string   `json:"id"`
-	GroupName string   `json:"groupname"`
-	Members   []string `json:"members"`
+	ID        string            `json:"id"`
+	GroupName string            `json:"groupname"`
+	Owner     string            `json:"owner"`
+	Members   []string          `json:"members"` // Public keys, in the order members joined
+	Roles     map[string]string `json:"roles"`   // Role of each member by public key
 }
 
+// GroupMessage is a message sent to a group. IPFSHash points to the message
+// encrypted separately for each recipient.
 type GroupMessage struct {
+	Seq       int      `json:"seq"` // Position of the message in the group's chat, from 1
 	IPFSHash  string   `json:"ipfsHash"`
 	Signature string   `json:"signature"`
 	Sender    string   `json:"sender"`
-	Receiver  []string `json:"receiver"`
+	Receiver  []string `json:"receiver"` // Members of the group when the message was sent
 	Timestamp string   `json:"timestamp"`
 }
+
+// GroupChat is the header of a group's chat. The messages are stored under
+// their own keys.
 type GroupChat struct {
-	Participants  []string       `json:"participants"`  // Public keys of the two participants
-	GroupMessages []GroupMessage `json:"groupmessages"` // List of messages exchanged
+	GroupID      string   `json:"groupId"`
+	Participants []string `json:"participants"` // Public keys of the members who received the latest message
+	LastSeq      int      `json:"lastSeq"`      // Sequence number of the latest message
 }
 
 // SmartContract defines the chaincode structure
@@ -75,12 +109,14 @@ type FriendRequest struct {
 	SenderName   string `json:"senderName"`
 	Receiver     string `json:"receiver"`
 	ReceiverName string `json:"receiverName"`
-	Status       string `json:"status"` // "pending", "accepted", "rejected"
+	Status       string `json:"status"` // "pending", "accepted", "rejected", "cancelled" or "expired"
 	Timestamp    int64  `json:"timestamp"`
+	RespondedAt  int64  `json:"respondedAt,omitempty"` // When the request was accepted, rejected or cancelled
 }
 
-// RegisterUser registers a user with their public key and stores user data
-func (s *SmartContract) RegisterUser(ctx contractapi.TransactionContextInterface, name string, phone string, publicKey string) error {
+// RegisterUser registers a user with their public key and stores user data.
+// The phone number, if any, is passed in the transient map under "phone".
+func (s *SmartContract) RegisterUser(ctx contractapi.TransactionContextInterface, name string, publicKey string) error {
 	// Check if user already exists
 	userExists, err := s.UserExists(ctx, publicKey)
 	if err != nil {
@@ -90,10 +126,33 @@ func (s *SmartContract) RegisterUser(ctx contractapi.TransactionContextInterface
 		return fmt.Errorf("user with public key %s already exists", publicKey)
 	}
 
+	// The name doubles as the user's handle, which must be unique
+	handle, err := normalizeHandle(name)
+	if err != nil {
+		return err
+	}
+	err = s.reserveHandle(ctx, handle, publicKey)
+	if err != nil {
+		return err
+	}
+
+	// The phone number is passed in the transient map and kept in the user
+	// collection, so it never appears in the transaction or public state
+	phone, err := transientField(ctx, transientPhone)
+	if err != nil {
+		return err
+	}
+	if len(phone) > 0 {
+		err = s.savePhone(ctx, publicKey, string(phone))
+		if err != nil {
+			return err
+		}
+	}
+
 	// Create a new user object
 	user := User{
-		Name:      name,
-		Phone:     phone,
+		Name:      strings.TrimSpace(name),
+		Handle:    handle,
 		PublicKey: publicKey,
 	}
 
@@ -104,17 +163,29 @@ func (s *SmartContract) RegisterUser(ctx contractapi.TransactionContextInterface
 	}
 
 	// Store the user data on the ledger
-	err = ctx.GetStub().PutState(publicKey, userJSON)
+	userKey, err := entityKey(ctx, userObjectType, publicKey)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(userKey, userJSON)
 	if err != nil {
 		return fmt.Errorf("failed to store user data on ledger: %v", err)
 	}
 
 	// Successfully stored the user
-	return nil
+	return emitEvent(ctx, eventUserRegistered, UserEvent{PublicKey: publicKey, Name: user.Name, Handle: handle})
 }
 
-// GetUser retrieves a user's data based on their public key
+// GetUser retrieves a user's data based on their public key, or a key they have
+// since replaced. The phone number is included only when the owner signs the
+// read, see isOwnerRead. Only the public key of a deactivated account is
+// returned.
 func (s *SmartContract) GetUser(ctx contractapi.TransactionContextInterface, publicKey string) (*User, error) {
+	publicKey, err := resolveUserKey(ctx, publicKey)
+	if err != nil {
+		return nil, err
+	}
+
 	// Check if user exists
 	userExists, err := s.UserExists(ctx, publicKey)
 	if err != nil {
@@ -125,7 +196,11 @@ func (s *SmartContract) GetUser(ctx contractapi.TransactionContextInterface, pub
 	}
 
 	// Retrieve the user data from the ledger
-	userJSON, err := ctx.GetStub().GetState(publicKey)
+	userKey, err := entityKey(ctx, userObjectType, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	userJSON, err := ctx.GetStub().GetState(userKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user data: %v", err)
 	}
@@ -139,39 +214,118 @@ func (s *SmartContract) GetUser(ctx contractapi.TransactionContextInterface, pub
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal user data: %v", err)
 	}
+	if user.Deactivated {
+		return hiddenUser(&user), nil
+	}
+
+	// The phone number is only returned to its owner
+	user.Phone = ""
+	owner, err := s.isOwnerRead(ctx, "GetUser", publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if owner {
+		user.Phone, err = s.getPhone(ctx, publicKey)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	return &user, nil
 }
 
 // UserExists checks whether a user already exists on the ledger by their public key
 func (s *SmartContract) UserExists(ctx contractapi.TransactionContextInterface, publicKey string) (bool, error) {
-	userJSON, err := ctx.GetStub().GetState(publicKey)
+	userKey, err := entityKey(ctx, userObjectType, publicKey)
+	if err != nil {
+		return false, err
+	}
+	userJSON, err := ctx.GetStub().GetState(userKey)
 	if err != nil {
 		return false, fmt.Errorf("failed to read user data for public key %s: %v", publicKey, err)
 	}
 	return userJSON != nil, nil
 }
 
-func (s *SmartContract) CreatePost(ctx contractapi.TransactionContextInterface, publicKey string, ipfsHash string, postID string) error {
-	// Check if the user exists
-	userBytes, err := ctx.GetStub().GetState(publicKey)
+// saveUser writes an updated user record back under its public key
+func (s *SmartContract) saveUser(ctx contractapi.TransactionContextInterface, user *User) error {
+	userJSON, err := json.Marshal(user)
 	if err != nil {
-		return fmt.Errorf("failed to read user data: %v", err)
+		return fmt.Errorf("failed to marshal user data: %v", err)
 	}
-	if userBytes == nil {
-		return fmt.Errorf("user does not exist: %s", publicKey)
+	userKey, err := entityKey(ctx, userObjectType, user.PublicKey)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(userKey, userJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store user data on ledger: %v", err)
+	}
+	return nil
+}
+
+// CreatePost stores a post authored by publicKey, signed with the author's key.
+// The post is listed under its hashtags and under the users whose handles it
+// mentions. The post's ID is the ID of the transaction, and is returned.
+func (s *SmartContract) CreatePost(ctx contractapi.TransactionContextInterface, publicKey string, ipfsHash string, hashtags []string, mentions []string, nonce string, signature string) (string, error) {
+	hashtagsJSON, err := json.Marshal(hashtags)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize hashtags: %v", err)
+	}
+	mentionsJSON, err := json.Marshal(mentions)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize mentions: %v", err)
+	}
+	// Authenticate the author, which also checks that the user exists
+	err = s.verifyUserSignature(ctx, publicKey, "CreatePost", []string{publicKey, ipfsHash, string(hashtagsJSON), string(mentionsJSON)}, nonce, signature)
+	if err != nil {
+		return "", err
+	}
+
+	hashtags, err = normalizeHashtags(hashtags)
+	if err != nil {
+		return "", err
+	}
+	mentions, err = s.resolveMentions(ctx, publicKey, mentions)
+	if err != nil {
+		return "", err
+	}
+
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return "", err
 	}
 
 	// Create a new Post struct
 	post := Post{
-		ID:            postID,
+		ID:            ctx.GetStub().GetTxID(),
 		UserPublicKey: publicKey,
 		ContentCID:    ipfsHash,
-		Timestamp:     time.Now().Unix(),
+		Timestamp:     timestamp,
 		Reactions:     make(map[string]string),
 		ReactionCount: 0,
 		ShareCount:    0,
+		Hashtags:      hashtags,
+		Mentions:      mentions,
+	}
+
+	err = s.storeNewPost(ctx, &post)
+	if err != nil {
+		return "", err
+	}
+
+	err = emitEvent(ctx, eventPostCreated, PostEvent{PostID: post.ID, PostCID: ipfsHash, AuthorPublicKey: publicKey, ContentCID: ipfsHash, Mentions: mentions})
+	if err != nil {
+		return "", err
 	}
+	return post.ID, nil
+}
+
+// storeNewPost stores a new post under its IPFS hash and adds it to the author's
+// post list and the indexes used to list posts
+func (s *SmartContract) storeNewPost(ctx contractapi.TransactionContextInterface, post *Post) error {
+	publicKey := post.UserPublicKey
+	ipfsHash := post.ContentCID
 
 	// Serialize the post
 	postJSON, err := json.Marshal(post)
@@ -180,15 +334,26 @@ func (s *SmartContract) CreatePost(ctx contractapi.TransactionContextInterface,
 	}
 
 	// Store the post using the IPFS hash as the key
-	err = ctx.GetStub().PutState(ipfsHash, postJSON)
+	postKey, err := entityKey(ctx, postObjectType, ipfsHash)
+	if err != nil {
+		return err
+	}
+	existingPostJSON, err := ctx.GetStub().GetState(postKey)
+	if err != nil {
+		return fmt.Errorf("failed to read post: %v", err)
+	}
+	if existingPostJSON != nil {
+		return fmt.Errorf("post %s already exists", ipfsHash)
+	}
+	err = ctx.GetStub().PutState(postKey, postJSON)
 	if err != nil {
 		return fmt.Errorf("failed to store post: %v", err)
 	}
 
 	// Create a composite key for posts
-	postsKey, err := ctx.GetStub().CreateCompositeKey("posts", []string{publicKey})
+	postsKey, err := entityKey(ctx, userPostsObjectType, publicKey)
 	if err != nil {
-		return fmt.Errorf("failed to create composite key: %v", err)
+		return err
 	}
 
 	log.Printf("Generated posts composite key: %s", postsKey)
@@ -219,10 +384,28 @@ func (s *SmartContract) CreatePost(ctx contractapi.TransactionContextInterface,
 		return fmt.Errorf("failed to update posts: %v", err)
 	}
 
+	// Index the post by author, newest first, for paginated queries
+	err = s.indexUserPost(ctx, post)
+	if err != nil {
+		return err
+	}
+
+	// Index the post by its ID so it can be found without knowing its IPFS hash
+	err = s.indexPostID(ctx, post)
+	if err != nil {
+		return err
+	}
+
+	// List the post under its hashtags and mentions
+	err = s.indexPostTopics(ctx, post)
+	if err != nil {
+		return err
+	}
+
 	// Create a separate key for all posts (for easier retrieval in GetAllPosts)
-	allPostsKey, err := ctx.GetStub().CreateCompositeKey("allposts", []string{ipfsHash})
+	allPostsKey, err := entityKey(ctx, allPostsObjectType, ipfsHash)
 	if err != nil {
-		return fmt.Errorf("failed to create all posts composite key: %v", err)
+		return err
 	}
 
 	log.Printf("Generated allposts composite key: %s", allPostsKey)
@@ -239,11 +422,35 @@ func (s *SmartContract) CreatePost(ctx contractapi.TransactionContextInterface,
 	return nil
 }
 
-// GetPost retrieves a post by ID
+// GetPost retrieves a post by ID, with its comment, share and report counts
 func (s *SmartContract) GetPost(ctx contractapi.TransactionContextInterface, postID string) (*Post, error) {
+	post, err := s.getPost(ctx, postID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Counts kept on the post record before the counters existed are added to them
+	counts, err := readCounters(ctx, counterObjectType, post.ContentCID)
+	if err != nil {
+		return nil, err
+	}
+	post.CommentCount += counts[commentCounter]
+	post.ShareCount += counts[shareCounter]
+	post.ReportCount += counts[reportCounter]
+	return post, nil
+}
+
+// getPost reads the ledger record of a post. Its counts are the ones stored on
+// the record, without the counters, so transactions that update the post do
+// not read the counters and conflict with every comment, share or report.
+func (s *SmartContract) getPost(ctx contractapi.TransactionContextInterface, postID string) (*Post, error) {
 	log.Printf("Fetching post with ID: %s", postID)
 
-	postJSON, err := ctx.GetStub().GetState(postID)
+	postKey, err := entityKey(ctx, postObjectType, postID)
+	if err != nil {
+		return nil, err
+	}
+	postJSON, err := ctx.GetStub().GetState(postKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read from world state: %v", err)
 	}
@@ -259,8 +466,117 @@ func (s *SmartContract) GetPost(ctx contractapi.TransactionContextInterface, pos
 	return &post, nil
 }
 
+// indexPostID records which IPFS hash a post ID belongs to
+func (s *SmartContract) indexPostID(ctx contractapi.TransactionContextInterface, post *Post) error {
+	indexKey, err := entityKey(ctx, postIDObjectType, post.ID)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(indexKey, []byte(post.ContentCID))
+	if err != nil {
+		return fmt.Errorf("failed to index post %s: %v", post.ID, err)
+	}
+	return nil
+}
+
+// GetPostByID retrieves a post by the ID assigned when it was created, rather
+// than by its IPFS hash
+func (s *SmartContract) GetPostByID(ctx contractapi.TransactionContextInterface, postID string) (*Post, error) {
+	indexKey, err := entityKey(ctx, postIDObjectType, postID)
+	if err != nil {
+		return nil, err
+	}
+	contentCID, err := ctx.GetStub().GetState(indexKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read post index: %v", err)
+	}
+	if contentCID == nil {
+		return nil, fmt.Errorf("post does not exist: %s", postID)
+	}
+	return s.GetPost(ctx, string(contentCID))
+}
+
+// PostView is a post together with the summary of its reactions and, for
+// reshares, the post they credit
+type PostView struct {
+	Post       *Post            `json:"post"`
+	Reactions  *ReactionSummary `json:"reactions"`
+	SharedPost *PostView        `json:"sharedPost,omitempty"` // Left out when the original is hidden or deleted
+}
+
+// GetPostView returns a post by its IPFS hash with everything needed to display
+// it, so clients do not need a query for the reactions and another for the
+// original of a reshare. The reactions include viewerPublicKey's when it is not empty.
+func (s *SmartContract) GetPostView(ctx contractapi.TransactionContextInterface, postID string, viewerPublicKey string) (*PostView, error) {
+	post, err := s.GetPost(ctx, postID)
+	if err != nil {
+		return nil, err
+	}
+	view, err := s.postView(ctx, post, viewerPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if post.SharedPostCID != "" {
+		// Reshares always credit an original post, which is not itself a reshare
+		original, err := s.GetPost(ctx, post.SharedPostCID)
+		if err != nil {
+			return nil, err
+		}
+		if !original.Hidden && !original.Deleted {
+			view.SharedPost, err = s.postView(ctx, original, viewerPublicKey)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return view, nil
+}
+
+// GetPostViewByID is GetPostView for a post given by the ID the ledger assigned it
+func (s *SmartContract) GetPostViewByID(ctx contractapi.TransactionContextInterface, postID string, viewerPublicKey string) (*PostView, error) {
+	indexKey, err := entityKey(ctx, postIDObjectType, postID)
+	if err != nil {
+		return nil, err
+	}
+	contentCID, err := ctx.GetStub().GetState(indexKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read post index: %v", err)
+	}
+	if contentCID == nil {
+		return nil, fmt.Errorf("post does not exist: %s", postID)
+	}
+	return s.GetPostView(ctx, string(contentCID), viewerPublicKey)
+}
+
+// postView pairs a post with the summary of its reactions
+func (s *SmartContract) postView(ctx contractapi.TransactionContextInterface, post *Post, viewerPublicKey string) (*PostView, error) {
+	reactions, err := s.postReactions(ctx, post, viewerPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &PostView{Post: post, Reactions: reactions}, nil
+}
+
+// savePost writes an updated post back under its IPFS hash
+func (s *SmartContract) savePost(ctx contractapi.TransactionContextInterface, post *Post) error {
+	postJSON, err := json.Marshal(post)
+	if err != nil {
+		return fmt.Errorf("failed to marshal post: %v", err)
+	}
+	postKey, err := entityKey(ctx, postObjectType, post.ContentCID)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(postKey, postJSON)
+	if err != nil {
+		return fmt.Errorf("failed to update post %s: %v", post.ContentCID, err)
+	}
+	return nil
+}
+
 func (s *SmartContract) GetAllPosts(ctx contractapi.TransactionContextInterface) (string, error) {
-	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("allposts", []string{})
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(allPostsObjectType, []string{})
 	if err != nil {
 		log.Printf("Failed to get iterator for all posts: %v", err)
 		return "[]", fmt.Errorf("failed to get all posts: %v", err)
@@ -300,56 +616,12 @@ func (s *SmartContract) GetAllPosts(ctx contractapi.TransactionContextInterface)
 	return string(jsonPosts), nil
 }
 
-func (s *SmartContract) AddReaction(ctx contractapi.TransactionContextInterface, postID string, userPublicKey string, reactionType string) (*Post, error) {
-	// Retrieve the existing post state directly using the postID (IPFS hash)
-	existingPostJSON, err := ctx.GetStub().GetState(postID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve post state for postID '%s': %v", postID, err)
-	}
-
-	// Check if the post exists
-	if existingPostJSON == nil {
-		return nil, fmt.Errorf("post with postID '%s' does not exist", postID)
-	}
-	// Deserialize the post JSON into the Post struct
-	var post Post
-	err = json.Unmarshal(existingPostJSON, &post)
-	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal post data for postID '%s': %v", postID, err)
-	}
-
-	// Initialize reactions map if nil
-	if post.Reactions == nil {
-		post.Reactions = make(map[string]string)
-	}
-
-	// Add or update the user's reaction
-	post.Reactions[userPublicKey] = reactionType
-
-	// Update reaction count
-	post.ReactionCount = len(post.Reactions)
-
-	// Serialize the updated post
-	updatedPostJSON, err := json.Marshal(post)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal updated post for postID '%s': %v", postID, err)
-	}
-	// Save the updated post state
-	err = ctx.GetStub().PutState(postID, updatedPostJSON)
-	if err != nil {
-		return nil, fmt.Errorf("failed to update post state for postID '%s': %v", postID, err)
-	}
-
-	// Return the updated post
-	return &post, nil
-}
-
 func (s *SmartContract) GetAllUserPosts(ctx contractapi.TransactionContextInterface) (map[string][]string, error) {
 	// Create a map to store all posts, keyed by the user's public key
 	allPosts := make(map[string][]string)
 
 	// Query for all posts using the composite key prefix
-	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("posts", []string{})
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(userPostsObjectType, []string{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to retrieve posts: %v", err)
 	}
@@ -372,12 +644,25 @@ func (s *SmartContract) GetAllUserPosts(ctx contractapi.TransactionContextInterf
 		}
 		publicKey := compositeKeyParts[0]
 
+		// The posts of deactivated accounts are hidden
+		hidden, err := s.isPostListHidden(ctx, publicKey)
+		if err != nil {
+			return nil, err
+		}
+		if hidden {
+			continue
+		}
+
 		// Unmarshal the posts into a list of IPFS hashes
 		var posts []string
 		err = json.Unmarshal(queryResponse.Value, &posts)
 		if err != nil {
 			return nil, fmt.Errorf("failed to unmarshal posts for user %s: %v", publicKey, err)
 		}
+		posts, err = s.listedPosts(ctx, posts)
+		if err != nil {
+			return nil, err
+		}
 
 		// Store the posts in the map
 		allPosts[publicKey] = posts
@@ -386,21 +671,28 @@ func (s *SmartContract) GetAllUserPosts(ctx contractapi.TransactionContextInterf
 	return allPosts, nil
 }
 
-// GetPostsByUser retrieves all posts created by a specific user
+// GetPostsByUser retrieves all posts created by a specific user, who may be
+// looked up by a key they have since replaced. Hidden and deleted posts are
+// left out, and a deactivated account has none.
 func (s *SmartContract) GetPostsByUser(ctx contractapi.TransactionContextInterface, publicKey string) ([]string, error) {
-	// Check if the user exists
-	userBytes, err := ctx.GetStub().GetState(publicKey)
+	publicKey, err := resolveUserKey(ctx, publicKey)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read user data: %v", err)
+		return nil, err
 	}
-	if userBytes == nil {
-		return nil, fmt.Errorf("user does not exist: %s", publicKey)
+
+	// The user must exist, and a deactivated account lists no posts
+	deactivated, err := s.isDeactivated(ctx, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if deactivated {
+		return nil, fmt.Errorf("no posts found for user: %s", publicKey)
 	}
 
 	// Create the composite key for the user's posts
-	postsKey, err := ctx.GetStub().CreateCompositeKey("posts", []string{publicKey})
+	postsKey, err := entityKey(ctx, userPostsObjectType, publicKey)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create composite key: %v", err)
+		return nil, err
 	}
 
 	// Get the posts from the state
@@ -419,120 +711,23 @@ func (s *SmartContract) GetPostsByUser(ctx contractapi.TransactionContextInterfa
 		return nil, fmt.Errorf("failed to unmarshal posts: %v", err)
 	}
 
-	return posts, nil
+	return s.listedPosts(ctx, posts)
 }
 
 // QueryUserByName retrieves a user by their name
 func (s *SmartContract) QueryUserByName(ctx contractapi.TransactionContextInterface, name string) (*User, error) {
-	// Get all keys from the ledger
-	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
-	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve states from ledger: %v", err)
-	}
-	defer resultsIterator.Close()
-
-	// Iterate through all ledger entries
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return nil, fmt.Errorf("error iterating through ledger states: %v", err)
-		}
-
-		// Parse the user JSON
-		var user User
-		if err := json.Unmarshal(queryResponse.Value, &user); err != nil {
-			continue // Ignore invalid entries
-		}
-
-		// Check if the name matches
-		if user.Name == name {
-			return &user, nil
-		}
-	}
-
-	return nil, fmt.Errorf("user with name %s not found", name)
-}
-
-func (s *SmartContract) AddMessage(ctx contractapi.TransactionContextInterface, chatID string, message string, senderPublicKey string, receiverPublicKey string) error {
-	// Retrieve existing chat data
-	chatData, err := ctx.GetStub().GetState(chatID)
-	if err != nil {
-		return fmt.Errorf("failed to get chat: %v", err)
-	}
-
-	var chat Chat
-	if len(chatData) == 0 {
-		// Create a new chat if it doesn't exist
-
-		chat = Chat{
-			Participants: [2]string{senderPublicKey, receiverPublicKey}, // Add sender and receiver to participants
-			Messages:     []Message{},
-		}
-	} else {
-		log.Printf("i am here")
-		// Unmarshal existing chat data
-		err = json.Unmarshal(chatData, &chat)
-		if err != nil {
-			return fmt.Errorf("failed to unmarshal chat data: %v", err)
-		}
-	}
-
-	// Unmarshal the new message
-	var newMessage Message
-	err = json.Unmarshal([]byte(message), &newMessage)
-	if err != nil {
-		return fmt.Errorf("failed to unmarshal message data: %v", err)
-	}
-
-	// Append the new message
-	chat.Messages = append(chat.Messages, newMessage)
-
-	// Update the state
-	chatBytes, err := json.Marshal(chat)
-	if err != nil {
-		return fmt.Errorf("failed to marshal chat: %v", err)
-	}
-
-	// Store the updated chat data in the blockchain state
-	err = ctx.GetStub().PutState(chatID, chatBytes)
-	if err != nil {
-		return fmt.Errorf("failed to store updated chat: %v", err)
-	}
-
-	// Fire an event to notify the client
-	eventPayload := fmt.Sprintf("Message added to chat %s", chatID)
-	err = ctx.GetStub().SetEvent("MessageAddedEvent", []byte(eventPayload))
+	// Look the name up in the handle index
+	publicKey, err := s.ResolveHandle(ctx, name)
 	if err != nil {
-		return fmt.Errorf("failed to set event: %v", err)
-	}
-
-	return nil
-}
-
-// GetChat retrieves a chat with all its messages using the chatID
-func (s *SmartContract) GetChat(ctx contractapi.TransactionContextInterface, chatID string) (*Chat, error) {
-	// Retrieve the chat data from the state
-	chatData, err := ctx.GetStub().GetState(chatID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get chat: %v", err)
-	}
-
-	if len(chatData) == 0 {
-		return nil, fmt.Errorf("chat with ID %s not found", chatID)
-	}
-
-	var chat Chat
-	err = json.Unmarshal(chatData, &chat)
-	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal chat data: %v", err)
+		return nil, err
 	}
 
-	return &chat, nil
+	return s.GetUser(ctx, publicKey)
 }
 
 func (s *SmartContract) GetAllUsers(ctx contractapi.TransactionContextInterface) ([]*User, error) {
-	// Range query with empty string for startKey and endKey does a full scan
-	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	// Scan only the user namespace
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(userObjectType, []string{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get state iterator: %v", err)
 	}
@@ -550,63 +745,91 @@ func (s *SmartContract) GetAllUsers(ctx contractapi.TransactionContextInterface)
 		if err != nil {
 			return nil, fmt.Errorf("failed to unmarshal user: %v", err)
 		}
+		if user.Deactivated {
+			continue
+		}
 		users = append(users, &user)
 	}
 
 	return users, nil
 }
 
-// CreateGroup creates a new group with the given name and members
-func (s *SmartContract) CreateGroup(ctx contractapi.TransactionContextInterface, id string, groupname string, members []string) error {
+// CreateGroup creates a new group owned by its creator, signed with the creator's
+// key. Members are given by public key and join as plain members. The group's
+// ID is the ID of the transaction, and is returned.
+func (s *SmartContract) CreateGroup(ctx contractapi.TransactionContextInterface, groupname string, creatorPublicKey string, members []string, nonce string, signature string) (string, error) {
+	membersJSON, err := json.Marshal(members)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize members: %v", err)
+	}
+	err = s.verifyUserSignature(ctx, creatorPublicKey, "CreateGroup", []string{groupname, creatorPublicKey, string(membersJSON)}, nonce, signature)
+	if err != nil {
+		return "", err
+	}
+	id := ctx.GetStub().GetTxID()
+
 	// Check if the group already exists
 	exists, err := s.GroupExists(ctx, id)
 	if err != nil {
-		return fmt.Errorf("failed to check if group exists: %v", err)
+		return "", fmt.Errorf("failed to check if group exists: %v", err)
 	}
 	if exists {
-		return fmt.Errorf("group with ID %s already exists", id)
+		return "", fmt.Errorf("group with ID %s already exists", id)
 	}
 
 	// Validate input
-	if len(members) == 0 {
-		return fmt.Errorf("group must have at least one member")
-	}
-
-	// Validate user names
-	for _, userName := range members {
-		if userName == "" {
-			return fmt.Errorf("empty user name is not allowed")
-		}
-		// Optional: Add additional validation for user names
+	if groupname == "" {
+		return "", fmt.Errorf("group name is required")
 	}
 
-	// Create the group object
-	group := Group{
+	// Create the group object with the creator as its owner
+	group := &Group{
 		ID:        id,
 		GroupName: groupname,
-		Members:   members, // Now expects user names
+		Owner:     creatorPublicKey,
+		Members:   []string{creatorPublicKey},
+		Roles:     map[string]string{creatorPublicKey: groupRoleOwner},
 	}
-
-	// Serialize the group to JSON
-	groupJSON, err := json.Marshal(group)
+	err = s.indexGroupMember(ctx, id, creatorPublicKey)
 	if err != nil {
-		return fmt.Errorf("failed to serialize group: %v", err)
+		return "", err
+	}
+
+	for _, member := range members {
+		if member == "" {
+			return "", fmt.Errorf("empty member public key is not allowed")
+		}
+		if member == creatorPublicKey {
+			continue
+		}
+		err = s.addGroupMember(ctx, group, member, groupRoleMember)
+		if err != nil {
+			return "", err
+		}
 	}
 
 	// Store the group on the blockchain
-	err = ctx.GetStub().PutState(id, groupJSON)
+	err = s.saveGroup(ctx, group)
 	if err != nil {
-		return fmt.Errorf("failed to put group state: %v", err)
+		return "", err
 	}
 
-	return nil
+	err = emitEvent(ctx, eventGroupCreated, GroupEvent{GroupID: id, GroupName: groupname, Members: group.Members, Actor: creatorPublicKey})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
 }
 
 // ReadGroup retrieves a group from the blockchain by its ID
 func (s *SmartContract) ReadGroup(ctx contractapi.TransactionContextInterface, id string) (*Group, error) {
 
 	// Get the group JSON from the blockchain
-	groupJSON, err := ctx.GetStub().GetState(id)
+	groupKey, err := entityKey(ctx, groupObjectType, id)
+	if err != nil {
+		return nil, err
+	}
+	groupJSON, err := ctx.GetStub().GetState(groupKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read group: %v", err)
 	}
@@ -626,7 +849,11 @@ func (s *SmartContract) ReadGroup(ctx contractapi.TransactionContextInterface, i
 
 // GroupExists checks if a group exists on the blockchain
 func (s *SmartContract) GroupExists(ctx contractapi.TransactionContextInterface, id string) (bool, error) {
-	groupJSON, err := ctx.GetStub().GetState(id)
+	groupKey, err := entityKey(ctx, groupObjectType, id)
+	if err != nil {
+		return false, err
+	}
+	groupJSON, err := ctx.GetStub().GetState(groupKey)
 	if err != nil {
 		return false, fmt.Errorf("failed to read group: %v", err)
 	}
@@ -634,37 +861,36 @@ func (s *SmartContract) GroupExists(ctx contractapi.TransactionContextInterface,
 	return groupJSON != nil, nil
 }
 
-// AddMemberToGroup adds a new user's name to an existing group
-func (s *SmartContract) AddMemberToGroup(ctx contractapi.TransactionContextInterface, id string, userName string) error {
+// AddMemberToGroup adds a user to an existing group, signed by one of its admins or its owner
+func (s *SmartContract) AddMemberToGroup(ctx contractapi.TransactionContextInterface, id string, actorPublicKey string, memberPublicKey string, nonce string, signature string) error {
+	err := s.verifyUserSignature(ctx, actorPublicKey, "AddMemberToGroup", []string{id, actorPublicKey, memberPublicKey}, nonce, signature)
+	if err != nil {
+		return err
+	}
+
 	// Retrieve the existing group
 	group, err := s.ReadGroup(ctx, id)
 	if err != nil {
 		return err
 	}
-
-	// Check if the user name already exists
-	for _, existingMember := range group.Members {
-		if existingMember == userName {
-			return fmt.Errorf("user name already exists in the group")
-		}
+	err = requireGroupRole(group, actorPublicKey, groupRoleAdmin)
+	if err != nil {
+		return err
 	}
 
-	// Add the new user name
-	group.Members = append(group.Members, userName)
-
-	// Serialize the updated group
-	groupJSON, err := json.Marshal(group)
+	// Add the new member
+	err = s.addGroupMember(ctx, group, memberPublicKey, groupRoleMember)
 	if err != nil {
-		return fmt.Errorf("failed to serialize updated group: %v", err)
+		return err
 	}
 
 	// Update the group on the blockchain
-	err = ctx.GetStub().PutState(id, groupJSON)
+	err = s.saveGroup(ctx, group)
 	if err != nil {
-		return fmt.Errorf("failed to update group: %v", err)
+		return err
 	}
 
-	return nil
+	return emitEvent(ctx, eventMemberAdded, GroupEvent{GroupID: id, GroupName: group.GroupName, Members: []string{memberPublicKey}, Actor: actorPublicKey})
 }
 
 // GetAllGroups retrieves all groups from the ledger
@@ -672,10 +898,10 @@ func (s *SmartContract) GetAllGroups(ctx contractapi.TransactionContextInterface
 	// Initialize a slice to store all groups
 	var allGroups []*Group
 
-	// Fetch all records from the ledger
-	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	// Fetch all records in the group namespace
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(groupObjectType, []string{})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get state by range: %v", err)
+		return nil, fmt.Errorf("failed to get groups: %v", err)
 	}
 	defer resultsIterator.Close()
 
@@ -713,49 +939,90 @@ func (s *SmartContract) GetAllGroups(ctx contractapi.TransactionContextInterface
 	return allGroups, nil
 }
 
-// SendFriendRequest creates a new friend request
-func (s *SmartContract) SendFriendRequest(ctx contractapi.TransactionContextInterface, sender string, receiver string) (string, error) {
-	// Validate that both sender and receiver exist
-	senderExists, err := s.UserExists(ctx, sender)
-	if err != nil || !senderExists {
-		return "", fmt.Errorf("sender user does not exist: %s", sender)
+// SendFriendRequest creates a new friend request, signed with the sender's key.
+// If the receiver already has a pending request to the sender, that request is
+// accepted instead and the two become friends.
+func (s *SmartContract) SendFriendRequest(ctx contractapi.TransactionContextInterface, sender string, receiver string, nonce string, signature string) (string, error) {
+	// Authenticate the sender, which also checks that the sender exists
+	err := s.verifyUserSignature(ctx, sender, "SendFriendRequest", []string{sender, receiver}, nonce, signature)
+	if err != nil {
+		return "", err
+	}
+	if sender == receiver {
+		return "", fmt.Errorf("cannot send a friend request to yourself")
+	}
+
+	err = s.requireActiveUser(ctx, receiver)
+	if err != nil {
+		return "", err
 	}
 
-	receiverExists, err := s.UserExists(ctx, receiver)
-	if err != nil || !receiverExists {
-		return "", fmt.Errorf("receiver user does not exist: %s", receiver)
+	err = s.checkNotBlocked(ctx, sender, receiver)
+	if err != nil {
+		return "", err
 	}
 
-	// Check if a friend request already exists
-	existingRequest, err := s.GetFriendRequest(ctx, sender, receiver)
-	if err == nil && existingRequest != nil {
-		return "", fmt.Errorf("friend request already exists with status: %s", existingRequest.Status)
+	friends, err := s.areFriends(ctx, sender, receiver)
+	if err != nil {
+		return "", err
+	}
+	if friends {
+		return "", fmt.Errorf("user %s is already a friend of user %s", receiver, sender)
 	}
 
-	// Create a new friend request
-	friendRequest := FriendRequest{
-		Sender:    sender,
-		Receiver:  receiver,
-		Status:    "pending",
-		Timestamp: time.Now().Unix(),
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// A pending request the other way round means both users want the friendship
+	reverseRequest, err := s.getFriendRequest(ctx, receiver, sender)
+	if err != nil {
+		return "", err
+	}
+	if reverseRequest != nil && reverseRequest.Status == friendRequestPending {
+		reverseRequest.Status = friendRequestAccepted
+		reverseRequest.RespondedAt = now
+		requestKey, err := s.saveFriendRequest(ctx, reverseRequest)
+		if err != nil {
+			return "", err
+		}
+		err = s.addFriend(ctx, receiver, sender)
+		if err != nil {
+			return "", fmt.Errorf("failed to add friend: %v", err)
+		}
+		err = emitEvent(ctx, eventFriendRequestResponded, FriendRequestEvent{Sender: receiver, Receiver: sender, Status: reverseRequest.Status})
+		if err != nil {
+			return "", err
+		}
+		return requestKey, nil
 	}
 
-	// Generate a unique key for the friend request
-	requestKey, err := ctx.GetStub().CreateCompositeKey("friendrequest", []string{sender, receiver})
+	// Check whether an earlier request blocks sending a new one
+	existingRequest, err := s.getFriendRequest(ctx, sender, receiver)
 	if err != nil {
-		return "", fmt.Errorf("failed to create composite key: %v", err)
+		return "", err
+	}
+	err = checkFriendRequestCanBeSent(existingRequest, now)
+	if err != nil {
+		return "", err
 	}
 
-	// Serialize the friend request
-	requestJSON, err := json.Marshal(friendRequest)
+	// Create a new friend request, replacing any earlier one
+	friendRequest := FriendRequest{
+		Sender:    sender,
+		Receiver:  receiver,
+		Status:    friendRequestPending,
+		Timestamp: now,
+	}
+	requestKey, err := s.saveFriendRequest(ctx, &friendRequest)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal friend request: %v", err)
+		return "", err
 	}
 
-	// Store the friend request on the ledger
-	err = ctx.GetStub().PutState(requestKey, requestJSON)
+	err = emitEvent(ctx, eventFriendRequestSent, FriendRequestEvent{Sender: sender, Receiver: receiver, Status: friendRequest.Status})
 	if err != nil {
-		return "", fmt.Errorf("failed to store friend request: %v", err)
+		return "", err
 	}
 
 	// Return the request key as the result
@@ -764,38 +1031,30 @@ func (s *SmartContract) SendFriendRequest(ctx contractapi.TransactionContextInte
 
 // GetFriendRequest retrieves a specific friend request
 func (s *SmartContract) GetFriendRequest(ctx contractapi.TransactionContextInterface, sender string, receiver string) (*FriendRequest, error) {
-	// Create the composite key for the friend request
-	requestKey, err := ctx.GetStub().CreateCompositeKey("friendrequest", []string{sender, receiver})
-	if err != nil {
-		return nil, fmt.Errorf("failed to create composite key: %v", err)
-	}
-
-	// Retrieve the friend request from the ledger
-	requestJSON, err := ctx.GetStub().GetState(requestKey)
+	friendRequest, err := s.getFriendRequest(ctx, sender, receiver)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read friend request: %v", err)
+		return nil, err
 	}
-	if requestJSON == nil {
+	if friendRequest == nil {
 		return nil, fmt.Errorf("friend request not found")
 	}
 
-	// Deserialize the friend request
-	var friendRequest FriendRequest
-	err = json.Unmarshal(requestJSON, &friendRequest)
-	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal friend request: %v", err)
-	}
-
-	return &friendRequest, nil
+	return friendRequest, nil
 }
 
-// RespondToFriendRequest allows a user to accept or reject a friend request
-func (s *SmartContract) RespondToFriendRequest(ctx contractapi.TransactionContextInterface, sender string, receiver string, response string) error {
+// RespondToFriendRequest allows a user to accept or reject a friend request.
+// Only the receiver can respond, so the call must be signed with the receiver's key.
+func (s *SmartContract) RespondToFriendRequest(ctx contractapi.TransactionContextInterface, sender string, receiver string, response string, nonce string, signature string) error {
 	// Validate response
-	if response != "accepted" && response != "rejected" {
+	if response != friendRequestAccepted && response != friendRequestRejected {
 		return fmt.Errorf("invalid response. Must be 'accepted' or 'rejected'")
 	}
 
+	err := s.verifyUserSignature(ctx, receiver, "RespondToFriendRequest", []string{sender, receiver, response}, nonce, signature)
+	if err != nil {
+		return err
+	}
+
 	// Retrieve the existing friend request
 	existingRequest, err := s.GetFriendRequest(ctx, sender, receiver)
 	if err != nil {
@@ -803,45 +1062,46 @@ func (s *SmartContract) RespondToFriendRequest(ctx contractapi.TransactionContex
 	}
 
 	// Check if the request is still pending
-	if existingRequest.Status != "pending" {
+	if existingRequest.Status == friendRequestExpired {
+		return fmt.Errorf("friend request has expired")
+	}
+	if existingRequest.Status != friendRequestPending {
 		return fmt.Errorf("friend request has already been %s", existingRequest.Status)
 	}
-
-	// Update the friend request status
-	existingRequest.Status = response
-
-	// Recreate the composite key
-	requestKey, err := ctx.GetStub().CreateCompositeKey("friendrequest", []string{sender, receiver})
-	if err != nil {
-		return fmt.Errorf("failed to create composite key: %v", err)
+	if response == friendRequestAccepted {
+		err = s.requireActiveUser(ctx, sender)
+		if err != nil {
+			return err
+		}
 	}
 
-	// Serialize the updated friend request
-	updatedRequestJSON, err := json.Marshal(existingRequest)
+	// Update the friend request status
+	now, err := txTimestamp(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to marshal updated friend request: %v", err)
+		return err
 	}
-
-	// Store the updated friend request
-	err = ctx.GetStub().PutState(requestKey, updatedRequestJSON)
+	existingRequest.Status = response
+	existingRequest.RespondedAt = now
+	_, err = s.saveFriendRequest(ctx, existingRequest)
 	if err != nil {
-		return fmt.Errorf("failed to update friend request: %v", err)
+		return err
 	}
 
-	// If accepted, add to friends list (you would need to implement this separately)
-	if response == "accepted" {
+	// If accepted, add to friends list
+	if response == friendRequestAccepted {
 		err = s.addFriend(ctx, sender, receiver)
 		if err != nil {
 			return fmt.Errorf("failed to add friend: %v", err)
 		}
 	}
 
-	return nil
+	return emitEvent(ctx, eventFriendRequestResponded, FriendRequestEvent{Sender: sender, Receiver: receiver, Status: response})
 }
 
+// GetFriendRequestsByUser returns every friend request sent or received by a user
 func (s *SmartContract) GetFriendRequestsByUser(ctx contractapi.TransactionContextInterface, publicKey string) (string, error) {
-	// Create an iterator for friend requests
-	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("friendrequest", []string{})
+	// Read the requests listed under the user
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(userFriendRequestObjectType, []string{publicKey})
 	if err != nil {
 		return "", fmt.Errorf("failed to get iterator for friend requests: %v", err)
 	}
@@ -854,39 +1114,21 @@ func (s *SmartContract) GetFriendRequestsByUser(ctx contractapi.TransactionConte
 			return "", fmt.Errorf("failed to get next item from iterator: %v", err)
 		}
 
-		// Split the composite key to extract sender and receiver
-		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		friendRequest, err := s.friendRequestFromIndex(ctx, queryResponse.Key)
 		if err != nil {
-			return "", fmt.Errorf("failed to split composite key: %v", err)
+			return "", err
+		}
+		if friendRequest == nil {
+			continue
 		}
 
-		// Check if the current user is sender or receiver
-		if len(compositeKeyParts) == 2 && (compositeKeyParts[0] == publicKey || compositeKeyParts[1] == publicKey) {
-			var friendRequest FriendRequest
-			err = json.Unmarshal(queryResponse.Value, &friendRequest)
-			if err != nil {
-				return "", fmt.Errorf("failed to unmarshal friend request: %v", err)
-			}
-
-			// Fetch sender's name based on the sender public key
-			senderName, err := s.getUserName(ctx, friendRequest.Sender)
-			if err != nil {
-				return "", fmt.Errorf("failed to fetch sender name: %v", err)
-			}
-
-			// Fetch receiver's name based on the receiver public key
-			receiverName, err := s.getUserName(ctx, friendRequest.Receiver)
-			if err != nil {
-				return "", fmt.Errorf("failed to fetch receiver name: %v", err)
-			}
-
-			// Add sender and receiver names to the request
-			friendRequest.SenderName = senderName
-			log.Printf(friendRequest.SenderName)
-			friendRequest.ReceiverName = receiverName
-
-			userFriendRequests = append(userFriendRequests, &friendRequest)
+		// Add sender and receiver names to the request
+		err = s.fillFriendRequestNames(ctx, friendRequest)
+		if err != nil {
+			return "", err
 		}
+
+		userFriendRequests = append(userFriendRequests, friendRequest)
 	}
 
 	// Encode the friend requests to base64
@@ -897,10 +1139,33 @@ func (s *SmartContract) GetFriendRequestsByUser(ctx contractapi.TransactionConte
 	return base64.StdEncoding.EncodeToString(friendRequestsJSON), nil
 }
 
+// fillFriendRequestNames looks up the display names of a request's sender and receiver
+func (s *SmartContract) fillFriendRequestNames(ctx contractapi.TransactionContextInterface, friendRequest *FriendRequest) error {
+	// Fetch sender's name based on the sender public key
+	senderName, err := s.getUserName(ctx, friendRequest.Sender)
+	if err != nil {
+		return fmt.Errorf("failed to fetch sender name: %v", err)
+	}
+
+	// Fetch receiver's name based on the receiver public key
+	receiverName, err := s.getUserName(ctx, friendRequest.Receiver)
+	if err != nil {
+		return fmt.Errorf("failed to fetch receiver name: %v", err)
+	}
+
+	friendRequest.SenderName = senderName
+	friendRequest.ReceiverName = receiverName
+	return nil
+}
+
 // Helper function to fetch user name by public key
 func (s *SmartContract) getUserName(ctx contractapi.TransactionContextInterface, publicKey string) (string, error) {
 	// Query the user details from the ledger using the public key
-	userBytes, err := ctx.GetStub().GetState(publicKey)
+	userKey, err := entityKey(ctx, userObjectType, publicKey)
+	if err != nil {
+		return "", err
+	}
+	userBytes, err := ctx.GetStub().GetState(userKey)
 	if err != nil {
 		return "", fmt.Errorf("failed to get user data: %v", err)
 	}
@@ -920,7 +1185,10 @@ func (s *SmartContract) getUserName(ctx contractapi.TransactionContextInterface,
 
 func (s *SmartContract) addFriend(ctx contractapi.TransactionContextInterface, user1 string, user2 string) error {
 	// Retrieve the friends list of user1
-	user1FriendsKey := fmt.Sprintf("friends_%s", user1)
+	user1FriendsKey, err := entityKey(ctx, friendsObjectType, user1)
+	if err != nil {
+		return err
+	}
 	user1FriendsJSON, err := ctx.GetStub().GetState(user1FriendsKey)
 	if err != nil {
 		return fmt.Errorf("failed to get friends list for user1: %v", err)
@@ -956,7 +1224,10 @@ func (s *SmartContract) addFriend(ctx contractapi.TransactionContextInterface, u
 	}
 
 	// Repeat the same steps for user2
-	user2FriendsKey := fmt.Sprintf("friends_%s", user2)
+	user2FriendsKey, err := entityKey(ctx, friendsObjectType, user2)
+	if err != nil {
+		return err
+	}
 	user2FriendsJSON, err := ctx.GetStub().GetState(user2FriendsKey)
 	if err != nil {
 		return fmt.Errorf("failed to get friends list for user2: %v", err)
@@ -991,9 +1262,19 @@ func (s *SmartContract) addFriend(ctx contractapi.TransactionContextInterface, u
 	return nil
 }
 
+// GetFriendsByUser returns the public keys of a user's friends. The user may be
+// looked up by a key they have since replaced.
 func (s *SmartContract) GetFriendsByUser(ctx contractapi.TransactionContextInterface, publicKey string) ([]string, error) {
+	publicKey, err := resolveUserKey(ctx, publicKey)
+	if err != nil {
+		return nil, err
+	}
+
 	// Retrieve the friends list key for the given user
-	friendsKey := fmt.Sprintf("friends_%s", publicKey)
+	friendsKey, err := entityKey(ctx, friendsObjectType, publicKey)
+	if err != nil {
+		return nil, err
+	}
 	friendsListJSON, err := ctx.GetStub().GetState(friendsKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to retrieve friends list: %v", err)
@@ -1016,7 +1297,10 @@ func (s *SmartContract) GetFriendsByUser(ctx contractapi.TransactionContextInter
 
 func (s *SmartContract) GetFriendsWithDetailsByUser(ctx contractapi.TransactionContextInterface, publicKey string) (string, error) {
 	// Retrieve the friends list for the given user
-	friendsKey := fmt.Sprintf("friends_%s", publicKey)
+	friendsKey, err := entityKey(ctx, friendsObjectType, publicKey)
+	if err != nil {
+		return "", err
+	}
 	friendsListJSON, err := ctx.GetStub().GetState(friendsKey)
 	if err != nil {
 		return "", fmt.Errorf("failed to retrieve friends list: %v", err)
@@ -1038,7 +1322,11 @@ func (s *SmartContract) GetFriendsWithDetailsByUser(ctx contractapi.TransactionC
 	var friendsDetails []map[string]string
 	for _, friendKey := range friendsList.Friends {
 		// Fetch the friend's user data from the ledger
-		userBytes, err := ctx.GetStub().GetState(friendKey)
+		userKey, err := entityKey(ctx, userObjectType, friendKey)
+		if err != nil {
+			return "", err
+		}
+		userBytes, err := ctx.GetStub().GetState(userKey)
 		if err != nil {
 			return "", fmt.Errorf("failed to fetch friend data: %v", err)
 		}
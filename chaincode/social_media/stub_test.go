@@ -0,0 +1,285 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// fakeStub is an in-memory ledger for the calls the SmartContract makes. The
+// shim's own MockStub has no paginated queries and cannot delete private
+// data, so the calls are implemented here; any other call panics through the
+// nil embedded interface.
+type fakeStub struct {
+	shim.ChaincodeStubInterface
+
+	txCount   int
+	state     map[string][]byte
+	private   map[string]map[string][]byte
+	transient map[string][]byte
+	eventName string
+}
+
+func newFakeStub() *fakeStub {
+	return &fakeStub{
+		state:   make(map[string][]byte),
+		private: make(map[string]map[string][]byte),
+	}
+}
+
+// newContext starts a new transaction on the stub and returns its context.
+// Each transaction has its own ID and a timestamp one second after the last.
+func (stub *fakeStub) newContext() *contractapi.TransactionContext {
+	stub.txCount++
+	stub.transient = nil
+	stub.eventName = ""
+
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(stub)
+	ctx.SetClientIdentity(&fakeIdentity{})
+	return ctx
+}
+
+// newAdminContext is newContext for a client enrolled as an admin
+func (stub *fakeStub) newAdminContext() *contractapi.TransactionContext {
+	ctx := stub.newContext()
+	ctx.SetClientIdentity(&fakeIdentity{attributes: map[string]string{adminAttribute: "true"}})
+	return ctx
+}
+
+// fakeIdentity is a client certificate carrying attributes
+type fakeIdentity struct {
+	cid.ClientIdentity
+
+	attributes map[string]string
+}
+
+func (id *fakeIdentity) AssertAttributeValue(name string, value string) error {
+	if id.attributes[name] != value {
+		return fmt.Errorf("attribute %s is not %s", name, value)
+	}
+	return nil
+}
+
+func (stub *fakeStub) GetTxID() string {
+	return fmt.Sprintf("tx%d", stub.txCount)
+}
+
+func (stub *fakeStub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return &timestamp.Timestamp{Seconds: 1700000000 + int64(stub.txCount)}, nil
+}
+
+func (stub *fakeStub) GetTransient() (map[string][]byte, error) {
+	return stub.transient, nil
+}
+
+func (stub *fakeStub) SetEvent(name string, payload []byte) error {
+	stub.eventName = name
+	return nil
+}
+
+func (stub *fakeStub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	return shim.CreateCompositeKey(objectType, attributes)
+}
+
+func (stub *fakeStub) SplitCompositeKey(compositeKey string) (string, []string, error) {
+	parts := strings.Split(strings.Trim(compositeKey, "\x00"), "\x00")
+	return parts[0], parts[1:], nil
+}
+
+func (stub *fakeStub) GetState(key string) ([]byte, error) {
+	return stub.state[key], nil
+}
+
+// PutState treats an empty value as a delete, as the peer does
+func (stub *fakeStub) PutState(key string, value []byte) error {
+	if len(value) == 0 {
+		delete(stub.state, key)
+		return nil
+	}
+	stub.state[key] = value
+	return nil
+}
+
+func (stub *fakeStub) DelState(key string) error {
+	delete(stub.state, key)
+	return nil
+}
+
+func (stub *fakeStub) GetStateByRange(startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	if endKey == "" {
+		endKey = string(utf8.MaxRune)
+	}
+	return &fakeIterator{results: scanKeys(stub.state, startKey, endKey)}, nil
+}
+
+func (stub *fakeStub) GetStateByPartialCompositeKey(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	prefix, err := shim.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeIterator{results: scanKeys(stub.state, prefix, prefix+string(utf8.MaxRune))}, nil
+}
+
+// GetStateByPartialCompositeKeyWithPagination starts a page at the bookmark
+// key, and returns the key that follows the page as the next bookmark
+func (stub *fakeStub) GetStateByPartialCompositeKeyWithPagination(objectType string, attributes []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	prefix, err := shim.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, nil, err
+	}
+	start := prefix
+	if bookmark != "" {
+		start = bookmark
+	}
+	results := scanKeys(stub.state, start, prefix+string(utf8.MaxRune))
+
+	next := ""
+	if int32(len(results)) > pageSize {
+		next = results[pageSize].Key
+		results = results[:pageSize]
+	}
+	metadata := &pb.QueryResponseMetadata{FetchedRecordsCount: int32(len(results)), Bookmark: next}
+	return &fakeIterator{results: results}, metadata, nil
+}
+
+func (stub *fakeStub) GetPrivateData(collection string, key string) ([]byte, error) {
+	return stub.private[collection][key], nil
+}
+
+func (stub *fakeStub) GetPrivateDataHash(collection string, key string) ([]byte, error) {
+	value, ok := stub.private[collection][key]
+	if !ok {
+		return nil, nil
+	}
+	hash := sha256.Sum256(value)
+	return hash[:], nil
+}
+
+func (stub *fakeStub) PutPrivateData(collection string, key string, value []byte) error {
+	if stub.private[collection] == nil {
+		stub.private[collection] = make(map[string][]byte)
+	}
+	stub.private[collection][key] = value
+	return nil
+}
+
+func (stub *fakeStub) DelPrivateData(collection string, key string) error {
+	delete(stub.private[collection], key)
+	return nil
+}
+
+// scanKeys returns the entries of state from startKey up to endKey, in key order
+func scanKeys(state map[string][]byte, startKey string, endKey string) []*queryresult.KV {
+	results := []*queryresult.KV{}
+	for key, value := range state {
+		if key >= startKey && key < endKey {
+			results = append(results, &queryresult.KV{Key: key, Value: value})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Key < results[j].Key
+	})
+	return results
+}
+
+// fakeIterator iterates over the results of a query, read when it was made
+type fakeIterator struct {
+	results []*queryresult.KV
+}
+
+func (it *fakeIterator) HasNext() bool {
+	return len(it.results) > 0
+}
+
+func (it *fakeIterator) Next() (*queryresult.KV, error) {
+	if len(it.results) == 0 {
+		return nil, fmt.Errorf("no more results")
+	}
+	next := it.results[0]
+	it.results = it.results[1:]
+	return next, nil
+}
+
+func (it *fakeIterator) Close() error {
+	return nil
+}
+
+// testUser is a user's key pair, which signs calls the way the backend does
+type testUser struct {
+	privateKey *ecdsa.PrivateKey
+	publicKey  string
+	nonces     int
+}
+
+func newTestUser(t *testing.T) *testUser {
+	t.Helper()
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %v", err)
+	}
+	return &testUser{privateKey: privateKey, publicKey: hex.EncodeToString(publicKeyBytes)}
+}
+
+// sign returns a fresh nonce and the user's signature over a call with it
+func (u *testUser) sign(t *testing.T, function string, args ...string) (string, string) {
+	t.Helper()
+	u.nonces++
+	nonce := fmt.Sprintf("nonce-%d", u.nonces)
+	return nonce, u.signWithNonce(t, function, args, nonce)
+}
+
+// signWithNonce returns the user's signature over a call with the given nonce
+func (u *testUser) signWithNonce(t *testing.T, function string, args []string, nonce string) string {
+	t.Helper()
+	payload, err := canonicalPayload(function, args, nonce)
+	if err != nil {
+		t.Fatalf("failed to build payload: %v", err)
+	}
+	hash := sha256.Sum256(payload)
+	r, s, err := ecdsa.Sign(rand.Reader, u.privateKey, hash[:])
+	if err != nil {
+		t.Fatalf("failed to sign %s: %v", function, err)
+	}
+	return r.String() + "," + s.String()
+}
+
+// registerTestUser registers a new user called name on the stub's ledger
+func registerTestUser(t *testing.T, contract *SmartContract, stub *fakeStub, name string) *testUser {
+	t.Helper()
+	user := newTestUser(t)
+	err := contract.RegisterUser(stub.newContext(), name, user.publicKey)
+	if err != nil {
+		t.Fatalf("failed to register %s: %v", name, err)
+	}
+	return user
+}
+
+// createTestPost has user post the content stored under ipfsHash
+func createTestPost(t *testing.T, contract *SmartContract, stub *fakeStub, user *testUser, ipfsHash string) {
+	t.Helper()
+	nonce, signature := user.sign(t, "CreatePost", user.publicKey, ipfsHash, "[]", "[]")
+	_, err := contract.CreatePost(stub.newContext(), user.publicKey, ipfsHash, []string{}, []string{}, nonce, signature)
+	if err != nil {
+		t.Fatalf("failed to create post %s: %v", ipfsHash, err)
+	}
+}
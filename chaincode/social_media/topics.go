@@ -0,0 +1,377 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Limits on the topics of a post
+const (
+	maxHashtagLength   = 64
+	maxHashtagsPerPost = 10
+	maxMentionsPerPost = 10
+)
+
+// Windows of the trending hashtags query
+const (
+	secondsPerDay       = 24 * 60 * 60
+	defaultTrendingDays = 1
+	maxTrendingDays     = 7
+)
+
+// HashtagCount is the number of posts that used a hashtag
+type HashtagCount struct {
+	Hashtag string `json:"hashtag"`
+	Count   int    `json:"count"`
+}
+
+// normalizeHashtag returns the canonical form of a hashtag, without the leading
+// '#'. Hashtags are case-insensitive and may only contain letters, digits and '_'.
+func normalizeHashtag(hashtag string) (string, error) {
+	normalized := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(hashtag), "#"))
+	if len(normalized) == 0 || len(normalized) > maxHashtagLength {
+		return "", fmt.Errorf("hashtag must be between 1 and %d characters", maxHashtagLength)
+	}
+	for _, c := range normalized {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '_' {
+			return "", fmt.Errorf("hashtag %q may only contain letters, digits and '_'", hashtag)
+		}
+	}
+	return normalized, nil
+}
+
+// normalizeHashtags normalizes and deduplicates the hashtags of a post
+func normalizeHashtags(hashtags []string) ([]string, error) {
+	if len(hashtags) > maxHashtagsPerPost {
+		return nil, fmt.Errorf("a post can have at most %d hashtags", maxHashtagsPerPost)
+	}
+	normalized := []string{}
+	seen := make(map[string]bool)
+	for _, hashtag := range hashtags {
+		tag, err := normalizeHashtag(hashtag)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized, nil
+}
+
+// resolveMentions turns the handles mentioned in a post into public keys.
+// Handles that belong to no user are ignored, since not every '@' in a post is
+// meant as a mention, and so are the author, deactivated users and users with
+// a block between them and the author.
+func (s *SmartContract) resolveMentions(ctx contractapi.TransactionContextInterface, authorPublicKey string, handles []string) ([]string, error) {
+	if len(handles) > maxMentionsPerPost {
+		return nil, fmt.Errorf("a post can mention at most %d users", maxMentionsPerPost)
+	}
+	mentions := []string{}
+	seen := make(map[string]bool)
+	for _, handle := range handles {
+		normalized, err := normalizeHandle(strings.TrimPrefix(strings.TrimSpace(handle), "@"))
+		if err != nil {
+			continue
+		}
+		handleKey, err := entityKey(ctx, handleObjectType, normalized)
+		if err != nil {
+			return nil, err
+		}
+		owner, err := ctx.GetStub().GetState(handleKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read handle %s: %v", normalized, err)
+		}
+		publicKey := string(owner)
+		if owner == nil || publicKey == authorPublicKey || seen[publicKey] {
+			continue
+		}
+		if s.checkNotBlocked(ctx, authorPublicKey, publicKey) != nil {
+			continue
+		}
+		if s.requireActiveUser(ctx, publicKey) != nil {
+			continue
+		}
+		seen[publicKey] = true
+		mentions = append(mentions, publicKey)
+	}
+	return mentions, nil
+}
+
+// topicKeys returns the index keys a post is listed under for its hashtags and mentions
+func topicKeys(ctx contractapi.TransactionContextInterface, post *Post) ([]string, error) {
+	keys := []string{}
+	day := fmt.Sprintf("%019d", post.Timestamp/secondsPerDay)
+	for _, tag := range post.Hashtags {
+		hashtagKey, err := entityKey(ctx, hashtagPostObjectType, tag, reverseTimestamp(post.Timestamp), post.ContentCID)
+		if err != nil {
+			return nil, err
+		}
+		dayKey, err := entityKey(ctx, hashtagDayObjectType, day, tag, post.ContentCID)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, hashtagKey, dayKey)
+	}
+	for _, publicKey := range post.Mentions {
+		mentionKey, err := entityKey(ctx, mentionObjectType, publicKey, reverseTimestamp(post.Timestamp), post.ContentCID)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, mentionKey)
+	}
+	return keys, nil
+}
+
+// indexPostTopics lists a post under its hashtags and the users it mentions.
+// Hidden and deleted posts are kept out of the indexes.
+func (s *SmartContract) indexPostTopics(ctx contractapi.TransactionContextInterface, post *Post) error {
+	if post.Hidden || post.Deleted {
+		return fmt.Errorf("post %s is hidden or deleted and cannot be indexed", post.ContentCID)
+	}
+	keys, err := topicKeys(ctx, post)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		err = ctx.GetStub().PutState(key, indexMarker)
+		if err != nil {
+			return fmt.Errorf("failed to index topics of post %s: %v", post.ContentCID, err)
+		}
+	}
+	return nil
+}
+
+// unindexPostTopics removes a post from its hashtag and mention indexes
+func (s *SmartContract) unindexPostTopics(ctx contractapi.TransactionContextInterface, post *Post) error {
+	keys, err := topicKeys(ctx, post)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		err = ctx.GetStub().DelState(key)
+		if err != nil {
+			return fmt.Errorf("failed to remove topics of post %s: %v", post.ContentCID, err)
+		}
+	}
+	return nil
+}
+
+// postPageFromIndex reads one page of an index whose keys end in a post's IPFS
+// hash, such as hashtagpost~tag~reverseTimestamp~contentCID
+func postPageFromIndex(ctx contractapi.TransactionContextInterface, objectType string, attributes []string, pageSize int32, bookmark string) (*PostPage, error) {
+	pageSize = normalizePageSize(pageSize)
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(objectType, attributes, pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve posts: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	page := &PostPage{Posts: []string{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate posts: %v", err)
+		}
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to split composite key: %v", err)
+		}
+		if len(compositeKeyParts) > 0 {
+			page.Posts = append(page.Posts, compositeKeyParts[len(compositeKeyParts)-1])
+		}
+	}
+
+	page.Bookmark = nextBookmark(metadata.FetchedRecordsCount, metadata.Bookmark, pageSize)
+	return page, nil
+}
+
+// GetPostsByHashtag returns one page of the IPFS hashes of the posts tagged with a hashtag, newest first
+func (s *SmartContract) GetPostsByHashtag(ctx contractapi.TransactionContextInterface, hashtag string, pageSize int32, bookmark string) (*PostPage, error) {
+	tag, err := normalizeHashtag(hashtag)
+	if err != nil {
+		return nil, err
+	}
+	return postPageFromIndex(ctx, hashtagPostObjectType, []string{tag}, pageSize, bookmark)
+}
+
+// GetMentionsForUser returns one page of the IPFS hashes of the posts that
+// mention a user, newest first, including mentions of keys the user has since
+// replaced
+func (s *SmartContract) GetMentionsForUser(ctx contractapi.TransactionContextInterface, publicKey string, pageSize int32, bookmark string) (*PostPage, error) {
+	publicKey, err := resolveUserKey(ctx, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	userExists, err := s.UserExists(ctx, publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read user data: %v", err)
+	}
+	if !userExists {
+		return nil, fmt.Errorf("user does not exist: %s", publicKey)
+	}
+
+	keys, err := s.userKeys(ctx, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	sources := make([]feedSource, 0, len(keys))
+	for _, key := range keys {
+		sources = append(sources, feedSource{objectType: mentionObjectType, attributes: []string{key}})
+	}
+	return mergeFeed(ctx, sources, pageSize, bookmark)
+}
+
+// GetTrendingHashtags returns the hashtags used by the most posts over the last
+// days days, counting today, most used first. Ties are broken alphabetically.
+func (s *SmartContract) GetTrendingHashtags(ctx contractapi.TransactionContextInterface, days int, limit int32) ([]*HashtagCount, error) {
+	if days <= 0 {
+		days = defaultTrendingDays
+	}
+	if days > maxTrendingDays {
+		days = maxTrendingDays
+	}
+	limit = normalizePageSize(limit)
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	today := now / secondsPerDay
+
+	counts := make(map[string]int)
+	for day := today - int64(days) + 1; day <= today; day++ {
+		err = countHashtagsOfDay(ctx, day, counts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	trending := make([]*HashtagCount, 0, len(counts))
+	for tag, count := range counts {
+		trending = append(trending, &HashtagCount{Hashtag: tag, Count: count})
+	}
+	sort.Slice(trending, func(i, j int) bool {
+		if trending[i].Count != trending[j].Count {
+			return trending[i].Count > trending[j].Count
+		}
+		return trending[i].Hashtag < trending[j].Hashtag
+	})
+	if int32(len(trending)) > limit {
+		trending = trending[:limit]
+	}
+	return trending, nil
+}
+
+// countHashtagsOfDay adds the number of posts made on a day to counts, by hashtag
+func countHashtagsOfDay(ctx contractapi.TransactionContextInterface, day int64, counts map[string]int) error {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(hashtagDayObjectType, []string{fmt.Sprintf("%019d", day)})
+	if err != nil {
+		return fmt.Errorf("failed to get hashtags of day %d: %v", day, err)
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return fmt.Errorf("failed to iterate hashtags: %v", err)
+		}
+
+		// hashtagday~day~tag~contentCID
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return fmt.Errorf("failed to split composite key: %v", err)
+		}
+		if len(compositeKeyParts) == 3 {
+			counts[compositeKeyParts[1]]++
+		}
+	}
+	return nil
+}
+
+// FollowHashtag adds a hashtag to the ones a user follows, signed with the user's key
+func (s *SmartContract) FollowHashtag(ctx contractapi.TransactionContextInterface, publicKey string, hashtag string, nonce string, signature string) error {
+	err := s.verifyUserSignature(ctx, publicKey, "FollowHashtag", []string{publicKey, hashtag}, nonce, signature)
+	if err != nil {
+		return err
+	}
+	tag, err := normalizeHashtag(hashtag)
+	if err != nil {
+		return err
+	}
+
+	followKey, err := entityKey(ctx, hashtagFollowObjectType, publicKey, tag)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(followKey, indexMarker)
+	if err != nil {
+		return fmt.Errorf("failed to follow hashtag %s: %v", tag, err)
+	}
+
+	log.Printf("User %s followed hashtag %s", publicKey, tag)
+	return nil
+}
+
+// UnfollowHashtag removes a hashtag from the ones a user follows, signed with the user's key
+func (s *SmartContract) UnfollowHashtag(ctx contractapi.TransactionContextInterface, publicKey string, hashtag string, nonce string, signature string) error {
+	err := s.verifyUserSignature(ctx, publicKey, "UnfollowHashtag", []string{publicKey, hashtag}, nonce, signature)
+	if err != nil {
+		return err
+	}
+	tag, err := normalizeHashtag(hashtag)
+	if err != nil {
+		return err
+	}
+
+	followKey, err := entityKey(ctx, hashtagFollowObjectType, publicKey, tag)
+	if err != nil {
+		return err
+	}
+	followed, err := ctx.GetStub().GetState(followKey)
+	if err != nil {
+		return fmt.Errorf("failed to read followed hashtag %s: %v", tag, err)
+	}
+	if followed == nil {
+		return fmt.Errorf("user %s does not follow hashtag %s", publicKey, tag)
+	}
+	err = ctx.GetStub().DelState(followKey)
+	if err != nil {
+		return fmt.Errorf("failed to unfollow hashtag %s: %v", tag, err)
+	}
+
+	log.Printf("User %s unfollowed hashtag %s", publicKey, tag)
+	return nil
+}
+
+// GetFollowedHashtags returns the hashtags a user follows, in alphabetical order
+func (s *SmartContract) GetFollowedHashtags(ctx contractapi.TransactionContextInterface, publicKey string) ([]string, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(hashtagFollowObjectType, []string{publicKey})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get followed hashtags: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	hashtags := []string{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate followed hashtags: %v", err)
+		}
+
+		// hashtagfollow~publicKey~tag
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to split composite key: %v", err)
+		}
+		if len(compositeKeyParts) == 2 {
+			hashtags = append(hashtags, compositeKeyParts[1])
+		}
+	}
+	return hashtags, nil
+}
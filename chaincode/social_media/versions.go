@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// PostVersion is one revision of a post's content
+type PostVersion struct {
+	ContentCID  string `json:"contentCID"`
+	PreviousCID string `json:"previousCID"` // Empty for the original content
+	Timestamp   int64  `json:"timestamp"`
+}
+
+// currentContentCID returns the IPFS hash of the latest revision of a post. A
+// post keeps its original hash as its ID, so reactions, comments and shares
+// stay attached across edits.
+func (p *Post) currentContentCID() string {
+	if len(p.Versions) == 0 {
+		return p.ContentCID
+	}
+	return p.Versions[len(p.Versions)-1].ContentCID
+}
+
+// getAuthoredPost reads a live post and checks that publicKey wrote it
+func (s *SmartContract) getAuthoredPost(ctx contractapi.TransactionContextInterface, postID string, publicKey string) (*Post, error) {
+	post, err := s.getPost(ctx, postID)
+	if err != nil {
+		return nil, err
+	}
+	if post.Deleted {
+		return nil, fmt.Errorf("post %s has been deleted", postID)
+	}
+	authored, err := isUserKey(ctx, post.UserPublicKey, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if !authored {
+		return nil, fmt.Errorf("only the author can change post %s", postID)
+	}
+	return post, nil
+}
+
+// EditPost replaces the content of a post with newContentCID, signed by the author.
+// The previous content stays in the post's version list. The post is listed
+// under the hashtags and mentions of the new content instead of the old, unless
+// it is hidden.
+func (s *SmartContract) EditPost(ctx contractapi.TransactionContextInterface, postID string, publicKey string, newContentCID string, hashtags []string, mentions []string, nonce string, signature string) error {
+	hashtagsJSON, err := json.Marshal(hashtags)
+	if err != nil {
+		return fmt.Errorf("failed to serialize hashtags: %v", err)
+	}
+	mentionsJSON, err := json.Marshal(mentions)
+	if err != nil {
+		return fmt.Errorf("failed to serialize mentions: %v", err)
+	}
+	err = s.verifyUserSignature(ctx, publicKey, "EditPost", []string{postID, publicKey, newContentCID, string(hashtagsJSON), string(mentionsJSON)}, nonce, signature)
+	if err != nil {
+		return err
+	}
+
+	post, err := s.getAuthoredPost(ctx, postID, publicKey)
+	if err != nil {
+		return err
+	}
+	previousCID := post.currentContentCID()
+	if newContentCID == "" || newContentCID == previousCID {
+		return fmt.Errorf("new content must differ from the current version")
+	}
+
+	hashtags, err = normalizeHashtags(hashtags)
+	if err != nil {
+		return err
+	}
+	mentions, err = s.resolveMentions(ctx, publicKey, mentions)
+	if err != nil {
+		return err
+	}
+
+	// Only users the previous version did not mention are told about the edit
+	mentionedBefore := make(map[string]bool, len(post.Mentions))
+	for _, mentioned := range post.Mentions {
+		mentionedBefore[mentioned] = true
+	}
+	newMentions := []string{}
+	for _, mentioned := range mentions {
+		if !mentionedBefore[mentioned] {
+			newMentions = append(newMentions, mentioned)
+		}
+	}
+
+	err = s.unindexPostTopics(ctx, post)
+	if err != nil {
+		return err
+	}
+	post.Hashtags = hashtags
+	post.Mentions = mentions
+	// A hidden post is listed under its new topics when a moderator unhides it
+	if !post.Hidden {
+		err = s.indexPostTopics(ctx, post)
+		if err != nil {
+			return err
+		}
+	}
+
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	// The version list starts with the original content on the first edit
+	if len(post.Versions) == 0 {
+		post.Versions = append(post.Versions, PostVersion{ContentCID: post.ContentCID, Timestamp: post.Timestamp})
+	}
+	post.Versions = append(post.Versions, PostVersion{
+		ContentCID:  newContentCID,
+		PreviousCID: previousCID,
+		Timestamp:   timestamp,
+	})
+
+	err = s.savePost(ctx, post)
+	if err != nil {
+		return err
+	}
+
+	err = emitEvent(ctx, eventPostEdited, PostEvent{PostID: post.ID, PostCID: postID, AuthorPublicKey: publicKey, ContentCID: newContentCID, Mentions: newMentions})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Post %s edited, now at version %d", postID, len(post.Versions))
+	return nil
+}
+
+// DeletePost tombstones a post, signed by the author. It is dropped from the
+// author's post list and the post indexes; the record itself is kept, flagged
+// as deleted and without its hashtags, mentions or earlier versions, so
+// comments and shares that refer to it still resolve.
+func (s *SmartContract) DeletePost(ctx contractapi.TransactionContextInterface, postID string, publicKey string, nonce string, signature string) error {
+	err := s.verifyUserSignature(ctx, publicKey, "DeletePost", []string{postID, publicKey}, nonce, signature)
+	if err != nil {
+		return err
+	}
+
+	post, err := s.getAuthoredPost(ctx, postID, publicKey)
+	if err != nil {
+		return err
+	}
+	contentCID := post.currentContentCID()
+	err = s.unlistPost(ctx, post)
+	if err != nil {
+		return err
+	}
+
+	// Remove the post from its author's list, kept under the author's current
+	// key. The list of a key being rotated away is left for the rotation, which
+	// works through it by position.
+	author, err := resolveUserKey(ctx, post.UserPublicKey)
+	if err != nil {
+		return err
+	}
+	postsKey, err := entityKey(ctx, userPostsObjectType, author)
+	if err != nil {
+		return err
+	}
+	postsBytes, err := ctx.GetStub().GetState(postsKey)
+	if err != nil {
+		return fmt.Errorf("failed to read posts: %v", err)
+	}
+	var posts []string
+	if postsBytes != nil {
+		err = json.Unmarshal(postsBytes, &posts)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal posts: %v", err)
+		}
+	}
+	remaining := make([]string, 0, len(posts))
+	for _, hash := range posts {
+		if hash != postID {
+			remaining = append(remaining, hash)
+		}
+	}
+	updatedPostsBytes, err := json.Marshal(remaining)
+	if err != nil {
+		return fmt.Errorf("failed to marshal updated posts: %v", err)
+	}
+	err = ctx.GetStub().PutState(postsKey, updatedPostsBytes)
+	if err != nil {
+		return fmt.Errorf("failed to update posts: %v", err)
+	}
+
+	log.Printf("Post %s deleted by its author", postID)
+	return emitEvent(ctx, eventPostDeleted, PostEvent{PostID: post.ID, PostCID: postID, AuthorPublicKey: publicKey, ContentCID: contentCID})
+}
+
+// unlistPost flags a post as deleted and drops the index entries that list it,
+// along with its hashtags, mentions and version list. The caller removes it
+// from its author's post list.
+func (s *SmartContract) unlistPost(ctx contractapi.TransactionContextInterface, post *Post) error {
+	userPostKey, err := entityKey(ctx, userPostObjectType, post.UserPublicKey, reverseTimestamp(post.Timestamp), post.ContentCID)
+	if err != nil {
+		return err
+	}
+	allPostsKey, err := entityKey(ctx, allPostsObjectType, post.ContentCID)
+	if err != nil {
+		return err
+	}
+	for _, key := range []string{userPostKey, allPostsKey} {
+		err = ctx.GetStub().DelState(key)
+		if err != nil {
+			return fmt.Errorf("failed to remove post %s from index: %v", post.ContentCID, err)
+		}
+	}
+	err = s.unindexPostTopics(ctx, post)
+	if err != nil {
+		return err
+	}
+
+	post.Deleted = true
+	post.Hashtags = nil
+	post.Mentions = nil
+	post.Versions = nil
+	return s.savePost(ctx, post)
+}
+
+// GetPostHistory returns every version of a post's content, oldest first
+func (s *SmartContract) GetPostHistory(ctx contractapi.TransactionContextInterface, postID string) ([]PostVersion, error) {
+	post, err := s.getPost(ctx, postID)
+	if err != nil {
+		return nil, err
+	}
+	if post.Deleted {
+		return nil, fmt.Errorf("post %s has been deleted", postID)
+	}
+	if len(post.Versions) == 0 {
+		return []PostVersion{{ContentCID: post.ContentCID, Timestamp: post.Timestamp}}, nil
+	}
+	return post.Versions, nil
+}
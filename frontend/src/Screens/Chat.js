@@ -4,6 +4,7 @@ import { Card, CardContent, CardHeader, CardTitle } from "../Components/ui/card"
 import { Input } from "../Components/ui/input";
 import { Send, User, MessageCircle, Loader } from 'lucide-react';
 import Navbar from "./Navbar";
+import { authHeaders } from "../utils/api";
 
 const API_BASE_URL = "http://localhost:8081";
 
@@ -61,15 +62,16 @@ const ChatApp = () => {
     try {
       const response = await fetch(`${API_BASE_URL}/chat`, {
         method: "POST",
-        headers: { "Content-Type": "application/json" },
+        headers: { "Content-Type": "application/json", ...authHeaders() },
         body: JSON.stringify({
           operation: "get",
           username: selectedUser.username,
           senderUsername: currentUser.name,
         }),
       });
+      // The newest page of the conversation, oldest message first
       const messageData = await response.json();
-      const normalizedMessages = messageData.map((msg) => ({
+      const normalizedMessages = (messageData.messages || []).map((msg) => ({
         senderUsername: msg.username || currentUser.name,
         messages: msg,
         timestamp: msg.timestamp || new Date().toISOString(),
@@ -88,7 +90,7 @@ const ChatApp = () => {
     try {
       const response = await fetch(`${API_BASE_URL}/chat`, {
         method: "POST",
-        headers: { "Content-Type": "application/json" },
+        headers: { "Content-Type": "application/json", ...authHeaders() },
         body: JSON.stringify({
           operation: "send",
           username: currentUser.name,
@@ -2,6 +2,7 @@ import { useEffect, useState } from "react";
 import Navbar from "./Navbar";
 import PostReactions from "./PostReactions";
 import { User, MessageSquare, Share2 } from 'lucide-react';
+import { authHeaders } from '../utils/api';
 
 const UserFeed = () => {
   const [posts, setPosts] = useState([]);
@@ -9,9 +10,10 @@ const UserFeed = () => {
   const [currentUser, setCurrentUser] = useState(null);
 
   useEffect(() => {
-    const fetchPosts = async () => {
+    const fetchPosts = async (viewer) => {
       try {
-        const response = await fetch("http://localhost:8081/feed");
+        const query = viewer ? `?publicKey=${encodeURIComponent(viewer)}` : "";
+        const response = await fetch(`http://localhost:8081/feed${query}`);
         if (!response.ok) {
           throw new Error(`Failed to fetch posts: ${response.status} ${response.statusText}`);
         }
@@ -24,17 +26,19 @@ const UserFeed = () => {
       }
     };
 
-    fetchPosts();
-
+    let viewer = null;
     try {
       const userDataString = localStorage.getItem("user");
       if (userDataString) {
         const userData = JSON.parse(userDataString);
         setCurrentUser(userData);
+        viewer = userData.publicKey;
       }
     } catch (error) {
       console.error("Error parsing localStorage data:", error);
     }
+
+    fetchPosts(viewer);
   }, []);
 
   const handleReactionUpdate = (updatedPost) => {
@@ -45,6 +49,37 @@ const UserFeed = () => {
     );
   };
 
+  const handleShare = async (post) => {
+    if (!currentUser?.publicKey) {
+      return;
+    }
+    const quote = window.prompt("Add a comment to your share (optional)", "");
+    if (quote === null) {
+      return;
+    }
+    try {
+      const response = await fetch(`http://localhost:8081/post/${post.id}/share`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json", ...authHeaders() },
+        body: JSON.stringify({
+          publicKey: currentUser.publicKey,
+          name: currentUser.name,
+          content: quote,
+        }),
+      });
+      if (!response.ok) {
+        throw new Error(`Failed to share post: ${response.status} ${response.statusText}`);
+      }
+      setPosts((prevPosts) =>
+        prevPosts.map((p) =>
+          p.id === post.id ? { ...p, shareCount: (p.shareCount || 0) + 1 } : p
+        )
+      );
+    } catch (error) {
+      console.error("Error sharing post:", error.message);
+    }
+  };
+
   if (loading) {
     return <div className="flex justify-center items-center h-screen bg-gradient-animation">
       <div className="animate-spin rounded-full h-32 w-32 border-t-2 border-b-2 border-[#4dbf38]"></div>
@@ -68,6 +103,21 @@ const UserFeed = () => {
                   </div>
                 </div>
                 <p className="text-gray-700 mb-4">{post.content}</p>
+                {post.sharedPost && (
+                  <div className="border border-gray-200 rounded p-4 mb-4">
+                    <span className="text-sm text-gray-500">
+                      Shared from {post.sharedPost.user?.name || "Anonymous User"}
+                    </span>
+                    <p className="text-gray-700">{post.sharedPost.content}</p>
+                    {post.sharedPost.imageHash && (
+                      <img
+                        src={`http://localhost:8080/ipfs/${post.sharedPost.imageHash}`}
+                        alt="Shared post content"
+                        className="w-full h-48 object-cover mt-2 rounded"
+                      />
+                    )}
+                  </div>
+                )}
                 {post.imageHash && (
                   <img
                     src={`http://localhost:8080/ipfs/${post.imageHash}`}
@@ -95,9 +145,12 @@ const UserFeed = () => {
                       <MessageSquare className="h-5 w-5 mr-1" />
                       <span>Comment</span>
                     </button>
-                    <button className="flex items-center text-gray-500 hover:text-[#4dbf38]">
+                    <button
+                      className="flex items-center text-gray-500 hover:text-[#4dbf38]"
+                      onClick={() => handleShare(post.sharedPost || post)}
+                    >
                       <Share2 className="h-5 w-5 mr-1" />
-                      <span>Share</span>
+                      <span>Share{post.shareCount ? ` (${post.shareCount})` : ""}</span>
                     </button>
                   </div>
                 </div>
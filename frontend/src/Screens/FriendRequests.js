@@ -1,4 +1,5 @@
 import { useEffect, useState } from "react";
+import { authHeaders } from "../utils/api";
 
 const API_BASE_URL = "http://localhost:8081";
 
@@ -64,6 +65,7 @@ const FriendRequests = () => {
         method: "POST",
         headers: {
           "Content-Type": "application/json",
+          ...authHeaders(),
         },
         body: JSON.stringify({
           senderPublicKey: friendRequest.sender,
@@ -3,6 +3,7 @@ import { toast, Toaster } from "sonner";
 import { Button } from "../Components/ui/button";
 import { Card, CardContent, CardHeader, CardTitle } from "../Components/ui/card";
 import { Input } from "../Components/ui/input";
+import { authHeaders } from "../utils/api";
 
 const API_BASE_URL = "http://localhost:8081";
 
@@ -50,8 +51,9 @@ const GroupChatApp = () => {
         method: "POST",
         headers: {
           "Content-Type": "application/json",
+          ...authHeaders(),
         },
-        body: JSON.stringify({ user_name: user.name }),
+        body: JSON.stringify({ publicKey: user.publicKey, user_name: user.name }),
       });
 
       if (!response.ok) {
@@ -75,28 +77,20 @@ const GroupChatApp = () => {
     }
   }, [currentUser, retrieveUserFromStorage, selectedGroup]);
 
-  // Fetch messages from localStorage or the server
+  // Fetch messages from the server
   const fetchMessages = useCallback(async () => {
     if (!currentUser || !selectedGroup) return;
 
-    // Check if messages are stored in localStorage
-    const storedMessages = JSON.parse(localStorage.getItem(`messages_${selectedGroup.id}`));
-    if (storedMessages) {
-      setMessages(storedMessages);
-      return;
-    }
-
     setLoading((prev) => ({ ...prev, messages: true }));
 
     try {
       const response = await fetch(`${API_BASE_URL}/groupchat`, {
         method: "POST",
-        headers: { "Content-Type": "application/json" },
+        headers: { "Content-Type": "application/json", ...authHeaders() },
         body: JSON.stringify({
           operation: "get",
           groupID: selectedGroup.id,
-          participants: selectedGroup.members,
-          senderUsername: currentUser.name,
+          publicKey: currentUser.publicKey,
         }),
       });
 
@@ -104,30 +98,9 @@ const GroupChatApp = () => {
         throw new Error("Failed to fetch messages");
       }
 
-      // Retrieve all messages and process them
+      // The newest page of the chat, decrypted and in sequence order
       const messageData = await response.json();
-      const members = selectedGroup.members || []; // Assuming `members` is part of the group data
-      const allMessages = [];
-
-      for (const member of members) {
-        const processedMessages = messageData.map((msg) => ({
-          senderUsername: member,
-          plainText: msg,
-          timestamp: msg.timestamp || new Date().toISOString(),
-        }));
-
-        allMessages.push(...processedMessages);
-      }
-
-      // Sort messages by timestamp to display in the correct order
-      allMessages.sort(
-        (a, b) => new Date(a.timestamp).getTime() - new Date(b.timestamp).getTime()
-      );
-
-      setMessages(allMessages);
-
-      // Store messages in localStorage for future use
-      localStorage.setItem(`messages_${selectedGroup.id}`, JSON.stringify(allMessages));
+      setMessages(messageData.messages || []);
     } catch (error) {
       console.error("Messages fetch error:", error);
       toast.error("Could not retrieve messages");
@@ -144,12 +117,11 @@ const GroupChatApp = () => {
     try {
       const response = await fetch(`${API_BASE_URL}/groupchat`, {
         method: "POST",
-        headers: { "Content-Type": "application/json" },
+        headers: { "Content-Type": "application/json", ...authHeaders() },
         body: JSON.stringify({
           operation: "send",
-          participants: selectedGroup.members,
           groupID: selectedGroup.id,
-          username: currentUser.name,
+          publicKey: currentUser.publicKey,
           plainText: newMessage,
         }),
       });
@@ -158,19 +130,8 @@ const GroupChatApp = () => {
         throw new Error("Failed to send message");
       }
 
-      const newMessageObj = {
-        senderUsername: currentUser.name,
-        plainText: newMessage,
-        timestamp: new Date().toISOString(),
-      };
-
-      setMessages((prev) => [...prev, newMessageObj]);
       setNewMessage("");
 
-      // Store the new message in localStorage
-      const updatedMessages = [...messages, newMessageObj];
-      localStorage.setItem(`messages_${selectedGroup.id}`, JSON.stringify(updatedMessages));
-
       // Refresh messages to ensure sync with backend
       await fetchMessages();
     } catch (error) {
@@ -249,15 +210,15 @@ const GroupChatApp = () => {
             <CardContent className="flex-grow overflow-y-auto flex flex-col">
               {messages.map((msg, index) => (
                 <div
-                  key={index}
+                  key={msg.seq ?? index}
                   className={`mb-2 p-2 rounded max-w-xs ${
-                    msg.senderUsername === currentUser?.name
+                    msg.sender === currentUser?.publicKey
                       ? "bg-blue-100 self-end text-right ml-auto"
                       : "bg-gray-100 self-start text-left mr-auto"
                   }`}
                 >
                   <p className="text-sm text-gray-700">
-                    {msg.senderUsername !== currentUser?.name && (
+                    {msg.sender !== currentUser?.publicKey && (
                       <span className="font-semibold mr-1">
                         {msg.senderUsername}:
                       </span>
@@ -2,6 +2,7 @@ import { useEffect, useState } from "react";
 import { Button } from "../Components/ui/button";
 import { Card, CardContent, CardHeader, CardTitle } from "../Components/ui/card";
 import { Input } from "../Components/ui/input";
+import { authHeaders } from "../utils/api";
 
 const API_BASE_URL = "http://localhost:8081"; // Base backend URL
 
@@ -49,11 +50,11 @@ const CreateGroup = () => {
     fetchUsers();
   }, []);
 
-  const toggleUserSelection = (username) => {
+  const toggleUserSelection = (publicKey) => {
     setSelectedUsers((prev) =>
-      prev.includes(username)
-        ? prev.filter((user) => user !== username)
-        : [...prev, username]
+      prev.includes(publicKey)
+        ? prev.filter((user) => user !== publicKey)
+        : [...prev, publicKey]
     );
   };
 
@@ -69,10 +70,12 @@ const CreateGroup = () => {
         method: "POST",
         headers: {
           "Content-Type": "application/json",
+          ...authHeaders(),
         },
         body: JSON.stringify({
-          name:groupName,
-          members: [currentUser.name, ...selectedUsers],
+          groupname: groupName,
+          creatorPublicKey: currentUser.publicKey,
+          members: selectedUsers,
         }),
       });
      
@@ -110,18 +113,18 @@ const CreateGroup = () => {
           <h3 className="text-lg font-semibold mb-2">Select Users:</h3>
           <div className="max-h-40 overflow-y-auto">
             {users
-              .filter((user) => user.name !== currentUser?.name)
+              .filter((user) => user.publicKey !== currentUser?.publicKey)
               .map((user) => (
-                <div key={user.name} className="flex items-center mb-2">
+                <div key={user.publicKey} className="flex items-center mb-2">
                   <input
                     type="checkbox"
-                    id={`user-${user.name}`}
-                    checked={selectedUsers.includes(user.name)}
-                    onChange={() => toggleUserSelection(user.name)}
+                    id={`user-${user.publicKey}`}
+                    checked={selectedUsers.includes(user.publicKey)}
+                    onChange={() => toggleUserSelection(user.publicKey)}
                     disabled={loading}
                     className="mr-2"
                   />
-                  <label htmlFor={`user-${user.name}`}>
+                  <label htmlFor={`user-${user.publicKey}`}>
                     {user.name}
                   </label>
                 </div>
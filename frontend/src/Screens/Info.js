@@ -1,6 +1,7 @@
 import React, { useState } from 'react';
 import { useNavigate } from 'react-router-dom'; // Import useNavigate instead
 import './UserInfo.css'; // Import the CSS file
+import { authHeaders } from '../utils/api';
 
 const UserInfo = () => {
     const [userInfo, setUserInfo] = useState({
@@ -41,6 +42,7 @@ const UserInfo = () => {
         try {
             const response = await fetch('http://localhost:8081/info', { // Ensure the correct endpoint
                 method: 'POST',
+                headers: authHeaders(),
                 body: formData,
             });
 
@@ -3,6 +3,7 @@ import { useNavigate } from "react-router-dom";
 import { AlertCircle, CheckCircle2, Loader2, LogOut, Send, Image, Video } from 'lucide-react';
 import Navbar from "./Navbar";
 import "./Userpost.css";
+import { authHeaders } from "../utils/api";
 
 const Alert = ({ children, className, ...props }) => (
   <div
@@ -111,6 +112,7 @@ const CreatePost = () => {
     try {
       const response = await fetch("http://localhost:8081/post", {
         method: "POST",
+        headers: authHeaders(),
         body: formData,
       });
 
@@ -1,5 +1,6 @@
 import React, { useState } from "react";
 import { ThumbsUp, Heart, Laugh, Angry, Frown } from 'lucide-react';
+import { authHeaders } from '../utils/api';
 
 const reactions = {
   like: { icon: ThumbsUp, color: "text-[#052a47]", label: "Like" },
@@ -26,6 +27,7 @@ const PostReactions = ({ post, currentUser, onReactionUpdate }) => {
           method: "POST",
           headers: {
             "Content-Type": "application/json",
+            ...authHeaders(),
           },
           body: JSON.stringify({
             userPublicKey: currentUser.publicKey,
@@ -1,4 +1,5 @@
 import { useEffect, useState } from 'react';
+import { authHeaders } from '../utils/api';
 const Share = ({ postId }) => {
   const [isSharing, setIsSharing] = useState(false);
   const [shareStatus, setShareStatus] = useState(null);
@@ -51,6 +52,7 @@ const Share = ({ postId }) => {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
+          ...authHeaders(),
         },
         body: JSON.stringify({
           postId: postId,
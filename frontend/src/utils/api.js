@@ -1,5 +1,16 @@
 const API_BASE_URL = "http://localhost:8081";
 
+// authHeaders returns the Authorization header for the session opened at
+// login or sign-up; the backend rejects writes without it.
+export const authHeaders = () => {
+  try {
+    const user = JSON.parse(localStorage.getItem("user"));
+    return user?.sessionToken ? { Authorization: `Bearer ${user.sessionToken}` } : {};
+  } catch {
+    return {};
+  }
+};
+
 export const fetchUsers = async () => {
   const response = await fetch(`${API_BASE_URL}/users`);
   if (!response.ok) throw new Error("Failed to fetch users");
@@ -15,7 +26,7 @@ export const fetchFriendRequests = async (userPublicKey) => {
 export const sendFriendRequest = async (sender, receiver) => {
   const response = await fetch(`${API_BASE_URL}/friend-request/send`, {
     method: "POST",
-    headers: { "Content-Type": "application/json" },
+    headers: { "Content-Type": "application/json", ...authHeaders() },
     body: JSON.stringify({ senderPublicKey: sender, receiverPublicKey: receiver }),
   });
   if (!response.ok) throw new Error("Failed to send friend request");
@@ -25,7 +36,7 @@ export const sendFriendRequest = async (sender, receiver) => {
 export const respondToFriendRequest = async (sender, receiver, responseStatus) => {
   const response = await fetch(`${API_BASE_URL}/friend-request/respond`, {
     method: "POST",
-    headers: { "Content-Type": "application/json" },
+    headers: { "Content-Type": "application/json", ...authHeaders() },
     body: JSON.stringify({
       senderPublicKey: sender,
       receiverPublicKey: receiver,
@@ -15,8 +15,15 @@ cd ../fabric-samples/test-network
 ./network.sh down
 ./network.sh up createChannel -c mychannel -ca
 
-# Deploy chaincode
-./network.sh deployCC -ccn social_media -ccp ../../decentralized-social-media/chaincode/social_media -ccl go
+# Deploy chaincode with its private data collections. The chaincode keeps the
+# channel's default endorsement policy, which needs both Org1 and Org2, so both
+# orgs hold the phone numbers and chats in collections_config.json: an org that
+# is not a member of a collection cannot endorse the transactions that use it.
+# The collections keep the data off the public ledger, which only records hashes.
+# An endorsing peer hands private writes to at least one other member peer
+# before it endorses them, so they survive the loss of that peer.
+./network.sh deployCC -ccn social_media -ccp ../../decentralized-social-media/chaincode/social_media -ccl go \
+    -cccg ../../decentralized-social-media/chaincode/social_media/collections_config.json
 
 # Copy connection profile
 mkdir -p ../../decentralized-social-media/network